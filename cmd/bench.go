@@ -0,0 +1,116 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/bench"
+	"github.com/spf13/cobra"
+)
+
+// benchCmd represents the bench command
+var (
+	benchCmd = &cobra.Command{
+		Use:   "bench",
+		Short: "Measures the throughput and the latencies of the task API",
+		Long: `Sends a configurable mix of create, get, list, update and delete operations from concurrent clients
+to one or more nodes and reports the throughput, the p50/p99/p999 latencies per operation and per node, and the errors.
+With --handover-after the leader is asked to transfer its leadership during the run to measure the failover impact.`,
+		RunE: runBench,
+	}
+	benchUrls           []string
+	benchClients        int
+	benchDuration       time.Duration
+	benchMix            string
+	benchRequestTimeout time.Duration
+	benchHandoverAfter  time.Duration
+	benchOut            string
+)
+
+func init() {
+	rootCmd.AddCommand(benchCmd)
+	benchCmd.Flags().StringSliceVar(&benchUrls, "url", []string{"http://localhost:8000"}, "API URL of the nodes, the clients are spread across them")
+	benchCmd.Flags().IntVar(&benchClients, "clients", 10, "Number of concurrent clients")
+	benchCmd.Flags().DurationVar(&benchDuration, "duration", 30*time.Second, "How long to send operations")
+	benchCmd.Flags().StringVar(&benchMix, "mix", "create=20,get=50,list=5,update=20,delete=5", "Weight of each operation")
+	benchCmd.Flags().DurationVar(&benchRequestTimeout, "request-timeout", 5*time.Second, "How long a client waits for an answer")
+	benchCmd.Flags().DurationVar(&benchHandoverAfter, "handover-after", 0, "Ask the leader to hand its leadership over once elapsed, 0 never does")
+	benchCmd.Flags().StringVar(&benchOut, "out", "", "File receiving the report in JSON")
+}
+
+func printStats(out io.Writer, name string, st bench.Stats) {
+	fmt.Fprintf(out, "  %-28s %8d %7d %10.1f %10s %10s %10s %10s\n", name, st.Count, st.Errors, st.Throughput,
+		st.P50.Round(time.Microsecond), st.P99.Round(time.Microsecond), st.P999.Round(time.Microsecond), st.Max.Round(time.Microsecond))
+}
+
+func printBenchReport(out io.Writer, report *bench.Report) {
+	fmt.Fprintf(out, "%d operations in %s\n", report.Total.Count, report.Duration.Round(time.Millisecond))
+	fmt.Fprintf(out, "  %-28s %8s %7s %10s %10s %10s %10s %10s\n", "", "ops", "errors", "ops/s", "p50", "p99", "p999", "max")
+	printStats(out, "total", report.Total)
+	for _, group := range []map[string]bench.Stats{report.Operations, report.Nodes} {
+		names := make([]string, 0, len(group))
+		for name := range group {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			printStats(out, name, group[name])
+		}
+	}
+
+	kinds := make([]string, 0, len(report.Errors))
+	for kind := range report.Errors {
+		kinds = append(kinds, kind)
+	}
+	sort.Strings(kinds)
+	for _, kind := range kinds {
+		fmt.Fprintf(out, "error %s: %d\n", kind, report.Errors[kind])
+	}
+
+	failover := report.Failover
+	switch {
+	case failover == nil:
+	case failover.Error != "":
+		fmt.Fprintf(out, "leader transfer at %s failed: %s\n", failover.Start.Round(time.Millisecond), failover.Error)
+	case !failover.Recovered:
+		fmt.Fprintf(out, "leader transfer of %s at %s, writes never recovered, %d errors\n", failover.Leader,
+			failover.Start.Round(time.Millisecond), failover.Errors)
+	default:
+		fmt.Fprintf(out, "leader transfer of %s at %s, writes unavailable for %s, %d errors, max latency %s\n", failover.Leader,
+			failover.Start.Round(time.Millisecond), failover.Unavailable.Round(time.Millisecond), failover.Errors,
+			failover.MaxLatency.Round(time.Millisecond))
+	}
+}
+
+func runBench(cmd *cobra.Command, args []string) error {
+	mix, err := bench.ParseMix(benchMix)
+	if err != nil {
+		return err
+	}
+	cfg := bench.Config{
+		Urls:           benchUrls,
+		Clients:        benchClients,
+		Duration:       benchDuration,
+		Mix:            mix,
+		RequestTimeout: benchRequestTimeout,
+		HandoverAfter:  benchHandoverAfter,
+	}
+	report, err := bench.Run(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	printBenchReport(cmd.OutOrStdout(), report)
+	if benchOut == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(benchOut, data, 0644)
+}
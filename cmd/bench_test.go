@@ -0,0 +1,34 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/bench"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustPrintBenchReport(t *testing.T) {
+	var out bytes.Buffer
+	report := &bench.Report{
+		Duration: 10 * time.Second,
+		Total:    bench.Stats{Count: 120, Errors: 2, Throughput: 11.8, P50: 3 * time.Millisecond, P99: 40 * time.Millisecond},
+		Operations: map[string]bench.Stats{
+			bench.OpCreate: {Count: 120, Errors: 2},
+		},
+		Nodes: map[string]bench.Stats{
+			"http://localhost:8000": {Count: 120, Errors: 2},
+		},
+		Errors: map[string]int{"status 503": 2},
+		Failover: &bench.Failover{Leader: "http://localhost:8000", Start: 5 * time.Second, Recovered: true,
+			Unavailable: 1200 * time.Millisecond, Errors: 2, MaxLatency: time.Second},
+	}
+
+	printBenchReport(&out, report)
+
+	assert.Contains(t, out.String(), "120 operations in 10s")
+	assert.Contains(t, out.String(), "http://localhost:8000")
+	assert.Contains(t, out.String(), "error status 503: 2")
+	assert.Contains(t, out.String(), "writes unavailable for 1.2s, 2 errors")
+}
@@ -0,0 +1,98 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/balchua/bopbag/pkg/infrastructure"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/spf13/cobra"
+)
+
+// clusterCmd groups the commands operating a running cluster through the API of one of its nodes
+var (
+	clusterCmd = &cobra.Command{
+		Use:   "cluster",
+		Short: "Operates a running cluster",
+	}
+	upgradePlanCmd = &cobra.Command{
+		Use:   "upgrade-plan",
+		Short: "Lists the nodes to upgrade to this version, in order",
+		Long: `Asks a node of the cluster which nodes do not run the target version yet and prints them in the order
+they must be upgraded: spares and stand-bys first, then the voters with the leader last.
+Run it with the new binary, the target version and schema default to the ones of this binary.`,
+		RunE: showUpgradePlan,
+	}
+	clusterUrl          string
+	targetVersion       string
+	targetSchemaVersion int
+)
+
+func init() {
+	rootCmd.AddCommand(clusterCmd)
+	clusterCmd.PersistentFlags().StringVar(&clusterUrl, "url", "http://localhost:8000", "API URL of any node of the cluster")
+	clusterCmd.AddCommand(upgradePlanCmd)
+	upgradePlanCmd.Flags().StringVar(&targetVersion, "target-version", version, "Version the cluster is upgraded to")
+	upgradePlanCmd.Flags().IntVar(&targetSchemaVersion, "target-schema", infrastructure.SchemaVersion, "Schema version supported by the target version")
+}
+
+func fetchUpgradePlan() (*domain.UpgradePlan, error) {
+	query := url.Values{}
+	query.Set("version", targetVersion)
+	query.Set("schemaVersion", strconv.Itoa(targetSchemaVersion))
+	httpClient := &http.Client{Timeout: 30 * time.Second}
+	resp, err := httpClient.Get(strings.TrimSuffix(clusterUrl, "/") + "/api/v1/cluster/upgrade-plan?" + query.Encode())
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	var plan domain.UpgradePlan
+	if err := json.NewDecoder(resp.Body).Decode(&plan); err != nil {
+		return nil, err
+	}
+	return &plan, nil
+}
+
+func printUpgradePlan(out io.Writer, plan *domain.UpgradePlan) {
+	fmt.Fprintf(out, "target version %s (schema %d), cluster schema %d\n", plan.TargetVersion, plan.TargetSchemaVersion,
+		plan.ClusterSchemaVersion)
+	if len(plan.UpToDate) > 0 {
+		fmt.Fprintf(out, "up to date: %s\n", strings.Join(plan.UpToDate, ", "))
+	}
+	if len(plan.Steps) == 0 {
+		fmt.Fprintln(out, "nothing to upgrade")
+	}
+	for _, step := range plan.Steps {
+		leader := ""
+		if step.Leader {
+			leader = ", leader"
+		}
+		fmt.Fprintf(out, "%d. %s (%s%s) runs %q\n", step.Order, step.Address, client.NodeRole(step.Role), leader, step.Version)
+		for _, action := range step.Actions {
+			fmt.Fprintf(out, "   - %s\n", action)
+		}
+	}
+	for _, note := range plan.Notes {
+		fmt.Fprintf(out, "note: %s\n", note)
+	}
+}
+
+func showUpgradePlan(cmd *cobra.Command, args []string) error {
+	plan, err := fetchUpgradePlan()
+	if err != nil {
+		return err
+	}
+	printUpgradePlan(cmd.OutOrStdout(), plan)
+	return nil
+}
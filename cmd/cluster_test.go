@@ -0,0 +1,51 @@
+package cmd
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustPrintUpgradePlan(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/cluster/upgrade-plan", r.URL.Path)
+		assert.Equal(t, "v0.3.0", r.URL.Query().Get("version"))
+		assert.Equal(t, "2", r.URL.Query().Get("schemaVersion"))
+		w.Write([]byte(`{"targetVersion": "v0.3.0", "targetSchemaVersion": 2, "clusterSchemaVersion": 1,
+			"upToDate": ["odin:9000"],
+			"steps": [{"order": 1, "address": "norse:9000", "role": 0, "leader": true, "version": "v0.2.0",
+				"actions": ["transfer the leadership and voting rights away", "stop the node"]}],
+			"notes": ["once every voter runs v0.3.0 the leader migrates the schema from 1 to 2"]}`))
+	}))
+	defer server.Close()
+
+	var out bytes.Buffer
+	upgradePlanCmd.SetOut(&out)
+	clusterUrl = server.URL
+	targetVersion = "v0.3.0"
+	targetSchemaVersion = 2
+
+	err := showUpgradePlan(upgradePlanCmd, nil)
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "up to date: odin:9000")
+	assert.Contains(t, out.String(), `1. norse:9000 (voter, leader) runs "v0.2.0"`)
+	assert.Contains(t, out.String(), "   - transfer the leadership")
+	assert.Contains(t, out.String(), "note: once every voter")
+}
+
+func TestFailUpgradePlanFromUnavailableNode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no leader", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	clusterUrl = server.URL
+	targetVersion = "v0.3.0"
+
+	err := showUpgradePlan(upgradePlanCmd, nil)
+	assert.NotNil(t, err)
+	assert.Contains(t, err.Error(), "no leader")
+}
@@ -0,0 +1,76 @@
+/*
+Copyright © 2021 balchua
+
+Licensed under the Apache License, Version 2.0 (the "License");
+you may not use this file except in compliance with the License.
+You may obtain a copy of the License at
+
+    http://www.apache.org/licenses/LICENSE-2.0
+
+Unless required by applicable law or agreed to in writing, software
+distributed under the License is distributed on an "AS IS" BASIS,
+WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+See the License for the specific language governing permissions and
+limitations under the License.
+*/
+package cmd
+
+import (
+	"fmt"
+	"io"
+
+	"github.com/balchua/bopbag/pkg/infrastructure"
+	"github.com/spf13/cobra"
+)
+
+// recoverCmd represents the recover command
+var (
+	recoverCmd = &cobra.Command{
+		Use:   "recover",
+		Short: "Rebuilds a cluster which lost its quorum",
+		Long: `Shows the last known membership of the node owning the data directory and, with --confirm,
+rewrites its raft configuration so that it restarts as the only voter of the cluster.
+The node must be stopped, the other nodes can then be wiped and joined again.`,
+		RunE: recoverNode,
+	}
+	recoverDbPath  string
+	recoverConfirm bool
+)
+
+func init() {
+	rootCmd.AddCommand(recoverCmd)
+	recoverCmd.Flags().StringVar(&recoverDbPath, "db", "./", "Path to the dqlite database files of the surviving node")
+	recoverCmd.Flags().BoolVar(&recoverConfirm, "confirm", false, "Rewrite the raft configuration, otherwise only show the membership")
+}
+
+func printMembership(out io.Writer, membership *infrastructure.Membership) {
+	fmt.Fprintf(out, "cluster: %s\n", membership.ClusterId)
+	fmt.Fprintf(out, "this node: %d %s\n", membership.Self.ID, membership.Self.Address)
+	fmt.Fprintf(out, "members:\n")
+	for _, member := range membership.Members {
+		fmt.Fprintf(out, "  %d %s %s\n", member.ID, member.Address, member.Role)
+	}
+}
+
+func recoverNode(cmd *cobra.Command, args []string) error {
+	out := cmd.OutOrStdout()
+	membership, err := infrastructure.ReadMembership(recoverDbPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "last known membership")
+	printMembership(out, membership)
+
+	if !recoverConfirm {
+		fmt.Fprintln(out, "run again with --confirm to make this node the only voter of the cluster")
+		return nil
+	}
+
+	membership, err = infrastructure.RecoverAsSingleVoter(recoverDbPath)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintln(out, "membership rewritten, start this node without --join then join new nodes to it")
+	printMembership(out, membership)
+	return nil
+}
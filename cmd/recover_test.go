@@ -0,0 +1,53 @@
+package cmd
+
+import (
+	"bytes"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustShowLastKnownMembership(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp/", "tempdb")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "info.yaml"), []byte("ID: 3297041220608546300\nAddress: norse:9000\nRole: 0\n"), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "cluster.yaml"), []byte(`- ID: 3297041220608546300
+  Address: norse:9000
+  Role: 0
+- ID: 7997991560008497000
+  Address: norse:9001
+  Role: 0
+`), 0600)
+	ioutil.WriteFile(filepath.Join(dir, "cluster-id"), []byte("5b0b3a4e-7a4c-4a43-9d7e-3c3f0f6f1a2b\n"), 0600)
+
+	var out bytes.Buffer
+	recoverCmd.SetOut(&out)
+	recoverDbPath = dir
+	recoverConfirm = false
+
+	err = recoverNode(recoverCmd, nil)
+	assert.Nil(t, err)
+	assert.Contains(t, out.String(), "5b0b3a4e-7a4c-4a43-9d7e-3c3f0f6f1a2b")
+	assert.Contains(t, out.String(), "7997991560008497000 norse:9001 voter")
+	assert.Contains(t, out.String(), "--confirm")
+}
+
+func TestFailRecoverEmptyDataDirectory(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp/", "tempdb")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	recoverDbPath = dir
+	recoverConfirm = true
+
+	assert.NotNil(t, recoverNode(recoverCmd, nil))
+}
@@ -0,0 +1,73 @@
+package cmd
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/balchua/bopbag/pkg/usecase"
+)
+
+// parseRetryPolicies overrides the default policies with settings such as
+// task.add=attempts:5,backoff:20ms,max-backoff:2s,timeout:5s,query-timeout:1s, the operation * overrides every operation.
+func parseRetryPolicies(settings []string) (usecase.RetryPolicies, error) {
+	policies := usecase.DefaultRetryPolicies()
+	for _, setting := range settings {
+		kv := strings.SplitN(setting, "=", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("bad retry policy %q, expected <operation>=<field>:<value>,...", setting)
+		}
+		operations := []string{kv[0]}
+		if kv[0] == domain.AnyTarget {
+			operations = []string{}
+			for operation := range policies {
+				operations = append(operations, operation)
+			}
+		} else if _, ok := policies[kv[0]]; !ok {
+			return nil, fmt.Errorf("unknown operation %q in retry policy", kv[0])
+		}
+		for _, operation := range operations {
+			policy, err := parseRetryPolicy(policies[operation], kv[1])
+			if err != nil {
+				return nil, fmt.Errorf("retry policy of %s: %w", operation, err)
+			}
+			policies[operation] = policy
+		}
+	}
+	return policies, nil
+}
+
+func parseRetryPolicy(policy usecase.RetryPolicy, fields string) (usecase.RetryPolicy, error) {
+	for _, field := range strings.Split(fields, ",") {
+		nv := strings.SplitN(field, ":", 2)
+		if len(nv) != 2 {
+			return policy, fmt.Errorf("bad field %q", field)
+		}
+		var err error
+		switch nv[0] {
+		case "attempts":
+			var attempts uint64
+			attempts, err = strconv.ParseUint(nv[1], 10, 32)
+			if err == nil && attempts == 0 {
+				err = fmt.Errorf("at least one attempt is required")
+			}
+			policy.Attempts = uint(attempts)
+		case "backoff":
+			policy.Backoff, err = time.ParseDuration(nv[1])
+		case "max-backoff":
+			policy.MaxBackoff, err = time.ParseDuration(nv[1])
+		case "timeout":
+			policy.Timeout, err = time.ParseDuration(nv[1])
+		case "query-timeout":
+			policy.QueryTimeout, err = time.ParseDuration(nv[1])
+		default:
+			err = fmt.Errorf("unknown field %q", nv[0])
+		}
+		if err != nil {
+			return policy, err
+		}
+	}
+	return policy, nil
+}
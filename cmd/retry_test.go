@@ -0,0 +1,31 @@
+package cmd
+
+import (
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/balchua/bopbag/pkg/usecase"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustParseRetryPolicies(t *testing.T) {
+	policies, err := parseRetryPolicies([]string{
+		"*=timeout:3s",
+		"task.add=attempts:5,backoff:20ms,max-backoff:2s,query-timeout:500ms",
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, usecase.RetryPolicy{Attempts: 5, Backoff: 20 * time.Millisecond, MaxBackoff: 2 * time.Second, Timeout: 3 * time.Second,
+		QueryTimeout: 500 * time.Millisecond},
+		policies[domain.OperationTaskAdd])
+	assert.Equal(t, 3*time.Second, policies[domain.OperationTaskFindAll].Timeout)
+	assert.Equal(t, usecase.DefaultRetryPolicies()[domain.OperationTaskUpdate].Attempts, policies[domain.OperationTaskUpdate].Attempts)
+}
+
+func TestFailParseRetryPolicies(t *testing.T) {
+	for _, setting := range []string{"task.add", "task.explode=attempts:1", "task.add=attempts:0", "task.add=retries:3", "task.add=timeout:soon", "task.add=query-timeout:-"} {
+		_, err := parseRetryPolicies([]string{setting})
+		assert.NotNilf(t, err, "%q must be rejected", setting)
+	}
+}
@@ -17,14 +17,19 @@ package cmd
 
 import (
 	"context"
+	"net"
 	"os"
 	"os/signal"
 	"strconv"
+	"time"
 
 	"github.com/balchua/bopbag/pkg/applog"
 	"github.com/balchua/bopbag/pkg/controller"
+	"github.com/balchua/bopbag/pkg/discovery"
+	"github.com/balchua/bopbag/pkg/domain"
 	"github.com/balchua/bopbag/pkg/infrastructure"
 	"github.com/balchua/bopbag/pkg/repository"
+	"github.com/balchua/bopbag/pkg/snowflake"
 	"github.com/balchua/bopbag/pkg/usecase"
 	fiber "github.com/gofiber/fiber/v2"
 	"github.com/spf13/cobra"
@@ -51,10 +56,52 @@ var (
 	taskService    *usecase.TaskService
 	taskController *controller.TaskController
 
+	projectController *controller.ProjectController
 	clusterController *controller.ClusterController
 	clusterService    *usecase.ClusterService
 	applogger         *applog.Logger
 	enableTls         bool
+	clusterId         string
+	bootstrap         bool
+	discoveryDns      string
+	discoverySrv      string
+	discoveryPort     int
+	discoveryTimeout  time.Duration
+	discoveryInterval time.Duration
+	bootstrapQuorum   int
+	peersFile         string
+	multicastGroup    string
+	multicast         *discovery.Multicast
+	decommission      bool
+	healingController *controller.HealingController
+	healingService    *usecase.HealingService
+	autoHeal          bool
+	healInterval      time.Duration
+	healGracePeriod   time.Duration
+	healRemoveDead    bool
+	voters            int
+	standBys          int
+	rolesFrequency    time.Duration
+	failureDomain     string
+	apiUrl            string
+	heartbeatInterval time.Duration
+	registryService   *usecase.NodeRegistryService
+	upgradeService    *usecase.UpgradeService
+	upgradeController *controller.UpgradeController
+	migrationInterval time.Duration
+	faultInjection    bool
+	adminToken        string
+	faultService      *usecase.FaultService
+	faultController   *controller.FaultController
+	retryPolicies     []string
+	requestTimeout    time.Duration
+	idempotencyTtl    time.Duration
+	idempotency       *usecase.IdempotencyService
+	tenantService     *usecase.TenantService
+	tenantController  *controller.TenantController
+	trustTenantHeader bool
+	quotaService      *usecase.QuotaService
+	quotaController   *controller.QuotaController
 )
 
 func init() {
@@ -65,32 +112,158 @@ func init() {
 	serveCmd.PersistentFlags().StringVar(&dbAddress, "dbAddress", "localhost:9000", "the database port ex. localhost:9000")
 	serveCmd.PersistentFlags().BoolVar(&enableTls, "enableTls", true, "Enable secure mode")
 	serveCmd.PersistentFlags().StringVar(&certsPath, "certs", "./", "Path to dqlite certificates")
+	serveCmd.PersistentFlags().StringVar(&clusterId, "cluster-id", "", "Identity of the cluster this node must belong to, a cluster started with --bootstrap gets this identity")
+	serveCmd.PersistentFlags().BoolVar(&bootstrap, "bootstrap", false, "Bootstrap a new cluster from an empty data directory, only for the first start of the first node")
+	serveCmd.PersistentFlags().StringVar(&discoveryDns, "discovery-dns", "", "DNS name resolving to all the peers, ex. a headless service")
+	serveCmd.PersistentFlags().StringVar(&discoverySrv, "discovery-srv", "", "SRV record listing all the peers, ex. _dqlite._tcp.bopbag-headless")
+	serveCmd.PersistentFlags().IntVar(&discoveryPort, "discovery-port", 9000, "dqlite port of the peers resolved with --discovery-dns")
+	serveCmd.PersistentFlags().DurationVar(&discoveryTimeout, "discovery-timeout", 5*time.Minute, "How long to look for peers before giving up")
+	serveCmd.PersistentFlags().DurationVar(&discoveryInterval, "discovery-interval", 5*time.Second, "Time between two peer discovery rounds")
+	serveCmd.PersistentFlags().StringVar(&peersFile, "peers-file", "", "File listing the dqlite address of the peers, one per line")
+	serveCmd.PersistentFlags().StringVar(&multicastGroup, "discovery-multicast", "", "UDP multicast group used to find peers on the local network, ex. 239.255.42.99:9999")
+	serveCmd.PersistentFlags().BoolVar(&decommission, "decommission-on-shutdown", false, "Remove this node from the cluster when it shuts down")
+	serveCmd.PersistentFlags().IntVar(&voters, "voters", 3, "Desired number of voters, must be an odd number greater than 1")
+	serveCmd.PersistentFlags().IntVar(&standBys, "standbys", 3, "Desired number of stand-bys, must be an odd number")
+	serveCmd.PersistentFlags().DurationVar(&rolesFrequency, "roles-adjustment-frequency", 30*time.Second, "How often the leader reassigns the roles of the nodes")
+	serveCmd.PersistentFlags().StringVar(&failureDomain, "failure-domain", "", "Zone or rack of this node, voters are spread across failure domains")
+	serveCmd.PersistentFlags().BoolVar(&autoHeal, "auto-heal", false, "Replace the voters which stay unreachable with healthy stand-bys or spares")
+	serveCmd.PersistentFlags().DurationVar(&healInterval, "heal-interval", 10*time.Second, "Time between two auto-healing probes")
+	serveCmd.PersistentFlags().DurationVar(&healGracePeriod, "heal-grace-period", time.Minute, "How long a node must stay unreachable before it is considered dead")
+	serveCmd.PersistentFlags().BoolVar(&healRemoveDead, "heal-remove-dead", false, "Remove dead nodes from the cluster")
+	serveCmd.PersistentFlags().StringVar(&apiUrl, "api-url", "", "URL other nodes and clients use to reach the API of this node (default http://<hostname>:<port>)")
+	serveCmd.PersistentFlags().DurationVar(&heartbeatInterval, "heartbeat-interval", 10*time.Second, "How often this node refreshes its heartbeat in the node registry")
+	serveCmd.PersistentFlags().DurationVar(&migrationInterval, "migration-interval", 30*time.Second, "How often the leader checks whether every voter supports the pending schema migrations")
+	serveCmd.PersistentFlags().BoolVar(&faultInjection, "fault-injection", false, "Enable the admin API injecting faults in this node, for chaos testing only")
+	serveCmd.PersistentFlags().StringVar(&adminToken, "admin-token", "", "Token the admin API expects in the X-Admin-Token header")
+	serveCmd.PersistentFlags().StringArrayVar(&retryPolicies, "retry-policy", []string{}, "Retry policy of a task operation, ex. task.add=attempts:5,backoff:20ms,max-backoff:2s,timeout:5s,query-timeout:1s, * sets every operation")
+	serveCmd.PersistentFlags().DurationVar(&requestTimeout, "request-timeout", 30*time.Second, "Time after which a request still waiting for the database is abandoned, 0 disables it")
+	serveCmd.PersistentFlags().DurationVar(&idempotencyTtl, "idempotency-ttl", 24*time.Hour, "How long the response of a request carrying an Idempotency-Key header is replayed to its retries")
+	serveCmd.PersistentFlags().BoolVar(&trustTenantHeader, "trust-tenant-header", false, "Trust the X-Tenant-Id header naming the tenant of a request, set by an authenticating proxy in front of the nodes")
+	serveCmd.PersistentFlags().IntVar(&bootstrapQuorum, "bootstrap-quorum", -1, "Number of peers which must agree no cluster exists before bootstrapping one, -1 waits for a majority of --voters")
 
 }
 
 func startWiring() {
-	retries := 5000
+	policies, err := parseRetryPolicies(retryPolicies)
+	if err != nil {
+		applogger.Log.Fatal("invalid retry policy", zap.Error(err))
+	}
 	taskRepo, _ = repository.NewTaskRepository(applogger, dqliteInst.DB())
-	taskService = usecase.NewTaskService(taskRepo, uint(retries), applogger)
+	var tasks domain.TaskRepository = taskRepo
+	if faultService != nil {
+		tasks = repository.NewFaultyTaskRepository(taskRepo, faultService)
+		faultController = controller.NewFaultController(faultService)
+	}
+	snowflakeNode, err := repository.NewSnowflakeNodeRepository(applogger, dqliteInst.DB()).Allocate(dqliteInst.NodeID())
+	if err != nil {
+		applogger.Log.Fatal("unable to allocate the snowflake node number", zap.Error(err))
+	}
+	taskService = usecase.NewTaskService(tasks, snowflake.NewGenerator(uint64(snowflakeNode)), policies, applogger)
+	idempotency = usecase.NewIdempotencyService(repository.NewIdempotencyRepository(applogger, dqliteInst.DB()),
+		idempotencyConfig(), applogger)
 	clusterRepo = repository.NewClusterRepository(dqliteInst)
-	clusterService = usecase.NewClusterService(clusterRepo, applogger)
+	nodeRepo := repository.NewNodeRegistryRepository(applogger, dqliteInst.DB())
+	clusterService = usecase.NewClusterService(clusterRepo, nodeRepo, applogger)
 	taskController = controller.NewTaskController(taskService)
+	projectController = controller.NewProjectController(usecase.NewProjectService(
+		repository.NewProjectRepository(applogger, dqliteInst.DB()), tasks, applogger))
+	tenantRepo := repository.NewTenantRepository(applogger, dqliteInst.DB())
+	tenantService = usecase.NewTenantService(tenantRepo, applogger)
+	tenantController = controller.NewTenantController(tenantService)
+	quotaService = usecase.NewQuotaService(repository.NewQuotaRepository(applogger, dqliteInst.DB()), tenantRepo, applogger)
+	quotaController = controller.NewQuotaController(quotaService)
 	clusterController = controller.NewClusterController(clusterService)
+	healingCfg := usecase.HealingConfig{
+		Interval:    healInterval,
+		GracePeriod: healGracePeriod,
+		RemoveDead:  healRemoveDead,
+	}
+	healingService = usecase.NewHealingService(clusterRepo, repository.NewHealingAuditRepository(applogger, dqliteInst.DB()),
+		healingCfg, applogger)
+	healingController = controller.NewHealingController(healingService)
+	registryService = usecase.NewNodeRegistryService(nodeRepo, selfMetadata(), heartbeatInterval, applogger)
+	upgradeService = usecase.NewUpgradeService(clusterRepo, nodeRepo, applogger)
+	upgradeController = controller.NewUpgradeController(upgradeService)
 
 }
 
-func startAppServer() {
+func idempotencyConfig() usecase.IdempotencyConfig {
+	// a request holds its key for as long as it may run
+	lease := requestTimeout + 10*time.Second
+	if requestTimeout <= 0 {
+		lease = 5 * time.Minute
+	}
+	return usecase.IdempotencyConfig{
+		TTL:           idempotencyTtl,
+		Lease:         lease,
+		PurgeInterval: 10 * time.Minute,
+	}
+}
+
+func selfMetadata() domain.NodeMetadata {
+	hostname, err := os.Hostname()
+	if err != nil {
+		applogger.Log.Warn("unable to read the hostname", zap.Error(err))
+	}
+	url := apiUrl
+	if url == "" {
+		url = "http://" + net.JoinHostPort(hostname, strconv.Itoa(port))
+	}
+	return domain.NodeMetadata{
+		Address:       dbAddress,
+		ApiUrl:        url,
+		Version:       version,
+		StartTime:     time.Now().Format(time.RFC1123),
+		Hostname:      hostname,
+		FailureDomain: failureDomain,
+	}
+}
+
+func startAppServer(ctx context.Context) {
 	// Fiber instance
-	app := fiber.New()
+	app := fiber.New(fiber.Config{ErrorHandler: controller.ErrorHandler})
+	app.Use(controller.RequestContext(ctx, requestTimeout))
+
+	// without an admin token the admin API rejects every request
+	admin := app.Group("/api/v1/admin", controller.AdminOnly(adminToken))
+	admin.Get("/tenants", tenantController.FindAll)
+	admin.Get("/tenants/:id", tenantController.FindById)
+	admin.Post("/tenants", tenantController.NewTenant)
+	admin.Delete("/tenants/:id", tenantController.DeleteTenant)
+	admin.Get("/tenants/:id/quota", quotaController.FindByTenant)
+	admin.Put("/tenants/:id/quota", quotaController.SetQuota)
+	if faultService != nil {
+		// registered before the freeze so that a frozen node can still be thawed
+		admin.Get("/faults", faultController.ShowFaults)
+		admin.Post("/faults", faultController.Inject)
+		admin.Delete("/faults/:id", faultController.Clear)
+		admin.Delete("/faults", faultController.ClearAll)
+		app.Use(controller.Frozen(faultService))
+	}
 
 	// Routes
-	app.Get("/api/v1/task/:id", taskController.FindById)
-	app.Get("/api/v1/tasks", taskController.FindAll)
-	app.Post("/api/v1/task", taskController.NewTask)
-	app.Put("/api/v1/task/:id", taskController.UpdateTask)
-	app.Delete("/api/v1/task/:id", taskController.DeleteTask)
+	tenant := controller.Tenant(tenantService, trustTenantHeader)
+	// the requests of a tenant are counted once it is known
+	limited := controller.RateLimit(quotaService)
+	app.Get("/api/v1/task/:id", tenant, limited, taskController.FindById)
+	app.Get("/api/v1/tasks", tenant, limited, taskController.FindAll)
+	idempotent := controller.Idempotent(idempotency)
+	app.Post("/api/v1/task", tenant, limited, idempotent, taskController.NewTask)
+	app.Put("/api/v1/task/:id", tenant, limited, idempotent, taskController.UpdateTask)
+	app.Patch("/api/v1/task/:id", tenant, limited, idempotent, taskController.PatchTask)
+	app.Delete("/api/v1/task/:id", tenant, limited, idempotent, taskController.DeleteTask)
+	app.Get("/api/v1/project/:id", tenant, limited, projectController.FindById)
+	app.Get("/api/v1/project/:id/tasks", tenant, limited, projectController.FindTasks)
+	app.Get("/api/v1/projects", tenant, limited, projectController.FindAll)
+	app.Post("/api/v1/project", tenant, limited, idempotent, projectController.NewProject)
+	app.Put("/api/v1/project/:id", tenant, limited, idempotent, projectController.UpdateProject)
+	app.Delete("/api/v1/project/:id", tenant, limited, idempotent, projectController.DeleteProject)
 	app.Get("/api/v1/clusterInfo", clusterController.ShowCluster)
 	app.Delete("/api/v1/node/:nodeId", clusterController.RemoveNode)
+	app.Post("/api/v1/node/self/leave", clusterController.Leave)
+	app.Post("/api/v1/node/self/handover", clusterController.Handover)
+	app.Get("/api/v1/cluster/upgrade-plan", upgradeController.ShowPlan)
+	app.Get("/api/v1/healing/audit", healingController.ShowAudit)
 
 	appErr := app.Listen(":" + strconv.Itoa(port))
 	if appErr != nil {
@@ -98,9 +271,90 @@ func startAppServer() {
 	}
 }
 
-func startDqLite() {
+func newDiscoverer() (discovery.Discoverer, error) {
 	var err error
-	dqliteInst, err = infrastructure.NewDqlite(applogger, dbPath, dbAddress, join, enableTls, certsPath)
+	switch {
+	case discoveryDns != "" || discoverySrv != "":
+		return discovery.NewDNS(discoveryDns, discoverySrv, discoveryPort), nil
+	case peersFile != "":
+		return discovery.NewStaticFile(peersFile), nil
+	case multicastGroup != "":
+		// keeps announcing this node for as long as it runs
+		multicast, err = discovery.NewMulticast(multicastGroup, dbAddress, 2*time.Second)
+		if err != nil {
+			return nil, err
+		}
+		return multicast, nil
+	}
+	return nil, nil
+}
+
+func discoverPeers(discoverer discovery.Discoverer) {
+	initialized, err := infrastructure.IsInitialized(dbPath)
+	if err != nil {
+		applogger.Log.Fatal("unable to read the data directory", zap.Error(err))
+	}
+	if initialized {
+		// dqlite already knows its cluster
+		return
+	}
+
+	dial, err := infrastructure.NewDialFunc(enableTls, certsPath)
+	if err != nil {
+		applogger.Log.Fatal("unable to load the certificates", zap.Error(err))
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), discoveryTimeout)
+	defer cancel()
+
+	quorum := discoveryQuorum(bootstrapQuorum, voters)
+	if quorum == 0 {
+		applogger.Log.Warn("a node which cannot reach its peers bootstraps a cluster of its own, set --bootstrap-quorum")
+	}
+	cfg := discovery.Config{
+		Address:  dbAddress,
+		Quorum:   quorum,
+		Interval: discoveryInterval,
+		Dial:     dial,
+	}
+	join, err = discovery.Join(ctx, discoverer, cfg, applogger)
+	if err != nil {
+		applogger.Log.Fatal("unable to discover peers", zap.Error(err))
+	}
+}
+
+// discoveryQuorum is the number of peers which must agree no cluster exists, a negative quorum waits for
+// the peers which make a majority of the voters with this node.
+func discoveryQuorum(quorum int, voters int) int {
+	if quorum < 0 {
+		return voters / 2
+	}
+	return quorum
+}
+
+func startDqLite() {
+	discoverer, err := newDiscoverer()
+	if err != nil {
+		applogger.Log.Fatal("unable to start peer discovery", zap.Error(err))
+	}
+	if discoverer != nil && len(join) == 0 {
+		discoverPeers(discoverer)
+		// the peers agreed that no cluster exists yet
+		bootstrap = bootstrap || len(join) == 0
+	}
+	options := []infrastructure.Option{
+		infrastructure.WithClusterId(clusterId),
+		infrastructure.WithVoters(voters),
+		infrastructure.WithStandBys(standBys),
+		infrastructure.WithRolesAdjustmentFrequency(rolesFrequency),
+		infrastructure.WithFailureDomain(failureDomain),
+	}
+	if bootstrap {
+		options = append(options, infrastructure.WithBootstrap())
+	}
+	if faultService != nil {
+		options = append(options, infrastructure.WithPeerGate(faultService))
+	}
+	dqliteInst, err = infrastructure.NewDqlite(applogger, dbPath, dbAddress, join, enableTls, certsPath, options...)
 
 	if err != nil {
 		applogger.Log.Fatal("unable to instantiate dqlite", zap.Error(err))
@@ -109,6 +363,14 @@ func startDqLite() {
 }
 
 func shutdownDqlite() {
+	if multicast != nil {
+		multicast.Close()
+	}
+	if decommission {
+		if err := dqliteInst.Leave(); err != nil {
+			applogger.Log.Error("unable to leave the cluster", zap.Error(err))
+		}
+	}
 	dqliteInst.Shutdown(context.Background())
 
 }
@@ -116,16 +378,37 @@ func shutdownDqlite() {
 func start(cmd *cobra.Command, args []string) {
 
 	applogger = applog.NewLogger()
+	if faultInjection {
+		if adminToken == "" {
+			applogger.Log.Fatal("fault injection requires an admin token")
+		}
+		applogger.Log.Warn("fault injection is enabled, do not use in production")
+		faultService = usecase.NewFaultService(applogger)
+	}
 	startDqLite()
 	startWiring()
-	startAppServer()
+	// cancelled at shutdown, it also abandons the requests in flight
+	ctx, cancel := context.WithCancel(context.Background())
+	go startAppServer(ctx)
+
+	if err := registryService.Register(); err != nil {
+		applogger.Log.Error("unable to register the node", zap.Error(err))
+	}
+	go registryService.Run(ctx)
+	go upgradeService.Run(ctx, migrationInterval)
+	go idempotency.Run(ctx)
+	go quotaService.Run(ctx)
+	if autoHeal {
+		go healingService.Run(ctx)
+	}
 
-	ch := make(chan os.Signal)
+	ch := make(chan os.Signal, 1)
 	signal.Notify(ch, unix.SIGPWR)
 	signal.Notify(ch, unix.SIGINT)
 	signal.Notify(ch, unix.SIGQUIT)
 	signal.Notify(ch, unix.SIGTERM)
 	<-ch
 
+	cancel()
 	shutdownDqlite()
 }
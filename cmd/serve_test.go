@@ -1,6 +1,7 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -53,8 +54,15 @@ func TestStartApplication(t *testing.T) {
 	defer shutdownDqlite()
 
 	startWiring()
-	go startAppServer()
+	go startAppServer(context.Background())
 
 	time.Sleep(5 * time.Second)
 	assert.True(t, isOpened("0.0.0.0", 8000))
 }
+
+func TestMustWaitForAMajorityOfTheVotersByDefault(t *testing.T) {
+	assert.Equal(t, 1, discoveryQuorum(-1, 3))
+	assert.Equal(t, 2, discoveryQuorum(-1, 5))
+	assert.Equal(t, 0, discoveryQuorum(0, 3))
+	assert.Equal(t, 4, discoveryQuorum(4, 3))
+}
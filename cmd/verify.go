@@ -0,0 +1,91 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+
+	"github.com/anishathalye/porcupine"
+	"github.com/balchua/bopbag/pkg/verify"
+	"github.com/spf13/cobra"
+)
+
+// verifyCmd represents the verify command
+var (
+	verifyCmd = &cobra.Command{
+		Use:   "verify",
+		Short: "Checks that task reads and writes are linearizable",
+		Long: `Drives concurrent create, read, update and delete operations against the REST API of one or more nodes,
+records their history and checks it with a linearizability checker.
+Run it while injecting faults, ex. with chaoskube, the history, the report and a visualization are written to --out.`,
+		RunE: runVerify,
+	}
+	verifyUrls           []string
+	verifyClients        int
+	verifyDuration       time.Duration
+	verifyTasks          int
+	verifyRequestTimeout time.Duration
+	verifyCheckTimeout   time.Duration
+	verifyOut            string
+)
+
+func init() {
+	rootCmd.AddCommand(verifyCmd)
+	verifyCmd.Flags().StringSliceVar(&verifyUrls, "url", []string{"http://localhost:8000"}, "API URL of the nodes, the clients are spread across them")
+	verifyCmd.Flags().IntVar(&verifyClients, "clients", 5, "Number of concurrent clients")
+	verifyCmd.Flags().DurationVar(&verifyDuration, "duration", time.Minute, "How long to send operations")
+	verifyCmd.Flags().IntVar(&verifyTasks, "tasks", 10, "Number of tasks the clients operate on at the same time")
+	verifyCmd.Flags().DurationVar(&verifyRequestTimeout, "request-timeout", 5*time.Second, "How long a client waits for an answer")
+	verifyCmd.Flags().DurationVar(&verifyCheckTimeout, "check-timeout", 5*time.Minute, "How long the checker searches for a linearization")
+	verifyCmd.Flags().StringVar(&verifyOut, "out", "verify-report", "Directory receiving the history, the report and the visualization")
+}
+
+func printVerifyReport(out io.Writer, report *verify.Report) {
+	fmt.Fprintf(out, "%d operations, %d checked\n", report.Operations, report.Checked)
+	ops := make([]string, 0, len(report.Counts))
+	for op := range report.Counts {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		counts := report.Counts[op]
+		fmt.Fprintf(out, "  %-6s ok %d, not found %d, unknown %d\n", op, counts[verify.ResultOk], counts[verify.ResultNotFound],
+			counts[verify.ResultUnknown])
+	}
+	switch report.Result {
+	case porcupine.Ok:
+		fmt.Fprintln(out, "history is linearizable")
+	case porcupine.Illegal:
+		fmt.Fprintf(out, "history is NOT linearizable, tasks %v\n", report.Violations)
+	default:
+		fmt.Fprintln(out, "the checker timed out, no violation found")
+	}
+	if report.Visualization != "" {
+		fmt.Fprintf(out, "visualization: %s\n", report.Visualization)
+	}
+}
+
+func runVerify(cmd *cobra.Command, args []string) error {
+	cfg := verify.Config{
+		Urls:           verifyUrls,
+		Clients:        verifyClients,
+		Duration:       verifyDuration,
+		Tasks:          verifyTasks,
+		RequestTimeout: verifyRequestTimeout,
+	}
+	history, err := verify.Run(context.Background(), cfg)
+	if err != nil {
+		return err
+	}
+	report, err := verify.Check(history, verifyCheckTimeout, verifyOut)
+	if err != nil {
+		return err
+	}
+	printVerifyReport(cmd.OutOrStdout(), report)
+	if report.Result == porcupine.Illegal {
+		return fmt.Errorf("linearizability violation")
+	}
+	return nil
+}
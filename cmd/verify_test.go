@@ -0,0 +1,32 @@
+package cmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/anishathalye/porcupine"
+	"github.com/balchua/bopbag/pkg/verify"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustPrintVerifyReport(t *testing.T) {
+	var out bytes.Buffer
+	report := &verify.Report{
+		Result:     porcupine.Illegal,
+		Operations: 12,
+		Checked:    10,
+		Counts: map[string]map[string]int{
+			verify.OpRead:   {verify.ResultOk: 6, verify.ResultUnknown: 2},
+			verify.OpCreate: {verify.ResultOk: 4},
+		},
+		Violations:    []int64{3},
+		Visualization: "verify-report/visualization.html",
+	}
+
+	printVerifyReport(&out, report)
+
+	assert.Contains(t, out.String(), "12 operations, 10 checked")
+	assert.Contains(t, out.String(), "read   ok 6, not found 0, unknown 2")
+	assert.Contains(t, out.String(), "NOT linearizable, tasks [3]")
+	assert.Contains(t, out.String(), "verify-report/visualization.html")
+}
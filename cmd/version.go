@@ -0,0 +1,8 @@
+package cmd
+
+// version is set at build time, ex. go build -ldflags "-X github.com/balchua/bopbag/cmd.version=v0.2.0"
+var version = "dev"
+
+func init() {
+	rootCmd.Version = version
+}
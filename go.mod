@@ -3,10 +3,12 @@ module github.com/balchua/bopbag
 go 1.16
 
 require (
-	github.com/DATA-DOG/go-sqlmock v1.5.0 // indirect
+	github.com/DATA-DOG/go-sqlmock v1.5.0
 	github.com/Rican7/retry v0.3.1
 	github.com/andybalholm/brotli v1.0.3 // indirect
+	github.com/anishathalye/porcupine v1.0.0
 	github.com/canonical/go-dqlite v1.9.0
+	github.com/ghodss/yaml v1.0.0
 	github.com/gofiber/fiber/v2 v2.19.0
 	github.com/klauspost/compress v1.13.6 // indirect
 	github.com/pkg/errors v0.9.1
@@ -0,0 +1,156 @@
+// Package apiclient calls the task REST API of a bopbag node.
+package apiclient
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/domain"
+)
+
+// ErrNotFound is returned when the task does not exist.
+var ErrNotFound = errors.New("task not found")
+
+// StatusError is returned when the node answers with an unexpected status.
+type StatusError struct {
+	Code int
+	// ProblemCode is the stable error code of the problem the node answered with, if any.
+	ProblemCode string
+	Message     string
+}
+
+func (e *StatusError) Error() string {
+	return fmt.Sprintf("%d %s", e.Code, e.Message)
+}
+
+// problem is the part of the RFC 7807 answers the client reads.
+type problem struct {
+	Code   string `json:"code"`
+	Detail string `json:"detail"`
+}
+
+type Client struct {
+	baseUrl string
+	http    *http.Client
+}
+
+// New returns a client of the node serving the API at baseUrl, ex. http://localhost:8000
+func New(baseUrl string, timeout time.Duration) *Client {
+	return &Client{
+		baseUrl: strings.TrimSuffix(baseUrl, "/"),
+		http:    &http.Client{Timeout: timeout},
+	}
+}
+
+// BaseUrl returns the URL of the node.
+func (c *Client) BaseUrl() string {
+	return c.baseUrl
+}
+
+func (c *Client) CreateTask(ctx context.Context, task *domain.Task) (*domain.Task, error) {
+	var created domain.Task
+	if err := c.do(ctx, http.MethodPost, "/api/v1/task", task, &created); err != nil {
+		return nil, err
+	}
+	return &created, nil
+}
+
+func (c *Client) GetTask(ctx context.Context, id int64) (*domain.Task, error) {
+	var task domain.Task
+	if err := c.do(ctx, http.MethodGet, "/api/v1/task/"+strconv.FormatInt(id, 10), nil, &task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (c *Client) UpdateTask(ctx context.Context, task *domain.Task) (*domain.Task, error) {
+	var updated domain.Task
+	if err := c.do(ctx, http.MethodPut, "/api/v1/task/"+strconv.FormatInt(task.Id, 10), task, &updated); err != nil {
+		return nil, err
+	}
+	return &updated, nil
+}
+
+func (c *Client) DeleteTask(ctx context.Context, id int64) error {
+	return c.do(ctx, http.MethodDelete, "/api/v1/task/"+strconv.FormatInt(id, 10), nil, nil)
+}
+
+func (c *Client) ListTasks(ctx context.Context) (*[]domain.Task, error) {
+	var tasks []domain.Task
+	if err := c.do(ctx, http.MethodGet, "/api/v1/tasks", nil, &tasks); err != nil {
+		return nil, err
+	}
+	return &tasks, nil
+}
+
+// ClusterInfo lists the members of the cluster the node belongs to.
+func (c *Client) ClusterInfo(ctx context.Context) ([]domain.ClusterInfo, error) {
+	var members []domain.ClusterInfo
+	if err := c.do(ctx, http.MethodGet, "/api/v1/clusterInfo", nil, &members); err != nil {
+		return nil, err
+	}
+	return members, nil
+}
+
+// Handover asks the node to transfer its leadership to another voter.
+func (c *Client) Handover(ctx context.Context) error {
+	return c.do(ctx, http.MethodPost, "/api/v1/node/self/handover", nil, nil)
+}
+
+func (c *Client) do(ctx context.Context, method string, path string, in interface{}, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseUrl+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if key := domain.IdempotencyKeyOf(ctx); key != "" {
+		// set with domain.WithIdempotencyKey, a retry with the same key is not applied twice
+		req.Header.Set(domain.IdempotencyKeyHeader, key)
+	}
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	data, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+	if resp.StatusCode != http.StatusOK {
+		message := strings.TrimSpace(string(data))
+		// older nodes report a missing task as unavailable
+		if resp.StatusCode == http.StatusNotFound || strings.Contains(message, "no rows in result set") {
+			return fmt.Errorf("%w: %s", ErrNotFound, message)
+		}
+		statusErr := &StatusError{Code: resp.StatusCode, Message: message}
+		var p problem
+		if strings.HasPrefix(resp.Header.Get("Content-Type"), "application/problem+json") && json.Unmarshal(data, &p) == nil {
+			statusErr.ProblemCode = p.Code
+			statusErr.Message = p.Detail
+		}
+		return statusErr
+	}
+	if out == nil {
+		return nil
+	}
+	return json.Unmarshal(data, out)
+}
@@ -0,0 +1,110 @@
+package apiclient
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustCreateTask(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/v1/task", r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Contains(t, string(body), `"title":"verify"`)
+		w.Write([]byte(`{"id": "12", "title": "verify", "details": "c0-1"}`))
+	}))
+	defer server.Close()
+
+	task, err := New(server.URL, time.Second).CreateTask(context.Background(), &domain.Task{Title: "verify", Details: "c0-1"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(12), task.Id)
+}
+
+func TestMustSendTheIdempotencyKey(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "c0-1", r.Header.Get(domain.IdempotencyKeyHeader))
+		w.Write([]byte(`{"id": 12, "title": "verify", "details": "c0-1"}`))
+	}))
+	defer server.Close()
+	ctx := domain.WithIdempotencyKey(context.Background(), "c0-1")
+
+	_, err := New(server.URL, time.Second).CreateTask(ctx, &domain.Task{Title: "verify", Details: "c0-1"})
+
+	assert.Nil(t, err)
+}
+
+func TestMustReportMissingTask(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "sql: no rows in result set", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL, time.Second).GetTask(context.Background(), 12)
+
+	assert.True(t, errors.Is(err, ErrNotFound))
+}
+
+func TestFailOnUnavailableNode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "no leader", http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	err := New(server.URL, time.Second).DeleteTask(context.Background(), 12)
+
+	var statusErr *StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, http.StatusServiceUnavailable, statusErr.Code)
+	assert.False(t, errors.Is(err, ErrNotFound))
+}
+
+func TestMustListTasks(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "/api/v1/tasks", r.URL.Path)
+		w.Write([]byte(`[{"id": 1}, {"id": 2}]`))
+	}))
+	defer server.Close()
+
+	tasks, err := New(server.URL, time.Second).ListTasks(context.Background())
+
+	assert.Nil(t, err)
+	assert.Len(t, *tasks, 2)
+}
+
+func TestMustHandover(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPost, r.Method)
+		assert.Equal(t, "/api/v1/node/self/handover", r.URL.Path)
+		w.Write([]byte(`"leadership handed over"`))
+	}))
+	defer server.Close()
+
+	err := New(server.URL, time.Second).Handover(context.Background())
+
+	assert.Nil(t, err)
+}
+
+func TestMustReadProblemCode(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/problem+json")
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte(`{"type": "urn:bopbag:problem:no_leader", "status": 503, "code": "NO_LEADER", "detail": "no dqlite leader is available"}`))
+	}))
+	defer server.Close()
+
+	_, err := New(server.URL, time.Second).GetTask(context.Background(), 12)
+
+	var statusErr *StatusError
+	assert.True(t, errors.As(err, &statusErr))
+	assert.Equal(t, "NO_LEADER", statusErr.ProblemCode)
+	assert.Equal(t, "no dqlite leader is available", statusErr.Message)
+}
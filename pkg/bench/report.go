@@ -0,0 +1,175 @@
+package bench
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/apiclient"
+)
+
+// sample is an operation sent during the run, its times are offsets from the beginning of the run.
+type sample struct {
+	op    string
+	node  string
+	start time.Duration
+	end   time.Duration
+	err   string
+}
+
+func (s sample) latency() time.Duration {
+	return s.end - s.start
+}
+
+func (s sample) write() bool {
+	return s.op == OpCreate || s.op == OpUpdate || s.op == OpDelete
+}
+
+// Stats summarizes a set of operations, the latencies are the ones of the successful operations.
+type Stats struct {
+	Count      int           `json:"count"`
+	Errors     int           `json:"errors"`
+	Throughput float64       `json:"throughput"`
+	P50        time.Duration `json:"p50"`
+	P99        time.Duration `json:"p99"`
+	P999       time.Duration `json:"p999"`
+	Max        time.Duration `json:"max"`
+}
+
+// Failover describes the impact of the leader transfer triggered during the run.
+type Failover struct {
+	// Leader is the API URL of the node asked to hand its leadership over.
+	Leader string `json:"leader,omitempty"`
+	// Start is when the transfer was triggered, from the beginning of the run.
+	Start time.Duration `json:"start"`
+	Error string        `json:"error,omitempty"`
+	// Unavailable is how long it took for a write sent after the start to succeed.
+	Unavailable time.Duration `json:"unavailable"`
+	// Recovered is false when no write sent after the start succeeded.
+	Recovered bool `json:"recovered"`
+	// Errors counts the operations which failed while the writes were unavailable.
+	Errors int `json:"errors"`
+	// MaxLatency is the longest operation in flight while the writes were unavailable.
+	MaxLatency time.Duration `json:"maxLatency"`
+}
+
+// Report summarizes a run.
+type Report struct {
+	Duration   time.Duration    `json:"duration"`
+	Total      Stats            `json:"total"`
+	Operations map[string]Stats `json:"operations"`
+	Nodes      map[string]Stats `json:"nodes"`
+	// Errors counts the failed operations by kind, ex. timeout or status 503.
+	Errors   map[string]int `json:"errors"`
+	Failover *Failover      `json:"failover,omitempty"`
+}
+
+func newReport(samples []sample, duration time.Duration, failover *Failover) *Report {
+	report := &Report{
+		Duration:   duration,
+		Total:      stats(samples, duration),
+		Operations: make(map[string]Stats),
+		Nodes:      make(map[string]Stats),
+		Errors:     make(map[string]int),
+		Failover:   failover,
+	}
+	byOp := make(map[string][]sample)
+	byNode := make(map[string][]sample)
+	for _, s := range samples {
+		byOp[s.op] = append(byOp[s.op], s)
+		byNode[s.node] = append(byNode[s.node], s)
+		if s.err != "" {
+			report.Errors[s.err]++
+		}
+	}
+	for op, group := range byOp {
+		report.Operations[op] = stats(group, duration)
+	}
+	for node, group := range byNode {
+		report.Nodes[node] = stats(group, duration)
+	}
+	if failover != nil && failover.Error == "" {
+		measureFailover(failover, samples, duration)
+	}
+	return report
+}
+
+func stats(samples []sample, duration time.Duration) Stats {
+	latencies := []time.Duration{}
+	st := Stats{Count: len(samples)}
+	for _, s := range samples {
+		if s.err != "" {
+			st.Errors++
+			continue
+		}
+		latencies = append(latencies, s.latency())
+	}
+	if duration > 0 {
+		st.Throughput = float64(len(latencies)) / duration.Seconds()
+	}
+	sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+	st.P50 = percentile(latencies, 0.50)
+	st.P99 = percentile(latencies, 0.99)
+	st.P999 = percentile(latencies, 0.999)
+	st.Max = percentile(latencies, 1)
+	return st
+}
+
+// percentile returns the latency below which the fraction p of the sorted latencies fall.
+func percentile(sorted []time.Duration, p float64) time.Duration {
+	if len(sorted) == 0 {
+		return 0
+	}
+	n := int(math.Ceil(p*float64(len(sorted)))) - 1
+	if n < 0 {
+		n = 0
+	}
+	return sorted[n]
+}
+
+// measureFailover looks for the first write sent after the transfer started which succeeded.
+func measureFailover(failover *Failover, samples []sample, duration time.Duration) {
+	recovery := duration
+	for _, s := range samples {
+		if s.write() && s.err == "" && s.start >= failover.Start && s.end < recovery {
+			recovery = s.end
+			failover.Recovered = true
+		}
+	}
+	failover.Unavailable = recovery - failover.Start
+	for _, s := range samples {
+		if s.end < failover.Start || s.start > recovery {
+			continue
+		}
+		if s.err != "" {
+			failover.Errors++
+		}
+		if s.latency() > failover.MaxLatency {
+			failover.MaxLatency = s.latency()
+		}
+	}
+}
+
+// errorKind classifies the error of an operation for the breakdown, it is empty on success.
+func errorKind(err error) string {
+	var statusErr *apiclient.StatusError
+	var netErr net.Error
+	switch {
+	case err == nil:
+		return ""
+	case errors.Is(err, apiclient.ErrNotFound):
+		return "not found"
+	case errors.As(err, &statusErr) && statusErr.ProblemCode != "":
+		return fmt.Sprintf("status %d %s", statusErr.Code, statusErr.ProblemCode)
+	case errors.As(err, &statusErr):
+		return fmt.Sprintf("status %d", statusErr.Code)
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "timeout"
+	case errors.As(err, &netErr):
+		return "connection"
+	}
+	return "other"
+}
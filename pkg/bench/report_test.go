@@ -0,0 +1,57 @@
+package bench
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/apiclient"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustComputePercentiles(t *testing.T) {
+	samples := []sample{}
+	for i := 1; i <= 1000; i++ {
+		samples = append(samples, sample{op: OpGet, end: time.Duration(i) * time.Millisecond})
+	}
+	samples = append(samples, sample{op: OpGet, end: time.Hour, err: "timeout"})
+
+	st := stats(samples, 10*time.Second)
+
+	assert.Equal(t, 1001, st.Count)
+	assert.Equal(t, 1, st.Errors)
+	assert.Equal(t, 100.0, st.Throughput)
+	assert.Equal(t, 500*time.Millisecond, st.P50)
+	assert.Equal(t, 990*time.Millisecond, st.P99)
+	assert.Equal(t, 999*time.Millisecond, st.P999)
+	assert.Equal(t, time.Second, st.Max)
+}
+
+func TestMustMeasureFailover(t *testing.T) {
+	samples := []sample{
+		{op: OpCreate, start: 0, end: 10 * time.Millisecond},
+		{op: OpCreate, start: 95 * time.Millisecond, end: 400 * time.Millisecond, err: "status 503"},
+		{op: OpGet, start: 150 * time.Millisecond, end: 160 * time.Millisecond},
+		{op: OpUpdate, start: 200 * time.Millisecond, end: 450 * time.Millisecond},
+		{op: OpCreate, start: 410 * time.Millisecond, end: 600 * time.Millisecond},
+		{op: OpDelete, start: 500 * time.Millisecond, end: 510 * time.Millisecond, err: "not found"},
+	}
+	failover := &Failover{Leader: "http://norse:8000", Start: 100 * time.Millisecond}
+
+	report := newReport(samples, time.Second, failover)
+
+	assert.True(t, report.Failover.Recovered)
+	assert.Equal(t, 350*time.Millisecond, report.Failover.Unavailable)
+	assert.Equal(t, 1, report.Failover.Errors)
+	assert.Equal(t, 305*time.Millisecond, report.Failover.MaxLatency)
+	assert.Equal(t, map[string]int{"status 503": 1, "not found": 1}, report.Errors)
+}
+
+func TestMustClassifyErrors(t *testing.T) {
+	assert.Equal(t, "", errorKind(nil))
+	assert.Equal(t, "not found", errorKind(fmt.Errorf("%w: gone", apiclient.ErrNotFound)))
+	assert.Equal(t, "status 503", errorKind(&apiclient.StatusError{Code: http.StatusServiceUnavailable}))
+	assert.Equal(t, "status 503 NO_LEADER", errorKind(&apiclient.StatusError{Code: http.StatusServiceUnavailable, ProblemCode: "NO_LEADER"}))
+	assert.Equal(t, "other", errorKind(fmt.Errorf("boom")))
+}
@@ -0,0 +1,249 @@
+// Package bench measures the throughput and the latencies of the task API of a cluster.
+package bench
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/apiclient"
+	"github.com/balchua/bopbag/pkg/domain"
+)
+
+const (
+	OpCreate = "create"
+	OpGet    = "get"
+	OpList   = "list"
+	OpUpdate = "update"
+	OpDelete = "delete"
+)
+
+// maxIds bounds the tasks the clients remember to get, update and delete.
+const maxIds = 10000
+
+var knownOps = map[string]bool{OpCreate: true, OpGet: true, OpList: true, OpUpdate: true, OpDelete: true}
+
+// Mix weighs the operations sent by the clients.
+type Mix map[string]int
+
+// ParseMix reads weights such as create=20,get=50,list=5,update=20,delete=5, the operations left out are not sent.
+func ParseMix(value string) (Mix, error) {
+	mix := Mix{}
+	for _, part := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 || !knownOps[kv[0]] {
+			return nil, fmt.Errorf("bad mix entry %q, expected <create|get|list|update|delete>=<weight>", part)
+		}
+		weight, err := strconv.Atoi(kv[1])
+		if err != nil || weight < 0 {
+			return nil, fmt.Errorf("bad weight for %s: %q", kv[0], kv[1])
+		}
+		mix[kv[0]] = weight
+	}
+	if mix.total() == 0 {
+		return nil, fmt.Errorf("the mix sends no operation")
+	}
+	return mix, nil
+}
+
+func (m Mix) total() int {
+	total := 0
+	for _, weight := range m {
+		total += weight
+	}
+	return total
+}
+
+// pick returns the operation of weight n, n is between 0 and the total weight.
+func (m Mix) pick(n int) string {
+	ops := make([]string, 0, len(m))
+	for op := range m {
+		ops = append(ops, op)
+	}
+	sort.Strings(ops)
+	for _, op := range ops {
+		if n < m[op] {
+			return op
+		}
+		n -= m[op]
+	}
+	return ops[len(ops)-1]
+}
+
+// Config controls the load sent to the cluster.
+type Config struct {
+	// Urls of the nodes, the clients are spread across them.
+	Urls []string
+	// Clients is the number of concurrent clients.
+	Clients int
+	// Duration of the run.
+	Duration time.Duration
+	// Mix weighs the operations.
+	Mix Mix
+	// RequestTimeout is how long a client waits for an answer.
+	RequestTimeout time.Duration
+	// HandoverAfter asks the leader to transfer its leadership once elapsed, zero never does.
+	HandoverAfter time.Duration
+}
+
+// ids remembers the tasks created during the run.
+type ids struct {
+	mu     sync.Mutex
+	ids    []int64
+	random *rand.Rand
+}
+
+func (i *ids) add(id int64) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.ids) < maxIds {
+		i.ids = append(i.ids, id)
+		return
+	}
+	i.ids[i.random.Intn(len(i.ids))] = id
+}
+
+// pick returns a task, take also forgets it so that it is deleted only once.
+func (i *ids) pick(take bool) (int64, bool) {
+	i.mu.Lock()
+	defer i.mu.Unlock()
+	if len(i.ids) == 0 {
+		return 0, false
+	}
+	n := i.random.Intn(len(i.ids))
+	id := i.ids[n]
+	if take {
+		i.ids[n] = i.ids[len(i.ids)-1]
+		i.ids = i.ids[:len(i.ids)-1]
+	}
+	return id, true
+}
+
+// Run sends the mix of operations from concurrent clients until the duration elapses or the context
+// is cancelled, and reports the throughput, the latencies and the errors.
+func Run(ctx context.Context, cfg Config) (*Report, error) {
+	if len(cfg.Urls) == 0 {
+		return nil, fmt.Errorf("at least one node url is required")
+	}
+	if cfg.Clients < 1 {
+		return nil, fmt.Errorf("clients must be positive")
+	}
+	if cfg.Mix.total() == 0 {
+		return nil, fmt.Errorf("the mix sends no operation")
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	start := time.Now()
+	pool := &ids{random: rand.New(rand.NewSource(start.UnixNano()))}
+	samples := make([][]sample, cfg.Clients)
+	var failover *Failover
+	var wg sync.WaitGroup
+	if cfg.HandoverAfter > 0 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			failover = handoverAfter(ctx, cfg, start)
+		}()
+	}
+	for i := 0; i < cfg.Clients; i++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			api := apiclient.New(cfg.Urls[client%len(cfg.Urls)], cfg.RequestTimeout)
+			random := rand.New(rand.NewSource(start.UnixNano() + int64(client)))
+			for ctx.Err() == nil {
+				op := cfg.Mix.pick(random.Intn(cfg.Mix.total()))
+				samples[client] = append(samples[client], send(api, pool, op, cfg.RequestTimeout, start))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	all := []sample{}
+	for _, client := range samples {
+		all = append(all, client...)
+	}
+	return newReport(all, time.Since(start), failover), nil
+}
+
+func send(api *apiclient.Client, pool *ids, op string, timeout time.Duration, start time.Time) sample {
+	// every operation gets its own deadline, the end of the run must not fail the operations in flight
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	id, ok := pool.pick(op == OpDelete)
+	if !ok && op != OpList {
+		// nothing to read or change yet
+		op = OpCreate
+	}
+	s := sample{op: op, node: api.BaseUrl(), start: time.Since(start)}
+	var err error
+	switch op {
+	case OpCreate:
+		var task *domain.Task
+		if task, err = api.CreateTask(ctx, &domain.Task{Title: "bench", Details: "bench"}); err == nil {
+			pool.add(task.Id)
+		}
+	case OpGet:
+		_, err = api.GetTask(ctx, id)
+	case OpList:
+		_, err = api.ListTasks(ctx)
+	case OpUpdate:
+		_, err = api.UpdateTask(ctx, &domain.Task{Id: id, Title: "bench", Details: "updated"})
+	case OpDelete:
+		err = api.DeleteTask(ctx, id)
+	}
+	s.end = time.Since(start)
+	s.err = errorKind(err)
+	return s
+}
+
+// handoverAfter waits for the delay then asks the leader to hand its leadership over.
+func handoverAfter(ctx context.Context, cfg Config, start time.Time) *Failover {
+	select {
+	case <-ctx.Done():
+		return nil
+	case <-time.After(cfg.HandoverAfter):
+	}
+
+	failover := &Failover{Start: time.Since(start)}
+	leader, err := findLeader(cfg)
+	if err != nil {
+		failover.Error = err.Error()
+		return failover
+	}
+	failover.Leader = leader
+	reqCtx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	if err := apiclient.New(leader, 30*time.Second).Handover(reqCtx); err != nil {
+		failover.Error = err.Error()
+	}
+	return failover
+}
+
+// findLeader returns the API URL of the leader, as the node registry knows it.
+func findLeader(cfg Config) (string, error) {
+	var lastErr error
+	for _, url := range cfg.Urls {
+		ctx, cancel := context.WithTimeout(context.Background(), cfg.RequestTimeout)
+		members, err := apiclient.New(url, cfg.RequestTimeout).ClusterInfo(ctx)
+		cancel()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, member := range members {
+			if member.Leader && member.ApiUrl != "" {
+				return member.ApiUrl, nil
+			}
+		}
+		lastErr = fmt.Errorf("the API URL of the leader is unknown")
+	}
+	return "", lastErr
+}
@@ -0,0 +1,141 @@
+package bench
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeApi serves the task API from memory, it also reports itself as the leader of the cluster.
+type fakeApi struct {
+	mu        sync.Mutex
+	nextId    int64
+	tasks     map[int64]domain.Task
+	url       string
+	handovers int
+}
+
+func newFakeApi() *fakeApi {
+	return &fakeApi{tasks: make(map[int64]domain.Task)}
+}
+
+func (f *fakeApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	switch r.URL.Path {
+	case "/api/v1/tasks":
+		tasks := []domain.Task{}
+		for _, task := range f.tasks {
+			tasks = append(tasks, task)
+		}
+		json.NewEncoder(w).Encode(tasks)
+		return
+	case "/api/v1/clusterInfo":
+		json.NewEncoder(w).Encode([]domain.ClusterInfo{{ID: 1, Address: "norse:9000", Leader: true, ApiUrl: f.url}})
+		return
+	case "/api/v1/node/self/handover":
+		f.handovers++
+		w.Write([]byte(`"leadership handed over"`))
+		return
+	}
+
+	var task domain.Task
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&task)
+	}
+	id, _ := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/v1/task/"), 10, 64)
+	switch r.Method {
+	case http.MethodPost:
+		f.nextId++
+		task.Id = f.nextId
+		f.tasks[task.Id] = task
+	case http.MethodGet:
+		found, ok := f.tasks[id]
+		if !ok {
+			http.Error(w, "sql: no rows in result set", http.StatusServiceUnavailable)
+			return
+		}
+		task = found
+	case http.MethodPut:
+		f.tasks[id] = task
+	case http.MethodDelete:
+		delete(f.tasks, id)
+		w.Write([]byte(`"deleted"`))
+		return
+	}
+	json.NewEncoder(w).Encode(task)
+}
+
+func TestMustParseMix(t *testing.T) {
+	mix, err := ParseMix("create=20, get=50,list=5,update=20,delete=5")
+
+	assert.Nil(t, err)
+	assert.Equal(t, Mix{OpCreate: 20, OpGet: 50, OpList: 5, OpUpdate: 20, OpDelete: 5}, mix)
+	assert.Equal(t, OpCreate, mix.pick(0))
+	assert.Equal(t, OpDelete, mix.pick(20))
+	assert.Equal(t, OpUpdate, mix.pick(99))
+}
+
+func TestFailParseMix(t *testing.T) {
+	for _, value := range []string{"", "create", "explode=1", "get=-1", "get=0"} {
+		_, err := ParseMix(value)
+		assert.NotNilf(t, err, "%q must be rejected", value)
+	}
+}
+
+func TestMustBenchApi(t *testing.T) {
+	server := httptest.NewServer(newFakeApi())
+	defer server.Close()
+	mix, _ := ParseMix("create=20,get=50,list=5,update=20,delete=5")
+	cfg := Config{Urls: []string{server.URL}, Clients: 4, Duration: 300 * time.Millisecond, Mix: mix, RequestTimeout: time.Second}
+
+	report, err := Run(context.Background(), cfg)
+
+	assert.Nil(t, err)
+	assert.Greater(t, report.Total.Count, 0)
+	assert.Greater(t, report.Total.Throughput, 0.0)
+	assert.Greater(t, report.Operations[OpCreate].Count, 0)
+	assert.Equal(t, report.Total.Count, report.Nodes[server.URL].Count)
+	assert.Nil(t, report.Failover)
+}
+
+func TestMustTriggerHandoverDuringRun(t *testing.T) {
+	api := newFakeApi()
+	server := httptest.NewServer(api)
+	defer server.Close()
+	api.url = server.URL
+	mix, _ := ParseMix("create=1")
+	cfg := Config{Urls: []string{server.URL}, Clients: 2, Duration: 400 * time.Millisecond, Mix: mix,
+		RequestTimeout: time.Second, HandoverAfter: 100 * time.Millisecond}
+
+	report, err := Run(context.Background(), cfg)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, api.handovers)
+	assert.NotNil(t, report.Failover)
+	assert.Equal(t, server.URL, report.Failover.Leader)
+	assert.Empty(t, report.Failover.Error)
+	assert.True(t, report.Failover.Recovered)
+}
+
+func TestFailHandoverWithoutLeaderUrl(t *testing.T) {
+	server := httptest.NewServer(newFakeApi())
+	defer server.Close()
+	mix, _ := ParseMix("list=1")
+	cfg := Config{Urls: []string{server.URL}, Clients: 1, Duration: 300 * time.Millisecond, Mix: mix,
+		RequestTimeout: time.Second, HandoverAfter: 50 * time.Millisecond}
+
+	report, err := Run(context.Background(), cfg)
+
+	assert.Nil(t, err)
+	assert.Contains(t, report.Failover.Error, "unknown")
+}
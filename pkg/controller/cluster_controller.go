@@ -1,6 +1,9 @@
 package controller
 
 import (
+	"errors"
+
+	"github.com/balchua/bopbag/pkg/domain"
 	fiber "github.com/gofiber/fiber/v2"
 )
 
@@ -18,18 +21,42 @@ func (cl *ClusterController) ShowCluster(c *fiber.Ctx) error {
 	clusterInfo, err := cl.service.GetClusterInfo()
 
 	if err != nil {
-		return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+		return err
 	}
 	return c.JSON(clusterInfo)
 }
 
+// RemoveNode removes a node by ID or address, ?dryRun=true only reports the impact of the removal
+// and ?force=true removes the node even when it is unsafe.
 func (cl *ClusterController) RemoveNode(c *fiber.Ctx) error {
+	request := domain.RemovalRequest{
+		Node:   c.Params("nodeId"),
+		Force:  c.Query("force") == "true",
+		DryRun: c.Query("dryRun") == "true",
+	}
+	report, err := cl.service.RemoveNode(request)
+
+	switch {
+	case errors.Is(err, domain.ErrUnsafeRemoval):
+		problem := newProblem(c, err)
+		problem.Report = report
+		return sendProblem(c, problem)
+	case err != nil:
+		return err
+	}
+	return c.JSON(report)
+}
 
-	nodeId := c.Params("nodeId")
-	clusterInfo, err := cl.service.RemoveNode(nodeId)
+func (cl *ClusterController) Handover(c *fiber.Ctx) error {
+	if err := cl.service.HandoverLeadership(); err != nil {
+		return err
+	}
+	return c.JSON("leadership handed over")
+}
 
-	if err != nil {
-		return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+func (cl *ClusterController) Leave(c *fiber.Ctx) error {
+	if err := cl.service.LeaveCluster(); err != nil {
+		return err
 	}
-	return c.JSON(clusterInfo)
+	return c.JSON("node left the cluster")
 }
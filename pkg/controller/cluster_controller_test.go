@@ -21,9 +21,19 @@ func (m *MockClusterService) GetClusterInfo() ([]domain.ClusterInfo, error) {
 	return args.Get(0).([]domain.ClusterInfo), args.Error(1)
 }
 
-func (m *MockClusterService) RemoveNode(address string) (string, error) {
-	args := m.Called(address)
-	return args.String(0), args.Error(1)
+func (m *MockClusterService) RemoveNode(request domain.RemovalRequest) (*domain.RemovalReport, error) {
+	args := m.Called(request)
+	return args.Get(0).(*domain.RemovalReport), args.Error(1)
+}
+
+func (m *MockClusterService) HandoverLeadership() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockClusterService) LeaveCluster() error {
+	args := m.Called()
+	return args.Error(0)
 }
 
 func TestMustReturnClusterInfo(t *testing.T) {
@@ -79,7 +89,8 @@ func TestMustTryToRemoveNode(t *testing.T) {
 	// create an instance of our test object
 	mockClusterService := new(MockClusterService)
 
-	mockClusterService.On("RemoveNode", removedNode).Return(removedNode, nil)
+	report := &domain.RemovalReport{Address: removedNode, Safe: true, Removed: true}
+	mockClusterService.On("RemoveNode", domain.RemovalRequest{Node: removedNode}).Return(report, nil)
 
 	controller := NewClusterController(mockClusterService)
 
@@ -90,6 +101,77 @@ func TestMustTryToRemoveNode(t *testing.T) {
 	assert.Equalf(t, 200, resp.StatusCode, "Snode removed")
 }
 
+func TestMustDryRunRemoveNode(t *testing.T) {
+	app := setupApp()
+
+	mockClusterService := new(MockClusterService)
+	report := &domain.RemovalReport{ID: 2, VotersAfter: 2, FaultToleranceAfter: 0, Safe: true, DryRun: true}
+	mockClusterService.On("RemoveNode", domain.RemovalRequest{Node: "2", DryRun: true}).Return(report, nil)
+
+	controller := NewClusterController(mockClusterService)
+
+	app.Delete("/api/v1/node/:nodeId", controller.RemoveNode)
+	req := httptest.NewRequest("DELETE", "/api/v1/node/2?dryRun=true", nil)
+	resp, _ := app.Test(req, 1)
+	assert.Equalf(t, 200, resp.StatusCode, "dry run")
+
+	bodyBytes, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		log.Fatal(err)
+	}
+	assert.Contains(t, string(bodyBytes), `"VotersAfter":2`)
+}
+
+func TestMustRefuseUnsafeRemoval(t *testing.T) {
+	app := setupApp()
+
+	removedNode := "norse:9000"
+	mockClusterService := new(MockClusterService)
+	report := &domain.RemovalReport{Address: removedNode, Leader: true, Reasons: []string{"node is the leader"}}
+	mockClusterService.On("RemoveNode", domain.RemovalRequest{Node: removedNode}).Return(report, fmt.Errorf("%w", domain.ErrUnsafeRemoval))
+
+	controller := NewClusterController(mockClusterService)
+
+	app.Delete("/api/v1/node/:nodeId", controller.RemoveNode)
+	req := httptest.NewRequest("DELETE", "/api/v1/node/"+removedNode, nil)
+	resp, _ := app.Test(req, 1)
+	assert.Equalf(t, 409, resp.StatusCode, "unsafe removal refused")
+	bodyBytes, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(bodyBytes), `"code":"UNSAFE_REMOVAL"`)
+	assert.Contains(t, string(bodyBytes), "node is the leader")
+}
+
+func TestMustForceRemoveNode(t *testing.T) {
+	app := setupApp()
+
+	removedNode := "norse:9000"
+	mockClusterService := new(MockClusterService)
+	report := &domain.RemovalReport{Address: removedNode, Removed: true}
+	mockClusterService.On("RemoveNode", domain.RemovalRequest{Node: removedNode, Force: true}).Return(report, nil)
+
+	controller := NewClusterController(mockClusterService)
+
+	app.Delete("/api/v1/node/:nodeId", controller.RemoveNode)
+	req := httptest.NewRequest("DELETE", "/api/v1/node/"+removedNode+"?force=true", nil)
+	resp, _ := app.Test(req, 1)
+	assert.Equalf(t, 200, resp.StatusCode, "forced removal")
+}
+
+func TestFailRemoveUnknownNode(t *testing.T) {
+	app := setupApp()
+
+	mockClusterService := new(MockClusterService)
+	var report *domain.RemovalReport
+	mockClusterService.On("RemoveNode", domain.RemovalRequest{Node: "freya:9000"}).Return(report, fmt.Errorf("%w: freya:9000", domain.ErrNodeNotFound))
+
+	controller := NewClusterController(mockClusterService)
+
+	app.Delete("/api/v1/node/:nodeId", controller.RemoveNode)
+	req := httptest.NewRequest("DELETE", "/api/v1/node/freya:9000", nil)
+	resp, _ := app.Test(req, 1)
+	assert.Equalf(t, 404, resp.StatusCode, "unknown node")
+}
+
 func TestFailRemoveNode(t *testing.T) {
 	app := setupApp()
 
@@ -97,7 +179,8 @@ func TestFailRemoveNode(t *testing.T) {
 	// create an instance of our test object
 	mockClusterService := new(MockClusterService)
 
-	mockClusterService.On("RemoveNode", removedNode).Return(removedNode, fmt.Errorf("unable to remove node %s", removedNode))
+	var report *domain.RemovalReport
+	mockClusterService.On("RemoveNode", domain.RemovalRequest{Node: removedNode}).Return(report, fmt.Errorf("unable to remove node %s", removedNode))
 
 	controller := NewClusterController(mockClusterService)
 
@@ -107,3 +190,55 @@ func TestFailRemoveNode(t *testing.T) {
 	// Verify, if the status code is as expected
 	assert.Equalf(t, 503, resp.StatusCode, "node removed")
 }
+
+func TestMustHandoverLeadership(t *testing.T) {
+	app := setupApp()
+	mockClusterService := new(MockClusterService)
+	mockClusterService.On("HandoverLeadership").Return(nil)
+	controller := NewClusterController(mockClusterService)
+	app.Post("/api/v1/node/self/handover", controller.Handover)
+
+	req := httptest.NewRequest("POST", "/api/v1/node/self/handover", nil)
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 200, resp.StatusCode, "leadership handed over")
+}
+
+func TestFailHandoverLeadership(t *testing.T) {
+	app := setupApp()
+	mockClusterService := new(MockClusterService)
+	mockClusterService.On("HandoverLeadership").Return(fmt.Errorf("no other voter"))
+	controller := NewClusterController(mockClusterService)
+	app.Post("/api/v1/node/self/handover", controller.Handover)
+
+	req := httptest.NewRequest("POST", "/api/v1/node/self/handover", nil)
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 503, resp.StatusCode, "leadership not handed over")
+}
+
+func TestMustLeaveCluster(t *testing.T) {
+	app := setupApp()
+	mockClusterService := new(MockClusterService)
+	mockClusterService.On("LeaveCluster").Return(nil)
+	controller := NewClusterController(mockClusterService)
+	app.Post("/api/v1/node/self/leave", controller.Leave)
+
+	req := httptest.NewRequest("POST", "/api/v1/node/self/leave", nil)
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 200, resp.StatusCode, "node left")
+}
+
+func TestFailLeaveCluster(t *testing.T) {
+	app := setupApp()
+	mockClusterService := new(MockClusterService)
+	mockClusterService.On("LeaveCluster").Return(fmt.Errorf("no leader"))
+	controller := NewClusterController(mockClusterService)
+	app.Post("/api/v1/node/self/leave", controller.Leave)
+
+	req := httptest.NewRequest("POST", "/api/v1/node/self/leave", nil)
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 503, resp.StatusCode, "node did not leave")
+}
@@ -0,0 +1,73 @@
+package controller
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strconv"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+const AdminTokenHeader = "X-Admin-Token"
+
+type FaultController struct {
+	service FaultService
+}
+
+func NewFaultController(faultService FaultService) *FaultController {
+	return &FaultController{
+		service: faultService,
+	}
+}
+
+func (f *FaultController) ShowFaults(c *fiber.Ctx) error {
+	return c.JSON(f.service.Faults())
+}
+
+func (f *FaultController) Inject(c *fiber.Ctx) error {
+	fault := new(domain.Fault)
+	if err := c.BodyParser(fault); err != nil {
+		return malformed(err)
+	}
+	injected, err := f.service.Inject(fault)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusCreated).JSON(injected)
+}
+
+func (f *FaultController) Clear(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return malformed(err)
+	}
+	if err := f.service.Clear(id); err != nil {
+		return err
+	}
+	return c.JSON(fmt.Sprintf("fault %d is cleared", id))
+}
+
+func (f *FaultController) ClearAll(c *fiber.Ctx) error {
+	f.service.ClearAll()
+	return c.JSON("all faults are cleared")
+}
+
+// AdminOnly rejects the requests which do not carry the admin token in the X-Admin-Token header.
+func AdminOnly(token string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		given := c.Get(AdminTokenHeader)
+		if token == "" || subtle.ConstantTimeCompare([]byte(given), []byte(token)) != 1 {
+			return fiber.NewError(fiber.StatusUnauthorized, "admin token required")
+		}
+		return c.Next()
+	}
+}
+
+// Frozen holds the requests while a freeze fault is active.
+func Frozen(faultService FaultService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		faultService.WaitWhileFrozen()
+		return c.Next()
+	}
+}
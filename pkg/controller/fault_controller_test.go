@@ -0,0 +1,162 @@
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockFaultService struct {
+	mock.Mock
+}
+
+func (m *MockFaultService) Inject(fault *domain.Fault) (*domain.Fault, error) {
+	args := m.Called(fault)
+	return args.Get(0).(*domain.Fault), args.Error(1)
+}
+
+func (m *MockFaultService) Faults() []domain.Fault {
+	args := m.Called()
+	return args.Get(0).([]domain.Fault)
+}
+
+func (m *MockFaultService) Clear(id int64) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockFaultService) ClearAll() {
+	m.Called()
+}
+
+func (m *MockFaultService) WaitWhileFrozen() {
+	m.Called()
+}
+
+func setupFaultApp(service FaultService) *fiber.App {
+	app := setupApp()
+	controller := NewFaultController(service)
+	admin := app.Group("/api/v1/admin", AdminOnly("secret"))
+	admin.Get("/faults", controller.ShowFaults)
+	admin.Post("/faults", controller.Inject)
+	admin.Delete("/faults/:id", controller.Clear)
+	admin.Delete("/faults", controller.ClearAll)
+	return app
+}
+
+func TestMustInjectFault(t *testing.T) {
+	injected := &domain.Fault{Id: 1, Kind: domain.FaultFreeze, Duration: "10s"}
+	mockFaultService := new(MockFaultService)
+	mockFaultService.On("Inject", mock.Anything).Return(injected, nil)
+	app := setupFaultApp(mockFaultService)
+
+	req := httptest.NewRequest("POST", "/api/v1/admin/faults", strings.NewReader(`{"kind":"FREEZE","duration":"10s"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(AdminTokenHeader, "secret")
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 201, resp.StatusCode, "fault injected")
+	bodyBytes, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(bodyBytes), "FREEZE")
+}
+
+func TestFailInjectInvalidFault(t *testing.T) {
+	var injected *domain.Fault
+	mockFaultService := new(MockFaultService)
+	mockFaultService.On("Inject", mock.Anything).Return(injected, fmt.Errorf("%w: unknown kind", domain.ErrInvalidFault))
+	app := setupFaultApp(mockFaultService)
+
+	req := httptest.NewRequest("POST", "/api/v1/admin/faults", strings.NewReader(`{"kind":"FLOOD","duration":"10s"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(AdminTokenHeader, "secret")
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 422, resp.StatusCode, "invalid fault")
+}
+
+func TestFailInjectWithoutAdminToken(t *testing.T) {
+	mockFaultService := new(MockFaultService)
+	app := setupFaultApp(mockFaultService)
+
+	req := httptest.NewRequest("POST", "/api/v1/admin/faults", strings.NewReader(`{"kind":"FREEZE","duration":"10s"}`))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(AdminTokenHeader, "guess")
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 401, resp.StatusCode, "wrong admin token")
+	mockFaultService.AssertNotCalled(t, "Inject", mock.Anything)
+}
+
+func TestMustShowFaults(t *testing.T) {
+	mockFaultService := new(MockFaultService)
+	mockFaultService.On("Faults").Return([]domain.Fault{{Id: 3, Kind: domain.FaultPeer, Peer: "10.0.0.2:9000"}})
+	app := setupFaultApp(mockFaultService)
+
+	req := httptest.NewRequest("GET", "/api/v1/admin/faults", nil)
+	req.Header.Set(AdminTokenHeader, "secret")
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 200, resp.StatusCode, "show faults")
+	bodyBytes, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(bodyBytes), "10.0.0.2:9000")
+}
+
+func TestMustClearFault(t *testing.T) {
+	mockFaultService := new(MockFaultService)
+	mockFaultService.On("Clear", int64(3)).Return(nil)
+	app := setupFaultApp(mockFaultService)
+
+	req := httptest.NewRequest("DELETE", "/api/v1/admin/faults/3", nil)
+	req.Header.Set(AdminTokenHeader, "secret")
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 200, resp.StatusCode, "fault cleared")
+}
+
+func TestFailClearUnknownFault(t *testing.T) {
+	mockFaultService := new(MockFaultService)
+	mockFaultService.On("Clear", int64(4)).Return(domain.ErrFaultNotFound)
+	app := setupFaultApp(mockFaultService)
+
+	req := httptest.NewRequest("DELETE", "/api/v1/admin/faults/4", nil)
+	req.Header.Set(AdminTokenHeader, "secret")
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 404, resp.StatusCode, "unknown fault")
+}
+
+func TestMustClearAllFaults(t *testing.T) {
+	mockFaultService := new(MockFaultService)
+	mockFaultService.On("ClearAll").Return()
+	app := setupFaultApp(mockFaultService)
+
+	req := httptest.NewRequest("DELETE", "/api/v1/admin/faults", nil)
+	req.Header.Set(AdminTokenHeader, "secret")
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 200, resp.StatusCode, "all faults cleared")
+	mockFaultService.AssertCalled(t, "ClearAll")
+}
+
+func TestMustHoldRequestsWhileFrozen(t *testing.T) {
+	mockFaultService := new(MockFaultService)
+	mockFaultService.On("WaitWhileFrozen").Return()
+	app := setupApp()
+	app.Use(Frozen(mockFaultService))
+	app.Get("/api/v1/tasks", func(c *fiber.Ctx) error {
+		return c.JSON("[]")
+	})
+
+	req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 200, resp.StatusCode, "request served after the thaw")
+	mockFaultService.AssertCalled(t, "WaitWhileFrozen")
+}
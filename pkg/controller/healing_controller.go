@@ -0,0 +1,23 @@
+package controller
+
+import (
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+type HealingController struct {
+	service HealingService
+}
+
+func NewHealingController(healingService HealingService) *HealingController {
+	return &HealingController{
+		service: healingService,
+	}
+}
+
+func (h *HealingController) ShowAudit(c *fiber.Ctx) error {
+	audits, err := h.service.GetAuditTrail()
+	if err != nil {
+		return err
+	}
+	return c.JSON(audits)
+}
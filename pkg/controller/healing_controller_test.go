@@ -0,0 +1,51 @@
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockHealingService struct {
+	mock.Mock
+}
+
+func (m *MockHealingService) GetAuditTrail() (*[]domain.HealingAudit, error) {
+	args := m.Called()
+	return args.Get(0).(*[]domain.HealingAudit), args.Error(1)
+}
+
+func TestMustReturnAuditTrail(t *testing.T) {
+	app := setupApp()
+	audits := []domain.HealingAudit{{Id: 1, Address: "norse:9001", Action: domain.HealingDead}}
+	mockHealingService := new(MockHealingService)
+	mockHealingService.On("GetAuditTrail").Return(&audits, nil)
+	controller := NewHealingController(mockHealingService)
+	app.Get("/api/v1/healing/audit", controller.ShowAudit)
+
+	req := httptest.NewRequest("GET", "/api/v1/healing/audit", nil)
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 200, resp.StatusCode, "Show audit trail")
+	bodyBytes, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(bodyBytes), "norse:9001")
+}
+
+func TestFailAuditTrail(t *testing.T) {
+	app := setupApp()
+	audits := []domain.HealingAudit{}
+	mockHealingService := new(MockHealingService)
+	mockHealingService.On("GetAuditTrail").Return(&audits, fmt.Errorf("db error"))
+	controller := NewHealingController(mockHealingService)
+	app.Get("/api/v1/healing/audit", controller.ShowAudit)
+
+	req := httptest.NewRequest("GET", "/api/v1/healing/audit", nil)
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 503, resp.StatusCode, "Show audit trail")
+}
@@ -0,0 +1,53 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+// IdempotentReplayedHeader is set on the responses replayed to the retries of a request.
+const IdempotentReplayedHeader = "Idempotent-Replayed"
+
+// Idempotent answers the retries of a write carrying an Idempotency-Key header with the response of the first
+// request. The requests without the header are served as usual. The keys of a tenant are its own, it follows Tenant.
+func Idempotent(service IdempotencyService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		key := c.Get(domain.IdempotencyKeyHeader)
+		if key == "" || c.Method() == fiber.MethodGet || c.Method() == fiber.MethodHead {
+			return c.Next()
+		}
+		record, err := service.Begin(c.UserContext(), key, fingerprint(c))
+		if err != nil {
+			return err
+		}
+		if record != nil {
+			c.Status(record.Status)
+			c.Set(fiber.HeaderContentType, record.ContentType)
+			c.Set(IdempotentReplayedHeader, "true")
+			return c.Send(record.Body)
+		}
+
+		// the repositories record the writes under the key of the tenant, the one Begin reserved
+		c.SetUserContext(domain.WithIdempotencyKey(c.UserContext(), domain.TenantIdempotencyKey(c.UserContext(), key)))
+		if err := c.Next(); err != nil {
+			// the problem is recorded like any other response
+			if err := ErrorHandler(c, err); err != nil {
+				return err
+			}
+		}
+		response := c.Response()
+		body := append([]byte(nil), response.Body()...)
+		// a response which could not be recorded still stands, its retry takes the key over after the lease
+		_ = service.Complete(c.UserContext(), key, response.StatusCode(), string(response.Header.ContentType()), body)
+		return nil
+	}
+}
+
+// fingerprint tells apart two requests sent with the same key.
+func fingerprint(c *fiber.Ctx) string {
+	digest := sha256.Sum256(c.Body())
+	return c.Method() + " " + c.Path() + " " + hex.EncodeToString(digest[:])
+}
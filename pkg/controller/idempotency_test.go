@@ -0,0 +1,118 @@
+package controller
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockIdempotencyService struct {
+	mock.Mock
+}
+
+func (m *MockIdempotencyService) Begin(ctx context.Context, key string, fingerprint string) (*domain.IdempotencyRecord, error) {
+	args := m.Called(key, fingerprint)
+	return args.Get(0).(*domain.IdempotencyRecord), args.Error(1)
+}
+
+func (m *MockIdempotencyService) Complete(ctx context.Context, key string, status int, contentType string, body []byte) error {
+	args := m.Called(key, status, string(body))
+	return args.Error(0)
+}
+
+func newTaskRequest(key string) *http.Request {
+	req := httptest.NewRequest("POST", "/api/v1/task", strings.NewReader(`{"title":"test","details":"test"}`))
+	req.Header.Set("Content-Type", "application/json")
+	if key != "" {
+		req.Header.Set(domain.IdempotencyKeyHeader, key)
+	}
+	return req
+}
+
+func setupIdempotentApp(service IdempotencyService, handler fiber.Handler) *fiber.App {
+	app := setupApp()
+	app.Post("/api/v1/task", Idempotent(service), handler)
+	return app
+}
+
+func TestMustRecordTheFirstResponse(t *testing.T) {
+	mockService := new(MockIdempotencyService)
+	isPost := mock.MatchedBy(func(fingerprint string) bool { return strings.HasPrefix(fingerprint, "POST /api/v1/task ") })
+	mockService.On("Begin", "k1", isPost).Return((*domain.IdempotencyRecord)(nil), nil)
+	mockService.On("Complete", "k1", 200, `{"id":"12","title":"","details":"","completed":false,"createdAt":"0001-01-01T00:00:00Z","updatedAt":"0001-01-01T00:00:00Z","createdDate":"Mon, 01 Jan 0001 00:00:00 UTC"}`).Return(nil)
+	app := setupIdempotentApp(mockService, func(c *fiber.Ctx) error {
+		assert.Equal(t, "default/k1", domain.IdempotencyKeyOf(c.UserContext()))
+		return c.JSON(&domain.Task{Id: 12})
+	})
+
+	resp, _ := app.Test(newTaskRequest("k1"), 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	mockService.AssertExpectations(t)
+}
+
+func TestMustRecordTheProblem(t *testing.T) {
+	mockService := new(MockIdempotencyService)
+	mockService.On("Begin", "k1", mock.Anything).Return((*domain.IdempotencyRecord)(nil), nil)
+	mockService.On("Complete", "k1", 422, mock.Anything).Return(nil)
+	app := setupIdempotentApp(mockService, func(c *fiber.Ctx) error {
+		return domain.NewError(domain.ErrValidation, domain.CodeInvalidTask, "the title is required", nil)
+	})
+
+	resp, _ := app.Test(newTaskRequest("k1"), 1)
+
+	assert.Equal(t, 422, resp.StatusCode)
+	assert.Equal(t, ProblemContentType, resp.Header.Get("Content-Type"))
+	mockService.AssertExpectations(t)
+}
+
+func TestMustReplayTheRecordedResponse(t *testing.T) {
+	mockService := new(MockIdempotencyService)
+	mockService.On("Begin", "k1", mock.Anything).Return(&domain.IdempotencyRecord{Key: "k1", Status: 200,
+		ContentType: "application/json", Body: []byte(`{"id":12}`)}, nil)
+	app := setupIdempotentApp(mockService, func(c *fiber.Ctx) error {
+		t.Error("a replayed request must not be handled again")
+		return nil
+	})
+
+	resp, _ := app.Test(newTaskRequest("k1"), 1)
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, `{"id":12}`, string(body))
+	assert.Equal(t, "true", resp.Header.Get(IdempotentReplayedHeader))
+}
+
+func TestFailWhenTheKeyIsInUse(t *testing.T) {
+	mockService := new(MockIdempotencyService)
+	mockService.On("Begin", "k1", mock.Anything).Return((*domain.IdempotencyRecord)(nil),
+		domain.NewError(domain.ErrConflict, domain.CodeKeyInUse, "idempotency key k1 is used by a request in progress", nil))
+	app := setupIdempotentApp(mockService, func(c *fiber.Ctx) error {
+		t.Error("a request in progress must not be handled twice")
+		return nil
+	})
+
+	resp, _ := app.Test(newTaskRequest("k1"), 1)
+
+	assert.Equal(t, 409, resp.StatusCode)
+}
+
+func TestMustServeRequestsWithoutKey(t *testing.T) {
+	mockService := new(MockIdempotencyService)
+	app := setupIdempotentApp(mockService, func(c *fiber.Ctx) error {
+		return c.JSON(&domain.Task{Id: 12})
+	})
+
+	resp, _ := app.Test(newTaskRequest(""), 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	mockService.AssertNotCalled(t, "Begin", mock.Anything, mock.Anything)
+}
@@ -2,6 +2,7 @@ package controller
 
 import (
 	"context"
+	"time"
 
 	"github.com/balchua/bopbag/pkg/domain"
 )
@@ -9,12 +10,60 @@ import (
 type TaskService interface {
 	CreateTask(ctx context.Context, task *domain.Task) (*domain.Task, error)
 	UpdateTask(ctx context.Context, task *domain.Task) (*domain.Task, error)
+	PatchTask(ctx context.Context, id int64, patchType string, patch []byte) (*domain.Task, error)
 	DeleteTask(ctx context.Context, id int64) error
 	GetTaskById(ctx context.Context, id int64) (*domain.Task, error)
-	GetAllTasks(ctx context.Context) (*[]domain.Task, error)
+	GetTaskByKey(ctx context.Context, key string) (*domain.Task, error)
+	GetAllTasks(ctx context.Context, filter domain.TaskFilter) (*[]domain.Task, error)
+}
+
+type ProjectService interface {
+	CreateProject(ctx context.Context, project *domain.Project) (*domain.Project, error)
+	UpdateProject(ctx context.Context, project *domain.Project) (*domain.Project, error)
+	DeleteProject(ctx context.Context, id int64, cascade bool) error
+	GetProjectById(ctx context.Context, id int64) (*domain.Project, error)
+	GetAllProjects(ctx context.Context) (*[]domain.Project, error)
+	GetProjectTasks(ctx context.Context, id int64, filter domain.TaskFilter) (*[]domain.Task, error)
 }
 
 type ClusterService interface {
 	GetClusterInfo() ([]domain.ClusterInfo, error)
-	RemoveNode(address string) (string, error)
+	RemoveNode(request domain.RemovalRequest) (*domain.RemovalReport, error)
+	HandoverLeadership() error
+	LeaveCluster() error
+}
+
+type HealingService interface {
+	GetAuditTrail() (*[]domain.HealingAudit, error)
+}
+
+type UpgradeService interface {
+	Plan(targetVersion string, targetSchemaVersion int) (*domain.UpgradePlan, error)
+}
+
+type FaultService interface {
+	Inject(fault *domain.Fault) (*domain.Fault, error)
+	Faults() []domain.Fault
+	Clear(id int64) error
+	ClearAll()
+	WaitWhileFrozen()
+}
+
+type IdempotencyService interface {
+	Begin(ctx context.Context, key string, fingerprint string) (*domain.IdempotencyRecord, error)
+	Complete(ctx context.Context, key string, status int, contentType string, body []byte) error
+}
+
+type TenantService interface {
+	CreateTenant(ctx context.Context, tenant *domain.Tenant) (*domain.Tenant, error)
+	DeleteTenant(ctx context.Context, id string, cascade bool) error
+	GetTenantById(ctx context.Context, id string) (*domain.Tenant, error)
+	GetAllTenants(ctx context.Context) (*[]domain.Tenant, error)
+	Authenticate(ctx context.Context, token string) (*domain.Tenant, error)
+}
+
+type QuotaService interface {
+	GetQuota(ctx context.Context, tenant string) (*domain.Quota, error)
+	SetQuota(ctx context.Context, quota *domain.Quota) (*domain.Quota, error)
+	Admit(ctx context.Context) (time.Duration, error)
 }
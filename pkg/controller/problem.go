@@ -0,0 +1,94 @@
+package controller
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+const (
+	ProblemContentType = "application/problem+json"
+	problemTypePrefix  = "urn:bopbag:problem:"
+	// unavailableDetail replaces the message of the errors other than the domain ones, it may tell the
+	// internals of the node
+	unavailableDetail = "the request cannot be served, retry it later"
+)
+
+// Problem is the RFC 7807 body of the failed requests, Code is stable and meant for the clients.
+type Problem struct {
+	Type     string `json:"type"`
+	Title    string `json:"title"`
+	Status   int    `json:"status"`
+	Detail   string `json:"detail,omitempty"`
+	Instance string `json:"instance,omitempty"`
+	Code     string `json:"code"`
+	// Report tells why a node removal was refused.
+	Report *domain.RemovalReport `json:"report,omitempty"`
+}
+
+// statuses maps the kinds of domain errors to the status of the response.
+var statuses = []struct {
+	kind   error
+	status int
+}{
+	{domain.ErrMalformed, fiber.StatusBadRequest},
+	{domain.ErrNotFound, fiber.StatusNotFound},
+	{domain.ErrConflict, fiber.StatusConflict},
+	{domain.ErrValidation, fiber.StatusUnprocessableEntity},
+	{domain.ErrTooManyRequests, fiber.StatusTooManyRequests},
+	{domain.ErrNoLeader, fiber.StatusServiceUnavailable},
+	{domain.ErrUnavailable, fiber.StatusServiceUnavailable},
+}
+
+// ErrorHandler answers the failed requests with a problem, it is set in the fiber configuration.
+func ErrorHandler(c *fiber.Ctx, err error) error {
+	return sendProblem(c, newProblem(c, err))
+}
+
+func newProblem(c *fiber.Ctx, err error) *Problem {
+	problem := &Problem{
+		Status:   fiber.StatusServiceUnavailable,
+		Code:     domain.CodeUnavailable,
+		Detail:   unavailableDetail,
+		Instance: c.OriginalURL(),
+	}
+	var domainErr *domain.Error
+	var fiberErr *fiber.Error
+	switch {
+	case errors.As(err, &domainErr):
+		problem.Code = domainErr.Code
+		// the cause is left out, ex. the SQL error
+		problem.Detail = domainErr.Message
+		for _, s := range statuses {
+			if errors.Is(domainErr, s.kind) {
+				problem.Status = s.status
+				break
+			}
+		}
+	case errors.As(err, &fiberErr):
+		// raised by fiber itself or by the middlewares, ex. unknown route
+		problem.Status = fiberErr.Code
+		problem.Code = strings.ToUpper(strings.ReplaceAll(utils.StatusMessage(fiberErr.Code), " ", "_"))
+		problem.Detail = fiberErr.Message
+	}
+	problem.Title = utils.StatusMessage(problem.Status)
+	problem.Type = problemTypePrefix + strings.ToLower(problem.Code)
+	return problem
+}
+
+func sendProblem(c *fiber.Ctx, problem *Problem) error {
+	c.Status(problem.Status)
+	if err := c.JSON(problem); err != nil {
+		return err
+	}
+	c.Set(fiber.HeaderContentType, ProblemContentType)
+	return nil
+}
+
+// malformed reports a request whose parameters or body cannot be read.
+func malformed(err error) error {
+	return domain.NewError(domain.ErrMalformed, domain.CodeMalformedRequest, "malformed request", err)
+}
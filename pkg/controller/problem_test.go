@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+)
+
+func failWith(err error) *fiber.App {
+	app := setupApp()
+	app.Get("/api/v1/task/:id", func(c *fiber.Ctx) error {
+		return err
+	})
+	return app
+}
+
+func TestMustAnswerProblems(t *testing.T) {
+	cases := []struct {
+		err    error
+		status int
+		code   string
+		detail string
+	}{
+		{malformed(fmt.Errorf("strconv.ParseInt: parsing \"abc\": invalid syntax")), 400, domain.CodeMalformedRequest, "malformed request"},
+		{domain.NewError(domain.ErrNotFound, domain.CodeTaskNotFound, "task 12 not found", errors.New("sql: no rows in result set")), 404, domain.CodeTaskNotFound, "task 12 not found"},
+		{fmt.Errorf("%w: norse:9000", domain.ErrNodeNotFound), 404, domain.CodeNodeNotFound, domain.ErrNodeNotFound.Message},
+		{domain.NewError(domain.ErrConflict, domain.CodeConstraintViolation, "constraint violation", nil), 409, domain.CodeConstraintViolation, "constraint violation"},
+		{domain.NewError(domain.ErrValidation, domain.CodeInvalidTask, "the title is required", nil), 422, domain.CodeInvalidTask, "the title is required"},
+		{domain.NewError(domain.ErrNoLeader, domain.CodeNoLeader, "no dqlite leader is available", nil), 503, domain.CodeNoLeader, "no dqlite leader is available"},
+		{fmt.Errorf("dial tcp 10.0.0.12:9000: connection refused"), 503, domain.CodeUnavailable, unavailableDetail},
+		{fiber.ErrMethodNotAllowed, 405, "METHOD_NOT_ALLOWED", fiber.ErrMethodNotAllowed.Message},
+	}
+	for _, tc := range cases {
+		req := httptest.NewRequest("GET", "/api/v1/task/12", nil)
+		resp, _ := failWith(tc.err).Test(req, 1)
+
+		assert.Equalf(t, tc.status, resp.StatusCode, "status of %v", tc.err)
+		assert.Equal(t, ProblemContentType, resp.Header.Get("Content-Type"))
+		var problem Problem
+		assert.Nil(t, json.NewDecoder(resp.Body).Decode(&problem))
+		assert.Equal(t, tc.code, problem.Code)
+		assert.Equal(t, tc.detail, problem.Detail)
+		assert.Equal(t, tc.status, problem.Status)
+		assert.Equal(t, "/api/v1/task/12", problem.Instance)
+		assert.NotEmpty(t, problem.Title)
+		assert.Contains(t, problem.Type, problemTypePrefix)
+	}
+}
@@ -0,0 +1,106 @@
+package controller
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+type ProjectController struct {
+	projectService ProjectService
+}
+
+func NewProjectController(projectService ProjectService) *ProjectController {
+	return &ProjectController{
+		projectService: projectService,
+	}
+}
+
+func (p *ProjectController) NewProject(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+	project := new(domain.Project)
+	if err := c.BodyParser(project); err != nil {
+		return malformed(err)
+	}
+	newProject, err := p.projectService.CreateProject(ctx, project)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(newProject)
+}
+
+func (p *ProjectController) FindById(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return malformed(err)
+	}
+	project, err := p.projectService.GetProjectById(c.UserContext(), id)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(project)
+}
+
+func (p *ProjectController) FindAll(c *fiber.Ctx) error {
+	projects, err := p.projectService.GetAllProjects(c.UserContext())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(projects)
+}
+
+// FindTasks returns the tasks of the project, with the date ranges of TaskController.FindAll.
+func (p *ProjectController) FindTasks(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return malformed(err)
+	}
+	filter, err := taskFilter(c)
+	if err != nil {
+		return err
+	}
+	tasks, err := p.projectService.GetProjectTasks(c.UserContext(), id, filter)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(tasks)
+}
+
+// UpdateProject renames the project and archives or restores it, the key of the body is ignored.
+func (p *ProjectController) UpdateProject(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return malformed(err)
+	}
+	project := new(domain.Project)
+	if err := c.BodyParser(project); err != nil {
+		return malformed(err)
+	}
+	project.Id = id
+	updatedProject, err := p.projectService.UpdateProject(ctx, project)
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(updatedProject)
+}
+
+// DeleteProject removes the project, the cascade=true parameter removes its tasks with it.
+func (p *ProjectController) DeleteProject(c *fiber.Ctx) error {
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return malformed(err)
+	}
+	if err := p.projectService.DeleteProject(c.UserContext(), id, c.Query("cascade") == "true"); err != nil {
+		return err
+	}
+
+	return c.JSON(fmt.Sprintf("project %d is deleted", id))
+}
@@ -0,0 +1,158 @@
+package controller
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockProjectService struct {
+	mock.Mock
+}
+
+func (m *MockProjectService) CreateProject(ctx context.Context, project *domain.Project) (*domain.Project, error) {
+	args := m.Called(project)
+	return args.Get(0).(*domain.Project), args.Error(1)
+}
+
+func (m *MockProjectService) UpdateProject(ctx context.Context, project *domain.Project) (*domain.Project, error) {
+	args := m.Called(project)
+	return args.Get(0).(*domain.Project), args.Error(1)
+}
+
+func (m *MockProjectService) DeleteProject(ctx context.Context, id int64, cascade bool) error {
+	args := m.Called(id, cascade)
+	return args.Error(0)
+}
+
+func (m *MockProjectService) GetProjectById(ctx context.Context, id int64) (*domain.Project, error) {
+	args := m.Called(id)
+	return args.Get(0).(*domain.Project), args.Error(1)
+}
+
+func (m *MockProjectService) GetAllProjects(ctx context.Context) (*[]domain.Project, error) {
+	args := m.Called()
+	return args.Get(0).(*[]domain.Project), args.Error(1)
+}
+
+func (m *MockProjectService) GetProjectTasks(ctx context.Context, id int64, filter domain.TaskFilter) (*[]domain.Task, error) {
+	args := m.Called(id, filter)
+	return args.Get(0).(*[]domain.Task), args.Error(1)
+}
+
+func TestMustBeAbleToCreateAProject(t *testing.T) {
+	mockProjectService := new(MockProjectService)
+	mockProjectService.On("CreateProject", &domain.Project{Key: "OPS", Name: "Operations"}).
+		Return(&domain.Project{Id: 1, Key: "OPS", Name: "Operations"}, nil)
+	controller := NewProjectController(mockProjectService)
+	app := setupApp()
+	app.Post("/api/v1/project", controller.NewProject)
+
+	req := httptest.NewRequest("POST", "/api/v1/project", strings.NewReader(`{"key":"OPS","name":"Operations"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req, 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestFailCreateInvalidProject(t *testing.T) {
+	mockProjectService := new(MockProjectService)
+	mockProjectService.On("CreateProject", mock.Anything).Return((*domain.Project)(nil),
+		domain.NewError(domain.ErrValidation, domain.CodeInvalidProject, "invalid project", nil))
+	controller := NewProjectController(mockProjectService)
+	app := setupApp()
+	app.Post("/api/v1/project", controller.NewProject)
+
+	req := httptest.NewRequest("POST", "/api/v1/project", strings.NewReader(`{"key":"ops","name":"Operations"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req, 1)
+	malformedReq := httptest.NewRequest("POST", "/api/v1/project", strings.NewReader(`{"key":`))
+	malformedReq.Header.Set("Content-Type", "application/json")
+	malformedResp, _ := app.Test(malformedReq, 1)
+
+	assert.Equal(t, 422, resp.StatusCode)
+	assert.Equal(t, 400, malformedResp.StatusCode)
+}
+
+func TestMustReturnAllProjects(t *testing.T) {
+	mockProjectService := new(MockProjectService)
+	mockProjectService.On("GetAllProjects").Return(&[]domain.Project{{Id: 1, Key: "OPS", Name: "Operations"}}, nil)
+	controller := NewProjectController(mockProjectService)
+	app := setupApp()
+	app.Get("/api/v1/projects", controller.FindAll)
+
+	resp, _ := app.Test(httptest.NewRequest("GET", "/api/v1/projects", nil), 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestMustBeAbleToFindProjectById(t *testing.T) {
+	mockProjectService := new(MockProjectService)
+	mockProjectService.On("GetProjectById", int64(3)).Return(&domain.Project{Id: 3, Key: "OPS"}, nil)
+	mockProjectService.On("GetProjectById", int64(4)).Return((*domain.Project)(nil),
+		domain.NewError(domain.ErrNotFound, domain.CodeProjectNotFound, "project 4 not found", nil))
+	controller := NewProjectController(mockProjectService)
+	app := setupApp()
+	app.Get("/api/v1/project/:id", controller.FindById)
+
+	resp, _ := app.Test(httptest.NewRequest("GET", "/api/v1/project/3", nil), 1)
+	missingResp, _ := app.Test(httptest.NewRequest("GET", "/api/v1/project/4", nil), 1)
+	invalidResp, _ := app.Test(httptest.NewRequest("GET", "/api/v1/project/OPS", nil), 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 404, missingResp.StatusCode)
+	assert.Equal(t, 400, invalidResp.StatusCode)
+}
+
+func TestMustReturnTheTasksOfAProject(t *testing.T) {
+	mockProjectService := new(MockProjectService)
+	filter := domain.TaskFilter{CreatedAfter: time.Date(2021, 10, 1, 0, 0, 0, 0, time.UTC)}
+	mockProjectService.On("GetProjectTasks", int64(3), filter).Return(&[]domain.Task{{Id: 1, Key: "OPS-1"}}, nil)
+	controller := NewProjectController(mockProjectService)
+	app := setupApp()
+	app.Get("/api/v1/project/:id/tasks", controller.FindTasks)
+
+	resp, _ := app.Test(httptest.NewRequest("GET", "/api/v1/project/3/tasks?createdAfter=2021-10-01T00:00:00Z", nil), 1)
+	invalidResp, _ := app.Test(httptest.NewRequest("GET", "/api/v1/project/3/tasks?createdAfter=today", nil), 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 400, invalidResp.StatusCode)
+	mockProjectService.AssertNumberOfCalls(t, "GetProjectTasks", 1)
+}
+
+func TestMustBeAbleToArchiveAProject(t *testing.T) {
+	mockProjectService := new(MockProjectService)
+	mockProjectService.On("UpdateProject", &domain.Project{Id: 3, Name: "Operations", Archived: true}).
+		Return(&domain.Project{Id: 3, Key: "OPS", Name: "Operations", Archived: true}, nil)
+	controller := NewProjectController(mockProjectService)
+	app := setupApp()
+	app.Put("/api/v1/project/:id", controller.UpdateProject)
+
+	req := httptest.NewRequest("PUT", "/api/v1/project/3", strings.NewReader(`{"name":"Operations","archived":true}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req, 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+}
+
+func TestMustDeleteAProjectWithItsTasksOnRequestOnly(t *testing.T) {
+	mockProjectService := new(MockProjectService)
+	mockProjectService.On("DeleteProject", int64(3), false).
+		Return(domain.NewError(domain.ErrConflict, domain.CodeProjectNotEmpty, "project 3 has 2 tasks", nil))
+	mockProjectService.On("DeleteProject", int64(3), true).Return(nil)
+	controller := NewProjectController(mockProjectService)
+	app := setupApp()
+	app.Delete("/api/v1/project/:id", controller.DeleteProject)
+
+	resp, _ := app.Test(httptest.NewRequest("DELETE", "/api/v1/project/3", nil), 1)
+	cascadeResp, _ := app.Test(httptest.NewRequest("DELETE", "/api/v1/project/3?cascade=true", nil), 1)
+
+	assert.Equal(t, 409, resp.StatusCode)
+	assert.Equal(t, 200, cascadeResp.StatusCode)
+}
@@ -0,0 +1,58 @@
+package controller
+
+import (
+	"errors"
+	"math"
+	"strconv"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+type QuotaController struct {
+	quotaService QuotaService
+}
+
+func NewQuotaController(quotaService QuotaService) *QuotaController {
+	return &QuotaController{
+		quotaService: quotaService,
+	}
+}
+
+// FindByTenant returns the quota of the tenant, its zero limits are no limit.
+func (q *QuotaController) FindByTenant(c *fiber.Ctx) error {
+	quota, err := q.quotaService.GetQuota(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(quota)
+}
+
+// SetQuota replaces the quota of the tenant of the path, a limit left out of the body is removed.
+func (q *QuotaController) SetQuota(c *fiber.Ctx) error {
+	quota := new(domain.Quota)
+	if err := c.BodyParser(quota); err != nil {
+		return malformed(err)
+	}
+	quota.Tenant = c.Params("id")
+	saved, err := q.quotaService.SetQuota(c.UserContext(), quota)
+	if err != nil {
+		return err
+	}
+	return c.JSON(saved)
+}
+
+// RateLimit answers the requests of a tenant over its requests per second with a 429 status, the Retry-After
+// header tells in how many seconds the tenant can send requests again. It runs after Tenant.
+func RateLimit(service QuotaService) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		retryAfter, err := service.Admit(c.UserContext())
+		if errors.Is(err, domain.ErrTooManyRequests) {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(int(math.Ceil(retryAfter.Seconds()))))
+		}
+		if err != nil {
+			return err
+		}
+		return c.Next()
+	}
+}
@@ -0,0 +1,80 @@
+package controller
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockQuotaService struct {
+	mock.Mock
+}
+
+func (m *MockQuotaService) GetQuota(ctx context.Context, tenant string) (*domain.Quota, error) {
+	args := m.Called(tenant)
+	return args.Get(0).(*domain.Quota), args.Error(1)
+}
+
+func (m *MockQuotaService) SetQuota(ctx context.Context, quota *domain.Quota) (*domain.Quota, error) {
+	args := m.Called(quota)
+	return args.Get(0).(*domain.Quota), args.Error(1)
+}
+
+func (m *MockQuotaService) Admit(ctx context.Context) (time.Duration, error) {
+	args := m.Called(domain.TenantOf(ctx))
+	return args.Get(0).(time.Duration), args.Error(1)
+}
+
+func TestMustSetTheQuotaOfTheTenantOfThePath(t *testing.T) {
+	mockQuotaService := new(MockQuotaService)
+	quota := &domain.Quota{Tenant: "acme", MaxTasks: 100, RequestsPerSecond: 5}
+	mockQuotaService.On("SetQuota", quota).Return(quota, nil)
+	mockQuotaService.On("GetQuota", "globex").Return((*domain.Quota)(nil), unknownTenant)
+	controller := NewQuotaController(mockQuotaService)
+	app := setupApp()
+	app.Put("/api/v1/admin/tenants/:id/quota", controller.SetQuota)
+	app.Get("/api/v1/admin/tenants/:id/quota", controller.FindByTenant)
+
+	req := httptest.NewRequest("PUT", "/api/v1/admin/tenants/acme/quota",
+		strings.NewReader(`{"tenant":"globex","maxTasks":100,"requestsPerSecond":5}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req, 1)
+	body, _ := ioutil.ReadAll(resp.Body)
+	missingResp, _ := app.Test(httptest.NewRequest("GET", "/api/v1/admin/tenants/globex/quota", nil), 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Contains(t, string(body), `"tenant":"acme"`)
+	assert.Equal(t, 404, missingResp.StatusCode)
+	mockQuotaService.AssertExpectations(t)
+}
+
+func TestMustRateLimitTheRequestsOfTheTenant(t *testing.T) {
+	mockQuotaService := new(MockQuotaService)
+	mockQuotaService.On("Admit", domain.DefaultTenant).Return(time.Duration(0), nil)
+	mockQuotaService.On("Admit", "acme").
+		Return(250*time.Millisecond, domain.NewError(domain.ErrTooManyRequests, domain.CodeRateLimited, "tenant acme is limited to 5 requests per second", nil))
+	app := setupApp()
+	app.Get("/api/v1/tasks", Tenant(newTenantMockService(), true), RateLimit(mockQuotaService), func(c *fiber.Ctx) error {
+		return c.SendString(domain.TenantOf(c.UserContext()))
+	})
+
+	req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+	req.Header.Set(domain.TenantHeader, "acme")
+	limitedResp, _ := app.Test(req, 1)
+	body, _ := ioutil.ReadAll(limitedResp.Body)
+	status, tenant := tenantRequest(app, "", "")
+
+	assert.Equal(t, 429, limitedResp.StatusCode)
+	assert.Equal(t, "1", limitedResp.Header.Get(fiber.HeaderRetryAfter))
+	assert.Contains(t, string(body), domain.CodeRateLimited)
+	assert.Equal(t, 200, status)
+	assert.Equal(t, domain.DefaultTenant, tenant)
+}
@@ -0,0 +1,25 @@
+package controller
+
+import (
+	"context"
+	"time"
+
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+// RequestContext gives every request a context which ends with the parent, ex. at shutdown,
+// or when the timeout elapses, the controllers hand it down to the repositories.
+func RequestContext(parent context.Context, timeout time.Duration) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		var ctx context.Context
+		var cancel context.CancelFunc
+		if timeout > 0 {
+			ctx, cancel = context.WithTimeout(parent, timeout)
+		} else {
+			ctx, cancel = context.WithCancel(parent)
+		}
+		defer cancel()
+		c.SetUserContext(ctx)
+		return c.Next()
+	}
+}
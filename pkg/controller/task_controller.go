@@ -1,14 +1,18 @@
 package controller
 
 import (
-	"context"
 	"fmt"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/balchua/bopbag/pkg/domain"
 	fiber "github.com/gofiber/fiber/v2"
 )
 
+// AcceptPatchHeader lists the patch formats, RFC 5789.
+const AcceptPatchHeader = "Accept-Patch"
+
 type TaskController struct {
 	taskService TaskService
 }
@@ -22,74 +26,161 @@ func NewTaskController(taskService TaskService) *TaskController {
 
 func (q *TaskController) NewTask(c *fiber.Ctx) error {
 
-	ctx := context.TODO()
+	ctx := c.UserContext()
 	task := new(domain.Task)
 	if err := c.BodyParser(task); err != nil {
-		return fiber.NewError(fiber.StatusServiceUnavailable, "marshalling error!")
+		return malformed(err)
 	}
 	newTask, err := q.taskService.CreateTask(ctx, task)
 	if err != nil {
-		return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+		return err
 	}
 
 	return c.JSON(newTask)
 }
 
+// FindById returns the task with the numeric id or with the project key, ex. OPS-142.
 func (q *TaskController) FindById(c *fiber.Ctx) error {
-	ctx := context.Background()
+	ctx := c.UserContext()
 	idStr := c.Params("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 	if err != nil {
-		return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+		if _, ok := domain.ProjectOfTaskKey(idStr); ok {
+			task, queryError := q.taskService.GetTaskByKey(ctx, idStr)
+			if queryError != nil {
+				return queryError
+			}
+			return c.JSON(task)
+		}
+		return malformed(err)
 	}
 	task, queryError := q.taskService.GetTaskById(ctx, id)
 	if queryError != nil {
-		return fiber.NewError(fiber.StatusServiceUnavailable, queryError.Error())
+		return queryError
 	}
 
 	return c.JSON(task)
 
 }
 
+// FindAll returns the tasks, optionally in the date ranges of the createdAfter, createdBefore, updatedAfter,
+// updatedBefore, completedAfter and completedBefore parameters.
 func (q *TaskController) FindAll(c *fiber.Ctx) error {
-	ctx := context.Background()
-	tasks, queryError := q.taskService.GetAllTasks(ctx)
+	ctx := c.UserContext()
+	filter, err := taskFilter(c)
+	if err != nil {
+		return err
+	}
+	tasks, queryError := q.taskService.GetAllTasks(ctx, filter)
 	if queryError != nil {
-		return fiber.NewError(fiber.StatusServiceUnavailable, queryError.Error())
+		return queryError
 	}
 
 	return c.JSON(tasks)
 
 }
 
+// taskFilter reads the date ranges of the query parameters.
+func taskFilter(c *fiber.Ctx) (domain.TaskFilter, error) {
+	var filter domain.TaskFilter
+	bounds := []struct {
+		param string
+		bound *time.Time
+	}{
+		{"createdAfter", &filter.CreatedAfter},
+		{"createdBefore", &filter.CreatedBefore},
+		{"updatedAfter", &filter.UpdatedAfter},
+		{"updatedBefore", &filter.UpdatedBefore},
+		{"completedAfter", &filter.CompletedAfter},
+		{"completedBefore", &filter.CompletedBefore},
+	}
+	for _, b := range bounds {
+		bound, err := queryTime(c, b.param)
+		if err != nil {
+			return filter, err
+		}
+		*b.bound = bound
+	}
+	return filter, nil
+}
+
 func (q *TaskController) UpdateTask(c *fiber.Ctx) error {
 
-	ctx := context.TODO()
+	ctx := c.UserContext()
 	task := new(domain.Task)
 	idStr := c.Params("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
+	if err != nil {
+		return malformed(err)
+	}
 	if err := c.BodyParser(task); err != nil {
-		return fiber.NewError(fiber.StatusServiceUnavailable, "marshalling error!")
+		return malformed(err)
 	}
 	task.Id = id
 	newTask, err := q.taskService.UpdateTask(ctx, task)
 	if err != nil {
-		return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+		return err
 	}
 
 	return c.JSON(newTask)
 }
 
+// PatchTask applies the merge patch or the JSON patch of the body, the plain JSON body is a merge patch.
+func (q *TaskController) PatchTask(c *fiber.Ctx) error {
+	ctx := c.UserContext()
+	id, err := strconv.ParseInt(c.Params("id"), 10, 64)
+	if err != nil {
+		return malformed(err)
+	}
+	var patchType string
+	switch mediaType(c) {
+	case domain.MergePatchType, fiber.MIMEApplicationJSON:
+		patchType = domain.MergePatchType
+	case domain.JSONPatchType:
+		patchType = domain.JSONPatchType
+	default:
+		c.Set(AcceptPatchHeader, domain.MergePatchType+", "+domain.JSONPatchType)
+		return fiber.NewError(fiber.StatusUnsupportedMediaType, "the patch must be a "+domain.MergePatchType+" or a "+domain.JSONPatchType)
+	}
+	task, err := q.taskService.PatchTask(ctx, id, patchType, c.Body())
+	if err != nil {
+		return err
+	}
+
+	return c.JSON(task)
+}
+
+// queryTime reads the RFC 3339 time of the query parameter in UTC, it is zero when the parameter is missing.
+func queryTime(c *fiber.Ctx, param string) (time.Time, error) {
+	value := c.Query(param)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	// the + of an offset which was not escaped is decoded as a space
+	t, err := time.Parse(time.RFC3339, strings.ReplaceAll(value, " ", "+"))
+	if err != nil {
+		return time.Time{}, domain.NewError(domain.ErrMalformed, domain.CodeMalformedRequest,
+			param+" must be an RFC 3339 date and time", err)
+	}
+	return t.UTC(), nil
+}
+
+// mediaType returns the content type of the request without its parameters.
+func mediaType(c *fiber.Ctx) string {
+	contentType := string(c.Request().Header.ContentType())
+	return strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+}
+
 func (q *TaskController) DeleteTask(c *fiber.Ctx) error {
-	ctx := context.TODO()
+	ctx := c.UserContext()
 	idStr := c.Params("id")
 	id, err := strconv.ParseInt(idStr, 10, 64)
 
 	if err != nil {
-		return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+		return malformed(err)
 	}
 	if err := q.taskService.DeleteTask(ctx, id); err != nil {
-		return fiber.NewError(fiber.StatusServiceUnavailable, err.Error())
+		return err
 	}
 
 	return c.JSON(fmt.Sprintf("task %d is deleted", id))
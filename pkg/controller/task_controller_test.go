@@ -6,6 +6,7 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/balchua/bopbag/pkg/domain"
 	fiber "github.com/gofiber/fiber/v2"
@@ -27,6 +28,11 @@ func (m *MockTaskService) UpdateTask(ctx context.Context, task *domain.Task) (*d
 	return args.Get(0).(*domain.Task), args.Error(1)
 }
 
+func (m *MockTaskService) PatchTask(ctx context.Context, id int64, patchType string, patch []byte) (*domain.Task, error) {
+	args := m.Called(id, patchType, string(patch))
+	return args.Get(0).(*domain.Task), args.Error(1)
+}
+
 func (m *MockTaskService) DeleteTask(ctx context.Context, id int64) error {
 	args := m.Called(id)
 	return args.Error(0)
@@ -36,13 +42,18 @@ func (m *MockTaskService) GetTaskById(ctx context.Context, id int64) (*domain.Ta
 	return args.Get(0).(*domain.Task), args.Error(1)
 }
 
-func (m *MockTaskService) GetAllTasks(ctx context.Context) (*[]domain.Task, error) {
-	args := m.Called()
+func (m *MockTaskService) GetTaskByKey(ctx context.Context, key string) (*domain.Task, error) {
+	args := m.Called(ctx, key)
+	return args.Get(0).(*domain.Task), args.Error(1)
+}
+
+func (m *MockTaskService) GetAllTasks(ctx context.Context, filter domain.TaskFilter) (*[]domain.Task, error) {
+	args := m.Called(filter)
 	return args.Get(0).(*[]domain.Task), args.Error(1)
 }
 
 func setupApp() *fiber.App {
-	app := fiber.New()
+	app := fiber.New(fiber.Config{ErrorHandler: ErrorHandler})
 	return app
 }
 
@@ -72,6 +83,40 @@ func TestMustReturnAllTasks(t *testing.T) {
 
 }
 
+func TestMustFilterTasksByDateRange(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	filter := domain.TaskFilter{
+		CreatedAfter:  time.Date(2021, 10, 1, 8, 0, 0, 0, time.UTC),
+		UpdatedBefore: time.Date(2021, 10, 2, 0, 0, 0, 0, time.UTC),
+	}
+	mockTaskService.On("GetAllTasks", filter).Return(&[]domain.Task{}, nil)
+
+	controller := NewTaskController(mockTaskService)
+	app := setupApp()
+	app.Get("/api/v1/tasks", controller.FindAll)
+
+	// the offsets are converted to UTC, an unescaped + reads as a space
+	req := httptest.NewRequest("GET", "/api/v1/tasks?createdAfter=2021-10-01T10:00:00%2B02:00&updatedBefore=2021-10-02T00:00:00Z", nil)
+	resp, _ := app.Test(req, 1)
+	unescaped := httptest.NewRequest("GET", "/api/v1/tasks?createdAfter=2021-10-01T10:00:00+02:00&updatedBefore=2021-10-02T00:00:00Z", nil)
+	unescapedResp, _ := app.Test(unescaped, 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 200, unescapedResp.StatusCode)
+	mockTaskService.AssertNumberOfCalls(t, "GetAllTasks", 2)
+}
+
+func TestFailFilterWithInvalidDate(t *testing.T) {
+	controller := NewTaskController(new(MockTaskService))
+	app := setupApp()
+	app.Get("/api/v1/tasks", controller.FindAll)
+
+	req := httptest.NewRequest("GET", "/api/v1/tasks?createdAfter=Mon,%2025%20Oct%202021", nil)
+	resp, _ := app.Test(req, 1)
+
+	assert.Equal(t, 400, resp.StatusCode)
+}
+
 func TestMustReturnErrorWhenGetAllTaskFail(t *testing.T) {
 
 	app := setupApp()
@@ -133,10 +178,25 @@ func TestFindInvalidId(t *testing.T) {
 	resp, _ := app.Test(req, 1)
 
 	// Verify, if the status code is as expected
-	assert.Equalf(t, 503, resp.StatusCode, "Get By Id")
+	assert.Equalf(t, 400, resp.StatusCode, "Get By Id")
 
 }
 
+func TestMustBeAbleToFindByKey(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockTaskService.On("GetTaskByKey", mock.Anything, "OPS-142").Return(&domain.Task{Id: 1234, Key: "OPS-142", Project: "OPS"}, nil)
+	controller := NewTaskController(mockTaskService)
+	app := setupApp()
+	app.Get("/api/v1/task/:id", controller.FindById)
+
+	resp, _ := app.Test(httptest.NewRequest("GET", "/api/v1/task/OPS-142", nil), 1)
+	lowerResp, _ := app.Test(httptest.NewRequest("GET", "/api/v1/task/ops-142", nil), 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 400, lowerResp.StatusCode)
+	mockTaskService.AssertNumberOfCalls(t, "GetTaskByKey", 1)
+}
+
 func TestUnableToFindId(t *testing.T) {
 
 	task := &domain.Task{}
@@ -161,10 +221,9 @@ func TestMustBeAbleToInsertATask(t *testing.T) {
 
 	// prepare the mock
 	task := &domain.Task{
-		Id:          0,
-		Title:       "My First Task",
-		Details:     "Here you go, this is what i should do",
-		CreatedDate: "2021-10-25",
+		Id:      0,
+		Title:   "My First Task",
+		Details: "Here you go, this is what i should do",
 	}
 	mockTaskService := new(MockTaskService)
 	mockTaskService.On("CreateTask", mock.Anything, task).Return(task, nil)
@@ -187,10 +246,9 @@ func TestMustBeAbleToInsertATask(t *testing.T) {
 func TestMustFailWhenTaskJsonIsInvalid(t *testing.T) {
 	// prepare the mock
 	task := &domain.Task{
-		Id:          0,
-		Title:       "My First Task",
-		Details:     "Here you go, this is what i should do",
-		CreatedDate: "2021-10-25",
+		Id:      0,
+		Title:   "My First Task",
+		Details: "Here you go, this is what i should do",
 	}
 	mockTaskService := new(MockTaskService)
 	mockTaskService.On("CreateTask", mock.Anything, task).Return(task, fmt.Errorf("json parse error"))
@@ -206,17 +264,16 @@ func TestMustFailWhenTaskJsonIsInvalid(t *testing.T) {
 	resp, _ := app.Test(req, 1)
 
 	// Verify, if the status code is as expected
-	assert.Equalf(t, 503, resp.StatusCode, "New task")
+	assert.Equalf(t, 400, resp.StatusCode, "New task")
 
 }
 
 func TestMustFailWhenUnableToInsertATask(t *testing.T) {
 	// prepare the mock
 	task := &domain.Task{
-		Id:          0,
-		Title:       "My First Task",
-		Details:     "Here you go, this is what i should do",
-		CreatedDate: "2021-10-25",
+		Id:      0,
+		Title:   "My First Task",
+		Details: "Here you go, this is what i should do",
 	}
 	mockTaskService := new(MockTaskService)
 	mockTaskService.On("CreateTask", mock.Anything, task).Return(task, fmt.Errorf("database error"))
@@ -323,6 +380,84 @@ func TestFailUpdateTask(t *testing.T) {
 	resp, _ := app.Test(req, 1)
 
 	// Verify, if the status code is as expected
-	assert.Equalf(t, 503, resp.StatusCode, "Fail to update task")
+	assert.Equalf(t, 400, resp.StatusCode, "Fail to update task")
+
+}
+
+func TestMustHandTheRequestContextToTheService(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	hasDeadline := mock.MatchedBy(func(ctx context.Context) bool {
+		_, ok := ctx.Deadline()
+		return ok
+	})
+	mockTaskService.On("GetTaskById", hasDeadline, int64(1234)).Return(&domain.Task{Id: 1234}, nil)
+
+	controller := NewTaskController(mockTaskService)
+	app := setupApp()
+	app.Use(RequestContext(context.Background(), time.Minute))
+	app.Get("/api/v1/task/:id", controller.FindById)
+
+	req := httptest.NewRequest("GET", "/api/v1/task/1234", nil)
+	resp, _ := app.Test(req, 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	mockTaskService.AssertExpectations(t)
+}
+
+func TestFailWhenTheServerShutsDown(t *testing.T) {
+	parent, cancel := context.WithCancel(context.Background())
+	cancel()
+	mockTaskService := new(MockTaskService)
+	cancelled := mock.MatchedBy(func(ctx context.Context) bool {
+		return ctx.Err() == context.Canceled
+	})
+	mockTaskService.On("GetTaskById", cancelled, int64(1234)).Return((*domain.Task)(nil),
+		domain.NewError(domain.ErrUnavailable, domain.CodeUnavailable, "task.findById abandoned", context.Canceled))
+
+	controller := NewTaskController(mockTaskService)
+	app := setupApp()
+	app.Use(RequestContext(parent, 0))
+	app.Get("/api/v1/task/:id", controller.FindById)
+
+	req := httptest.NewRequest("GET", "/api/v1/task/1234", nil)
+	resp, _ := app.Test(req, 1)
+
+	assert.Equal(t, 503, resp.StatusCode)
+	mockTaskService.AssertExpectations(t)
+}
+
+func TestMustPatchTask(t *testing.T) {
+	mockTaskService := new(MockTaskService)
+	mockTaskService.On("PatchTask", int64(1), domain.MergePatchType, `{"title":"new title"}`).
+		Return(&domain.Task{Id: 1, Title: "new title", Details: "details"}, nil)
+	mockTaskService.On("PatchTask", int64(1), domain.JSONPatchType, `[{"op":"remove","path":"/details"}]`).
+		Return((*domain.Task)(nil), domain.NewError(domain.ErrValidation, domain.CodeInvalidTask, "the title and the details of the task are required", nil))
+
+	controller := NewTaskController(mockTaskService)
+	app := setupApp()
+	app.Patch("/api/v1/task/:id", controller.PatchTask)
+
+	merge := httptest.NewRequest("PATCH", "/api/v1/task/1", strings.NewReader(`{"title":"new title"}`))
+	merge.Header.Set("Content-Type", domain.MergePatchType)
+	mergeResp, _ := app.Test(merge, 1)
+	patch := httptest.NewRequest("PATCH", "/api/v1/task/1", strings.NewReader(`[{"op":"remove","path":"/details"}]`))
+	patch.Header.Set("Content-Type", domain.JSONPatchType+"; charset=utf-8")
+	patchResp, _ := app.Test(patch, 1)
+
+	assert.Equal(t, 200, mergeResp.StatusCode)
+	assert.Equal(t, 422, patchResp.StatusCode)
+	mockTaskService.AssertExpectations(t)
+}
+
+func TestFailPatchWithUnsupportedMediaType(t *testing.T) {
+	controller := NewTaskController(new(MockTaskService))
+	app := setupApp()
+	app.Patch("/api/v1/task/:id", controller.PatchTask)
+
+	req := httptest.NewRequest("PATCH", "/api/v1/task/1", strings.NewReader(`<title>new title</title>`))
+	req.Header.Set("Content-Type", "application/xml")
+	resp, _ := app.Test(req, 1)
 
+	assert.Equal(t, 415, resp.StatusCode)
+	assert.Equal(t, domain.MergePatchType+", "+domain.JSONPatchType, resp.Header.Get(AcceptPatchHeader))
 }
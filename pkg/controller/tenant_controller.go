@@ -0,0 +1,101 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+const bearerPrefix = "Bearer "
+
+type TenantController struct {
+	tenantService TenantService
+}
+
+func NewTenantController(tenantService TenantService) *TenantController {
+	return &TenantController{
+		tenantService: tenantService,
+	}
+}
+
+// NewTenant creates the tenant, the response carries its token, which is not shown again.
+func (t *TenantController) NewTenant(c *fiber.Ctx) error {
+	tenant := new(domain.Tenant)
+	if err := c.BodyParser(tenant); err != nil {
+		return malformed(err)
+	}
+	newTenant, err := t.tenantService.CreateTenant(c.UserContext(), tenant)
+	if err != nil {
+		return err
+	}
+	return c.Status(fiber.StatusCreated).JSON(newTenant)
+}
+
+func (t *TenantController) FindById(c *fiber.Ctx) error {
+	tenant, err := t.tenantService.GetTenantById(c.UserContext(), c.Params("id"))
+	if err != nil {
+		return err
+	}
+	return c.JSON(tenant)
+}
+
+func (t *TenantController) FindAll(c *fiber.Ctx) error {
+	tenants, err := t.tenantService.GetAllTenants(c.UserContext())
+	if err != nil {
+		return err
+	}
+	return c.JSON(tenants)
+}
+
+// DeleteTenant removes the tenant, the cascade=true parameter removes its tasks and projects with it.
+func (t *TenantController) DeleteTenant(c *fiber.Ctx) error {
+	id := c.Params("id")
+	if err := t.tenantService.DeleteTenant(c.UserContext(), id, c.Query("cascade") == "true"); err != nil {
+		return err
+	}
+	return c.JSON(fmt.Sprintf("tenant %s is deleted", id))
+}
+
+// Tenant hands the tenant of the request down to the repositories. A request with a bearer token in its
+// Authorization header belongs to the tenant of the token. A request with a X-Tenant-Id header belongs to
+// the tenant it names when trustHeader is set, the nodes are behind a proxy which authenticated it.
+// The other requests belong to the default tenant.
+func Tenant(service TenantService, trustHeader bool) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		ctx := c.UserContext()
+		named := c.Get(domain.TenantHeader)
+		tenant := domain.DefaultTenant
+		switch authorization := c.Get(fiber.HeaderAuthorization); {
+		case authorization != "":
+			token := strings.TrimPrefix(authorization, bearerPrefix)
+			if token == authorization || token == "" {
+				return fiber.NewError(fiber.StatusUnauthorized, "a bearer token is required")
+			}
+			found, err := service.Authenticate(ctx, token)
+			if errors.Is(err, domain.ErrNotFound) {
+				return fiber.NewError(fiber.StatusUnauthorized, "unknown tenant token")
+			} else if err != nil {
+				return err
+			}
+			if named != "" && named != found.Id {
+				return fiber.NewError(fiber.StatusForbidden, "the token does not belong to tenant "+named)
+			}
+			tenant = found.Id
+		case named == "" || named == domain.DefaultTenant:
+		case !trustHeader:
+			return fiber.NewError(fiber.StatusUnauthorized, "the "+domain.TenantHeader+" header is not trusted, a tenant token is required")
+		default:
+			if _, err := service.GetTenantById(ctx, named); errors.Is(err, domain.ErrNotFound) {
+				return fiber.NewError(fiber.StatusUnauthorized, "unknown tenant "+named)
+			} else if err != nil {
+				return err
+			}
+			tenant = named
+		}
+		c.SetUserContext(domain.WithTenant(ctx, tenant))
+		return c.Next()
+	}
+}
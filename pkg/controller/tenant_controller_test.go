@@ -0,0 +1,216 @@
+package controller
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	fiber "github.com/gofiber/fiber/v2"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockTenantService struct {
+	mock.Mock
+}
+
+func (m *MockTenantService) CreateTenant(ctx context.Context, tenant *domain.Tenant) (*domain.Tenant, error) {
+	args := m.Called(tenant)
+	return args.Get(0).(*domain.Tenant), args.Error(1)
+}
+
+func (m *MockTenantService) DeleteTenant(ctx context.Context, id string, cascade bool) error {
+	args := m.Called(id, cascade)
+	return args.Error(0)
+}
+
+func (m *MockTenantService) GetTenantById(ctx context.Context, id string) (*domain.Tenant, error) {
+	args := m.Called(id)
+	return args.Get(0).(*domain.Tenant), args.Error(1)
+}
+
+func (m *MockTenantService) GetAllTenants(ctx context.Context) (*[]domain.Tenant, error) {
+	args := m.Called()
+	return args.Get(0).(*[]domain.Tenant), args.Error(1)
+}
+
+func (m *MockTenantService) Authenticate(ctx context.Context, token string) (*domain.Tenant, error) {
+	args := m.Called(token)
+	return args.Get(0).(*domain.Tenant), args.Error(1)
+}
+
+var unknownTenant = domain.NewError(domain.ErrNotFound, domain.CodeTenantNotFound, "tenant not found", nil)
+
+func newTenantMockService() *MockTenantService {
+	mockTenantService := new(MockTenantService)
+	mockTenantService.On("Authenticate", "secret").Return(&domain.Tenant{Id: "acme"}, nil)
+	mockTenantService.On("Authenticate", mock.Anything).Return((*domain.Tenant)(nil), unknownTenant)
+	mockTenantService.On("GetTenantById", "acme").Return(&domain.Tenant{Id: "acme"}, nil)
+	mockTenantService.On("GetTenantById", mock.Anything).Return((*domain.Tenant)(nil), unknownTenant)
+	return mockTenantService
+}
+
+// tenantRequest answers the tenant the handler was given.
+func tenantRequest(app *fiber.App, authorization string, tenant string) (int, string) {
+	req := httptest.NewRequest("GET", "/api/v1/tasks", nil)
+	if authorization != "" {
+		req.Header.Set(fiber.HeaderAuthorization, authorization)
+	}
+	if tenant != "" {
+		req.Header.Set(domain.TenantHeader, tenant)
+	}
+	resp, _ := app.Test(req, 1)
+	body, _ := ioutil.ReadAll(resp.Body)
+	return resp.StatusCode, string(body)
+}
+
+func setupTenantApp(service TenantService, trustHeader bool) *fiber.App {
+	app := setupApp()
+	app.Get("/api/v1/tasks", Tenant(service, trustHeader), func(c *fiber.Ctx) error {
+		return c.SendString(domain.TenantOf(c.UserContext()))
+	})
+	return app
+}
+
+func TestMustResolveTheTenantOfTheToken(t *testing.T) {
+	app := setupTenantApp(newTenantMockService(), false)
+
+	status, tenant := tenantRequest(app, "Bearer secret", "")
+	namedStatus, namedTenant := tenantRequest(app, "Bearer secret", "acme")
+	defaultStatus, defaultTenant := tenantRequest(app, "", "")
+
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "acme", tenant)
+	assert.Equal(t, 200, namedStatus)
+	assert.Equal(t, "acme", namedTenant)
+	assert.Equal(t, 200, defaultStatus)
+	assert.Equal(t, domain.DefaultTenant, defaultTenant)
+}
+
+func TestFailWithoutTheCredentialsOfTheTenant(t *testing.T) {
+	app := setupTenantApp(newTenantMockService(), false)
+
+	unknownStatus, _ := tenantRequest(app, "Bearer guess", "")
+	basicStatus, _ := tenantRequest(app, "Basic c2VjcmV0", "")
+	untrustedStatus, _ := tenantRequest(app, "", "acme")
+	otherStatus, _ := tenantRequest(app, "Bearer secret", "globex")
+
+	assert.Equal(t, 401, unknownStatus)
+	assert.Equal(t, 401, basicStatus)
+	assert.Equal(t, 401, untrustedStatus)
+	assert.Equal(t, 403, otherStatus)
+}
+
+func TestMustTrustTheTenantHeaderOfTheProxy(t *testing.T) {
+	app := setupTenantApp(newTenantMockService(), true)
+
+	status, tenant := tenantRequest(app, "", "acme")
+	unknownStatus, _ := tenantRequest(app, "", "globex")
+
+	assert.Equal(t, 200, status)
+	assert.Equal(t, "acme", tenant)
+	assert.Equal(t, 401, unknownStatus)
+}
+
+func TestMustScopeTheIdempotencyKeyOfTheTenant(t *testing.T) {
+	mockService := new(MockIdempotencyService)
+	mockService.On("Begin", "k1", mock.Anything).Return((*domain.IdempotencyRecord)(nil), nil)
+	mockService.On("Complete", "k1", 200, mock.Anything).Return(nil)
+	app := setupApp()
+	app.Post("/api/v1/task", Tenant(newTenantMockService(), false), Idempotent(mockService), func(c *fiber.Ctx) error {
+		assert.Equal(t, "acme/k1", domain.IdempotencyKeyOf(c.UserContext()))
+		return c.JSON(&domain.Task{Id: 12})
+	})
+	req := newTaskRequest("k1")
+	req.Header.Set(fiber.HeaderAuthorization, "Bearer secret")
+
+	resp, _ := app.Test(req, 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	mockService.AssertExpectations(t)
+}
+
+// recordedIdempotencyService records the responses under the key of the tenant, like the idempotency service.
+type recordedIdempotencyService struct {
+	records map[string]*domain.IdempotencyRecord
+}
+
+func (r *recordedIdempotencyService) Begin(ctx context.Context, key string, fingerprint string) (*domain.IdempotencyRecord, error) {
+	return r.records[domain.TenantIdempotencyKey(ctx, key)], nil
+}
+
+func (r *recordedIdempotencyService) Complete(ctx context.Context, key string, status int, contentType string, body []byte) error {
+	r.records[domain.TenantIdempotencyKey(ctx, key)] = &domain.IdempotencyRecord{Status: status, ContentType: contentType, Body: body}
+	return nil
+}
+
+func TestFailToReplayTheKeyOfAnotherTenant(t *testing.T) {
+	service := &recordedIdempotencyService{records: map[string]*domain.IdempotencyRecord{}}
+	app := setupApp()
+	app.Post("/api/v1/task", Tenant(newTenantMockService(), false), Idempotent(service), func(c *fiber.Ctx) error {
+		return c.JSON(&domain.Task{Id: 12, Title: domain.TenantOf(c.UserContext())})
+	})
+	acme := newTaskRequest("k1")
+	acme.Header.Set(fiber.HeaderAuthorization, "Bearer secret")
+	app.Test(acme, 1)
+
+	resp, _ := app.Test(newTaskRequest("acme/k1"), 1)
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Empty(t, resp.Header.Get(IdempotentReplayedHeader))
+	assert.Contains(t, string(body), `"title":"default"`)
+	assert.Len(t, service.records, 2)
+}
+
+func TestMustBeAbleToCreateATenant(t *testing.T) {
+	mockTenantService := new(MockTenantService)
+	mockTenantService.On("CreateTenant", &domain.Tenant{Id: "acme", Name: "Acme"}).
+		Return(&domain.Tenant{Id: "acme", Name: "Acme", Token: "secret"}, nil)
+	controller := NewTenantController(mockTenantService)
+	app := setupApp()
+	app.Post("/api/v1/admin/tenants", controller.NewTenant)
+
+	req := httptest.NewRequest("POST", "/api/v1/admin/tenants", strings.NewReader(`{"id":"acme","name":"Acme"}`))
+	req.Header.Set("Content-Type", "application/json")
+	resp, _ := app.Test(req, 1)
+	body, _ := ioutil.ReadAll(resp.Body)
+
+	assert.Equal(t, 201, resp.StatusCode)
+	assert.Contains(t, string(body), `"token":"secret"`)
+}
+
+func TestMustReturnTheTenants(t *testing.T) {
+	mockTenantService := new(MockTenantService)
+	mockTenantService.On("GetAllTenants").Return(&[]domain.Tenant{{Id: "acme", Name: "Acme"}}, nil)
+	mockTenantService.On("GetTenantById", "globex").Return((*domain.Tenant)(nil), unknownTenant)
+	controller := NewTenantController(mockTenantService)
+	app := setupApp()
+	app.Get("/api/v1/admin/tenants", controller.FindAll)
+	app.Get("/api/v1/admin/tenants/:id", controller.FindById)
+
+	resp, _ := app.Test(httptest.NewRequest("GET", "/api/v1/admin/tenants", nil), 1)
+	missingResp, _ := app.Test(httptest.NewRequest("GET", "/api/v1/admin/tenants/globex", nil), 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 404, missingResp.StatusCode)
+}
+
+func TestMustDeleteTheTenantWithCascade(t *testing.T) {
+	mockTenantService := new(MockTenantService)
+	mockTenantService.On("DeleteTenant", "acme", true).Return(nil)
+	mockTenantService.On("DeleteTenant", "globex", false).
+		Return(domain.NewError(domain.ErrConflict, domain.CodeTenantNotEmpty, "tenant globex has 2 tasks and 0 projects", nil))
+	controller := NewTenantController(mockTenantService)
+	app := setupApp()
+	app.Delete("/api/v1/admin/tenants/:id", controller.DeleteTenant)
+
+	resp, _ := app.Test(httptest.NewRequest("DELETE", "/api/v1/admin/tenants/acme?cascade=true", nil), 1)
+	notEmptyResp, _ := app.Test(httptest.NewRequest("DELETE", "/api/v1/admin/tenants/globex", nil), 1)
+
+	assert.Equal(t, 200, resp.StatusCode)
+	assert.Equal(t, 409, notEmptyResp.StatusCode)
+}
@@ -0,0 +1,35 @@
+package controller
+
+import (
+	"errors"
+	"strconv"
+
+	fiber "github.com/gofiber/fiber/v2"
+)
+
+type UpgradeController struct {
+	service UpgradeService
+}
+
+func NewUpgradeController(upgradeService UpgradeService) *UpgradeController {
+	return &UpgradeController{
+		service: upgradeService,
+	}
+}
+
+// ShowPlan lists the nodes to upgrade to ?version=, ?schemaVersion= is the schema supported by that version.
+func (u *UpgradeController) ShowPlan(c *fiber.Ctx) error {
+	targetVersion := c.Query("version")
+	if targetVersion == "" {
+		return malformed(errors.New("the target version is required"))
+	}
+	targetSchemaVersion, err := strconv.Atoi(c.Query("schemaVersion", "0"))
+	if err != nil {
+		return malformed(err)
+	}
+	plan, err := u.service.Plan(targetVersion, targetSchemaVersion)
+	if err != nil {
+		return err
+	}
+	return c.JSON(plan)
+}
@@ -0,0 +1,62 @@
+package controller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockUpgradeService struct {
+	mock.Mock
+}
+
+func (m *MockUpgradeService) Plan(targetVersion string, targetSchemaVersion int) (*domain.UpgradePlan, error) {
+	args := m.Called(targetVersion, targetSchemaVersion)
+	return args.Get(0).(*domain.UpgradePlan), args.Error(1)
+}
+
+func TestMustReturnUpgradePlan(t *testing.T) {
+	app := setupApp()
+	plan := &domain.UpgradePlan{TargetVersion: "v0.3.0", Steps: []domain.UpgradeStep{{Order: 1, Address: "norse:9001"}}}
+	mockUpgradeService := new(MockUpgradeService)
+	mockUpgradeService.On("Plan", "v0.3.0", 2).Return(plan, nil)
+	controller := NewUpgradeController(mockUpgradeService)
+	app.Get("/api/v1/cluster/upgrade-plan", controller.ShowPlan)
+
+	req := httptest.NewRequest("GET", "/api/v1/cluster/upgrade-plan?version=v0.3.0&schemaVersion=2", nil)
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 200, resp.StatusCode, "Show upgrade plan")
+	bodyBytes, _ := ioutil.ReadAll(resp.Body)
+	assert.Contains(t, string(bodyBytes), "norse:9001")
+}
+
+func TestFailUpgradePlanWithoutVersion(t *testing.T) {
+	app := setupApp()
+	controller := NewUpgradeController(new(MockUpgradeService))
+	app.Get("/api/v1/cluster/upgrade-plan", controller.ShowPlan)
+
+	req := httptest.NewRequest("GET", "/api/v1/cluster/upgrade-plan", nil)
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 400, resp.StatusCode, "missing version")
+}
+
+func TestFailUpgradePlan(t *testing.T) {
+	app := setupApp()
+	var plan *domain.UpgradePlan
+	mockUpgradeService := new(MockUpgradeService)
+	mockUpgradeService.On("Plan", "v0.3.0", 0).Return(plan, fmt.Errorf("no leader"))
+	controller := NewUpgradeController(mockUpgradeService)
+	app.Get("/api/v1/cluster/upgrade-plan", controller.ShowPlan)
+
+	req := httptest.NewRequest("GET", "/api/v1/cluster/upgrade-plan?version=v0.3.0", nil)
+	resp, _ := app.Test(req, 1)
+
+	assert.Equalf(t, 503, resp.StatusCode, "no upgrade plan")
+}
@@ -0,0 +1,96 @@
+package discovery
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/canonical/go-dqlite/client"
+	"go.uber.org/zap"
+)
+
+// Discoverer lists the dqlite addresses of the peers a node may form a cluster with.
+type Discoverer interface {
+	Peers(ctx context.Context) ([]string, error)
+}
+
+// Config controls how a node finds out whether to join an existing cluster or to bootstrap a new one.
+type Config struct {
+	// Address is the dqlite address of this node.
+	Address string
+	// Quorum is the number of peers which must report that they do not belong to any cluster
+	// before a new cluster is bootstrapped.
+	Quorum int
+	// Interval is the time to wait between two discovery rounds.
+	Interval time.Duration
+	// Dial is used to reach the dqlite nodes of an existing cluster.
+	Dial client.DialFunc
+}
+
+// Join looks up the peers of this node until it can decide how to start.
+//
+// It returns the addresses of the reachable members of an existing cluster, or nil when this node
+// must bootstrap a new cluster. Amongst the peers that agree no cluster exists, only the one with the
+// lowest address bootstraps, the others join it on a later round.
+func Join(ctx context.Context, discoverer Discoverer, cfg Config, log *applog.Logger) ([]string, error) {
+	announcer, err := newAnnouncer(cfg.Address)
+	if err != nil {
+		return nil, err
+	}
+	defer announcer.Close()
+
+	for {
+		join, bootstrap, err := discover(ctx, discoverer, cfg, log)
+		if err != nil {
+			log.Log.Warn("peer discovery failed", zap.Error(err))
+		}
+		if len(join) > 0 {
+			log.Log.Info("joining existing cluster", zap.Strings("members", join))
+			return join, nil
+		}
+		if bootstrap {
+			log.Log.Info("no cluster found, bootstrapping a new one", zap.String("address", cfg.Address))
+			return nil, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, fmt.Errorf("unable to discover a cluster to join: %w", ctx.Err())
+		case <-time.After(cfg.Interval):
+		}
+	}
+}
+
+func discover(ctx context.Context, discoverer Discoverer, cfg Config, log *applog.Logger) ([]string, bool, error) {
+	peers, err := discoverer.Peers(ctx)
+	if err != nil {
+		return nil, false, err
+	}
+
+	members := make([]string, 0)
+	lowest := true
+	agreeing := 0
+	for _, peer := range peers {
+		state, announced := probe(ctx, peer, cfg.Dial)
+		switch state {
+		case member:
+			members = append(members, peer)
+		case unclustered:
+			if announced == cfg.Address {
+				continue
+			}
+			agreeing++
+			if announced < cfg.Address {
+				lowest = false
+			}
+		}
+	}
+	log.Log.Info("peer discovery round", zap.Strings("peers", peers), zap.Int("members", len(members)),
+		zap.Int("unclustered", agreeing))
+
+	if len(members) > 0 {
+		return members, false, nil
+	}
+	return nil, agreeing >= cfg.Quorum && lowest, nil
+}
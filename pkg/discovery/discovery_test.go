@@ -0,0 +1,76 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/stretchr/testify/assert"
+)
+
+func freeAddress(t *testing.T) (string, int) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to find a free port %v", err)
+	}
+	defer listener.Close()
+	port := listener.Addr().(*net.TCPAddr).Port
+	return "127.0.0.1:" + strconv.Itoa(port), port
+}
+
+func TestMustAnswerDiscoveryProbe(t *testing.T) {
+	address, _ := freeAddress(t)
+	announcer, err := newAnnouncer(address)
+	assert.Nil(t, err)
+	defer announcer.Close()
+
+	state, announced := probe(context.Background(), address, client.DefaultDialFunc)
+	assert.Equal(t, unclustered, state)
+	assert.Equal(t, address, announced)
+}
+
+func TestUnreachablePeer(t *testing.T) {
+	address, _ := freeAddress(t)
+
+	state, _ := probe(context.Background(), address, client.DefaultDialFunc)
+	assert.Equal(t, unreachable, state)
+}
+
+func TestMustBootstrapWhenAlone(t *testing.T) {
+	address, port := freeAddress(t)
+	announcer, err := newAnnouncer(address)
+	assert.Nil(t, err)
+	defer announcer.Close()
+
+	cfg := Config{Address: address, Quorum: 0, Dial: client.DefaultDialFunc}
+	join, bootstrap, err := discover(context.Background(), NewDNS("localhost", "", port), cfg, applog.NewLogger())
+	assert.Nil(t, err)
+	assert.Nil(t, join)
+	assert.True(t, bootstrap)
+}
+
+func TestMustWaitForQuorumBeforeBootstrapping(t *testing.T) {
+	address, port := freeAddress(t)
+	announcer, err := newAnnouncer(address)
+	assert.Nil(t, err)
+	defer announcer.Close()
+
+	cfg := Config{Address: address, Quorum: 2, Dial: client.DefaultDialFunc}
+	_, bootstrap, err := discover(context.Background(), NewDNS("localhost", "", port), cfg, applog.NewLogger())
+	assert.Nil(t, err)
+	assert.False(t, bootstrap)
+}
+
+func TestGiveUpDiscoveryAfterTimeout(t *testing.T) {
+	address, port := freeAddress(t)
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+
+	cfg := Config{Address: address, Quorum: 2, Interval: 10 * time.Millisecond, Dial: client.DefaultDialFunc}
+	_, err := Join(ctx, NewDNS("localhost", "", port), cfg, applog.NewLogger())
+	assert.NotNil(t, err)
+}
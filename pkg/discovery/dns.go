@@ -0,0 +1,60 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// DNS resolves the peers of a node from a headless service name or from an SRV record.
+type DNS struct {
+	// Name is a host name resolving to the address of every peer, e.g. a kubernetes headless service.
+	Name string
+	// Service is an SRV record listing the peers along with their dqlite port, e.g. _dqlite._tcp.bopbag-headless.
+	// It takes precedence over Name.
+	Service string
+	// Port is the dqlite port of the peers found through Name.
+	Port int
+
+	resolver *net.Resolver
+}
+
+func NewDNS(name string, service string, port int) *DNS {
+	return &DNS{
+		Name:     name,
+		Service:  service,
+		Port:     port,
+		resolver: net.DefaultResolver,
+	}
+}
+
+// Peers returns the dqlite addresses of every peer currently registered in DNS.
+func (d *DNS) Peers(ctx context.Context) ([]string, error) {
+	if d.Service != "" {
+		return d.lookupSRV(ctx)
+	}
+
+	hosts, err := d.resolver.LookupHost(ctx, d.Name)
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		peers = append(peers, net.JoinHostPort(host, strconv.Itoa(d.Port)))
+	}
+	return peers, nil
+}
+
+func (d *DNS) lookupSRV(ctx context.Context) ([]string, error) {
+	_, records, err := d.resolver.LookupSRV(ctx, "", "", d.Service)
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]string, 0, len(records))
+	for _, record := range records {
+		host := strings.TrimSuffix(record.Target, ".")
+		peers = append(peers, net.JoinHostPort(host, strconv.Itoa(int(record.Port))))
+	}
+	return peers, nil
+}
@@ -0,0 +1,115 @@
+package discovery
+
+import (
+	"context"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const multicastPrefix = "bopbag "
+
+// Multicast announces the dqlite address of this node on a UDP multicast group of the local network
+// and listens to the announcements of its peers.
+//
+// Unlike the other discoverers it must keep running once the node has started, so that nodes started
+// later on can find it.
+type Multicast struct {
+	address  string
+	interval time.Duration
+	group    *net.UDPAddr
+	conn     *net.UDPConn
+
+	mu    sync.Mutex
+	seen  map[string]time.Time
+	close chan struct{}
+	done  sync.WaitGroup
+}
+
+// NewMulticast joins the multicast group, ex. 239.255.42.99:9999, and starts announcing the address every interval.
+func NewMulticast(group string, address string, interval time.Duration) (*Multicast, error) {
+	groupAddr, err := net.ResolveUDPAddr("udp4", group)
+	if err != nil {
+		return nil, err
+	}
+	conn, err := net.ListenMulticastUDP("udp4", nil, groupAddr)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Multicast{
+		address:  address,
+		interval: interval,
+		group:    groupAddr,
+		conn:     conn,
+		seen:     make(map[string]time.Time),
+		close:    make(chan struct{}),
+	}
+	m.done.Add(2)
+	go m.listen()
+	go m.announce()
+	return m, nil
+}
+
+// Peers returns the addresses announced recently, including the address of this node.
+func (m *Multicast) Peers(ctx context.Context) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	peers := make([]string, 0, len(m.seen))
+	for address, last := range m.seen {
+		if time.Since(last) > 3*m.interval {
+			delete(m.seen, address)
+			continue
+		}
+		peers = append(peers, address)
+	}
+	sort.Strings(peers)
+	return peers, nil
+}
+
+// Close stops announcing this node and leaves the multicast group.
+func (m *Multicast) Close() {
+	close(m.close)
+	m.conn.Close()
+	m.done.Wait()
+}
+
+func (m *Multicast) announce() {
+	defer m.done.Done()
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+
+	for {
+		if sender, err := net.DialUDP("udp4", nil, m.group); err == nil {
+			sender.Write([]byte(multicastPrefix + m.address))
+			sender.Close()
+		}
+		select {
+		case <-m.close:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (m *Multicast) listen() {
+	defer m.done.Done()
+	buf := make([]byte, 512)
+	for {
+		n, _, err := m.conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		message := string(buf[:n])
+		if !strings.HasPrefix(message, multicastPrefix) {
+			continue
+		}
+		address := strings.TrimSpace(strings.TrimPrefix(message, multicastPrefix))
+		m.mu.Lock()
+		m.seen[address] = time.Now()
+		m.mu.Unlock()
+	}
+}
@@ -0,0 +1,35 @@
+package discovery
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustHearMulticastAnnouncements(t *testing.T) {
+	first, err := NewMulticast("239.255.42.99:19999", "norse:9000", 50*time.Millisecond)
+	if err != nil {
+		t.Skipf("multicast is not available %v", err)
+	}
+	defer first.Close()
+	second, err := NewMulticast("239.255.42.99:19999", "norse:9001", 50*time.Millisecond)
+	if err != nil {
+		t.Skipf("multicast is not available %v", err)
+	}
+	defer second.Close()
+
+	var peers []string
+	for i := 0; i < 20; i++ {
+		time.Sleep(50 * time.Millisecond)
+		peers, _ = first.Peers(context.Background())
+		if len(peers) == 2 {
+			break
+		}
+	}
+	if len(peers) == 0 {
+		t.Skip("multicast loopback is not available")
+	}
+	assert.Equal(t, []string{"norse:9000", "norse:9001"}, peers)
+}
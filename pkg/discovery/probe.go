@@ -0,0 +1,114 @@
+package discovery
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+const (
+	probeRequest    = "bopbag-discovery"
+	unclusteredResp = "unclustered"
+	probeTimeout    = 2 * time.Second
+)
+
+type peerState int
+
+const (
+	unreachable peerState = iota
+	unclustered
+	member
+)
+
+// announcer answers discovery probes on the dqlite address of a node which has not started dqlite yet,
+// telling its peers that it does not belong to any cluster.
+type announcer struct {
+	address  string
+	listener net.Listener
+	done     chan struct{}
+}
+
+func newAnnouncer(address string) (*announcer, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	a := &announcer{
+		address:  address,
+		listener: listener,
+		done:     make(chan struct{}),
+	}
+	go a.serve()
+	return a, nil
+}
+
+func (a *announcer) serve() {
+	defer close(a.done)
+	for {
+		conn, err := a.listener.Accept()
+		if err != nil {
+			return
+		}
+		go a.answer(conn)
+	}
+}
+
+func (a *announcer) answer(conn net.Conn) {
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil || strings.TrimSpace(line) != probeRequest {
+		return
+	}
+	conn.Write([]byte(unclusteredResp + " " + a.address + "\n"))
+}
+
+// Close releases the dqlite address so that dqlite can bind to it.
+func (a *announcer) Close() {
+	a.listener.Close()
+	<-a.done
+}
+
+// probe finds out whether the peer is part of a cluster, returning the dqlite address the peer
+// announced when it is not.
+func probe(ctx context.Context, address string, dial client.DialFunc) (peerState, string) {
+	if announced, ok := probeAnnouncer(ctx, address); ok {
+		return unclustered, announced
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, probeTimeout)
+	defer cancel()
+	cli, err := client.New(ctx, address, client.WithDialFunc(dial))
+	if err != nil {
+		return unreachable, ""
+	}
+	cli.Close()
+	return member, ""
+}
+
+func probeAnnouncer(ctx context.Context, address string) (string, bool) {
+	dialer := net.Dialer{Timeout: probeTimeout}
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return "", false
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(probeTimeout))
+
+	if _, err := conn.Write([]byte(probeRequest + "\n")); err != nil {
+		return "", false
+	}
+	line, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		return "", false
+	}
+	fields := strings.Fields(line)
+	if len(fields) != 2 || fields[0] != unclusteredResp {
+		return "", false
+	}
+	return fields[1], true
+}
@@ -0,0 +1,64 @@
+package discovery
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// StaticFile reads the peers from a file holding one dqlite address per line, lines starting with # are ignored.
+// The file is read again whenever it changes.
+type StaticFile struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	peers   []string
+}
+
+func NewStaticFile(path string) *StaticFile {
+	return &StaticFile{
+		path: path,
+	}
+}
+
+// Peers returns the addresses listed in the file.
+func (s *StaticFile) Peers(ctx context.Context) ([]string, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	info, err := os.Stat(s.path)
+	if err != nil {
+		return nil, err
+	}
+	if s.peers != nil && info.ModTime().Equal(s.modTime) && info.Size() == s.size {
+		return s.peers, nil
+	}
+
+	data, err := ioutil.ReadFile(s.path)
+	if err != nil {
+		return nil, err
+	}
+	peers := make([]string, 0)
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		peers = append(peers, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	s.peers = peers
+	s.modTime = info.ModTime()
+	s.size = info.Size()
+	return peers, nil
+}
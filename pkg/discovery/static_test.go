@@ -0,0 +1,52 @@
+package discovery
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustReadPeersFile(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp/", "peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers")
+	ioutil.WriteFile(path, []byte("# seed nodes\nnorse:9000\n\nnorse:9001\n"), 0600)
+
+	peers, err := NewStaticFile(path).Peers(context.Background())
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"norse:9000", "norse:9001"}, peers)
+}
+
+func TestMustReloadPeersFileOnChange(t *testing.T) {
+	dir, err := ioutil.TempDir("/tmp/", "peers")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "peers")
+	ioutil.WriteFile(path, []byte("norse:9000\n"), 0600)
+
+	static := NewStaticFile(path)
+	peers, _ := static.Peers(context.Background())
+	assert.Equal(t, []string{"norse:9000"}, peers)
+
+	ioutil.WriteFile(path, []byte("norse:9000\nnorse:9001\n"), 0600)
+	later := time.Now().Add(time.Second)
+	os.Chtimes(path, later, later)
+
+	peers, _ = static.Peers(context.Background())
+	assert.Equal(t, []string{"norse:9000", "norse:9001"}, peers)
+}
+
+func TestFailMissingPeersFile(t *testing.T) {
+	_, err := NewStaticFile("/non-existent/peers").Peers(context.Background())
+	assert.NotNil(t, err)
+}
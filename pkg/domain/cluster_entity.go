@@ -1,14 +1,74 @@
 package domain
 
+var (
+	// ErrNodeNotFound is returned when no member of the cluster matches the requested node.
+	ErrNodeNotFound = NewError(ErrNotFound, CodeNodeNotFound, "node not found", nil)
+	// ErrUnsafeRemoval is returned when removing a node would put the cluster at risk and the removal was not forced.
+	ErrUnsafeRemoval = NewError(ErrConflict, CodeUnsafeRemoval, "unsafe removal", nil)
+)
+
+// ClusterInfo is a member of the raft configuration, merged with what the node registered in the NODES table.
 type ClusterInfo struct {
-	ID      uint64 `json:"ID"`
-	Address string `json:"Address"`
-	Role    uint8  `json:"Role"`
-	Leader  bool   `json:"Leader"`
+	ID                uint64       `json:"ID"`
+	Address           string       `json:"Address"`
+	Role              uint8        `json:"Role"`
+	Leader            bool         `json:"Leader"`
+	FailureDomain     uint64       `json:"FailureDomain"`
+	FailureDomainName string       `json:"FailureDomainName,omitempty"`
+	ApiUrl            string       `json:"ApiUrl,omitempty"`
+	Version           string       `json:"Version,omitempty"`
+	Hostname          string       `json:"Hostname,omitempty"`
+	StartTime         string       `json:"StartTime,omitempty"`
+	Heartbeat         string       `json:"Heartbeat,omitempty"`
+	SchemaVersion     int          `json:"SchemaVersion,omitempty"`
+	Roles             *RolesConfig `json:"Roles,omitempty"`
+}
+
+// RolesConfig is the role management settings of a node, it is only reported for the node answering the request.
+type RolesConfig struct {
+	Voters                   int    `json:"Voters"`
+	StandBys                 int    `json:"StandBys"`
+	RolesAdjustmentFrequency string `json:"RolesAdjustmentFrequency"`
+	FailureDomain            string `json:"FailureDomain"`
+	FailureDomainCode        uint64 `json:"FailureDomainCode"`
+}
+
+// RemovalRequest identifies the node to remove, either by ID or by address.
+type RemovalRequest struct {
+	Node   string
+	Force  bool
+	DryRun bool
+}
+
+// RemovalReport describes the impact of removing a node on the voters of the cluster.
+// A negative fault tolerance means the voters left would not form a quorum.
+type RemovalReport struct {
+	ID                   uint64   `json:"ID"`
+	Address              string   `json:"Address"`
+	Role                 uint8    `json:"Role"`
+	Leader               bool     `json:"Leader"`
+	VotersBefore         int      `json:"VotersBefore"`
+	VotersAfter          int      `json:"VotersAfter"`
+	OnlineVotersAfter    int      `json:"OnlineVotersAfter"`
+	FaultToleranceBefore int      `json:"FaultToleranceBefore"`
+	FaultToleranceAfter  int      `json:"FaultToleranceAfter"`
+	Safe                 bool     `json:"Safe"`
+	Reasons              []string `json:"Reasons,omitempty"`
+	DryRun               bool     `json:"DryRun"`
+	Removed              bool     `json:"Removed"`
 }
 
 type ClusterRepository interface {
 	ClusterInfo() ([]byte, error)
-	RemoveNode(address string) (string, error)
+	RolesConfig() ([]byte, error)
 	FindLeader() (string, error)
+	Leave() error
+	LocalAddress() string
+	ProbeNode(address string) error
+	AssignRole(id uint64, role uint8) error
+	RemoveNodeById(id uint64) error
+	Handover() error
+	SchemaVersion() (int, error)
+	SupportedSchemaVersion() int
+	MigrateSchema(from int, to int) error
 }
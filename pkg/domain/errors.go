@@ -0,0 +1,87 @@
+package domain
+
+import "errors"
+
+// Kinds of errors, the API answers each of them with its own status code.
+var (
+	ErrMalformed       = errors.New("malformed request")
+	ErrNotFound        = errors.New("not found")
+	ErrValidation      = errors.New("validation failed")
+	ErrConflict        = errors.New("conflict")
+	ErrUnavailable     = errors.New("unavailable")
+	ErrNoLeader        = errors.New("no leader")
+	ErrTooManyRequests = errors.New("too many requests")
+)
+
+// Stable error codes, clients can rely on them while the messages may change.
+const (
+	CodeMalformedRequest    = "MALFORMED_REQUEST"
+	CodeTaskNotFound        = "TASK_NOT_FOUND"
+	CodeNodeNotFound        = "NODE_NOT_FOUND"
+	CodeFaultNotFound       = "FAULT_NOT_FOUND"
+	CodeInvalidTask         = "INVALID_TASK"
+	CodeInvalidFault        = "INVALID_FAULT"
+	CodeConstraintViolation = "CONSTRAINT_VIOLATION"
+	CodeUnsafeRemoval       = "UNSAFE_REMOVAL"
+	CodeNoLeader            = "NO_LEADER"
+	CodeDatabaseBusy        = "DATABASE_BUSY"
+	CodeDatabaseUnavailable = "DATABASE_UNAVAILABLE"
+	CodeInjectedFault       = "INJECTED_FAULT"
+	CodeUnavailable         = "UNAVAILABLE"
+	CodeInvalidKey          = "INVALID_IDEMPOTENCY_KEY"
+	CodeKeyInUse            = "IDEMPOTENCY_KEY_IN_USE"
+	CodeKeyReused           = "IDEMPOTENCY_KEY_REUSED"
+	CodeInvalidPatch        = "INVALID_PATCH"
+	CodePatchTestFailed     = "PATCH_TEST_FAILED"
+	CodeSchemaNotMigrated   = "SCHEMA_NOT_MIGRATED"
+	CodeInvalidProject      = "INVALID_PROJECT"
+	CodeProjectNotFound     = "PROJECT_NOT_FOUND"
+	CodeProjectArchived     = "PROJECT_ARCHIVED"
+	CodeProjectNotEmpty     = "PROJECT_NOT_EMPTY"
+	CodeTenantNotFound      = "TENANT_NOT_FOUND"
+	CodeInvalidTenant       = "INVALID_TENANT"
+	CodeTenantNotEmpty      = "TENANT_NOT_EMPTY"
+	CodeInvalidQuota        = "INVALID_QUOTA"
+	CodeTaskQuotaExceeded   = "TASK_QUOTA_EXCEEDED"
+	CodeDetailsTooLarge     = "DETAILS_TOO_LARGE"
+	CodeRateLimited         = "RATE_LIMITED"
+)
+
+// Error is an error of a known kind, identified by a stable code.
+type Error struct {
+	Kind    error
+	Code    string
+	Message string
+	Err     error
+}
+
+func NewError(kind error, code string, message string, cause error) *Error {
+	return &Error{
+		Kind:    kind,
+		Code:    code,
+		Message: message,
+		Err:     cause,
+	}
+}
+
+func (e *Error) Error() string {
+	if e.Err == nil {
+		return e.Message
+	}
+	return e.Message + ": " + e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is match the kind of the error.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// IsPermanent tells whether retrying the operation which failed with err cannot succeed.
+func IsPermanent(err error) bool {
+	return errors.Is(err, ErrMalformed) || errors.Is(err, ErrNotFound) || errors.Is(err, ErrValidation) ||
+		errors.Is(err, ErrConflict)
+}
@@ -0,0 +1,51 @@
+package domain
+
+const (
+	FaultRepository = "REPOSITORY"
+	FaultPeer       = "PEER"
+	FaultFreeze     = "FREEZE"
+)
+
+const (
+	PeerDrop  = "DROP"
+	PeerDelay = "DELAY"
+)
+
+// Repository operations faults can target, "*" targets all of them.
+const (
+	OperationTaskAdd      = "task.add"
+	OperationTaskFindById = "task.findById"
+	OperationTaskFindAll  = "task.findAll"
+	OperationTaskDelete   = "task.delete"
+	OperationTaskUpdate   = "task.update"
+	OperationTaskPatch    = "task.patch"
+	AnyTarget             = "*"
+)
+
+var (
+	ErrInjectedFault = NewError(ErrUnavailable, CodeInjectedFault, "injected fault", nil)
+	ErrInvalidFault  = NewError(ErrValidation, CodeInvalidFault, "invalid fault", nil)
+	ErrFaultNotFound = NewError(ErrNotFound, CodeFaultNotFound, "fault not found", nil)
+)
+
+// Fault is a failure injected in this node until it expires.
+//
+// A REPOSITORY fault adds latency to an operation and fails it with the error rate.
+// A PEER fault drops or delays the connections with a dqlite peer, identified by its host.
+// A FREEZE fault holds the API and the repository operations and drops every peer connection.
+type Fault struct {
+	Id        int64   `json:"id"`
+	Kind      string  `json:"kind"`
+	Operation string  `json:"operation,omitempty"`
+	Peer      string  `json:"peer,omitempty"`
+	Action    string  `json:"action,omitempty"`
+	Latency   string  `json:"latency,omitempty"`
+	ErrorRate float64 `json:"errorRate,omitempty"`
+	Duration  string  `json:"duration"`
+	Expires   string  `json:"expires"`
+}
+
+// FaultInjector is called before every repository operation, it may delay or fail the operation.
+type FaultInjector interface {
+	Before(operation string) error
+}
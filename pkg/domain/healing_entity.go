@@ -0,0 +1,32 @@
+package domain
+
+const (
+	RoleVoter   uint8 = 0
+	RoleStandBy uint8 = 1
+	RoleSpare   uint8 = 2
+)
+
+const (
+	HealingUnreachable = "UNREACHABLE"
+	HealingRecovered   = "RECOVERED"
+	HealingDead        = "DEAD"
+	HealingPromote     = "PROMOTE"
+	HealingDemote      = "DEMOTE"
+	HealingRemove      = "REMOVE"
+	HealingFailed      = "FAILED"
+)
+
+// HealingAudit records a decision taken by the auto-healing controller.
+type HealingAudit struct {
+	Id          int64  `json:"id"`
+	NodeId      uint64 `json:"nodeId"`
+	Address     string `json:"address"`
+	Action      string `json:"action"`
+	Reason      string `json:"reason"`
+	CreatedDate string `json:"createdDate"`
+}
+
+type HealingAuditRepository interface {
+	Add(audit *HealingAudit) (*HealingAudit, error)
+	FindAll() (*[]HealingAudit, error)
+}
@@ -0,0 +1,51 @@
+package domain
+
+import (
+	"context"
+	"time"
+)
+
+// IdempotencyKeyHeader carries the key a client picks to retry a write without applying it twice.
+const IdempotencyKeyHeader = "Idempotency-Key"
+
+// IdempotencyRecord maps an idempotency key to the response of the first request which carried it.
+type IdempotencyRecord struct {
+	Key string
+	// Fingerprint identifies the request, its method, path and body.
+	Fingerprint string
+	// Status is 0 while the request is in progress.
+	Status      int
+	ContentType string
+	Body        []byte
+	// Expires is when the key may be used again, or taken over while the request is in progress.
+	Expires time.Time
+}
+
+func (r *IdempotencyRecord) InProgress() bool {
+	return r.Status == 0
+}
+
+type IdempotencyRepository interface {
+	// Reserve records the key for a request in progress, it returns the existing record when the key is taken.
+	Reserve(ctx context.Context, record *IdempotencyRecord, now time.Time) (*IdempotencyRecord, error)
+	// Complete stores the response of the request.
+	Complete(ctx context.Context, record *IdempotencyRecord) error
+	// Release lets the key be taken over right away by a retry of the same request.
+	Release(ctx context.Context, key string, now time.Time) error
+	// DeleteExpired removes the completed records expired before now and the abandoned ones expired before abandoned.
+	DeleteExpired(ctx context.Context, now time.Time, abandoned time.Time) (int64, error)
+}
+
+type idempotencyKeyContext struct{}
+
+// WithIdempotencyKey hands the key of the request down to the repositories, they record what the request
+// wrote in the same transaction so that a retried attempt does not write it twice.
+func WithIdempotencyKey(ctx context.Context, key string) context.Context {
+	return context.WithValue(ctx, idempotencyKeyContext{}, key)
+}
+
+// IdempotencyKeyOf returns the idempotency key of the request, empty when it has none.
+func IdempotencyKeyOf(ctx context.Context) string {
+	key, _ := ctx.Value(idempotencyKeyContext{}).(string)
+	return key
+}
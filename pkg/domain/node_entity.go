@@ -0,0 +1,20 @@
+package domain
+
+// NodeMetadata is what a node registers about itself in the replicated NODES table.
+type NodeMetadata struct {
+	Address       string `json:"address"`
+	ApiUrl        string `json:"apiUrl"`
+	Version       string `json:"version"`
+	StartTime     string `json:"startTime"`
+	Hostname      string `json:"hostname"`
+	FailureDomain string `json:"failureDomain"`
+	Heartbeat     string `json:"heartbeat"`
+	// SchemaVersion is the latest schema version supported by the binary of the node, 0 when unknown.
+	SchemaVersion int `json:"schemaVersion"`
+}
+
+type NodeRegistryRepository interface {
+	Register(node *NodeMetadata) error
+	Heartbeat(address string, heartbeat string) error
+	FindAll() (*[]NodeMetadata, error)
+}
@@ -0,0 +1,49 @@
+package domain
+
+import (
+	"context"
+	"regexp"
+	"time"
+)
+
+var (
+	projectKeyPattern = regexp.MustCompile(`^[A-Z][A-Z0-9]{1,9}$`)
+	taskKeyPattern    = regexp.MustCompile(`^([A-Z][A-Z0-9]{1,9})-[1-9][0-9]{0,17}$`)
+)
+
+// Project groups tasks, its key prefixes the keys of its tasks, ex. OPS-142. The key cannot change.
+// An archived project gets no new tasks and its tasks cannot be changed until it is restored.
+// Its timestamps are maintained by the server like the ones of the tasks.
+type Project struct {
+	Id         int64      `json:"id"`
+	Key        string     `json:"key"`
+	Name       string     `json:"name"`
+	Archived   bool       `json:"archived"`
+	CreatedAt  time.Time  `json:"createdAt"`
+	ArchivedAt *time.Time `json:"archivedAt,omitempty"`
+}
+
+// ValidProjectKey tells whether the key is an upper case letter followed by 1 to 9 upper case letters or digits.
+func ValidProjectKey(key string) bool {
+	return projectKeyPattern.MatchString(key)
+}
+
+// ProjectOfTaskKey returns the project key of a task key, ok is false when key is not a task key.
+func ProjectOfTaskKey(key string) (project string, ok bool) {
+	match := taskKeyPattern.FindStringSubmatch(key)
+	if match == nil {
+		return "", false
+	}
+	return match[1], true
+}
+
+// ProjectRepository stores the projects, every operation gives up when its context is done.
+type ProjectRepository interface {
+	Add(ctx context.Context, project *Project) (*Project, error)
+	FindById(ctx context.Context, id int64) (*Project, error)
+	FindAll(ctx context.Context) (*[]Project, error)
+	// Update renames the project and archives or restores it, its archiving date is kept while it stays archived.
+	Update(ctx context.Context, project *Project) (*Project, error)
+	// Delete removes the project, a project with tasks is removed with its tasks when cascade is set only.
+	Delete(ctx context.Context, id int64, cascade bool) error
+}
@@ -0,0 +1,24 @@
+package domain
+
+import "context"
+
+// Quota limits what a tenant stores and how often it calls the API, a zero limit is no limit.
+type Quota struct {
+	Tenant string `json:"tenant"`
+	// MaxTasks the tenant can store.
+	MaxTasks int64 `json:"maxTasks"`
+	// MaxDetailsSize is the size in bytes of the details of a task.
+	MaxDetailsSize int64 `json:"maxDetailsSize"`
+	// RequestsPerSecond the whole cluster serves the tenant.
+	RequestsPerSecond int64 `json:"requestsPerSecond"`
+}
+
+// QuotaRepository stores the quotas and the request counters of the tenants, shared by every node.
+type QuotaRepository interface {
+	// FindByTenant returns the quota of the tenant, with no limit when it has none.
+	FindByTenant(ctx context.Context, tenant string) (*Quota, error)
+	Save(ctx context.Context, quota *Quota) (*Quota, error)
+	// CountRequests adds requests of the tenant to its counter of the second since the epoch, it returns the
+	// requests of the tenant counted in that second.
+	CountRequests(ctx context.Context, tenant string, second int64, requests int64) (int64, error)
+}
@@ -1,16 +1,121 @@
 package domain
 
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Task timestamps are maintained by the server in UTC, the values sent by the clients are ignored.
+// They are rendered in RFC 3339.
+// Project is the key of the project of the task, it is given on creation only and the task gets the next
+// key of the project.
+// The snowflake ids exceed 2^53, they are rendered as strings for the JavaScript clients.
+// The deprecated createdDate renders CreatedAt like the tasks did before createdAt, it is ignored on input.
 type Task struct {
-	Id          int64  `json:"id"`
-	Title       string `json:"title"`
-	Details     string `json:"details"`
-	CreatedDate string `json:"createdDate"`
+	Id          int64      `json:"id,string"`
+	Key         string     `json:"key,omitempty"`
+	Project     string     `json:"project,omitempty"`
+	Title       string     `json:"title"`
+	Details     string     `json:"details"`
+	Completed   bool       `json:"completed"`
+	CreatedAt   time.Time  `json:"createdAt"`
+	UpdatedAt   time.Time  `json:"updatedAt"`
+	CompletedAt *time.Time `json:"completedAt,omitempty"`
+}
+
+// MarshalJSON renders the task with the deprecated createdDate.
+func (t Task) MarshalJSON() ([]byte, error) {
+	type task Task
+	return json.Marshal(struct {
+		task
+		CreatedDate string `json:"createdDate"`
+	}{task(t), t.CreatedAt.UTC().Format(LegacyDateLayout)})
+}
+
+// UnmarshalJSON reads the id as a string, or as a number like the clients written before the ids were strings.
+func (t *Task) UnmarshalJSON(data []byte) error {
+	type task Task
+	decoded := struct {
+		*task
+		Id json.RawMessage `json:"id"`
+	}{task: (*task)(t)}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+	if len(decoded.Id) == 0 || string(decoded.Id) == "null" {
+		return nil
+	}
+	id, err := strconv.ParseInt(strings.Trim(string(decoded.Id), `"`), 10, 64)
+	if err != nil {
+		return fmt.Errorf("the id %s is not a number", decoded.Id)
+	}
+	t.Id = id
+	return nil
+}
+
+// TaskFilter selects the tasks by date range, the After bounds are inclusive, the Before bounds exclusive
+// and the zero times leave the range open. A ProjectId other than 0 selects the tasks of the project,
+// the repository applies it.
+type TaskFilter struct {
+	ProjectId       int64
+	CreatedAfter    time.Time
+	CreatedBefore   time.Time
+	UpdatedAfter    time.Time
+	UpdatedBefore   time.Time
+	CompletedAfter  time.Time
+	CompletedBefore time.Time
 }
 
+// Matches tells whether the task is in every range of the filter, a task never completed is outside
+// any completion range.
+func (f TaskFilter) Matches(task *Task) bool {
+	if !inRange(task.CreatedAt, f.CreatedAfter, f.CreatedBefore) || !inRange(task.UpdatedAt, f.UpdatedAfter, f.UpdatedBefore) {
+		return false
+	}
+	if f.CompletedAfter.IsZero() && f.CompletedBefore.IsZero() {
+		return true
+	}
+	return task.CompletedAt != nil && inRange(*task.CompletedAt, f.CompletedAfter, f.CompletedBefore)
+}
+
+func inRange(t time.Time, after time.Time, before time.Time) bool {
+	return (after.IsZero() || !t.Before(after)) && (before.IsZero() || t.Before(before))
+}
+
+// LegacyDateLayout is the format of the creation dates stored before the timestamps were typed.
+const LegacyDateLayout = time.RFC1123
+
+// ParseLegacyDate reads a creation date stored before the timestamps were typed. These dates were written
+// in the local time of the node, the zone abbreviation is resolved in loc when it carries no offset.
+func ParseLegacyDate(value string, loc *time.Location) (time.Time, bool) {
+	for _, layout := range []string{LegacyDateLayout, time.RFC1123Z, time.RFC3339, "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, loc); err == nil {
+			return t.UTC(), true
+		}
+	}
+	return time.Time{}, false
+}
+
+// Media types of the patches of a task.
+const (
+	MergePatchType = "application/merge-patch+json"
+	JSONPatchType  = "application/json-patch+json"
+)
+
+// TaskRepository stores the tasks, every operation gives up when its context is done.
 type TaskRepository interface {
-	Add(task *Task) (*Task, error)
-	FindById(id int64) (*Task, error)
-	FindAll() (*[]Task, error)
-	Delete(id int64) error
-	Update(task *Task) (*Task, error)
+	Add(ctx context.Context, task *Task) (*Task, error)
+	FindById(ctx context.Context, id int64) (*Task, error)
+	// FindByKey finds the task by its project key and number, ex. OPS-142.
+	FindByKey(ctx context.Context, key string) (*Task, error)
+	FindAll(ctx context.Context, filter TaskFilter) (*[]Task, error)
+	Delete(ctx context.Context, id int64) error
+	// Update stores the task, its completion date is kept while it stays completed.
+	Update(ctx context.Context, task *Task) (*Task, error)
+	// Patch lets patch change the task and stores it, in a single transaction.
+	Patch(ctx context.Context, id int64, patch func(task *Task) error) (*Task, error)
 }
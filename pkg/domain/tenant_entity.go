@@ -0,0 +1,61 @@
+package domain
+
+import (
+	"context"
+	"regexp"
+	"time"
+)
+
+// TenantHeader names the tenant of a request forwarded by a trusted proxy which authenticated it.
+const TenantHeader = "X-Tenant-Id"
+
+// DefaultTenant owns the requests which name no tenant, and the tasks written before the tenants existed.
+const DefaultTenant = "default"
+
+var tenantIdPattern = regexp.MustCompile(`^[a-z][a-z0-9-]{1,31}$`)
+
+// Tenant isolates the tasks and projects of a team sharing the cluster. Token authenticates its requests,
+// it is returned when the tenant is created only, the cluster keeps its SHA-256.
+type Tenant struct {
+	Id        string    `json:"id"`
+	Name      string    `json:"name"`
+	Token     string    `json:"token,omitempty"`
+	CreatedAt time.Time `json:"createdAt"`
+}
+
+// ValidTenantId tells whether the id is a lower case letter followed by 1 to 31 lower case letters, digits or dashes.
+func ValidTenantId(id string) bool {
+	return tenantIdPattern.MatchString(id)
+}
+
+// TenantRepository stores the tenants, every operation gives up when its context is done.
+type TenantRepository interface {
+	Add(ctx context.Context, tenant *Tenant, tokenHash string) (*Tenant, error)
+	FindById(ctx context.Context, id string) (*Tenant, error)
+	FindByTokenHash(ctx context.Context, tokenHash string) (*Tenant, error)
+	FindAll(ctx context.Context) (*[]Tenant, error)
+	// Delete removes the tenant, a tenant with tasks or projects is removed with them when cascade is set only.
+	Delete(ctx context.Context, id string, cascade bool) error
+}
+
+type tenantContext struct{}
+
+// WithTenant hands the tenant of the request down to the repositories, they read and write its rows only.
+func WithTenant(ctx context.Context, tenant string) context.Context {
+	return context.WithValue(ctx, tenantContext{}, tenant)
+}
+
+// TenantOf returns the tenant of the request, the default tenant when it has none.
+func TenantOf(ctx context.Context) string {
+	if tenant, _ := ctx.Value(tenantContext{}).(string); tenant != "" {
+		return tenant
+	}
+	return DefaultTenant
+}
+
+// TenantIdempotencyKey scopes the idempotency key of a request to its tenant, the keys picked by the clients
+// of two tenants do not collide. The keys of the default tenant are scoped too, a client of the default
+// tenant sending the key acme/k1 does not reach the key k1 of acme.
+func TenantIdempotencyKey(ctx context.Context, key string) string {
+	return TenantOf(ctx) + "/" + key
+}
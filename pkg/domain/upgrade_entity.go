@@ -0,0 +1,24 @@
+package domain
+
+// UpgradeStep is a node to upgrade, with the actions to take in order.
+type UpgradeStep struct {
+	Order         int      `json:"order"`
+	Address       string   `json:"address"`
+	ApiUrl        string   `json:"apiUrl"`
+	Role          uint8    `json:"role"`
+	Leader        bool     `json:"leader"`
+	Version       string   `json:"version"`
+	SchemaVersion int      `json:"schemaVersion"`
+	Actions       []string `json:"actions"`
+}
+
+// UpgradePlan lists the nodes which do not run the target version yet, spares and stand-bys first,
+// then the voters with the leader last.
+type UpgradePlan struct {
+	TargetVersion        string        `json:"targetVersion"`
+	TargetSchemaVersion  int           `json:"targetSchemaVersion"`
+	ClusterSchemaVersion int           `json:"clusterSchemaVersion"`
+	Steps                []UpgradeStep `json:"steps"`
+	UpToDate             []string      `json:"upToDate"`
+	Notes                []string      `json:"notes,omitempty"`
+}
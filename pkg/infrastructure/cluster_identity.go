@@ -0,0 +1,188 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/canonical/go-dqlite/client"
+	"github.com/canonical/go-dqlite/driver"
+	"github.com/pkg/errors"
+)
+
+const (
+	// clusterIdFile keeps the identity of the cluster this node belongs to, next to the dqlite data.
+	clusterIdFile = "cluster-id"
+	// dqliteInfoFile is written by dqlite the first time a node starts in a data directory.
+	dqliteInfoFile = "info.yaml"
+
+	clusterIdentitySchema = "CREATE TABLE IF NOT EXISTS CLUSTER_IDENTITY (ID INTEGER PRIMARY KEY CHECK (ID = 1), UUID VARCHAR(36) NOT NULL)"
+	findClusterId         = "SELECT UUID FROM CLUSTER_IDENTITY WHERE ID = 1"
+	insertClusterId       = "INSERT OR IGNORE INTO CLUSTER_IDENTITY (ID, UUID) VALUES (1, ?)"
+)
+
+// ErrClusterIdentityMismatch is returned when a node is asked to start as part of a cluster it does not belong to.
+var ErrClusterIdentityMismatch = errors.New("cluster identity mismatch")
+
+func newClusterId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	// RFC 4122 version 4, variant 1
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+func fileExists(dir string, file string) (bool, error) {
+	if _, err := os.Stat(filepath.Join(dir, file)); err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	return true, nil
+}
+
+func readLocalClusterId(dbPath string) (string, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dbPath, clusterIdFile))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", errors.Wrap(err, "read cluster id")
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func writeLocalClusterId(dbPath string, clusterId string) error {
+	if err := ioutil.WriteFile(filepath.Join(dbPath, clusterIdFile), []byte(clusterId+"\n"), 0600); err != nil {
+		return errors.Wrap(err, "write cluster id")
+	}
+	return nil
+}
+
+// remoteClusterId asks the cluster reachable through the join addresses for its identity.
+// An empty identity is returned when the cluster predates identities.
+func remoteClusterId(join []string, dial client.DialFunc) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+
+	store := client.NewInmemNodeStore()
+	nodes := make([]client.NodeInfo, 0, len(join))
+	for _, address := range join {
+		nodes = append(nodes, client.NodeInfo{Address: address})
+	}
+	if err := store.Set(ctx, nodes); err != nil {
+		return "", err
+	}
+
+	drv, err := driver.New(store, driver.WithDialFunc(dial), driver.WithContext(ctx))
+	if err != nil {
+		return "", err
+	}
+	connector, err := drv.OpenConnector(DB_NAME)
+	if err != nil {
+		return "", err
+	}
+	db := sql.OpenDB(connector)
+	defer db.Close()
+
+	var clusterId string
+	err = db.QueryRowContext(ctx, findClusterId).Scan(&clusterId)
+	if err == sql.ErrNoRows || (err != nil && strings.Contains(err.Error(), "no such table")) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	return clusterId, nil
+}
+
+// verifyBeforeStart runs the identity checks that must happen before dqlite touches the data directory,
+// it returns the cluster identity this node expects to find, if any.
+// An empty data directory which joins no cluster bootstraps one only when bootstrap is set.
+func verifyBeforeStart(dbPath string, join []string, expected string, bootstrap bool, dial client.DialFunc) (string, error) {
+	if bootstrap && len(join) > 0 {
+		return "", fmt.Errorf("a node cannot both bootstrap a new cluster and join %v", join)
+	}
+	localId, err := readLocalClusterId(dbPath)
+	if err != nil {
+		return "", err
+	}
+	if localId != "" && expected != "" && localId != expected {
+		return "", fmt.Errorf("%w: data directory %s belongs to cluster %s, not %s", ErrClusterIdentityMismatch, dbPath, localId, expected)
+	}
+
+	initialized, err := fileExists(dbPath, dqliteInfoFile)
+	if err != nil {
+		return "", err
+	}
+	if localId != "" {
+		expected = localId
+	}
+	if initialized {
+		return expected, nil
+	}
+
+	if len(join) == 0 {
+		if localId != "" {
+			return "", fmt.Errorf("%w: refusing to bootstrap a new cluster from %s which belonged to cluster %s, use --join to rejoin it", ErrClusterIdentityMismatch, dbPath, localId)
+		}
+		if !bootstrap {
+			return "", fmt.Errorf("%w: refusing to bootstrap a new cluster from the empty directory %s, use --bootstrap to start a new cluster or --join to join one", ErrClusterIdentityMismatch, dbPath)
+		}
+		return expected, nil
+	}
+	if expected == "" {
+		return "", nil
+	}
+
+	remoteId, err := remoteClusterId(join, dial)
+	if err != nil {
+		return "", errors.Wrapf(err, "verify identity of cluster %v", join)
+	}
+	if remoteId != "" && remoteId != expected {
+		return "", fmt.Errorf("%w: %v belongs to cluster %s, not %s", ErrClusterIdentityMismatch, join, remoteId, expected)
+	}
+	return expected, nil
+}
+
+// ensureClusterIdentity records the identity of a new cluster, or checks the identity of an existing one,
+// and keeps a copy of it in the data directory.
+func (d *Dqlite) ensureClusterIdentity(dbPath string, expected string) (string, error) {
+	var clusterId string
+	err := d.db.QueryRow(findClusterId).Scan(&clusterId)
+	if err == sql.ErrNoRows {
+		newId := expected
+		if newId == "" {
+			if newId, err = newClusterId(); err != nil {
+				return "", err
+			}
+		}
+		if _, err = d.db.Exec(insertClusterId, newId); err != nil {
+			return "", errors.Wrap(err, "record cluster id")
+		}
+		// another node may have won the race to record the identity
+		err = d.db.QueryRow(findClusterId).Scan(&clusterId)
+	}
+	if err != nil {
+		return "", errors.Wrap(err, "read cluster id")
+	}
+
+	if expected != "" && clusterId != expected {
+		return "", fmt.Errorf("%w: node joined cluster %s, expected %s", ErrClusterIdentityMismatch, clusterId, expected)
+	}
+
+	if err := writeLocalClusterId(dbPath, clusterId); err != nil {
+		return "", err
+	}
+	return clusterId, nil
+}
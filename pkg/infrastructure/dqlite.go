@@ -2,13 +2,10 @@ package infrastructure
 
 import (
 	"context"
-	"crypto/tls"
-	"crypto/x509"
 	"database/sql"
 	"encoding/json"
-	"fmt"
-	"io/ioutil"
-	"path/filepath"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/balchua/bopbag/pkg/applog"
@@ -23,48 +20,105 @@ const (
 	MAX_IDLE_CONNECTION_TIME = 2 * time.Second
 	DB_NAME                  = "bopbag"
 	taskSchema               = "CREATE TABLE IF NOT EXISTS TASKS (ID INTEGER PRIMARY KEY AUTOINCREMENT, TITLE VARCHAR(50), DETAILS VARCHAR(1000), CREATED_DATE VARCHAR(50), UNIQUE(ID))"
+	healingAuditSchema       = "CREATE TABLE IF NOT EXISTS HEALING_AUDIT (ID INTEGER PRIMARY KEY AUTOINCREMENT, NODE_ID VARCHAR(20), ADDRESS VARCHAR(255), ACTION VARCHAR(20), REASON VARCHAR(1000), CREATED_DATE VARCHAR(50))"
+	nodesSchema              = "CREATE TABLE IF NOT EXISTS NODES (ADDRESS VARCHAR(255) PRIMARY KEY, API_URL VARCHAR(255), VERSION VARCHAR(50), START_TIME VARCHAR(50), HOSTNAME VARCHAR(255), FAILURE_DOMAIN VARCHAR(255), HEARTBEAT VARCHAR(50))"
+	snowflakeNodesSchema     = "CREATE TABLE IF NOT EXISTS SNOWFLAKE_NODES (NODE INTEGER PRIMARY KEY, NODE_ID VARCHAR(20) NOT NULL UNIQUE)"
 )
 
+// describeTimeout bounds the time the cluster info waits for all the members to describe themselves.
+const describeTimeout = 2 * time.Second
+
 type Dqlite struct {
-	dqlite  *app.App
-	address string
-	log     *applog.Logger
-	db      *sql.DB
+	dqlite    *app.App
+	address   string
+	log       *applog.Logger
+	db        *sql.DB
+	clusterId string
+	left      int32
+	dial      client.DialFunc
+	roles     RolesConfig
+}
+
+// RolesConfig reports how this node asks dqlite to manage the roles of the cluster members.
+type RolesConfig struct {
+	Voters                   int    `json:"Voters"`
+	StandBys                 int    `json:"StandBys"`
+	RolesAdjustmentFrequency string `json:"RolesAdjustmentFrequency"`
+	FailureDomain            string `json:"FailureDomain"`
+	FailureDomainCode        uint64 `json:"FailureDomainCode"`
+}
+
+type nodeInfo struct {
+	ID            uint64 `json:"ID"`
+	Address       string `json:"Address"`
+	Role          int    `json:"Role"`
+	FailureDomain uint64 `json:"FailureDomain"`
 }
 
-func NewDqlite(log *applog.Logger, dbPath string, dbAddress string, join []string, enableTls bool, certsPath string) (*Dqlite, error) {
+func NewDqlite(log *applog.Logger, dbPath string, dbAddress string, join []string, enableTls bool, certsPath string, opts ...Option) (*Dqlite, error) {
 
 	var dqlite *app.App
 	var err error
 
+	o := defaultOptions()
+	for _, opt := range opts {
+		opt(o)
+	}
+
 	dqliteInstance := &Dqlite{}
+	dial := client.DefaultDialFunc
 
 	dqliteInstance.address = dbAddress
 	dqliteInstance.log = log
 
+	dqliteInstance.roles = RolesConfig{
+		Voters:                   o.voters,
+		StandBys:                 o.standBys,
+		RolesAdjustmentFrequency: o.rolesAdjustmentFrequency.String(),
+		FailureDomain:            o.failureDomain,
+		FailureDomainCode:        failureDomainCode(o.failureDomain),
+	}
+
 	options := []app.Option{
 		app.WithAddress(dqliteInstance.address),
 		app.WithLogFunc(dqliteInstance.dqliteLog),
+		app.WithVoters(o.voters),
+		app.WithStandBys(o.standBys),
+		app.WithRolesAdjustmentFrequency(o.rolesAdjustmentFrequency),
+		app.WithFailureDomain(dqliteInstance.roles.FailureDomainCode),
 	}
 
 	if enableTls {
-		// Load the TLS certificates.
-		crt := filepath.Join(certsPath, "cluster.crt")
-		key := filepath.Join(certsPath, "cluster.key")
-
-		keypair, err := tls.LoadX509KeyPair(crt, key)
+		listenTls, dialTls, err := loadTLSConfig(certsPath)
 		if err != nil {
-			return nil, errors.Wrap(err, "load keypair")
+			return nil, err
 		}
-		data, err := ioutil.ReadFile(crt)
-		if err != nil {
-			return nil, errors.Wrap(err, "read certificate")
+		if o.peerGate != nil {
+			guardListener(listenTls, o.peerGate)
 		}
-		pool := x509.NewCertPool()
-		if !pool.AppendCertsFromPEM(data) {
-			return nil, fmt.Errorf("bad certificate")
+		options = append(options, app.WithTLS(listenTls, dialTls))
+		dial = client.DialFuncWithTLS(client.DefaultDialFunc, dialTls)
+	}
+	if o.peerGate != nil {
+		if !enableTls {
+			log.Log.Warn("the raft connections can only be faulted with TLS enabled")
+		}
+		dial = guardDial(dial, o.peerGate)
+	}
+	dqliteInstance.dial = dial
+
+	expectedClusterId, err := verifyBeforeStart(dbPath, join, o.clusterId, o.bootstrap, dial)
+	if err != nil {
+		log.Log.Error("cluster identity check failed", zap.Error(err))
+		return nil, err
+	}
+
+	if o.bootstrap {
+		// a wiped data directory started with the flag again bootstraps another cluster
+		if initialized, err := IsInitialized(dbPath); err == nil && !initialized {
+			log.Log.Warn("bootstrapping a new cluster, drop --bootstrap once it is started",
+				zap.String("db", dbPath), zap.String("clusterId", expectedClusterId))
 		}
-		options = append(options, app.WithTLS(app.SimpleTLSConfig(keypair, pool)))
 	}
 
 	if join != nil {
@@ -95,7 +149,23 @@ func NewDqlite(log *applog.Logger, dbPath string, dbAddress string, join []strin
 		return nil, err
 	}
 
-	log.Log.Sugar().Infof("database %s started", DB_NAME)
+	clusterId, err := dqliteInstance.ensureClusterIdentity(dbPath, expectedClusterId)
+	if err != nil {
+		log.Log.Error("cluster identity check failed", zap.Error(err))
+		dqliteInstance.db.Close()
+		dqlite.Close()
+		return nil, err
+	}
+	dqliteInstance.clusterId = clusterId
+
+	if err := dqliteInstance.checkSchema(); err != nil {
+		log.Log.Error("schema compatibility check failed", zap.Error(err))
+		dqliteInstance.db.Close()
+		dqlite.Close()
+		return nil, err
+	}
+
+	log.Log.Sugar().Infof("database %s started in cluster %s", DB_NAME, clusterId)
 	return dqliteInstance, nil
 }
 
@@ -117,6 +187,24 @@ func (d *Dqlite) migrate() error {
 	if _, err = d.db.Exec(taskSchema); err != nil {
 		d.log.Log.Fatal("unable to create schema", zap.Error(err))
 	}
+	if _, err = d.db.Exec(clusterIdentitySchema); err != nil {
+		d.log.Log.Fatal("unable to create schema", zap.Error(err))
+	}
+	if _, err = d.db.Exec(healingAuditSchema); err != nil {
+		d.log.Log.Fatal("unable to create schema", zap.Error(err))
+	}
+	if _, err = d.db.Exec(nodesSchema); err != nil {
+		d.log.Log.Fatal("unable to create schema", zap.Error(err))
+	}
+	if _, err = d.db.Exec(snowflakeNodesSchema); err != nil {
+		d.log.Log.Fatal("unable to create schema", zap.Error(err))
+	}
+	if _, err = d.db.Exec(schemaVersionSchema); err != nil {
+		d.log.Log.Fatal("unable to create schema", zap.Error(err))
+	}
+	if _, err = d.db.Exec(schemaSupportSchema); err != nil {
+		d.log.Log.Fatal("unable to create schema", zap.Error(err))
+	}
 	return err
 }
 
@@ -128,6 +216,16 @@ func (d *Dqlite) DB() *sql.DB {
 	return d.db
 }
 
+// NodeID returns the dqlite id of this node.
+func (d *Dqlite) NodeID() uint64 {
+	return d.dqlite.ID()
+}
+
+// ClusterId returns the identity of the cluster this node belongs to.
+func (d *Dqlite) ClusterId() string {
+	return d.clusterId
+}
+
 func (d *Dqlite) GetClusterInfo() ([]byte, error) {
 	ctx := context.Background()
 	cli, err := d.dqlite.Client(ctx)
@@ -135,12 +233,32 @@ func (d *Dqlite) GetClusterInfo() ([]byte, error) {
 	if err != nil {
 		return nil, err
 	}
+	defer cli.Close()
 	cluster, err := cli.Cluster(ctx)
 	if err != nil {
 		return nil, err
 	}
 
-	data, err := json.Marshal(cluster)
+	// the members are described in parallel, the unreachable ones all give up at the same deadline
+	describeCtx, cancel := context.WithTimeout(ctx, describeTimeout)
+	defer cancel()
+	nodes := make([]nodeInfo, len(cluster))
+	var wg sync.WaitGroup
+	for i, node := range cluster {
+		nodes[i] = nodeInfo{
+			ID:      node.ID,
+			Address: node.Address,
+			Role:    int(node.Role),
+		}
+		wg.Add(1)
+		go func(info *nodeInfo) {
+			defer wg.Done()
+			info.FailureDomain = d.describe(describeCtx, info.Address)
+		}(&nodes[i])
+	}
+	wg.Wait()
+
+	data, err := json.Marshal(nodes)
 	if err != nil {
 		return nil, err
 	}
@@ -148,31 +266,24 @@ func (d *Dqlite) GetClusterInfo() ([]byte, error) {
 	return data, nil
 }
 
-func (d *Dqlite) RemoveNode(address string) (string, error) {
-	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(30*time.Second))
-
-	defer cancel()
-	cli, err := d.dqlite.Leader(ctx)
+// describe returns the failure domain of a node, or 0 when the node cannot be reached.
+func (d *Dqlite) describe(ctx context.Context, address string) uint64 {
+	cli, err := client.New(ctx, address, client.WithDialFunc(d.dial))
 	if err != nil {
-		return "", err
+		return 0
 	}
-	cluster, err := cli.Cluster(ctx)
+	defer cli.Close()
+
+	metadata, err := cli.Describe(ctx)
 	if err != nil {
-		return "", err
+		return 0
 	}
+	return metadata.FailureDomain
+}
 
-	for _, node := range cluster {
-		if node.Address != address {
-			continue
-		}
-		//ignore error returned by dqlite
-		err := cli.Remove(ctx, node.ID)
-		if err != nil {
-			d.log.Log.Sugar().Errorf("Error while removing a node %v", zap.Error(err))
-		}
-		return address, nil
-	}
-	return "", fmt.Errorf("no node has address %q", address)
+// GetRolesConfig returns the role management settings of this node.
+func (d *Dqlite) GetRolesConfig() ([]byte, error) {
+	return json.Marshal(d.roles)
 }
 
 func (d *Dqlite) Leader() (string, error) {
@@ -198,14 +309,106 @@ func (d *Dqlite) Leader() (string, error) {
 
 }
 
+// Address returns the dqlite address of this node.
+func (d *Dqlite) Address() string {
+	return d.address
+}
+
+// Probe checks that the dqlite node listening on the address is up and responsive.
+func (d *Dqlite) Probe(address string) error {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(5*time.Second))
+
+	defer cancel()
+	cli, err := client.New(ctx, address, client.WithDialFunc(d.dial))
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	_, err = cli.Leader(ctx)
+	return err
+}
+
+// Assign changes the role of a node, it must be called on the leader.
+func (d *Dqlite) Assign(id uint64, role uint8) error {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(30*time.Second))
+
+	defer cancel()
+	cli, err := d.dqlite.Leader(ctx)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	return cli.Assign(ctx, id, client.NodeRole(role))
+}
+
+// RemoveById removes a node from the cluster configuration.
+func (d *Dqlite) RemoveById(id uint64) error {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(30*time.Second))
+
+	defer cancel()
+	cli, err := d.dqlite.Leader(ctx)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	return cli.Remove(ctx, id)
+}
+
+// Handover transfers the leadership and voting rights of this node to other nodes, ex. before it is upgraded.
+func (d *Dqlite) Handover() error {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(60*time.Second))
+
+	defer cancel()
+	return d.dqlite.Handover(ctx)
+}
+
+// Leave hands over the leadership and voting rights of this node, then removes it from the cluster.
+func (d *Dqlite) Leave() error {
+	if atomic.LoadInt32(&d.left) == 1 {
+		return nil
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(60*time.Second))
+
+	defer cancel()
+
+	if err := d.dqlite.Handover(ctx); err != nil {
+		return errors.Wrap(err, "handover")
+	}
+	cli, err := d.dqlite.Leader(ctx)
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+
+	if err := cli.Remove(ctx, d.dqlite.ID()); err != nil {
+		return errors.Wrap(err, "remove node")
+	}
+	atomic.StoreInt32(&d.left, 1)
+	d.log.Log.Sugar().Infof("Node %s left the cluster", d.address)
+	return nil
+}
+
 func (d *Dqlite) Shutdown(ctx context.Context) {
 	if err := d.db.Close(); err != nil {
 		d.log.Log.Sugar().Errorf("Unable to close the db %v", err)
 	}
-	if err := d.dqlite.Handover(ctx); err != nil {
-		d.log.Log.Sugar().Errorf("Unable to handover leadership %v", err)
+	if atomic.LoadInt32(&d.left) == 0 {
+		if err := d.dqlite.Handover(ctx); err != nil {
+			d.log.Log.Sugar().Errorf("Unable to handover leadership %v", err)
+		}
 	}
 	if err := d.dqlite.Close(); err != nil {
 		d.log.Log.Sugar().Errorf("Unable to shutdown dqlite %v", err)
 	}
 }
+
+// Kill stops the node abruptly, without handing over its leadership, the way a crash would.
+func (d *Dqlite) Kill() {
+	d.db.Close()
+	if err := d.dqlite.Close(); err != nil {
+		d.log.Log.Sugar().Errorf("Unable to shutdown dqlite %v", err)
+	}
+}
@@ -0,0 +1,104 @@
+package infrastructure
+
+import (
+	"hash/fnv"
+	"strconv"
+	"time"
+)
+
+// Option can be used to tweak the dqlite instance created by NewDqlite.
+type Option func(*options)
+
+type options struct {
+	clusterId                string
+	bootstrap                bool
+	voters                   int
+	standBys                 int
+	rolesAdjustmentFrequency time.Duration
+	failureDomain            string
+	peerGate                 PeerGate
+}
+
+// WithClusterId pins the identity of the cluster this node must belong to.
+//
+// When set, the node refuses to join a cluster with a different identity and
+// a cluster bootstrapped by this node gets this identity.
+func WithClusterId(clusterId string) Option {
+	return func(o *options) {
+		o.clusterId = clusterId
+	}
+}
+
+// WithBootstrap lets the node bootstrap a new cluster from an empty data directory when it joins no cluster.
+// Without it the node refuses to start, so that a wiped data directory cannot silently bootstrap another cluster.
+func WithBootstrap() Option {
+	return func(o *options) {
+		o.bootstrap = true
+	}
+}
+
+// WithVoters sets the number of voters dqlite keeps in the cluster, it must be an odd number greater than 1.
+// Zero keeps the default of 3.
+func WithVoters(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.voters = n
+		}
+	}
+}
+
+// WithStandBys sets the number of stand-bys dqlite keeps in the cluster, it must be an odd number.
+// Zero keeps the default of 3.
+func WithStandBys(n int) Option {
+	return func(o *options) {
+		if n > 0 {
+			o.standBys = n
+		}
+	}
+}
+
+// WithRolesAdjustmentFrequency sets how often the leader checks whether roles must be reassigned.
+func WithRolesAdjustmentFrequency(frequency time.Duration) Option {
+	return func(o *options) {
+		if frequency > 0 {
+			o.rolesAdjustmentFrequency = frequency
+		}
+	}
+}
+
+// WithFailureDomain sets the zone or rack of this node, dqlite spreads the voters across failure domains.
+func WithFailureDomain(failureDomain string) Option {
+	return func(o *options) {
+		o.failureDomain = failureDomain
+	}
+}
+
+// WithPeerGate lets the gate drop or delay the connections with the dqlite peers, it is used to inject faults.
+// Only the connections dialed by this node go through the gate when TLS is disabled.
+func WithPeerGate(gate PeerGate) Option {
+	return func(o *options) {
+		o.peerGate = gate
+	}
+}
+
+// failureDomainCode turns a failure domain into the numeric code dqlite expects.
+// Numeric domains are used as is, names are hashed.
+func failureDomainCode(failureDomain string) uint64 {
+	if failureDomain == "" {
+		return 0
+	}
+	if code, err := strconv.ParseUint(failureDomain, 10, 64); err == nil {
+		return code
+	}
+	h := fnv.New64a()
+	h.Write([]byte(failureDomain))
+	return h.Sum64()
+}
+
+func defaultOptions() *options {
+	return &options{
+		voters:                   3,
+		standBys:                 3,
+		rolesAdjustmentFrequency: 30 * time.Second,
+	}
+}
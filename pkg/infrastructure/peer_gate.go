@@ -0,0 +1,79 @@
+package infrastructure
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+
+	"github.com/canonical/go-dqlite/client"
+)
+
+// PeerGate decides whether a connection with a dqlite peer, identified by its host, may be established.
+//
+// The dqlite application owns its listener and the dialer of the raft connections, the gate is
+// therefore consulted on the TLS handshake of the inbound connections, which the application
+// proxies to dqlite, and on the connections this node dials itself. Every raft connection is
+// inbound on one of its two nodes, a fault injected on both of them cuts them apart. The established
+// connections are tracked so that the gate can close them later, closing one forgets it.
+type PeerGate interface {
+	Admit(host string) error
+	Track(host string, conn net.Conn) net.Conn
+}
+
+// guardListener makes the TLS handshake of the inbound connections go through the gate.
+func guardListener(listen *tls.Config, gate PeerGate) {
+	listen.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		config := listen.Clone()
+		config.GetConfigForClient = nil
+		// the peer is known once its certificate is
+		config.VerifyConnection = func(state tls.ConnectionState) error {
+			host := peerHost(hello.Conn.RemoteAddr(), state)
+			if err := gate.Admit(host); err != nil {
+				return err
+			}
+			// the application proxies the connection itself, it forgets it once it is closed
+			gate.Track(host, hello.Conn)
+			return nil
+		}
+		return config, nil
+	}
+}
+
+// guardDial makes the connections dialed by this node go through the gate.
+func guardDial(dial client.DialFunc, gate PeerGate) client.DialFunc {
+	return func(ctx context.Context, address string) (net.Conn, error) {
+		host, _, err := net.SplitHostPort(address)
+		if err != nil {
+			return nil, err
+		}
+		if err := gate.Admit(host); err != nil {
+			return nil, err
+		}
+		conn, err := dial(ctx, address)
+		if err != nil {
+			return nil, err
+		}
+		return gate.Track(host, conn), nil
+	}
+}
+
+// peerHost identifies the peer of an inbound connection by its remote host. The nodes sharing a host
+// all connect from a loopback address, they are told apart by the IP of their certificate.
+func peerHost(addr net.Addr, state tls.ConnectionState) string {
+	host := remoteHost(addr)
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() || len(state.PeerCertificates) == 0 {
+		return host
+	}
+	if ips := state.PeerCertificates[0].IPAddresses; len(ips) > 0 {
+		return ips[0].String()
+	}
+	return host
+}
+
+func remoteHost(addr net.Addr) string {
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
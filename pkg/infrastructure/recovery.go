@@ -0,0 +1,93 @@
+package infrastructure
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+
+	dqlite "github.com/canonical/go-dqlite"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/ghodss/yaml"
+	"github.com/pkg/errors"
+)
+
+// dqliteStoreFile is where dqlite keeps the last known members of the cluster.
+const dqliteStoreFile = "cluster.yaml"
+
+// Membership is what a data directory knows about the cluster it belongs to.
+type Membership struct {
+	Self      client.NodeInfo
+	Members   []client.NodeInfo
+	ClusterId string
+}
+
+// ReadMembership inspects a data directory without starting dqlite.
+func ReadMembership(dbPath string) (*Membership, error) {
+	initialized, err := IsInitialized(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	if !initialized {
+		return nil, fmt.Errorf("%s is not a dqlite data directory", dbPath)
+	}
+
+	membership := &Membership{}
+	data, err := ioutil.ReadFile(filepath.Join(dbPath, dqliteInfoFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "read node info")
+	}
+	if err := yaml.Unmarshal(data, &membership.Self); err != nil {
+		return nil, errors.Wrap(err, "parse node info")
+	}
+
+	store, err := client.NewYamlNodeStore(filepath.Join(dbPath, dqliteStoreFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "open node store")
+	}
+	if membership.Members, err = store.Get(context.Background()); err != nil {
+		return nil, errors.Wrap(err, "read node store")
+	}
+
+	if membership.ClusterId, err = readLocalClusterId(dbPath); err != nil {
+		return nil, err
+	}
+	return membership, nil
+}
+
+// RecoverAsSingleVoter rewrites the raft configuration stored in the data directory so that this node
+// restarts as the only voter of the cluster, it is used after the majority of the voters are lost for good.
+// The node must not be running.
+func RecoverAsSingleVoter(dbPath string) (*Membership, error) {
+	membership, err := ReadMembership(dbPath)
+	if err != nil {
+		return nil, err
+	}
+
+	listener, err := net.Listen("tcp", membership.Self.Address)
+	if err != nil {
+		return nil, fmt.Errorf("%s is in use, stop the node before recovering it: %w", membership.Self.Address, err)
+	}
+	listener.Close()
+
+	self := client.NodeInfo{
+		ID:      membership.Self.ID,
+		Address: membership.Self.Address,
+		Role:    client.Voter,
+	}
+	if err := dqlite.ReconfigureMembershipExt(dbPath, []dqlite.NodeInfo{self}); err != nil {
+		return nil, errors.Wrap(err, "reconfigure membership")
+	}
+
+	store, err := client.NewYamlNodeStore(filepath.Join(dbPath, dqliteStoreFile))
+	if err != nil {
+		return nil, errors.Wrap(err, "open node store")
+	}
+	if err := store.Set(context.Background(), []client.NodeInfo{self}); err != nil {
+		return nil, errors.Wrap(err, "update node store")
+	}
+
+	membership.Members = []client.NodeInfo{self}
+	return membership, nil
+}
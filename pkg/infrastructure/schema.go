@@ -0,0 +1,295 @@
+package infrastructure
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/pkg/errors"
+)
+
+const (
+	// baselineSchemaVersion is the schema created with CREATE TABLE IF NOT EXISTS on every start.
+	baselineSchemaVersion = 1
+	schemaVersionSchema   = "CREATE TABLE IF NOT EXISTS SCHEMA_VERSION (ID INTEGER PRIMARY KEY CHECK (ID = 1), VERSION INTEGER)"
+	schemaSupportSchema   = "CREATE TABLE IF NOT EXISTS SCHEMA_SUPPORT (ADDRESS VARCHAR(255) PRIMARY KEY, SCHEMA_VERSION INTEGER)"
+	initSchemaVersion     = "INSERT OR IGNORE INTO SCHEMA_VERSION (ID, VERSION) VALUES (1, ?)"
+	findSchemaVersion     = "SELECT VERSION FROM SCHEMA_VERSION WHERE ID = 1"
+	updateSchemaVersion   = "UPDATE SCHEMA_VERSION SET VERSION = ? WHERE ID = 1 AND VERSION = ?"
+	advertiseSchema       = "INSERT OR REPLACE INTO SCHEMA_SUPPORT (ADDRESS, SCHEMA_VERSION) VALUES (?, ?)"
+)
+
+type migration struct {
+	version    int
+	statements []string
+	// convert rewrites the existing rows after the statements, in the same transaction
+	convert func(ctx context.Context, tx *sql.Tx) error
+}
+
+// migrations change the schema after the baseline. They are applied in order, by the leader only,
+// once every voter runs a binary supporting them, or all at once when a cluster is bootstrapped.
+// A new migration must get the next version.
+var migrations = []migration{
+	{
+		// idempotency keys of the task writes, TASK_ID is the task the request wrote
+		version: 2,
+		statements: []string{
+			"CREATE TABLE IF NOT EXISTS IDEMPOTENCY_KEYS (IDEMPOTENCY_KEY VARCHAR(255) PRIMARY KEY, FINGERPRINT VARCHAR(255), " +
+				"STATUS INTEGER, CONTENT_TYPE VARCHAR(255), BODY BLOB, TASK_ID INTEGER, EXPIRES INTEGER)",
+			"CREATE INDEX IF NOT EXISTS IDEMPOTENCY_KEYS_EXPIRES ON IDEMPOTENCY_KEYS (EXPIRES)",
+		},
+	},
+	{
+		// typed task timestamps, in milliseconds since the epoch in UTC. CREATED_DATE is still written
+		// for the binaries reading the previous schema.
+		version: 3,
+		statements: []string{
+			"ALTER TABLE TASKS ADD COLUMN COMPLETED INTEGER NOT NULL DEFAULT 0",
+			"ALTER TABLE TASKS ADD COLUMN CREATED_AT INTEGER",
+			"ALTER TABLE TASKS ADD COLUMN UPDATED_AT INTEGER",
+			"ALTER TABLE TASKS ADD COLUMN COMPLETED_AT INTEGER",
+			"CREATE INDEX IF NOT EXISTS TASKS_CREATED_AT ON TASKS (CREATED_AT)",
+			"CREATE INDEX IF NOT EXISTS TASKS_UPDATED_AT ON TASKS (UPDATED_AT)",
+		},
+		convert: convertTaskDates,
+	},
+	{
+		// projects, NEXT_NUMBER is the number of the next task of the project, its key is PROJECT_KEY-NUMBER
+		version: 4,
+		statements: []string{
+			"CREATE TABLE IF NOT EXISTS PROJECTS (ID INTEGER PRIMARY KEY AUTOINCREMENT, PROJECT_KEY VARCHAR(10) NOT NULL UNIQUE, " +
+				"NAME VARCHAR(255), NEXT_NUMBER INTEGER NOT NULL, CREATED_AT INTEGER)",
+			"ALTER TABLE TASKS ADD COLUMN PROJECT_ID INTEGER REFERENCES PROJECTS (ID)",
+			"ALTER TABLE TASKS ADD COLUMN TASK_KEY VARCHAR(32)",
+			"CREATE UNIQUE INDEX IF NOT EXISTS TASKS_TASK_KEY ON TASKS (TASK_KEY)",
+			"CREATE INDEX IF NOT EXISTS TASKS_PROJECT_ID ON TASKS (PROJECT_ID)",
+		},
+	},
+	{
+		// archived projects, in milliseconds since the epoch, NULL while the project is active
+		version: 5,
+		statements: []string{
+			"ALTER TABLE PROJECTS ADD COLUMN ARCHIVED_AT INTEGER",
+		},
+	},
+	{
+		// tenants, the rows written before them belong to the default tenant. TOKEN_HASH is the SHA-256 of
+		// the token of the tenant. The keys of the projects and tasks are unique per tenant, SQLite cannot
+		// drop the UNIQUE constraint of PROJECT_KEY so PROJECTS is rebuilt, TASKS keeps referencing it by name.
+		version: 6,
+		statements: []string{
+			"CREATE TABLE IF NOT EXISTS TENANTS (ID VARCHAR(32) PRIMARY KEY, NAME VARCHAR(255), " +
+				"TOKEN_HASH VARCHAR(64) NOT NULL UNIQUE, CREATED_AT INTEGER)",
+			"ALTER TABLE TASKS ADD COLUMN TENANT_ID VARCHAR(32) NOT NULL DEFAULT 'default'",
+			"DROP INDEX IF EXISTS TASKS_TASK_KEY",
+			"CREATE UNIQUE INDEX IF NOT EXISTS TASKS_TENANT_TASK_KEY ON TASKS (TENANT_ID, TASK_KEY)",
+			"CREATE INDEX IF NOT EXISTS TASKS_TENANT_ID ON TASKS (TENANT_ID, ID)",
+			"CREATE TABLE PROJECTS_BY_TENANT (ID INTEGER PRIMARY KEY AUTOINCREMENT, TENANT_ID VARCHAR(32) NOT NULL DEFAULT 'default', " +
+				"PROJECT_KEY VARCHAR(10) NOT NULL, NAME VARCHAR(255), NEXT_NUMBER INTEGER NOT NULL, CREATED_AT INTEGER, " +
+				"ARCHIVED_AT INTEGER, UNIQUE (TENANT_ID, PROJECT_KEY))",
+			"INSERT INTO PROJECTS_BY_TENANT (ID, PROJECT_KEY, NAME, NEXT_NUMBER, CREATED_AT, ARCHIVED_AT) " +
+				"SELECT ID, PROJECT_KEY, NAME, NEXT_NUMBER, CREATED_AT, ARCHIVED_AT FROM PROJECTS",
+			"DROP TABLE PROJECTS",
+			"ALTER TABLE PROJECTS_BY_TENANT RENAME TO PROJECTS",
+		},
+	},
+	{
+		// quotas of the tenants, a missing or NULL limit is no limit. RATE_LIMITS counts the requests of every
+		// tenant per second, so that all the nodes share the limit.
+		version: 7,
+		statements: []string{
+			"CREATE TABLE IF NOT EXISTS TENANT_QUOTAS (TENANT_ID VARCHAR(32) PRIMARY KEY, MAX_TASKS INTEGER, " +
+				"MAX_DETAILS_SIZE INTEGER, REQUESTS_PER_SECOND INTEGER)",
+			"CREATE TABLE IF NOT EXISTS RATE_LIMITS (TENANT_ID VARCHAR(32) NOT NULL, EPOCH_SECOND INTEGER NOT NULL, " +
+				"REQUESTS INTEGER NOT NULL, PRIMARY KEY (TENANT_ID, EPOCH_SECOND))",
+		},
+	},
+}
+
+// SchemaVersion is the latest schema version this binary can read and write.
+var SchemaVersion = baselineSchemaVersion + len(migrations)
+
+// ErrSchemaTooNew is returned when the cluster schema was migrated beyond what this binary supports.
+var ErrSchemaTooNew = errors.New("cluster schema is newer than this binary supports")
+
+// checkSchema refuses to start a binary older than the cluster schema and advertises the schema version
+// this node supports.
+func (d *Dqlite) checkSchema() error {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(30*time.Second))
+
+	defer cancel()
+	if err := d.initSchemaVersion(ctx); err != nil {
+		return err
+	}
+	current, err := d.SchemaVersion()
+	if err != nil {
+		return err
+	}
+	if current > SchemaVersion {
+		return fmt.Errorf("%w: cluster schema %d, supported %d", ErrSchemaTooNew, current, SchemaVersion)
+	}
+	if _, err := d.db.ExecContext(ctx, advertiseSchema, d.address, SchemaVersion); err != nil {
+		return errors.Wrap(err, "advertise schema version")
+	}
+	return nil
+}
+
+// initSchemaVersion records the schema version of a cluster which has none. A node which is the only voter
+// applies all the migrations, no other voter can run an older binary, the other nodes record the baseline
+// for the leader to migrate.
+func (d *Dqlite) initSchemaVersion(ctx context.Context) error {
+	var version int
+	err := d.db.QueryRowContext(ctx, findSchemaVersion).Scan(&version)
+	if err != sql.ErrNoRows {
+		return errors.Wrap(err, "read schema version")
+	}
+	alone, err := d.onlyVoter(ctx)
+	if err != nil {
+		return errors.Wrap(err, "read cluster voters")
+	}
+
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if _, err := tx.ExecContext(ctx, initSchemaVersion, baselineSchemaVersion); err != nil {
+		return errors.Wrap(err, "initialize schema version")
+	}
+	if alone {
+		if err := applyMigrations(ctx, tx, baselineSchemaVersion, SchemaVersion); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return errors.Wrap(err, "initialize schema version")
+	}
+	if alone {
+		d.log.Log.Sugar().Infof("schema initialized at version %d", SchemaVersion)
+	}
+	return nil
+}
+
+// onlyVoter tells whether this node is the only voter of the cluster.
+func (d *Dqlite) onlyVoter(ctx context.Context) (bool, error) {
+	cli, err := d.dqlite.Leader(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer cli.Close()
+	cluster, err := cli.Cluster(ctx)
+	if err != nil {
+		return false, err
+	}
+	for _, node := range cluster {
+		if node.Role == client.Voter && node.ID != d.dqlite.ID() {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// SchemaVersion returns the schema version of the cluster.
+func (d *Dqlite) SchemaVersion() (int, error) {
+	var version int
+	if err := d.db.QueryRow(findSchemaVersion).Scan(&version); err != nil {
+		return 0, errors.Wrap(err, "read schema version")
+	}
+	return version, nil
+}
+
+// SupportedSchemaVersion returns the latest schema version of this binary.
+func (d *Dqlite) SupportedSchemaVersion() int {
+	return SchemaVersion
+}
+
+// MigrateSchema applies the migrations after version from up to version to in a single transaction.
+// It fails if another node changed the schema version in the meantime.
+func (d *Dqlite) MigrateSchema(from int, to int) error {
+	if to > SchemaVersion {
+		return fmt.Errorf("%w: requested %d, supported %d", ErrSchemaTooNew, to, SchemaVersion)
+	}
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now().Add(60*time.Second))
+
+	defer cancel()
+	tx, err := d.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	if err := applyMigrations(ctx, tx, from, to); err != nil {
+		tx.Rollback()
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return err
+	}
+	d.log.Log.Sugar().Infof("schema migrated from version %d to %d", from, to)
+	return nil
+}
+
+func applyMigrations(ctx context.Context, tx *sql.Tx, from int, to int) error {
+	for _, m := range migrations {
+		if m.version <= from || m.version > to {
+			continue
+		}
+		for _, statement := range m.statements {
+			if _, err := tx.ExecContext(ctx, statement); err != nil {
+				return errors.Wrapf(err, "migration %d", m.version)
+			}
+		}
+		if m.convert != nil {
+			if err := m.convert(ctx, tx); err != nil {
+				return errors.Wrapf(err, "migration %d", m.version)
+			}
+		}
+	}
+	result, err := tx.ExecContext(ctx, updateSchemaVersion, to, from)
+	if err != nil {
+		return err
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if updated != 1 {
+		return fmt.Errorf("schema version is no longer %d", from)
+	}
+	return nil
+}
+
+// convertTaskDates fills the timestamps of the existing tasks from their RFC 1123 creation date,
+// the dates which cannot be read become the epoch.
+func convertTaskDates(ctx context.Context, tx *sql.Tx) error {
+	dates := make(map[int64]string)
+	rows, err := tx.QueryContext(ctx, "SELECT ID, CREATED_DATE FROM TASKS WHERE CREATED_AT IS NULL")
+	if err != nil {
+		return err
+	}
+	for rows.Next() {
+		var id int64
+		var date sql.NullString
+		if err := rows.Scan(&id, &date); err != nil {
+			rows.Close()
+			return err
+		}
+		dates[id] = date.String
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	for id, date := range dates {
+		created, _ := domain.ParseLegacyDate(date, time.Local)
+		millis := created.UnixNano() / int64(time.Millisecond)
+		if created.IsZero() {
+			millis = 0
+		}
+		if _, err := tx.ExecContext(ctx, "UPDATE TASKS SET CREATED_AT = ?, UPDATED_AT = ? WHERE ID = ?", millis, millis, id); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,51 @@
+package infrastructure
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+
+	"github.com/canonical/go-dqlite/app"
+	"github.com/canonical/go-dqlite/client"
+	"github.com/pkg/errors"
+)
+
+// loadTLSConfig loads the cluster certificates, returning the listen and dial configurations.
+func loadTLSConfig(certsPath string) (*tls.Config, *tls.Config, error) {
+	crt := filepath.Join(certsPath, "cluster.crt")
+	key := filepath.Join(certsPath, "cluster.key")
+
+	keypair, err := tls.LoadX509KeyPair(crt, key)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "load keypair")
+	}
+	data, err := ioutil.ReadFile(crt)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "read certificate")
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(data) {
+		return nil, nil, fmt.Errorf("bad certificate")
+	}
+	listen, dial := app.SimpleTLSConfig(keypair, pool)
+	return listen, dial, nil
+}
+
+// NewDialFunc returns the function used to reach other dqlite nodes of the cluster.
+func NewDialFunc(enableTls bool, certsPath string) (client.DialFunc, error) {
+	if !enableTls {
+		return client.DefaultDialFunc, nil
+	}
+	_, dial, err := loadTLSConfig(certsPath)
+	if err != nil {
+		return nil, err
+	}
+	return client.DialFuncWithTLS(client.DefaultDialFunc, dial), nil
+}
+
+// IsInitialized tells whether dqlite has already been started in the data directory.
+func IsInitialized(dbPath string) (bool, error) {
+	return fileExists(dbPath, dqliteInfoFile)
+}
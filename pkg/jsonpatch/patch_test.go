@@ -0,0 +1,86 @@
+package jsonpatch
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustMergePatch(t *testing.T) {
+	// examples of RFC 7386 appendix A
+	cases := []struct{ doc, patch, want string }{
+		{`{"a":"b"}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":"b"}`, `{"b":"c"}`, `{"a":"b","b":"c"}`},
+		{`{"a":"b"}`, `{"a":null}`, `{}`},
+		{`{"a":"b","b":"c"}`, `{"a":null}`, `{"b":"c"}`},
+		{`{"a":["b"]}`, `{"a":"c"}`, `{"a":"c"}`},
+		{`{"a":{"b":"c"}}`, `{"a":{"b":"d","c":null}}`, `{"a":{"b":"d"}}`},
+		{`{"a":[{"b":"c"}]}`, `{"a":[1]}`, `{"a":[1]}`},
+		{`["a","b"]`, `["c","d"]`, `["c","d"]`},
+		{`{"e":null}`, `{"a":1}`, `{"a":1,"e":null}`},
+		{`[1,2]`, `{"a":"b","c":null}`, `{"a":"b"}`},
+	}
+	for _, c := range cases {
+		patched, err := Merge([]byte(c.doc), []byte(c.patch))
+
+		assert.Nil(t, err)
+		assert.JSONEqf(t, c.want, string(patched), "%s merged with %s", c.doc, c.patch)
+	}
+}
+
+func TestFailMergeMalformedPatch(t *testing.T) {
+	_, err := Merge([]byte(`{"a":"b"}`), []byte(`{"a":`))
+
+	assert.ErrorIs(t, err, ErrMalformed)
+}
+
+func TestMustApplyPatch(t *testing.T) {
+	// examples of RFC 6902 appendix A
+	cases := []struct{ doc, patch, want string }{
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz","value":"qux"}]`, `{"baz":"qux","foo":"bar"}`},
+		{`{"foo":["bar","baz"]}`, `[{"op":"add","path":"/foo/1","value":"qux"}]`, `{"foo":["bar","qux","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, `{"foo":"bar"}`},
+		{`{"foo":["bar","qux","baz"]}`, `[{"op":"remove","path":"/foo/1"}]`, `{"foo":["bar","baz"]}`},
+		{`{"baz":"qux","foo":"bar"}`, `[{"op":"replace","path":"/baz","value":"boo"}]`, `{"baz":"boo","foo":"bar"}`},
+		{`{"foo":{"bar":"baz","waldo":"fred"},"qux":{"corge":"grault"}}`, `[{"op":"move","from":"/foo/waldo","path":"/qux/thud"}]`,
+			`{"foo":{"bar":"baz"},"qux":{"corge":"grault","thud":"fred"}}`},
+		{`{"foo":["all","grass","cows","eat"]}`, `[{"op":"move","from":"/foo/1","path":"/foo/3"}]`, `{"foo":["all","cows","eat","grass"]}`},
+		{`{"baz":"qux","foo":["a",2,"c"]}`, `[{"op":"test","path":"/baz","value":"qux"},{"op":"test","path":"/foo/1","value":2}]`,
+			`{"baz":"qux","foo":["a",2,"c"]}`},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/child","value":{"grandchild":{}}}]`, `{"foo":"bar","child":{"grandchild":{}}}`},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/-","value":["abc","def"]}]`, `{"foo":["bar",["abc","def"]]}`},
+		{`{"foo":null}`, `[{"op":"test","path":"/foo","value":null}]`, `{"foo":null}`},
+		{`{"/":9,"~1":10}`, `[{"op":"test","path":"/~01","value":10}]`, `{"/":9,"~1":10}`},
+		{`{"foo":{"bar":1}}`, `[{"op":"copy","from":"/foo","path":"/baz"},{"op":"replace","path":"/baz/bar","value":2}]`,
+			`{"foo":{"bar":1},"baz":{"bar":2}}`},
+	}
+	for _, c := range cases {
+		patched, err := Apply([]byte(c.doc), []byte(c.patch))
+
+		assert.Nilf(t, err, "%s patched with %s", c.doc, c.patch)
+		assert.JSONEqf(t, c.want, string(patched), "%s patched with %s", c.doc, c.patch)
+	}
+}
+
+func TestFailApplyPatch(t *testing.T) {
+	cases := []struct {
+		doc, patch string
+		want       error
+	}{
+		{`{"baz":"qux"}`, `[{"op":"test","path":"/baz","value":"bar"}]`, ErrTestFailed},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz/bat","value":"qux"}]`, ErrInapplicable},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"/baz"}]`, ErrInapplicable},
+		{`{"foo":["bar"]}`, `[{"op":"add","path":"/foo/2","value":"qux"}]`, ErrInapplicable},
+		{`{"foo":{"bar":1}}`, `[{"op":"move","from":"/foo","path":"/foo/bar/baz"}]`, ErrInapplicable},
+		{`{"foo":"bar"}`, `[{"op":"add","path":"/baz"}]`, ErrMalformed},
+		{`{"foo":"bar"}`, `[{"op":"explode","path":"/baz"}]`, ErrMalformed},
+		{`{"foo":"bar"}`, `[{"op":"remove","path":"baz"}]`, ErrMalformed},
+		{`{"foo":["bar"]}`, `[{"op":"remove","path":"/foo/01"}]`, ErrMalformed},
+		{`{"foo":"bar"}`, `{"op":"remove","path":"/foo"}`, ErrMalformed},
+	}
+	for _, c := range cases {
+		_, err := Apply([]byte(c.doc), []byte(c.patch))
+
+		assert.ErrorIsf(t, err, c.want, "%s patched with %s", c.doc, c.patch)
+	}
+}
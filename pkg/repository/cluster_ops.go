@@ -6,7 +6,16 @@ import (
 
 type ClusterOps interface {
 	GetClusterInfo() ([]byte, error)
-	RemoveNode(address string) (string, error)
+	GetRolesConfig() ([]byte, error)
 	Leader() (string, error)
+	Leave() error
+	Address() string
+	Probe(address string) error
+	Assign(id uint64, role uint8) error
+	RemoveById(id uint64) error
+	Handover() error
+	SchemaVersion() (int, error)
+	SupportedSchemaVersion() int
+	MigrateSchema(from int, to int) error
 	Shutdown(ctx context.Context)
 }
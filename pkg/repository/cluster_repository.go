@@ -23,12 +23,8 @@ func (c *ClusterRepository) ClusterInfo() ([]byte, error) {
 	return clusterInfoInBytes, nil
 }
 
-func (c *ClusterRepository) RemoveNode(address string) (string, error) {
-	nodeRemoved, err := c.clusterOps.RemoveNode(address)
-	if err != nil {
-		return "", err
-	}
-	return nodeRemoved, nil
+func (c *ClusterRepository) RolesConfig() ([]byte, error) {
+	return c.clusterOps.GetRolesConfig()
 }
 
 func (c *ClusterRepository) FindLeader() (string, error) {
@@ -38,3 +34,39 @@ func (c *ClusterRepository) FindLeader() (string, error) {
 	}
 	return leadeNodeAddress, nil
 }
+
+func (c *ClusterRepository) Leave() error {
+	return c.clusterOps.Leave()
+}
+
+func (c *ClusterRepository) LocalAddress() string {
+	return c.clusterOps.Address()
+}
+
+func (c *ClusterRepository) ProbeNode(address string) error {
+	return c.clusterOps.Probe(address)
+}
+
+func (c *ClusterRepository) AssignRole(id uint64, role uint8) error {
+	return c.clusterOps.Assign(id, role)
+}
+
+func (c *ClusterRepository) RemoveNodeById(id uint64) error {
+	return c.clusterOps.RemoveById(id)
+}
+
+func (c *ClusterRepository) Handover() error {
+	return c.clusterOps.Handover()
+}
+
+func (c *ClusterRepository) SchemaVersion() (int, error) {
+	return c.clusterOps.SchemaVersion()
+}
+
+func (c *ClusterRepository) SupportedSchemaVersion() int {
+	return c.clusterOps.SupportedSchemaVersion()
+}
+
+func (c *ClusterRepository) MigrateSchema(from int, to int) error {
+	return c.clusterOps.MigrateSchema(from, to)
+}
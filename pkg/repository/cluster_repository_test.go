@@ -4,9 +4,11 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/balchua/bopbag/pkg/applog"
@@ -30,7 +32,7 @@ func TestMustRetrieveClusterInfo(t *testing.T) {
 	}
 	defer os.Remove(dir)
 	dbAddress := "127.0.0.1:50000"
-	dqliteInst, err := infrastructure.NewDqlite(applog, dir, dbAddress, nil, enableTls, certsPath)
+	dqliteInst, err := infrastructure.NewDqlite(applog, dir, dbAddress, nil, enableTls, certsPath, infrastructure.WithBootstrap())
 	defer dqliteInst.Shutdown(context.TODO())
 
 	repo := NewClusterRepository(dqliteInst)
@@ -44,7 +46,7 @@ func TestMustRetrieveClusterInfo(t *testing.T) {
 	assert.Contains(result, "127.0.0.1:50000")
 }
 
-func TestTryRemoveNode(t *testing.T) {
+func TestRemoveNoneExistentNode(t *testing.T) {
 	assert := assert.New(t)
 	applog := applog.NewLogger()
 	path, err := os.Getwd()
@@ -59,17 +61,26 @@ func TestTryRemoveNode(t *testing.T) {
 	}
 	defer os.Remove(dir)
 	dbAddress := "127.0.0.1:50000"
-	dqliteInst, err := infrastructure.NewDqlite(applog, dir, dbAddress, nil, enableTls, certsPath)
+	dqliteInst, err := infrastructure.NewDqlite(applog, dir, dbAddress, nil, enableTls, certsPath, infrastructure.WithBootstrap())
 	defer dqliteInst.Shutdown(context.TODO())
 
 	repo := NewClusterRepository(dqliteInst)
 
-	data, err := repo.RemoveNode(dbAddress)
-	assert.Nil(err)
-	assert.Equal("127.0.0.1:50000", data)
+	removeErr := repo.RemoveNodeById(12345)
+	assert.NotNil(removeErr)
 }
 
-func TestRemoveNoneExistentNode(t *testing.T) {
+func TestFailDbStartupNoDataDirectory(t *testing.T) {
+	assert := assert.New(t)
+	applog := applog.NewLogger()
+
+	dbAddress := "127.0.0.1:50000"
+	_, err := infrastructure.NewDqlite(applog, "/non-existent/", dbAddress, nil, false, "", infrastructure.WithBootstrap())
+
+	assert.NotNil(t, err)
+}
+
+func TestGetLeader(t *testing.T) {
 	assert := assert.New(t)
 	applog := applog.NewLogger()
 	path, err := os.Getwd()
@@ -84,46 +95,112 @@ func TestRemoveNoneExistentNode(t *testing.T) {
 	}
 	defer os.Remove(dir)
 	dbAddress := "127.0.0.1:50000"
-	dqliteInst, err := infrastructure.NewDqlite(applog, dir, dbAddress, nil, enableTls, certsPath)
+	dqliteInst, err := infrastructure.NewDqlite(applog, dir, dbAddress, nil, enableTls, certsPath, infrastructure.WithBootstrap())
 	defer dqliteInst.Shutdown(context.TODO())
 
 	repo := NewClusterRepository(dqliteInst)
 
-	_, removeErr := repo.RemoveNode("127.0.0.1:2000")
-	assert.NotNil(removeErr)
+	data, err := repo.FindLeader()
+	assert.Nil(err)
+	assert.Equal("127.0.0.1:50000", data)
 }
 
-func TestFailDbStartupNoDataDirectory(t *testing.T) {
+func TestMustPersistClusterId(t *testing.T) {
 	assert := assert.New(t)
 	applog := applog.NewLogger()
+	path, err := os.Getwd()
+	if err != nil {
+		log.Println(err)
+	}
+	certsPath := path + "/default-certs/"
+	dir, err := ioutil.TempDir("/tmp/", "tempdb")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbAddress := "127.0.0.1:50000"
+	dqliteInst, err := infrastructure.NewDqlite(applog, dir, dbAddress, nil, true, certsPath, infrastructure.WithBootstrap())
+	assert.Nil(err)
+	defer dqliteInst.Shutdown(context.TODO())
+
+	data, err := ioutil.ReadFile(filepath.Join(dir, "cluster-id"))
+	assert.Nil(err)
+	assert.Contains(string(data), dqliteInst.ClusterId())
+}
+
+func TestRefuseImplicitBootstrap(t *testing.T) {
+	assert := assert.New(t)
+	applog := applog.NewLogger()
+	dir, err := ioutil.TempDir("/tmp/", "tempdb")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	dbAddress := "127.0.0.1:50000"
+	_, err = infrastructure.NewDqlite(applog, dir, dbAddress, nil, false, "")
+
+	assert.True(errors.Is(err, infrastructure.ErrClusterIdentityMismatch))
+}
 
+func TestRefuseBootstrapWithExpectedClusterIdOnly(t *testing.T) {
+	assert := assert.New(t)
+	applog := applog.NewLogger()
+	dir, err := ioutil.TempDir("/tmp/", "tempdb")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
 	dbAddress := "127.0.0.1:50000"
-	_, err := infrastructure.NewDqlite(applog, "/non-existent/", dbAddress, nil, false, "")
+	_, err = infrastructure.NewDqlite(applog, dir, dbAddress, nil, false, "",
+		infrastructure.WithClusterId("5b0b3a4e-7a4c-4a43-9d7e-3c3f0f6f1a2b"))
 
-	assert.NotNil(t, err)
+	assert.True(errors.Is(err, infrastructure.ErrClusterIdentityMismatch))
 }
 
-func TestGetLeader(t *testing.T) {
+func TestRefuseBootstrapWhileJoining(t *testing.T) {
 	assert := assert.New(t)
 	applog := applog.NewLogger()
-	path, err := os.Getwd()
+	dir, err := ioutil.TempDir("/tmp/", "tempdb")
 	if err != nil {
-		log.Println(err)
+		log.Fatal(err)
 	}
-	certsPath := path + "/default-certs/"
-	enableTls := true
+	defer os.RemoveAll(dir)
+	dbAddress := "127.0.0.1:50000"
+	_, err = infrastructure.NewDqlite(applog, dir, dbAddress, []string{"127.0.0.1:50001"}, false, "",
+		infrastructure.WithBootstrap())
+
+	assert.NotNil(err)
+}
+
+func TestMustBootstrapWithExpectedClusterId(t *testing.T) {
+	assert := assert.New(t)
+	applog := applog.NewLogger()
 	dir, err := ioutil.TempDir("/tmp/", "tempdb")
 	if err != nil {
 		log.Fatal(err)
 	}
-	defer os.Remove(dir)
+	defer os.RemoveAll(dir)
 	dbAddress := "127.0.0.1:50000"
-	dqliteInst, err := infrastructure.NewDqlite(applog, dir, dbAddress, nil, enableTls, certsPath)
+	dqliteInst, err := infrastructure.NewDqlite(applog, dir, dbAddress, nil, false, "",
+		infrastructure.WithClusterId("5b0b3a4e-7a4c-4a43-9d7e-3c3f0f6f1a2b"), infrastructure.WithBootstrap())
+	assert.Nil(err)
 	defer dqliteInst.Shutdown(context.TODO())
 
-	repo := NewClusterRepository(dqliteInst)
+	assert.Equal("5b0b3a4e-7a4c-4a43-9d7e-3c3f0f6f1a2b", dqliteInst.ClusterId())
+}
 
-	data, err := repo.FindLeader()
-	assert.Nil(err)
-	assert.Equal("127.0.0.1:50000", data)
+func TestRefuseStartWithDifferentClusterId(t *testing.T) {
+	assert := assert.New(t)
+	applog := applog.NewLogger()
+	dir, err := ioutil.TempDir("/tmp/", "tempdb")
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "cluster-id"), []byte("5b0b3a4e-7a4c-4a43-9d7e-3c3f0f6f1a2b\n"), 0600)
+	dbAddress := "127.0.0.1:50000"
+	_, err = infrastructure.NewDqlite(applog, dir, dbAddress, []string{"127.0.0.1:50001"}, false, "",
+		infrastructure.WithClusterId("0d6d5e0a-1f7e-4d5b-8c1e-2a9b7f4c6e3d"))
+
+	assert.True(errors.Is(err, infrastructure.ErrClusterIdentityMismatch))
 }
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"strconv"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	dqlite "github.com/canonical/go-dqlite/driver"
+)
+
+// primary SQLite result codes, the extended codes keep them in their lowest byte
+const (
+	sqliteBusy       = 5
+	sqliteLocked     = 6
+	sqliteConstraint = 19
+)
+
+// databaseError turns the errors of the database driver into domain errors.
+func databaseError(err error) error {
+	var dqliteErr dqlite.Error
+	switch {
+	case err == nil:
+		return nil
+	case errors.Is(err, dqlite.ErrNoAvailableLeader):
+		return domain.NewError(domain.ErrNoLeader, domain.CodeNoLeader, "no dqlite leader is available", err)
+	case errors.Is(err, driver.ErrBadConn), errors.Is(err, sql.ErrConnDone), errors.Is(err, context.DeadlineExceeded),
+		errors.Is(err, context.Canceled):
+		// dqlite reports a lost leadership as a bad connection, and a hung leader as a timeout
+		return domain.NewError(domain.ErrUnavailable, domain.CodeDatabaseUnavailable, "database unavailable", err)
+	case errors.As(err, &dqliteErr) && dqliteErr.Code&0xff == sqliteConstraint:
+		return domain.NewError(domain.ErrConflict, domain.CodeConstraintViolation, "constraint violation", err)
+	case errors.As(err, &dqliteErr) && (dqliteErr.Code&0xff == sqliteBusy || dqliteErr.Code&0xff == sqliteLocked):
+		return domain.NewError(domain.ErrUnavailable, domain.CodeDatabaseBusy, "database busy", err)
+	}
+	return err
+}
+
+// queryError is databaseError for the queries run with a context, a query failing after its context
+// ended is reported as the context error, whatever the driver said.
+func queryError(ctx context.Context, err error) error {
+	if err != nil && ctx.Err() != nil && !errors.Is(err, ctx.Err()) {
+		err = fmt.Errorf("%w: %v", ctx.Err(), err)
+	}
+	return databaseError(err)
+}
+
+func taskNotFound(id int64, cause error) error {
+	return domain.NewError(domain.ErrNotFound, domain.CodeTaskNotFound, "task "+strconv.FormatInt(id, 10)+" not found", cause)
+}
+
+func projectNotFound(id int64, cause error) error {
+	return domain.NewError(domain.ErrNotFound, domain.CodeProjectNotFound, "project "+strconv.FormatInt(id, 10)+" not found", cause)
+}
+
+func tenantNotFound(id string) error {
+	return domain.NewError(domain.ErrNotFound, domain.CodeTenantNotFound, "tenant "+id+" not found", nil)
+}
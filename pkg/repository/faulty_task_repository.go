@@ -0,0 +1,70 @@
+package repository
+
+import (
+	"context"
+
+	"github.com/balchua/bopbag/pkg/domain"
+)
+
+// FaultyTaskRepository lets the fault injector delay or fail every operation of the wrapped repository.
+type FaultyTaskRepository struct {
+	repo     domain.TaskRepository
+	injector domain.FaultInjector
+}
+
+func NewFaultyTaskRepository(repo domain.TaskRepository, injector domain.FaultInjector) *FaultyTaskRepository {
+	return &FaultyTaskRepository{
+		repo:     repo,
+		injector: injector,
+	}
+}
+
+func (f *FaultyTaskRepository) Add(ctx context.Context, task *domain.Task) (*domain.Task, error) {
+	if err := f.injector.Before(domain.OperationTaskAdd); err != nil {
+		return nil, err
+	}
+	return f.repo.Add(ctx, task)
+}
+
+func (f *FaultyTaskRepository) FindById(ctx context.Context, id int64) (*domain.Task, error) {
+	if err := f.injector.Before(domain.OperationTaskFindById); err != nil {
+		return nil, err
+	}
+	return f.repo.FindById(ctx, id)
+}
+
+// FindByKey is a task.findById operation, the faults of the lookups by id target it too.
+func (f *FaultyTaskRepository) FindByKey(ctx context.Context, key string) (*domain.Task, error) {
+	if err := f.injector.Before(domain.OperationTaskFindById); err != nil {
+		return nil, err
+	}
+	return f.repo.FindByKey(ctx, key)
+}
+
+func (f *FaultyTaskRepository) FindAll(ctx context.Context, filter domain.TaskFilter) (*[]domain.Task, error) {
+	if err := f.injector.Before(domain.OperationTaskFindAll); err != nil {
+		return nil, err
+	}
+	return f.repo.FindAll(ctx, filter)
+}
+
+func (f *FaultyTaskRepository) Delete(ctx context.Context, id int64) error {
+	if err := f.injector.Before(domain.OperationTaskDelete); err != nil {
+		return err
+	}
+	return f.repo.Delete(ctx, id)
+}
+
+func (f *FaultyTaskRepository) Update(ctx context.Context, task *domain.Task) (*domain.Task, error) {
+	if err := f.injector.Before(domain.OperationTaskUpdate); err != nil {
+		return nil, err
+	}
+	return f.repo.Update(ctx, task)
+}
+
+func (f *FaultyTaskRepository) Patch(ctx context.Context, id int64, patch func(task *domain.Task) error) (*domain.Task, error) {
+	if err := f.injector.Before(domain.OperationTaskPatch); err != nil {
+		return nil, err
+	}
+	return f.repo.Patch(ctx, id, patch)
+}
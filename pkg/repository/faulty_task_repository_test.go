@@ -0,0 +1,66 @@
+package repository
+
+import (
+	"context"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeInjector struct {
+	err        error
+	operations []string
+}
+
+func (f *fakeInjector) Before(operation string) error {
+	f.operations = append(f.operations, operation)
+	return f.err
+}
+
+func TestMustRunTheOperationWithoutFault(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	expectWritable(mock, 1)
+	mock.ExpectExec("DELETE FROM TASKS").WithArgs(1, domain.DefaultTenant).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	taskRepo, _ := NewTaskRepository(applog.NewLogger(), db)
+	injector := &fakeInjector{}
+	repo := NewFaultyTaskRepository(taskRepo, injector)
+
+	err = repo.Delete(context.Background(), 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{domain.OperationTaskDelete}, injector.operations)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailTheOperationWithInjectedFault(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	taskRepo, _ := NewTaskRepository(applog.NewLogger(), db)
+	injector := &fakeInjector{err: domain.ErrInjectedFault}
+	repo := NewFaultyTaskRepository(taskRepo, injector)
+
+	task, err := repo.Add(context.Background(), &domain.Task{Title: "test"})
+
+	assert.Nil(t, task)
+	assert.ErrorIs(t, err, domain.ErrInjectedFault)
+	assert.Equal(t, []string{domain.OperationTaskAdd}, injector.operations)
+	// the database is never reached
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+const (
+	insertHealingAudit  = "INSERT INTO HEALING_AUDIT (NODE_ID, ADDRESS, ACTION, REASON, CREATED_DATE) VALUES(?,?,?,?,?)"
+	findAllHealingAudit = "SELECT ID, NODE_ID, ADDRESS, ACTION, REASON, CREATED_DATE FROM HEALING_AUDIT ORDER BY ID"
+)
+
+type HealingAuditRepositoryImpl struct {
+	db  *sql.DB
+	log *applog.Logger
+}
+
+func NewHealingAuditRepository(applog *applog.Logger, db *sql.DB) *HealingAuditRepositoryImpl {
+	return &HealingAuditRepositoryImpl{
+		db:  db,
+		log: applog,
+	}
+}
+
+func (h *HealingAuditRepositoryImpl) Add(audit *domain.HealingAudit) (*domain.HealingAudit, error) {
+	result, err := h.db.Exec(insertHealingAudit, strconv.FormatUint(audit.NodeId, 10), audit.Address, audit.Action,
+		audit.Reason, audit.CreatedDate)
+	if err != nil {
+		return nil, err
+	}
+
+	id, _ := result.LastInsertId()
+	h.log.Log.Info("healing decision recorded", zap.String("action", audit.Action), zap.String("address", audit.Address))
+	returnAudit := *audit
+	returnAudit.Id = id
+	return &returnAudit, nil
+}
+
+func (h *HealingAuditRepositoryImpl) FindAll() (*[]domain.HealingAudit, error) {
+	audits := make([]domain.HealingAudit, 0)
+
+	rows, err := h.db.Query(findAllHealingAudit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var audit domain.HealingAudit
+		var nodeId string
+		if err := rows.Scan(&audit.Id, &nodeId, &audit.Address, &audit.Action, &audit.Reason, &audit.CreatedDate); err != nil {
+			return nil, err
+		}
+		audit.NodeId, _ = strconv.ParseUint(nodeId, 10, 64)
+		audits = append(audits, audit)
+	}
+	return &audits, rows.Err()
+}
@@ -0,0 +1,77 @@
+package repository
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuccessfulAuditInsert(t *testing.T) {
+	assert := assert.New(t)
+	applog := applog.NewLogger()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	audit := &domain.HealingAudit{
+		NodeId:      11590821130369819000,
+		Address:     "norse:9001",
+		Action:      domain.HealingDead,
+		Reason:      "unreachable",
+		CreatedDate: "20211026",
+	}
+	mock.ExpectExec("INSERT INTO HEALING_AUDIT").
+		WithArgs("11590821130369819000", "norse:9001", domain.HealingDead, "unreachable", "20211026").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	repo := NewHealingAuditRepository(applog, db)
+	inserted, err := repo.Add(audit)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+	assert.Nil(err)
+	assert.Equal(int64(1), inserted.Id)
+}
+
+func TestFailAuditInsert(t *testing.T) {
+	applog := applog.NewLogger()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectExec("INSERT INTO HEALING_AUDIT").WillReturnError(fmt.Errorf("database error"))
+
+	repo := NewHealingAuditRepository(applog, db)
+	_, addErr := repo.Add(&domain.HealingAudit{Action: domain.HealingRemove})
+
+	assert.NotNil(t, addErr)
+}
+
+func TestSuccessfulAuditFindAll(t *testing.T) {
+	assert := assert.New(t)
+	applog := applog.NewLogger()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	columns := []string{"id", "node_id", "address", "action", "reason", "created_date"}
+	rows := sqlmock.NewRows(columns).
+		AddRow(int64(1), "11590821130369819000", "norse:9001", domain.HealingUnreachable, "timeout", "20211026").
+		AddRow(int64(2), "11590821130369819000", "norse:9001", domain.HealingDead, "timeout", "20211026")
+	mock.ExpectQuery("SELECT ID, NODE_ID, ADDRESS, ACTION, REASON, CREATED_DATE FROM HEALING_AUDIT").WillReturnRows(rows)
+
+	repo := NewHealingAuditRepository(applog, db)
+	audits, err := repo.FindAll()
+
+	assert.Nil(err)
+	assert.Equal(2, len(*audits))
+	assert.Equal(uint64(11590821130369819000), (*audits)[0].NodeId)
+}
@@ -0,0 +1,97 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+const (
+	findIdempotencyKey     = "SELECT FINGERPRINT, STATUS, CONTENT_TYPE, BODY, EXPIRES FROM IDEMPOTENCY_KEYS WHERE IDEMPOTENCY_KEY = ?"
+	insertIdempotencyKey   = "INSERT INTO IDEMPOTENCY_KEYS (IDEMPOTENCY_KEY, FINGERPRINT, STATUS, CONTENT_TYPE, EXPIRES) VALUES(?,?,0,'',?)"
+	takeOverIdempotencyKey = "UPDATE IDEMPOTENCY_KEYS SET EXPIRES = ? WHERE IDEMPOTENCY_KEY = ? AND STATUS = 0"
+	deleteIdempotencyKey   = "DELETE FROM IDEMPOTENCY_KEYS WHERE IDEMPOTENCY_KEY = ?"
+	completeIdempotencyKey = "UPDATE IDEMPOTENCY_KEYS SET STATUS = ?, CONTENT_TYPE = ?, BODY = ?, EXPIRES = ? WHERE IDEMPOTENCY_KEY = ? AND STATUS = 0"
+	releaseIdempotencyKey  = "UPDATE IDEMPOTENCY_KEYS SET EXPIRES = ? WHERE IDEMPOTENCY_KEY = ? AND STATUS = 0"
+	deleteExpiredKeys      = "DELETE FROM IDEMPOTENCY_KEYS WHERE (STATUS <> 0 AND EXPIRES <= ?) OR (STATUS = 0 AND EXPIRES <= ?)"
+)
+
+type IdempotencyRepositoryImpl struct {
+	db  *sql.DB
+	log *applog.Logger
+}
+
+func NewIdempotencyRepository(applog *applog.Logger, db *sql.DB) *IdempotencyRepositoryImpl {
+	return &IdempotencyRepositoryImpl{
+		db:  db,
+		log: applog,
+	}
+}
+
+// Reserve takes the key when it is free, expired, or abandoned by an earlier attempt of the same request.
+// A taken over reservation keeps the task the abandoned attempt wrote.
+func (i *IdempotencyRepositoryImpl) Reserve(ctx context.Context, record *domain.IdempotencyRecord, now time.Time) (*domain.IdempotencyRecord, error) {
+	tx, err := i.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer tx.Rollback()
+
+	existing := &domain.IdempotencyRecord{Key: record.Key}
+	var body []byte
+	var expires int64
+	err = tx.QueryRowContext(ctx, findIdempotencyKey, record.Key).
+		Scan(&existing.Fingerprint, &existing.Status, &existing.ContentType, &body, &expires)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		_, err = tx.ExecContext(ctx, insertIdempotencyKey, record.Key, record.Fingerprint, record.Expires.Unix())
+	case err != nil:
+		return nil, queryError(ctx, err)
+	case expires > now.Unix():
+		existing.Body = body
+		existing.Expires = time.Unix(expires, 0)
+		return existing, nil
+	case !existing.InProgress():
+		if _, err = tx.ExecContext(ctx, deleteIdempotencyKey, record.Key); err == nil {
+			_, err = tx.ExecContext(ctx, insertIdempotencyKey, record.Key, record.Fingerprint, record.Expires.Unix())
+		}
+	case existing.Fingerprint == record.Fingerprint:
+		_, err = tx.ExecContext(ctx, takeOverIdempotencyKey, record.Expires.Unix(), record.Key)
+	default:
+		// abandoned by another request, which may have written already
+		existing.Expires = time.Unix(expires, 0)
+		return existing, nil
+	}
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	i.log.Log.Info("idempotency key reserved", zap.String("key", record.Key))
+	return nil, nil
+}
+
+func (i *IdempotencyRepositoryImpl) Complete(ctx context.Context, record *domain.IdempotencyRecord) error {
+	_, err := i.db.ExecContext(ctx, completeIdempotencyKey, record.Status, record.ContentType, record.Body,
+		record.Expires.Unix(), record.Key)
+	return queryError(ctx, err)
+}
+
+func (i *IdempotencyRepositoryImpl) Release(ctx context.Context, key string, now time.Time) error {
+	_, err := i.db.ExecContext(ctx, releaseIdempotencyKey, now.Unix(), key)
+	return queryError(ctx, err)
+}
+
+func (i *IdempotencyRepositoryImpl) DeleteExpired(ctx context.Context, now time.Time, abandoned time.Time) (int64, error) {
+	result, err := i.db.ExecContext(ctx, deleteExpiredKeys, now.Unix(), abandoned.Unix())
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+	return result.RowsAffected()
+}
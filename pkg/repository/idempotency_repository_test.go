@@ -0,0 +1,177 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"testing"
+	"time"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+var idempotencyColumns = []string{"FINGERPRINT", "STATUS", "CONTENT_TYPE", "BODY", "EXPIRES"}
+
+func newRecord(now time.Time) *domain.IdempotencyRecord {
+	return &domain.IdempotencyRecord{
+		Key:         "k1",
+		Fingerprint: "POST /api/v1/task 1234",
+		Expires:     now.Add(time.Minute),
+	}
+}
+
+func TestMustReserveFreeKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	now := time.Unix(1000, 0)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT FINGERPRINT, STATUS, CONTENT_TYPE, BODY, EXPIRES FROM IDEMPOTENCY_KEYS").
+		WithArgs("k1").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectExec("INSERT INTO IDEMPOTENCY_KEYS").
+		WithArgs("k1", "POST /api/v1/task 1234", int64(1060)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	repo := NewIdempotencyRepository(applog.NewLogger(), db)
+
+	existing, err := repo.Reserve(context.Background(), newRecord(now), now)
+
+	assert.Nil(t, err)
+	assert.Nil(t, existing)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustReturnTheRecordedResponse(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	now := time.Unix(1000, 0)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT FINGERPRINT, STATUS, CONTENT_TYPE, BODY, EXPIRES FROM IDEMPOTENCY_KEYS").
+		WithArgs("k1").
+		WillReturnRows(sqlmock.NewRows(idempotencyColumns).
+			AddRow("POST /api/v1/task 1234", 200, "application/json", []byte(`{"id":12}`), 2000))
+	mock.ExpectRollback()
+	repo := NewIdempotencyRepository(applog.NewLogger(), db)
+
+	existing, err := repo.Reserve(context.Background(), newRecord(now), now)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 200, existing.Status)
+	assert.Equal(t, []byte(`{"id":12}`), existing.Body)
+	assert.Equal(t, time.Unix(2000, 0), existing.Expires)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustReuseExpiredKey(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	now := time.Unix(1000, 0)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT FINGERPRINT, STATUS, CONTENT_TYPE, BODY, EXPIRES FROM IDEMPOTENCY_KEYS").
+		WithArgs("k1").
+		WillReturnRows(sqlmock.NewRows(idempotencyColumns).
+			AddRow("DELETE /api/v1/task/3 1234", 200, "application/json", []byte(`"task 3 is deleted"`), 900))
+	mock.ExpectExec("DELETE FROM IDEMPOTENCY_KEYS").WithArgs("k1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO IDEMPOTENCY_KEYS").
+		WithArgs("k1", "POST /api/v1/task 1234", int64(1060)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	repo := NewIdempotencyRepository(applog.NewLogger(), db)
+
+	existing, err := repo.Reserve(context.Background(), newRecord(now), now)
+
+	assert.Nil(t, err)
+	assert.Nil(t, existing)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustTakeOverAbandonedReservation(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	now := time.Unix(1000, 0)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT FINGERPRINT, STATUS, CONTENT_TYPE, BODY, EXPIRES FROM IDEMPOTENCY_KEYS").
+		WithArgs("k1").
+		WillReturnRows(sqlmock.NewRows(idempotencyColumns).AddRow("POST /api/v1/task 1234", 0, "", nil, 990))
+	// the task the abandoned attempt wrote is kept
+	mock.ExpectExec("UPDATE IDEMPOTENCY_KEYS SET EXPIRES = ?").
+		WithArgs(int64(1060), "k1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	repo := NewIdempotencyRepository(applog.NewLogger(), db)
+
+	existing, err := repo.Reserve(context.Background(), newRecord(now), now)
+
+	assert.Nil(t, err)
+	assert.Nil(t, existing)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustNotTakeOverReservationOfAnotherRequest(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	now := time.Unix(1000, 0)
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT FINGERPRINT, STATUS, CONTENT_TYPE, BODY, EXPIRES FROM IDEMPOTENCY_KEYS").
+		WithArgs("k1").
+		WillReturnRows(sqlmock.NewRows(idempotencyColumns).AddRow("DELETE /api/v1/task/3 1234", 0, "", nil, 990))
+	mock.ExpectRollback()
+	repo := NewIdempotencyRepository(applog.NewLogger(), db)
+
+	existing, err := repo.Reserve(context.Background(), newRecord(now), now)
+
+	assert.Nil(t, err)
+	assert.True(t, existing.InProgress())
+	assert.Equal(t, "DELETE /api/v1/task/3 1234", existing.Fingerprint)
+}
+
+func TestMustCompleteAndDeleteExpiredKeys(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectExec("UPDATE IDEMPOTENCY_KEYS SET STATUS = ?").
+		WithArgs(200, "application/json", []byte(`{"id":12}`), int64(2000), "k1").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("DELETE FROM IDEMPOTENCY_KEYS WHERE").
+		WithArgs(int64(1000), int64(500)).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	repo := NewIdempotencyRepository(applog.NewLogger(), db)
+
+	completeErr := repo.Complete(context.Background(), &domain.IdempotencyRecord{Key: "k1", Status: 200,
+		ContentType: "application/json", Body: []byte(`{"id":12}`), Expires: time.Unix(2000, 0)})
+	deleted, deleteErr := repo.DeleteExpired(context.Background(), time.Unix(1000, 0), time.Unix(500, 0))
+
+	assert.Nil(t, completeErr)
+	assert.Nil(t, deleteErr)
+	assert.Equal(t, int64(3), deleted)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,74 @@
+package repository
+
+import (
+	"database/sql"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+const (
+	registerNode  = "INSERT OR REPLACE INTO NODES (ADDRESS, API_URL, VERSION, START_TIME, HOSTNAME, FAILURE_DOMAIN, HEARTBEAT) VALUES(?,?,?,?,?,?,?)"
+	heartbeatNode = "UPDATE NODES SET HEARTBEAT=? WHERE ADDRESS=?"
+	findAllNodes  = "SELECT N.ADDRESS, N.API_URL, N.VERSION, N.START_TIME, N.HOSTNAME, N.FAILURE_DOMAIN, N.HEARTBEAT, " +
+		"COALESCE(S.SCHEMA_VERSION, 0) FROM NODES N LEFT JOIN SCHEMA_SUPPORT S ON S.ADDRESS = N.ADDRESS ORDER BY N.ADDRESS"
+)
+
+type NodeRegistryRepositoryImpl struct {
+	db  *sql.DB
+	log *applog.Logger
+}
+
+func NewNodeRegistryRepository(applog *applog.Logger, db *sql.DB) *NodeRegistryRepositoryImpl {
+	return &NodeRegistryRepositoryImpl{
+		db:  db,
+		log: applog,
+	}
+}
+
+func (n *NodeRegistryRepositoryImpl) Register(node *domain.NodeMetadata) error {
+	_, err := n.db.Exec(registerNode, node.Address, node.ApiUrl, node.Version, node.StartTime, node.Hostname,
+		node.FailureDomain, node.Heartbeat)
+	if err != nil {
+		return err
+	}
+	n.log.Log.Info("node registered", zap.String("address", node.Address), zap.String("apiUrl", node.ApiUrl),
+		zap.String("version", node.Version))
+	return nil
+}
+
+// Heartbeat refreshes the heartbeat of a node, it returns a not found error when the node is not registered.
+func (n *NodeRegistryRepositoryImpl) Heartbeat(address string, heartbeat string) error {
+	result, err := n.db.Exec(heartbeatNode, heartbeat, address)
+	if err != nil {
+		return err
+	}
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return domain.NewError(domain.ErrNotFound, domain.CodeNodeNotFound, "node "+address+" is not registered", nil)
+	}
+	return nil
+}
+
+func (n *NodeRegistryRepositoryImpl) FindAll() (*[]domain.NodeMetadata, error) {
+	nodes := make([]domain.NodeMetadata, 0)
+
+	rows, err := n.db.Query(findAllNodes)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var node domain.NodeMetadata
+		if err := rows.Scan(&node.Address, &node.ApiUrl, &node.Version, &node.StartTime, &node.Hostname,
+			&node.FailureDomain, &node.Heartbeat, &node.SchemaVersion); err != nil {
+			return nil, err
+		}
+		nodes = append(nodes, node)
+	}
+	return &nodes, rows.Err()
+}
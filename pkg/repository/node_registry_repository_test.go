@@ -0,0 +1,107 @@
+package repository
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSuccessfulNodeRegister(t *testing.T) {
+	applog := applog.NewLogger()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	node := &domain.NodeMetadata{
+		Address:       "norse:9000",
+		ApiUrl:        "http://norse:8000",
+		Version:       "v0.2.0",
+		StartTime:     "Tue, 26 Oct 2021 10:00:00 UTC",
+		Hostname:      "norse",
+		FailureDomain: "zone-a",
+		Heartbeat:     "Tue, 26 Oct 2021 10:00:00 UTC",
+	}
+	mock.ExpectExec("INSERT OR REPLACE INTO NODES").
+		WithArgs(node.Address, node.ApiUrl, node.Version, node.StartTime, node.Hostname, node.FailureDomain, node.Heartbeat).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+
+	repo := NewNodeRegistryRepository(applog, db)
+
+	assert.Nil(t, repo.Register(node))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailNodeRegister(t *testing.T) {
+	applog := applog.NewLogger()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectExec("INSERT OR REPLACE INTO NODES").WillReturnError(fmt.Errorf("database error"))
+
+	repo := NewNodeRegistryRepository(applog, db)
+
+	assert.NotNil(t, repo.Register(&domain.NodeMetadata{Address: "norse:9000"}))
+}
+
+func TestSuccessfulNodeHeartbeat(t *testing.T) {
+	applog := applog.NewLogger()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectExec("UPDATE NODES SET HEARTBEAT").
+		WithArgs("Tue, 26 Oct 2021 10:00:10 UTC", "norse:9000").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+
+	repo := NewNodeRegistryRepository(applog, db)
+
+	assert.Nil(t, repo.Heartbeat("norse:9000", "Tue, 26 Oct 2021 10:00:10 UTC"))
+}
+
+func TestFailHeartbeatOfUnregisteredNode(t *testing.T) {
+	applog := applog.NewLogger()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectExec("UPDATE NODES SET HEARTBEAT").WillReturnResult(sqlmock.NewResult(0, 0))
+
+	repo := NewNodeRegistryRepository(applog, db)
+
+	assert.True(t, errors.Is(repo.Heartbeat("norse:9000", "Tue, 26 Oct 2021 10:00:10 UTC"), domain.ErrNotFound))
+}
+
+func TestSuccessfulNodeFindAll(t *testing.T) {
+	assert := assert.New(t)
+	applog := applog.NewLogger()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	columns := []string{"address", "api_url", "version", "start_time", "hostname", "failure_domain", "heartbeat", "schema_version"}
+	rows := sqlmock.NewRows(columns).
+		AddRow("norse:9000", "http://norse:8000", "v0.2.0", "Tue, 26 Oct 2021 10:00:00 UTC", "norse", "zone-a", "Tue, 26 Oct 2021 10:00:10 UTC", 2).
+		AddRow("odin:9000", "http://odin:8000", "v0.2.0", "Tue, 26 Oct 2021 10:00:00 UTC", "odin", "zone-b", "Tue, 26 Oct 2021 10:00:10 UTC", 1)
+	mock.ExpectQuery("SELECT (.+) FROM NODES N LEFT JOIN SCHEMA_SUPPORT").WillReturnRows(rows)
+
+	repo := NewNodeRegistryRepository(applog, db)
+	nodes, err := repo.FindAll()
+
+	assert.Nil(err)
+	assert.Equal(2, len(*nodes))
+	assert.Equal("http://odin:8000", (*nodes)[1].ApiUrl)
+	assert.Equal(2, (*nodes)[0].SchemaVersion)
+}
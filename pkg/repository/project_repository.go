@@ -0,0 +1,250 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+const (
+	legacyProjectColumns   = "ID, PROJECT_KEY, NAME, CREATED_AT"
+	archivedProjectColumns = legacyProjectColumns + ", ARCHIVED_AT"
+
+	insertProject       = "INSERT INTO PROJECTS (PROJECT_KEY, NAME, NEXT_NUMBER, CREATED_AT) VALUES (?, ?, 1, ?)"
+	insertTenantProject = "INSERT INTO PROJECTS (PROJECT_KEY, NAME, NEXT_NUMBER, CREATED_AT, TENANT_ID) VALUES (?, ?, 1, ?, ?)"
+	// the archiving date of a project which stays archived is kept
+	updateProject       = "UPDATE PROJECTS SET NAME=?, ARCHIVED_AT=CASE WHEN ? THEN COALESCE(ARCHIVED_AT, ?) ELSE NULL END WHERE ID=?"
+	legacyUpdateProject = "UPDATE PROJECTS SET NAME=? WHERE ID=?"
+	deleteProject       = "DELETE FROM PROJECTS WHERE ID = ?"
+	countProjectTasks   = "SELECT COUNT(*) FROM TASKS WHERE PROJECT_ID = ?"
+	deleteProjectTasks  = "DELETE FROM TASKS WHERE PROJECT_ID = ?"
+	orderByProjectKey   = " ORDER BY PROJECT_KEY"
+)
+
+type ProjectRepositoryImpl struct {
+	db      *sql.DB
+	log     *applog.Logger
+	cluster clusterSchema
+}
+
+func NewProjectRepository(applog *applog.Logger, db *sql.DB) *ProjectRepositoryImpl {
+	return &ProjectRepositoryImpl{
+		db:  db,
+		log: applog,
+	}
+}
+
+// schema reads the schema version of the cluster with db, the one of the transaction writing the project.
+// The projects of the other tenants than the one of ctx are out of its reach.
+func (p *ProjectRepositoryImpl) schema(ctx context.Context, db querier) (taskSchema, error) {
+	return readSchema(ctx, &p.cluster, db)
+}
+
+// Add stores the project of the tenant, a key the tenant already took is a conflict.
+func (p *ProjectRepositoryImpl) Add(ctx context.Context, project *domain.Project) (*domain.Project, error) {
+	schema, err := p.schema(ctx, p.db)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if !schema.projects() {
+		return nil, notMigrated("projects can be created", projectsSchemaVersion)
+	}
+	stored := *project
+	stored.Archived = false
+	stored.ArchivedAt = nil
+	stored.CreatedAt = fromMillis(millis(project.CreatedAt))
+	query, args := insertProject, []interface{}{stored.Key, stored.Name, millis(stored.CreatedAt)}
+	if schema.tenants() {
+		query, args = insertTenantProject, append(args, schema.tenant)
+	}
+	res, err := p.db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if stored.Id, err = res.LastInsertId(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	p.log.Log.Info("project inserted", zap.Int64("id", stored.Id), zap.String("key", stored.Key))
+	return &stored, nil
+}
+
+// FindById returns the project, there are none before the schema is migrated.
+func (p *ProjectRepositoryImpl) FindById(ctx context.Context, id int64) (*domain.Project, error) {
+	schema, err := p.schema(ctx, p.db)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	project, err := findProjectById(ctx, p.db, schema, id)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return project, nil
+}
+
+// FindAll returns the projects of the tenant ordered by key, there are none before the schema is migrated.
+func (p *ProjectRepositoryImpl) FindAll(ctx context.Context) (*[]domain.Project, error) {
+	projects := []domain.Project{}
+	schema, err := p.schema(ctx, p.db)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if !schema.projects() {
+		return &projects, nil
+	}
+	query, args := selectProjects(schema), []interface{}{}
+	if schema.tenants() {
+		query, args = query+" WHERE "+tenantColumn+" = ?", append(args, schema.tenant)
+	}
+	rows, err := p.db.QueryContext(ctx, query+orderByProjectKey, args...)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		project, err := scanProject(rows, schema)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		projects = append(projects, *project)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return &projects, nil
+}
+
+// Update reads the project back in the same transaction, its key and creation date are the stored ones.
+func (p *ProjectRepositoryImpl) Update(ctx context.Context, project *domain.Project) (*domain.Project, error) {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer tx.Rollback()
+	schema, err := p.schema(ctx, tx)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	var result sql.Result
+	switch {
+	case !schema.projects():
+		return nil, projectNotFound(project.Id, nil)
+	case schema.archiving():
+		query, args := schema.scope(updateProject, project.Name, project.Archived, nullMillis(project.ArchivedAt), project.Id)
+		result, err = tx.ExecContext(ctx, query, args...)
+	case project.Archived:
+		return nil, notMigrated("projects can be archived", archivingSchemaVersion)
+	default:
+		result, err = tx.ExecContext(ctx, legacyUpdateProject, project.Name, project.Id)
+	}
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if rowsAffected == 0 {
+		return nil, projectNotFound(project.Id, nil)
+	}
+	stored, err := findProjectById(ctx, tx, schema, project.Id)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	p.log.Log.Info("project updated", zap.Int64("id", stored.Id), zap.Bool("archived", stored.Archived))
+	return stored, nil
+}
+
+// Delete removes the project, and its tasks in the same transaction when cascade is set. The project of another
+// tenant is not found, its tasks are not even counted.
+func (p *ProjectRepositoryImpl) Delete(ctx context.Context, id int64, cascade bool) error {
+	tx, err := p.db.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer tx.Rollback()
+	schema, err := p.schema(ctx, tx)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if _, err = findProjectById(ctx, tx, schema, id); err != nil {
+		return queryError(ctx, err)
+	}
+	var tasks int64
+	if err = tx.QueryRowContext(ctx, countProjectTasks, id).Scan(&tasks); err != nil {
+		return queryError(ctx, err)
+	}
+	if tasks > 0 && !cascade {
+		return domain.NewError(domain.ErrConflict, domain.CodeProjectNotEmpty,
+			"project "+strconv.FormatInt(id, 10)+" has "+strconv.FormatInt(tasks, 10)+" tasks", nil)
+	}
+	if tasks > 0 {
+		if _, err = tx.ExecContext(ctx, deleteProjectTasks, id); err != nil {
+			return queryError(ctx, err)
+		}
+	}
+	query, args := schema.scope(deleteProject, id)
+	result, err := tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if rowsAffected == 0 {
+		return projectNotFound(id, nil)
+	}
+	if err = tx.Commit(); err != nil {
+		return queryError(ctx, err)
+	}
+	p.log.Log.Info("project deleted", zap.Int64("id", id), zap.Int64("tasks", tasks))
+	return nil
+}
+
+func selectProjects(schema taskSchema) string {
+	if schema.archiving() {
+		return "SELECT " + archivedProjectColumns + " FROM PROJECTS"
+	}
+	return "SELECT " + legacyProjectColumns + " FROM PROJECTS"
+}
+
+// findProjectById answers a missing project, or a project of another tenant, with a not found error.
+func findProjectById(ctx context.Context, db querier, schema taskSchema, id int64) (*domain.Project, error) {
+	if !schema.projects() {
+		return nil, projectNotFound(id, nil)
+	}
+	query, args := schema.scope(selectProjects(schema)+" WHERE ID = ?", id)
+	project, err := scanProject(db.QueryRowContext(ctx, query, args...), schema)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, projectNotFound(id, err)
+	}
+	return project, err
+}
+
+func scanProject(row scanner, schema taskSchema) (*domain.Project, error) {
+	var project domain.Project
+	var createdAt int64
+	var archivedAt sql.NullInt64
+	dest := []interface{}{&project.Id, &project.Key, &project.Name, &createdAt}
+	if schema.archiving() {
+		dest = append(dest, &archivedAt)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	project.CreatedAt = fromMillis(createdAt)
+	if archivedAt.Valid {
+		archived := fromMillis(archivedAt.Int64)
+		project.Archived = true
+		project.ArchivedAt = &archived
+	}
+	return &project, nil
+}
@@ -0,0 +1,280 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	dqlite "github.com/canonical/go-dqlite/driver"
+	"github.com/stretchr/testify/assert"
+)
+
+var projectRows = []string{"ID", "PROJECT_KEY", "NAME", "CREATED_AT", "ARCHIVED_AT"}
+
+const selectProjectRows = "SELECT ID, PROJECT_KEY, NAME, CREATED_AT, ARCHIVED_AT FROM PROJECTS"
+
+// expectProject answers the project of the default tenant with the id.
+func expectProject(mock sqlmock.Sqlmock, id int64) {
+	mock.ExpectQuery(regexp.QuoteMeta(selectProjectRows+" WHERE ID = ? AND TENANT_ID = ?")).
+		WithArgs(id, domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(projectRows).AddRow(id, "OPS", "Operations", millis(created), nil))
+}
+
+func TestSuccessfulProjectInsert(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 7)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO PROJECTS (PROJECT_KEY, NAME, NEXT_NUMBER, CREATED_AT, TENANT_ID) VALUES (?, ?, 1, ?, ?)")).
+		WithArgs("OPS", "Operations", millis(created), domain.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(3, 1))
+	mock.ExpectExec("INSERT INTO PROJECTS").
+		WithArgs("OPS", "Operations", millis(created), domain.DefaultTenant).
+		WillReturnError(dqlite.Error{Code: 2067, Message: "UNIQUE constraint failed: PROJECTS.PROJECT_KEY"})
+	repo := NewProjectRepository(applog.NewLogger(), db)
+	project := &domain.Project{Key: "OPS", Name: "Operations", CreatedAt: created}
+
+	inserted, addErr := repo.Add(context.Background(), project)
+	_, conflictErr := repo.Add(context.Background(), project)
+
+	assert.Nil(addErr)
+	assert.Equal(int64(3), inserted.Id)
+	assert.Equal(created, inserted.CreatedAt)
+	assert.True(errors.Is(conflictErr, domain.ErrConflict))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailProjectInsertBeforeTheMigration(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 3)
+	expectSchema(mock, 3)
+	expectSchema(mock, 3)
+	repo := NewProjectRepository(applog.NewLogger(), db)
+
+	_, addErr := repo.Add(context.Background(), &domain.Project{Key: "OPS", Name: "Operations", CreatedAt: created})
+	projects, findAllErr := repo.FindAll(context.Background())
+	_, findErr := repo.FindById(context.Background(), 1)
+
+	assert.Equal(domain.CodeSchemaNotMigrated, addErr.(*domain.Error).Code)
+	assert.Nil(findAllErr)
+	assert.Empty(*projects)
+	assert.True(errors.Is(findErr, domain.ErrNotFound))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSuccessfulProjectFindAll(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 7)
+	mock.ExpectQuery(regexp.QuoteMeta(selectProjectRows + " WHERE TENANT_ID = ? ORDER BY PROJECT_KEY")).
+		WithArgs(domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(projectRows).
+			AddRow(int64(4), "DEV", "Development", millis(updated), millis(updated)).
+			AddRow(int64(3), "OPS", "Operations", millis(created), nil))
+	repo := NewProjectRepository(applog.NewLogger(), db)
+
+	projects, findAllErr := repo.FindAll(context.Background())
+
+	assert.Nil(findAllErr)
+	assert.Equal([]domain.Project{
+		{Id: 4, Key: "DEV", Name: "Development", Archived: true, CreatedAt: updated, ArchivedAt: &updated},
+		{Id: 3, Key: "OPS", Name: "Operations", CreatedAt: created},
+	}, *projects)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSuccessfulProjectUpdate(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE PROJECTS SET NAME=?, ARCHIVED_AT=CASE WHEN ? THEN COALESCE(ARCHIVED_AT, ?) ELSE NULL END WHERE ID=? AND TENANT_ID = ?")).
+		WithArgs("Operations", true, millis(updated), int64(3), domain.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	// the project was already archived
+	mock.ExpectQuery(regexp.QuoteMeta(selectProjectRows+" WHERE ID = ? AND TENANT_ID = ?")).
+		WithArgs(int64(3), domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(projectRows).AddRow(int64(3), "OPS", "Operations", millis(created), millis(created)))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectExec("UPDATE PROJECTS").
+		WithArgs("Operations", false, nil, int64(4), domain.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	repo := NewProjectRepository(applog.NewLogger(), db)
+
+	project, updateErr := repo.Update(context.Background(), &domain.Project{Id: 3, Name: "Operations", Archived: true, ArchivedAt: &updated})
+	_, missingErr := repo.Update(context.Background(), &domain.Project{Id: 4, Name: "Operations"})
+
+	assert.Nil(updateErr)
+	assert.Equal("OPS", project.Key)
+	assert.Equal(created, *project.ArchivedAt)
+	assert.Equal(domain.CodeProjectNotFound, missingErr.(*domain.Error).Code)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailArchiveProjectBeforeTheMigration(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 4)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectSchema(mock, 4)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE PROJECTS SET NAME=? WHERE ID=?")).
+		WithArgs("Operations", int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT ID, PROJECT_KEY, NAME, CREATED_AT FROM PROJECTS WHERE ID = ?")).
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "PROJECT_KEY", "NAME", "CREATED_AT"}).AddRow(int64(3), "OPS", "Operations", millis(created)))
+	mock.ExpectCommit()
+	repo := NewProjectRepository(applog.NewLogger(), db)
+
+	_, archiveErr := repo.Update(context.Background(), &domain.Project{Id: 3, Name: "Operations", Archived: true, ArchivedAt: &updated})
+	project, renameErr := repo.Update(context.Background(), &domain.Project{Id: 3, Name: "Operations"})
+
+	assert.Equal(domain.CodeSchemaNotMigrated, archiveErr.(*domain.Error).Code)
+	assert.Nil(renameErr)
+	assert.False(project.Archived)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailDeleteProjectWithTasks(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	expectProject(mock, 3)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM TASKS WHERE PROJECT_ID = ?")).
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(2))
+	mock.ExpectRollback()
+	repo := NewProjectRepository(applog.NewLogger(), db)
+
+	deleteErr := repo.Delete(context.Background(), 3, false)
+
+	assert.True(errors.Is(deleteErr, domain.ErrConflict))
+	assert.Equal(domain.CodeProjectNotEmpty, deleteErr.(*domain.Error).Code)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustDeleteProjectWithItsTasks(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	expectProject(mock, 3)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM TASKS WHERE PROJECT_ID = ?")).
+		WithArgs(int64(3)).
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(2))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM TASKS WHERE PROJECT_ID = ?")).
+		WithArgs(int64(3)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM PROJECTS WHERE ID = ? AND TENANT_ID = ?")).
+		WithArgs(int64(3), domain.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectProjectRows+" WHERE ID = ? AND TENANT_ID = ?")).
+		WithArgs(int64(4), domain.DefaultTenant).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	repo := NewProjectRepository(applog.NewLogger(), db)
+
+	deleteErr := repo.Delete(context.Background(), 3, true)
+	missingErr := repo.Delete(context.Background(), 4, true)
+
+	assert.Nil(deleteErr)
+	assert.True(errors.Is(missingErr, domain.ErrNotFound))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustKeepTheProjectsOfOtherTenantsOutOfReach(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 7)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO PROJECTS (PROJECT_KEY, NAME, NEXT_NUMBER, CREATED_AT, TENANT_ID) VALUES (?, ?, 1, ?, ?)")).
+		WithArgs("OPS", "Operations", millis(created), "acme").
+		WillReturnResult(sqlmock.NewResult(5, 1))
+	// project 3 belongs to another tenant, its tasks are not counted
+	mock.ExpectQuery(regexp.QuoteMeta(selectProjectRows+" WHERE ID = ? AND TENANT_ID = ?")).
+		WithArgs(int64(3), "acme").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(selectProjectRows+" WHERE ID = ? AND TENANT_ID = ?")).
+		WithArgs(int64(3), "acme").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE PROJECTS SET NAME=?, ARCHIVED_AT=CASE WHEN ? THEN COALESCE(ARCHIVED_AT, ?) ELSE NULL END WHERE ID=? AND TENANT_ID = ?")).
+		WithArgs("Operations", false, nil, int64(3), "acme").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	repo := NewProjectRepository(applog.NewLogger(), db)
+	ctx := domain.WithTenant(context.Background(), "acme")
+
+	project, addErr := repo.Add(ctx, &domain.Project{Key: "OPS", Name: "Operations", CreatedAt: created})
+	_, findErr := repo.FindById(ctx, 3)
+	deleteErr := repo.Delete(ctx, 3, true)
+	_, updateErr := repo.Update(ctx, &domain.Project{Id: 3, Name: "Operations"})
+
+	assert.Nil(addErr)
+	assert.Equal(int64(5), project.Id)
+	for _, err := range []error{findErr, deleteErr, updateErr} {
+		assert.Equal(domain.CodeProjectNotFound, err.(*domain.Error).Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
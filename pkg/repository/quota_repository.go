@@ -0,0 +1,115 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+const (
+	findQuota = "SELECT MAX_TASKS, MAX_DETAILS_SIZE, REQUESTS_PER_SECOND FROM TENANT_QUOTAS WHERE TENANT_ID = ?"
+	saveQuota = "INSERT INTO TENANT_QUOTAS (TENANT_ID, MAX_TASKS, MAX_DETAILS_SIZE, REQUESTS_PER_SECOND) VALUES (?, ?, ?, ?) " +
+		"ON CONFLICT (TENANT_ID) DO UPDATE SET MAX_TASKS = excluded.MAX_TASKS, MAX_DETAILS_SIZE = excluded.MAX_DETAILS_SIZE, " +
+		"REQUESTS_PER_SECOND = excluded.REQUESTS_PER_SECOND"
+	countRequests = "INSERT INTO RATE_LIMITS (TENANT_ID, EPOCH_SECOND, REQUESTS) VALUES (?, ?, ?) " +
+		"ON CONFLICT (TENANT_ID, EPOCH_SECOND) DO UPDATE SET REQUESTS = REQUESTS + excluded.REQUESTS"
+	findRequests = "SELECT REQUESTS FROM RATE_LIMITS WHERE TENANT_ID = ? AND EPOCH_SECOND = ?"
+	// the counters of the past seconds are of no use, every tenant deletes its own. The counters of the
+	// last 5 seconds are kept, the clock of the node deleting them may be ahead of the others.
+	deletePastRequests = "DELETE FROM RATE_LIMITS WHERE TENANT_ID = ? AND EPOCH_SECOND < ? - 5"
+)
+
+type QuotaRepositoryImpl struct {
+	db      *sql.DB
+	log     *applog.Logger
+	cluster clusterSchema
+}
+
+func NewQuotaRepository(applog *applog.Logger, db *sql.DB) *QuotaRepositoryImpl {
+	return &QuotaRepositoryImpl{
+		db:  db,
+		log: applog,
+	}
+}
+
+// FindByTenant returns the quota of the tenant, the tenants have no limit before the schema is migrated.
+func (q *QuotaRepositoryImpl) FindByTenant(ctx context.Context, tenant string) (*domain.Quota, error) {
+	quota := &domain.Quota{Tenant: tenant}
+	schema, err := readSchema(ctx, &q.cluster, q.db)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if !schema.quotas() {
+		return quota, nil
+	}
+	var maxTasks, maxDetailsSize, requestsPerSecond sql.NullInt64
+	err = q.db.QueryRowContext(ctx, findQuota, tenant).Scan(&maxTasks, &maxDetailsSize, &requestsPerSecond)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return quota, nil
+	case err != nil:
+		return nil, queryError(ctx, err)
+	}
+	quota.MaxTasks = maxTasks.Int64
+	quota.MaxDetailsSize = maxDetailsSize.Int64
+	quota.RequestsPerSecond = requestsPerSecond.Int64
+	return quota, nil
+}
+
+// Save replaces the quota of the tenant, its zero limits are stored as no limit.
+func (q *QuotaRepositoryImpl) Save(ctx context.Context, quota *domain.Quota) (*domain.Quota, error) {
+	schema, err := readSchema(ctx, &q.cluster, q.db)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if !schema.quotas() {
+		return nil, notMigrated("quotas can be set", quotasSchemaVersion)
+	}
+	_, err = q.db.ExecContext(ctx, saveQuota, quota.Tenant, nullLimit(quota.MaxTasks), nullLimit(quota.MaxDetailsSize),
+		nullLimit(quota.RequestsPerSecond))
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	q.log.Log.Info("quota saved", zap.String("tenant", quota.Tenant), zap.Int64("maxTasks", quota.MaxTasks),
+		zap.Int64("maxDetailsSize", quota.MaxDetailsSize), zap.Int64("requestsPerSecond", quota.RequestsPerSecond))
+	saved := *quota
+	return &saved, nil
+}
+
+// CountRequests adds the requests to the counter of the tenant in the second and reads it back in the same
+// transaction, the counters more than 5 seconds old are deleted with it.
+func (q *QuotaRepositoryImpl) CountRequests(ctx context.Context, tenant string, second int64, requests int64) (int64, error) {
+	tx, err := q.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+	defer tx.Rollback()
+	schema, err := readSchema(ctx, &q.cluster, tx)
+	if err != nil {
+		return 0, queryError(ctx, err)
+	}
+	if !schema.quotas() {
+		return 0, notMigrated("requests can be rate limited", quotasSchemaVersion)
+	}
+	if _, err = tx.ExecContext(ctx, countRequests, tenant, second, requests); err != nil {
+		return 0, queryError(ctx, err)
+	}
+	if err = tx.QueryRowContext(ctx, findRequests, tenant, second).Scan(&requests); err != nil {
+		return 0, queryError(ctx, err)
+	}
+	if _, err = tx.ExecContext(ctx, deletePastRequests, tenant, second); err != nil {
+		return 0, queryError(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return 0, queryError(ctx, err)
+	}
+	return requests, nil
+}
+
+func nullLimit(limit int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: limit, Valid: limit > 0}
+}
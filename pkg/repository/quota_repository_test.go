@@ -0,0 +1,105 @@
+package repository
+
+import (
+	"context"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+var quotaRows = []string{"MAX_TASKS", "MAX_DETAILS_SIZE", "REQUESTS_PER_SECOND"}
+
+func TestSuccessfulQuotaSave(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 7)
+	mock.ExpectExec(regexp.QuoteMeta(saveQuota)).
+		WithArgs("acme", int64(100), nil, int64(5)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(findQuota)).
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows(quotaRows).AddRow(100, nil, 5))
+	mock.ExpectQuery(regexp.QuoteMeta(findQuota)).
+		WithArgs("globex").
+		WillReturnRows(sqlmock.NewRows(quotaRows))
+	repo := NewQuotaRepository(applog.NewLogger(), db)
+
+	saved, saveErr := repo.Save(context.Background(), &domain.Quota{Tenant: "acme", MaxTasks: 100, RequestsPerSecond: 5})
+	quota, findErr := repo.FindByTenant(context.Background(), "acme")
+	unlimited, unlimitedErr := repo.FindByTenant(context.Background(), "globex")
+
+	assert.Nil(saveErr)
+	assert.Equal(int64(100), saved.MaxTasks)
+	assert.Nil(findErr)
+	assert.Equal(domain.Quota{Tenant: "acme", MaxTasks: 100, RequestsPerSecond: 5}, *quota)
+	assert.Nil(unlimitedErr)
+	assert.Equal(domain.Quota{Tenant: "globex"}, *unlimited)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustCountTheRequestsOfTheSecond(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	mock.ExpectExec(regexp.QuoteMeta(countRequests)).
+		WithArgs("acme", int64(1632650400), int64(2)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(findRequests)).
+		WithArgs("acme", int64(1632650400)).
+		WillReturnRows(sqlmock.NewRows([]string{"REQUESTS"}).AddRow(3))
+	mock.ExpectExec(regexp.QuoteMeta(deletePastRequests)).
+		WithArgs("acme", int64(1632650400)).
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectCommit()
+	repo := NewQuotaRepository(applog.NewLogger(), db)
+
+	requests, countErr := repo.CountRequests(context.Background(), "acme", created.Unix(), 2)
+
+	assert.Nil(countErr)
+	assert.Equal(int64(3), requests)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailQuotaBeforeTheMigration(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 6)
+	expectSchema(mock, 6)
+	mock.ExpectBegin()
+	expectSchema(mock, 6)
+	mock.ExpectRollback()
+	repo := NewQuotaRepository(applog.NewLogger(), db)
+
+	quota, findErr := repo.FindByTenant(context.Background(), "acme")
+	_, saveErr := repo.Save(context.Background(), &domain.Quota{Tenant: "acme", MaxTasks: 100})
+	_, countErr := repo.CountRequests(context.Background(), "acme", created.Unix(), 1)
+
+	assert.Nil(findErr)
+	assert.Equal(domain.Quota{Tenant: "acme"}, *quota)
+	assert.Equal(domain.CodeSchemaNotMigrated, saveErr.(*domain.Error).Code)
+	assert.Equal(domain.CodeSchemaNotMigrated, countErr.(*domain.Error).Code)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
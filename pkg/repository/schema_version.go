@@ -0,0 +1,27 @@
+package repository
+
+import (
+	"context"
+	"sync/atomic"
+)
+
+const findSchemaVersion = "SELECT VERSION FROM SCHEMA_VERSION WHERE ID = 1"
+
+// clusterSchema reads the schema version of the cluster until it reaches the latest version a repository
+// knows, a schema is never migrated back.
+type clusterSchema struct {
+	version int32
+}
+
+// read returns the schema version, db is the transaction writing with it if any.
+func (c *clusterSchema) read(ctx context.Context, db querier, latest int) (int, error) {
+	if version := int(atomic.LoadInt32(&c.version)); version >= latest {
+		return version, nil
+	}
+	var version int
+	if err := db.QueryRowContext(ctx, findSchemaVersion).Scan(&version); err != nil {
+		return 0, err
+	}
+	atomic.StoreInt32(&c.version, int32(version))
+	return version, nil
+}
@@ -0,0 +1,61 @@
+package repository
+
+import (
+	"database/sql"
+	"fmt"
+	"strconv"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/snowflake"
+	"go.uber.org/zap"
+)
+
+const (
+	findSnowflakeNode = "SELECT NODE FROM SNOWFLAKE_NODES WHERE NODE_ID = ?"
+	// takes the lowest free node number in one statement, dqlite applies the writes one at a time
+	allocateSnowflakeNode = "INSERT OR IGNORE INTO SNOWFLAKE_NODES (NODE, NODE_ID) SELECT NODE, ? FROM " +
+		"(SELECT MIN(N) AS NODE FROM (SELECT 0 AS N UNION ALL SELECT NODE + 1 FROM SNOWFLAKE_NODES) " +
+		"WHERE N <= ? AND N NOT IN (SELECT NODE FROM SNOWFLAKE_NODES)) WHERE NODE IS NOT NULL"
+)
+
+// SnowflakeNodeRepositoryImpl gives every dqlite node its own node number for the snowflake ids, the
+// random dqlite ids do not fit in the node bits of the ids.
+type SnowflakeNodeRepositoryImpl struct {
+	db  *sql.DB
+	log *applog.Logger
+}
+
+func NewSnowflakeNodeRepository(applog *applog.Logger, db *sql.DB) *SnowflakeNodeRepositoryImpl {
+	return &SnowflakeNodeRepositoryImpl{
+		db:  db,
+		log: applog,
+	}
+}
+
+// Allocate returns the node number of the dqlite node, taking the lowest free one the first time.
+// A number is kept for the life of the cluster, it fails once every number is taken.
+func (s *SnowflakeNodeRepositoryImpl) Allocate(nodeId uint64) (int64, error) {
+	id := strconv.FormatUint(nodeId, 10)
+	node, err := s.find(id)
+	if err != sql.ErrNoRows {
+		return node, err
+	}
+	if _, err := s.db.Exec(allocateSnowflakeNode, id, int64(1<<snowflake.NodeBits-1)); err != nil {
+		return 0, err
+	}
+	node, err = s.find(id)
+	if err == sql.ErrNoRows {
+		return 0, fmt.Errorf("every one of the %d snowflake node numbers is taken", 1<<snowflake.NodeBits)
+	}
+	if err != nil {
+		return 0, err
+	}
+	s.log.Log.Info("snowflake node number allocated", zap.String("nodeId", id), zap.Int64("node", node))
+	return node, nil
+}
+
+func (s *SnowflakeNodeRepositoryImpl) find(id string) (int64, error) {
+	var node int64
+	err := s.db.QueryRow(findSnowflakeNode, id).Scan(&node)
+	return node, err
+}
@@ -0,0 +1,65 @@
+package repository
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMustKeepAllocatedSnowflakeNode(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectQuery(regexp.QuoteMeta(findSnowflakeNode)).WithArgs("3297041220608546238").
+		WillReturnRows(sqlmock.NewRows([]string{"NODE"}).AddRow(4))
+
+	node, err := NewSnowflakeNodeRepository(applog.NewLogger(), db).Allocate(3297041220608546238)
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(4), node)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestMustAllocateSnowflakeNodeOnce(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectQuery(regexp.QuoteMeta(findSnowflakeNode)).WithArgs("12").
+		WillReturnRows(sqlmock.NewRows([]string{"NODE"}))
+	mock.ExpectExec(regexp.QuoteMeta(allocateSnowflakeNode)).WithArgs("12", int64(1023)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(regexp.QuoteMeta(findSnowflakeNode)).WithArgs("12").
+		WillReturnRows(sqlmock.NewRows([]string{"NODE"}).AddRow(1))
+
+	node, err := NewSnowflakeNodeRepository(applog.NewLogger(), db).Allocate(12)
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), node)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
+
+func TestFailWhenEverySnowflakeNodeIsTaken(t *testing.T) {
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectQuery(regexp.QuoteMeta(findSnowflakeNode)).WithArgs("12").
+		WillReturnRows(sqlmock.NewRows([]string{"NODE"}))
+	mock.ExpectExec(regexp.QuoteMeta(allocateSnowflakeNode)).WithArgs("12", int64(1023)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectQuery(regexp.QuoteMeta(findSnowflakeNode)).WithArgs("12").
+		WillReturnRows(sqlmock.NewRows([]string{"NODE"}))
+
+	_, err = NewSnowflakeNodeRepository(applog.NewLogger(), db).Allocate(12)
+
+	assert.NotNil(t, err)
+	assert.Nil(t, mock.ExpectationsWereMet())
+}
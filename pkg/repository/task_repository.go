@@ -1,8 +1,9 @@
 package repository
 
 import (
+	"context"
 	"database/sql"
-	"time"
+	"errors"
 
 	"github.com/balchua/bopbag/pkg/applog"
 	"github.com/balchua/bopbag/pkg/domain"
@@ -10,16 +11,16 @@ import (
 )
 
 const (
-	insert   = "INSERT INTO TASKS (TITLE, DETAILS, CREATED_DATE) VALUES(?,?,?)"
-	delete   = "DELETE FROM TASKS WHERE ID = ?"
-	findById = "SELECT ID, TITLE, DETAILS, CREATED_DATE FROM TASKS WHERE ID = ?"
-	findAll  = "SELECT ID, TITLE, DETAILS, CREATED_DATE FROM TASKS"
-	update   = "UPDATE TASKS SET TITLE=?, DETAILS=? WHERE ID=?"
+	deleteTask = "DELETE FROM TASKS WHERE ID = ?"
+
+	findAppliedTask = "SELECT TASK_ID FROM IDEMPOTENCY_KEYS WHERE IDEMPOTENCY_KEY = ?"
+	markAppliedTask = "UPDATE IDEMPOTENCY_KEYS SET TASK_ID = ? WHERE IDEMPOTENCY_KEY = ?"
 )
 
 type TaskRepositoryImpl struct {
-	db  *sql.DB
-	log *applog.Logger
+	db      *sql.DB
+	log     *applog.Logger
+	cluster clusterSchema
 }
 
 func NewTaskRepository(applog *applog.Logger, db *sql.DB) (*TaskRepositoryImpl, error) {
@@ -30,114 +31,284 @@ func NewTaskRepository(applog *applog.Logger, db *sql.DB) (*TaskRepositoryImpl,
 	return taskRepo, nil
 }
 
-func (t *TaskRepositoryImpl) Add(task *domain.Task) (*domain.Task, error) {
-	var err error
-	var result sql.Result
+// schema reads the schema version of the cluster with db, the one of the transaction writing the task.
+// The tasks of the other tenants than the one of ctx are out of its reach.
+func (t *TaskRepositoryImpl) schema(ctx context.Context, db querier) (taskSchema, error) {
+	return readSchema(ctx, &t.cluster, db)
+}
 
-	if result, err = t.db.Exec(insert, task.Title, task.Details, task.CreatedDate); err != nil {
-		return nil, err
+func (t *TaskRepositoryImpl) Add(ctx context.Context, task *domain.Task) (*domain.Task, error) {
+	if key := domain.IdempotencyKeyOf(ctx); key != "" {
+		return t.addOnce(ctx, key, task)
 	}
 
-	rowsAffected, err := result.RowsAffected()
-	t.log.Log.Info("record inserted", zap.Int64("records", rowsAffected))
-	id, _ := result.LastInsertId()
-	returnTask := &domain.Task{
-		Id:          id,
-		Title:       task.Title,
-		Details:     task.Details,
-		CreatedDate: task.CreatedDate,
-	}
-	return returnTask, err
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer tx.Rollback()
+	schema, err := t.schema(ctx, tx)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	inserted, err := schema.insert(ctx, tx, task)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	t.log.Log.Info("record inserted", zap.Int64("id", inserted.Id), zap.String("key", inserted.Key))
+	return inserted, nil
 }
 
-func (t *TaskRepositoryImpl) FindById(id int64) (*domain.Task, error) {
-	var task domain.Task
+func (t *TaskRepositoryImpl) FindById(ctx context.Context, id int64) (*domain.Task, error) {
 	lg, _ := zap.NewProduction()
 	lg.Info("Id to find", zap.Int64("id", id))
 
-	row := t.db.QueryRow(findById, id)
-	if err := row.Scan(&task.Id, &task.Title, &task.Details, &task.CreatedDate); err != nil {
-		return nil, err
+	schema, err := t.schema(ctx, t.db)
+	if err != nil {
+		return nil, queryError(ctx, err)
 	}
-	return &task, nil
+	task, err := schema.find(ctx, t.db, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, taskNotFound(id, err)
+		}
+		return nil, queryError(ctx, err)
+	}
+	return task, nil
 
 }
 
-func (t *TaskRepositoryImpl) FindAll() (*[]domain.Task, error) {
-	var tasks []domain.Task
-	lg, _ := zap.NewProduction()
-
-	var (
-		taskId          sql.NullInt64
-		taskTitle       sql.NullString
-		taskDetails     sql.NullString
-		taskCreatedDate sql.NullString
-	)
-	rows, err := t.db.Query(findAll)
+func (t *TaskRepositoryImpl) FindByKey(ctx context.Context, key string) (*domain.Task, error) {
+	schema, err := t.schema(ctx, t.db)
 	if err != nil {
-		return nil, err
+		return nil, queryError(ctx, err)
 	}
-	defer rows.Close()
-	for rows.Next() {
-		var task domain.Task
-		if err := rows.Scan(&taskId, &taskTitle, &taskDetails, &taskCreatedDate); err != nil {
-			lg.Error("no record found", zap.Error(err))
-			return nil, err
-		}
-		lg.Info("retrieved task", zap.Int64("id", taskId.Int64), zap.String("title", taskTitle.String),
-			zap.String("details", taskDetails.String),
-			zap.String("createdDate", taskCreatedDate.String))
-
-		task = domain.Task{
-			Id:          taskId.Int64,
-			Title:       taskTitle.String,
-			Details:     taskDetails.String,
-			CreatedDate: taskCreatedDate.String,
+	task, err := schema.findByKey(ctx, t.db, key)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, domain.NewError(domain.ErrNotFound, domain.CodeTaskNotFound, "task "+key+" not found", err)
 		}
+		return nil, queryError(ctx, err)
+	}
+	return task, nil
+}
+
+func (t *TaskRepositoryImpl) FindAll(ctx context.Context, filter domain.TaskFilter) (*[]domain.Task, error) {
+	lg, _ := zap.NewProduction()
 
-		tasks = append(tasks, task)
+	schema, err := t.schema(ctx, t.db)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	tasks, err := schema.findAll(ctx, t.db, filter)
+	if err != nil {
+		lg.Error("no record found", zap.Error(err))
+		return nil, queryError(ctx, err)
 	}
+	lg.Info("retrieved tasks", zap.Int("tasks", len(tasks)))
 
 	return &tasks, nil
 }
 
-func (t *TaskRepositoryImpl) Delete(id int64) error {
-	var err error
-	var result sql.Result
-	var rowsAffected int64
-
+// Delete removes the task unless its project is archived.
+func (t *TaskRepositoryImpl) Delete(ctx context.Context, id int64) error {
 	lg, _ := zap.NewProduction()
 
 	lg.Info("Id to find", zap.Int64("id", id))
 
-	if result, err = t.db.Exec(delete, id); err != nil {
+	if key := domain.IdempotencyKeyOf(ctx); key != "" {
+		return t.deleteOnce(ctx, key, id)
+	}
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer tx.Rollback()
+	if err = t.deleteIn(ctx, tx, id); err != nil {
 		return err
 	}
-	rowsAffected, err = result.RowsAffected()
-
-	lg.Info("number of rows affected", zap.Int64("rows", rowsAffected))
+	return queryError(ctx, tx.Commit())
+}
 
+// deleteIn deletes the task in the transaction tx.
+func (t *TaskRepositoryImpl) deleteIn(ctx context.Context, tx *sql.Tx, id int64) error {
+	schema, err := t.schema(ctx, tx)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if err = schema.writable(ctx, tx, id); err != nil {
+		return queryError(ctx, err)
+	}
+	result, err := schema.delete(ctx, tx, id)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	t.log.Log.Info("number of rows affected", zap.Int64("rows", rowsAffected))
+	if rowsAffected == 0 {
+		return taskNotFound(id, nil)
+	}
 	return nil
-
 }
 
-func (t *TaskRepositoryImpl) Update(task *domain.Task) (*domain.Task, error) {
+// Update reads the task back in the same transaction, its creation and completion dates are the stored ones.
+// The tasks of an archived project cannot be updated.
+func (t *TaskRepositoryImpl) Update(ctx context.Context, task *domain.Task) (*domain.Task, error) {
 	var err error
 	var result sql.Result
-	currentTime := time.Now()
-	task.CreatedDate = currentTime.Format(time.RFC1123)
-	if result, err = t.db.Exec(update, task.Title, task.Details, task.Id); err != nil {
-		return nil, err
+	var tx *sql.Tx
+
+	if tx, err = t.db.BeginTx(ctx, nil); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer tx.Rollback()
+	schema, err := t.schema(ctx, tx)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if err = schema.writable(ctx, tx, task.Id); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if result, err = schema.update(ctx, tx, task); err != nil {
+		return nil, queryError(ctx, err)
 	}
 
 	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
 	t.log.Log.Info("record updated", zap.Int64("records", rowsAffected))
-	id := task.Id
-	returnTask := &domain.Task{
-		Id:          id,
-		Title:       task.Title,
-		Details:     task.Details,
-		CreatedDate: task.CreatedDate,
-	}
-	return returnTask, err
+	if rowsAffected == 0 {
+		return nil, taskNotFound(task.Id, nil)
+	}
+	returnTask, err := schema.find(ctx, tx, task.Id)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return returnTask, nil
+}
+
+// Patch reads the task, lets patch change it and writes it back in a single transaction,
+// the error of patch is returned as is. The tasks of an archived project cannot be patched.
+func (t *TaskRepositoryImpl) Patch(ctx context.Context, id int64, patch func(task *domain.Task) error) (*domain.Task, error) {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer tx.Rollback()
+	schema, err := t.schema(ctx, tx)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	task, err := schema.find(ctx, tx, id)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, taskNotFound(id, err)
+		}
+		return nil, queryError(ctx, err)
+	}
+	if err = schema.writable(ctx, tx, id); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if err = patch(task); err != nil {
+		return nil, err
+	}
+	task.Id = id
+	if _, err = schema.update(ctx, tx, task); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if task, err = schema.find(ctx, tx, id); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	t.log.Log.Info("record patched", zap.Int64("id", id))
+	return task, nil
+}
+
+// addOnce inserts the task unless an earlier attempt of the request did, in which case it returns that task.
+func (t *TaskRepositoryImpl) addOnce(ctx context.Context, key string, task *domain.Task) (*domain.Task, error) {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer tx.Rollback()
+	schema, err := t.schema(ctx, tx)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	appliedId, err := appliedTask(ctx, tx, key)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if appliedId.Valid {
+		t.log.Log.Info("task already inserted", zap.String("key", key), zap.Int64("id", appliedId.Int64))
+		returnTask, err := schema.find(ctx, tx, appliedId.Int64)
+		if err != nil {
+			if errors.Is(err, sql.ErrNoRows) {
+				return nil, taskNotFound(appliedId.Int64, err)
+			}
+			return nil, queryError(ctx, err)
+		}
+		return returnTask, nil
+	}
+
+	inserted, err := schema.insert(ctx, tx, task)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if _, err = tx.ExecContext(ctx, markAppliedTask, inserted.Id, key); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	t.log.Log.Info("record inserted", zap.String("key", key), zap.Int64("id", inserted.Id))
+	return inserted, nil
+}
+
+// deleteOnce deletes the task unless an earlier attempt of the request did.
+func (t *TaskRepositoryImpl) deleteOnce(ctx context.Context, key string, id int64) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer tx.Rollback()
+	appliedId, err := appliedTask(ctx, tx, key)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if appliedId.Valid && appliedId.Int64 == id {
+		t.log.Log.Info("task already deleted", zap.String("key", key), zap.Int64("id", id))
+		return nil
+	}
+
+	if err = t.deleteIn(ctx, tx, id); err != nil {
+		return err
+	}
+	if _, err = tx.ExecContext(ctx, markAppliedTask, id, key); err != nil {
+		return queryError(ctx, err)
+	}
+	return queryError(ctx, tx.Commit())
+}
+
+// appliedTask returns the task an earlier attempt of the request wrote, it is null when none did.
+func appliedTask(ctx context.Context, tx *sql.Tx, key string) (sql.NullInt64, error) {
+	var id sql.NullInt64
+	err := tx.QueryRowContext(ctx, findAppliedTask, key).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		// the key was not reserved, there is nothing to record the write in
+		return id, nil
+	}
+	return id, err
 }
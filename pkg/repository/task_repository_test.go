@@ -1,13 +1,19 @@
 package repository
 
 import (
+	"context"
+	"database/sql"
+	"errors"
 	"fmt"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/DATA-DOG/go-sqlmock"
 	"github.com/balchua/bopbag/pkg/applog"
 	"github.com/balchua/bopbag/pkg/domain"
+	dqlite "github.com/canonical/go-dqlite/driver"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -21,6 +27,41 @@ func ErrorContains(out error, want string) bool {
 	return strings.Contains(out.Error(), want)
 }
 
+var (
+	taskColumns       = []string{"ID", "TITLE", "DETAILS", "CREATED_DATE", "COMPLETED", "CREATED_AT", "UPDATED_AT", "COMPLETED_AT", "PROJECT_ID", "TASK_KEY"}
+	legacyTaskColumns = []string{"ID", "TITLE", "DETAILS", "CREATED_DATE"}
+	created           = time.Date(2021, 9, 26, 10, 0, 0, 0, time.UTC)
+	updated           = time.Date(2021, 9, 27, 10, 0, 0, 0, time.UTC)
+)
+
+const (
+	selectTasks       = "SELECT ID, TITLE, DETAILS, CREATED_DATE, COMPLETED, CREATED_AT, UPDATED_AT, COMPLETED_AT, PROJECT_ID, TASK_KEY FROM TASKS"
+	selectLegacyTasks = "SELECT ID, TITLE, DETAILS, CREATED_DATE FROM TASKS"
+)
+
+// expectSchema answers the schema version the repository reads until the cluster is migrated.
+func expectSchema(mock sqlmock.Sqlmock, version int) {
+	mock.ExpectQuery("SELECT VERSION FROM SCHEMA_VERSION").WillReturnRows(sqlmock.NewRows([]string{"VERSION"}).AddRow(version))
+}
+
+// expectWritable answers that the task is not in an archived project.
+func expectWritable(mock sqlmock.Sqlmock, id int64) {
+	mock.ExpectQuery("SELECT P.PROJECT_KEY FROM TASKS").WithArgs(id, domain.DefaultTenant).WillReturnRows(sqlmock.NewRows([]string{"PROJECT_KEY"}))
+}
+
+// expectQuota answers that the tenant has no quota.
+func expectQuota(mock sqlmock.Sqlmock) {
+	mock.ExpectQuery(regexp.QuoteMeta(findTaskQuota)).WithArgs(domain.DefaultTenant).WillReturnRows(sqlmock.NewRows([]string{"MAX_TASKS", "MAX_DETAILS_SIZE"}))
+}
+
+func legacyDate(t time.Time) string {
+	return t.In(time.Local).Format(time.RFC1123)
+}
+
+func newTimedTask() *domain.Task {
+	return &domain.Task{Title: "test", Details: "test", CreatedAt: created, UpdatedAt: created}
+}
+
 // TestSuccessfulInsert is an integration test, it runs a real Dqlite instance and delete it afterwards
 func TestSuccessfulInsert(t *testing.T) {
 	assert := assert.New(t)
@@ -30,14 +71,15 @@ func TestSuccessfulInsert(t *testing.T) {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
-	task := &domain.Task{
-		Title:       "test",
-		Details:     "test",
-		CreatedDate: "20210926",
-	}
-	mock.ExpectExec("INSERT INTO TASKS").WithArgs("test", "test", "20210926").WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	expectQuota(mock)
+	mock.ExpectExec("INSERT INTO TASKS").
+		WithArgs(nil, "test", "test", legacyDate(created), false, millis(created), millis(created), nil, nil, nil, domain.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 	repo, err := NewTaskRepository(applog, db)
-	repo.Add(task)
+	task, addErr := repo.Add(context.Background(), newTimedTask())
 
 	// we make sure that all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -45,6 +87,36 @@ func TestSuccessfulInsert(t *testing.T) {
 	}
 	assert.NotNil(repo)
 	assert.Nil(err)
+	assert.Nil(addErr)
+	assert.Equal(int64(1), task.Id)
+	assert.Equal(created, task.CreatedAt)
+}
+
+func TestMustInsertTaskWithItsId(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	expectQuota(mock)
+	mock.ExpectExec("INSERT INTO TASKS").
+		WithArgs(int64(1234567890123), "test", "test", legacyDate(created), false, millis(created), millis(created), nil, nil, nil, domain.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(1234567890123, 1))
+	mock.ExpectCommit()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	task := newTimedTask()
+	task.Id = 1234567890123
+
+	inserted, addErr := repo.Add(context.Background(), task)
+
+	assert.Nil(addErr)
+	assert.Equal(int64(1234567890123), inserted.Id)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
 }
 
 func TestFailInsert(t *testing.T) {
@@ -55,16 +127,15 @@ func TestFailInsert(t *testing.T) {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 	defer db.Close()
-	task := &domain.Task{
-		Title:       "test",
-		Details:     "test",
-		CreatedDate: "20210926",
-	}
-	mock.ExpectExec("INSERT INTO TASKS").WithArgs("test", "test", "20210926").
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	expectQuota(mock)
+	mock.ExpectExec("INSERT INTO TASKS").
 		WillReturnError(fmt.Errorf("database error"))
+	mock.ExpectRollback()
 
 	repo, err := NewTaskRepository(applog, db)
-	_, addErr := repo.Add(task)
+	_, addErr := repo.Add(context.Background(), newTimedTask())
 
 	// we make sure that all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -81,15 +152,16 @@ func TestSuccessfulFindOne(t *testing.T) {
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
-	columns := []string{"id", "title", "details", "created_date"}
-	row := sqlmock.NewRows(columns).AddRow(int64(1), "test", "test", "20210926")
-	mock.ExpectQuery("SELECT ID, TITLE, DETAILS, CREATED_DATE FROM TASKS WHERE ID = ?").
-		WithArgs(int64(1)).
+	expectSchema(mock, 7)
+	row := sqlmock.NewRows(taskColumns).AddRow(int64(1), "test", "test", legacyDate(created), true,
+		millis(created), millis(updated), millis(updated), nil, nil)
+	mock.ExpectQuery(selectTasks+" WHERE ID = ?").
+		WithArgs(int64(1), domain.DefaultTenant).
 		WillReturnRows(row)
 
 	repo, err := NewTaskRepository(applog, db)
 
-	insertedTask, err := repo.FindById(int64(1))
+	insertedTask, err := repo.FindById(context.Background(), int64(1))
 	// we make sure that all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -98,7 +170,10 @@ func TestSuccessfulFindOne(t *testing.T) {
 	assert.Equal(insertedTask.Id, int64(1))
 	assert.Equal(insertedTask.Title, "test")
 	assert.Equal(insertedTask.Details, "test")
-	assert.Equal(insertedTask.CreatedDate, "20210926")
+	assert.True(insertedTask.Completed)
+	assert.Equal(created, insertedTask.CreatedAt)
+	assert.Equal(updated, insertedTask.UpdatedAt)
+	assert.Equal(updated, *insertedTask.CompletedAt)
 	assert.Nil(err)
 
 }
@@ -111,12 +186,13 @@ func TestFailFindOne(t *testing.T) {
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
-	mock.ExpectQuery("SELECT ID, TITLE, DETAILS, CREATED_DATE FROM TASKS WHERE ID = ?").
+	expectSchema(mock, 7)
+	mock.ExpectQuery(selectTasks + " WHERE ID = ?").
 		WillReturnError(fmt.Errorf("database error"))
 
 	repo, err := NewTaskRepository(applog, db)
 
-	_, findErr := repo.FindById(int64(1))
+	_, findErr := repo.FindById(context.Background(), int64(1))
 	// we make sure that all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -133,21 +209,22 @@ func TestSuccessfulFindNothing(t *testing.T) {
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
-	columns := []string{"id", "title", "details", "created_date"}
-	emptyResult := sqlmock.NewRows(columns)
-	mock.ExpectQuery("SELECT ID, TITLE, DETAILS, CREATED_DATE FROM TASKS WHERE ID = ?").
-		WithArgs(int64(2)).
+	expectSchema(mock, 7)
+	emptyResult := sqlmock.NewRows(taskColumns)
+	mock.ExpectQuery(selectTasks+" WHERE ID = ?").
+		WithArgs(int64(2), domain.DefaultTenant).
 		WillReturnRows(emptyResult)
 
 	repo, err := NewTaskRepository(applog, db)
 
-	_, queryErr := repo.FindById(int64(2))
+	_, queryErr := repo.FindById(context.Background(), int64(2))
 
 	// we make sure that all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
 	}
 	assert.NotNil(t, queryErr)
+	assert.True(errors.Is(queryErr, domain.ErrNotFound))
 	mustFail := ErrorContains(queryErr, "sql: no rows in result set")
 
 	assert.True(mustFail)
@@ -162,14 +239,16 @@ func TestSuccessfulFindAll(t *testing.T) {
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
-	columns := []string{"id", "title", "details", "created_date"}
-	row := sqlmock.NewRows(columns).AddRow(int64(1), "test", "test", "20210926").AddRow(int64(2), "test2", "test2", "20211019")
-	mock.ExpectQuery("SELECT ID, TITLE, DETAILS, CREATED_DATE FROM TASKS").
+	expectSchema(mock, 7)
+	row := sqlmock.NewRows(taskColumns).
+		AddRow(int64(1), "test", "test", legacyDate(created), false, millis(created), millis(created), nil, nil, nil).
+		AddRow(int64(2), "test2", "test2", legacyDate(updated), false, millis(updated), millis(updated), nil, nil, nil)
+	mock.ExpectQuery(selectTasks).
 		WillReturnRows(row)
 
 	repo, err := NewTaskRepository(applog, db)
 
-	tasks, err := repo.FindAll()
+	tasks, err := repo.FindAll(context.Background(), domain.TaskFilter{})
 
 	// we make sure that all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -188,14 +267,14 @@ func TestSuccessfulFindAllEmptyDB(t *testing.T) {
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
-	columns := []string{"id", "title", "details", "created_date"}
-	emptyResult := sqlmock.NewRows(columns)
-	mock.ExpectQuery("SELECT ID, TITLE, DETAILS, CREATED_DATE FROM TASKS").
+	expectSchema(mock, 7)
+	emptyResult := sqlmock.NewRows(taskColumns)
+	mock.ExpectQuery(selectTasks).
 		WillReturnRows(emptyResult)
 
 	repo, err := NewTaskRepository(applog, db)
 
-	tasks, err := repo.FindAll()
+	tasks, err := repo.FindAll(context.Background(), domain.TaskFilter{})
 	// we make sure that all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -212,12 +291,13 @@ func TestFailedFindAll(t *testing.T) {
 	if err != nil {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
-	mock.ExpectQuery("SELECT ID, TITLE, DETAILS, CREATED_DATE FROM TASKS").
+	expectSchema(mock, 7)
+	mock.ExpectQuery(selectTasks).
 		WillReturnError(fmt.Errorf("database error"))
 
 	repo, err := NewTaskRepository(applog, db)
 
-	_, findAllErr := repo.FindAll()
+	_, findAllErr := repo.FindAll(context.Background(), domain.TaskFilter{})
 	// we make sure that all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -226,6 +306,427 @@ func TestFailedFindAll(t *testing.T) {
 	assert.NotNil(findAllErr)
 }
 
+func TestMustFindTasksByDateRange(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 7)
+	mock.ExpectQuery(regexp.QuoteMeta(selectTasks+" WHERE TENANT_ID = ? AND CREATED_AT >= ? AND UPDATED_AT < ? AND COMPLETED_AT >= ?")).
+		WithArgs(domain.DefaultTenant, millis(created), millis(updated), millis(created)).
+		WillReturnRows(sqlmock.NewRows(taskColumns).
+			AddRow(int64(1), "test", "test", legacyDate(created), true, millis(created), millis(created), millis(created), nil, nil))
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+
+	tasks, findAllErr := repo.FindAll(context.Background(), domain.TaskFilter{
+		CreatedAfter:   created,
+		UpdatedBefore:  updated,
+		CompletedAfter: created,
+	})
+
+	assert.Nil(findAllErr)
+	assert.Equal(1, len(*tasks))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustFindTasksOfAProject(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 7)
+	mock.ExpectQuery(regexp.QuoteMeta(selectTasks+" WHERE TENANT_ID = ? AND PROJECT_ID = ? AND CREATED_AT >= ? ORDER BY ID")).
+		WithArgs(domain.DefaultTenant, int64(3), millis(created)).
+		WillReturnRows(sqlmock.NewRows(taskColumns).
+			AddRow(int64(1), "test", "test", legacyDate(created), false, millis(created), millis(created), nil, int64(3), "OPS-1"))
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+
+	tasks, findAllErr := repo.FindAll(context.Background(), domain.TaskFilter{ProjectId: 3, CreatedAfter: created})
+
+	assert.Nil(findAllErr)
+	assert.Equal("OPS-1", (*tasks)[0].Key)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustReadTasksInsertedByPreviousBinaries(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 7)
+	mock.ExpectQuery(selectTasks+" WHERE ID = ?").
+		WithArgs(int64(1), domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(taskColumns).AddRow(int64(1), "test", "test", legacyDate(created), false, nil, nil, nil, nil, nil))
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+
+	task, findErr := repo.FindById(context.Background(), 1)
+
+	assert.Nil(findErr)
+	assert.Equal(created, task.CreatedAt)
+	assert.Equal(created, task.UpdatedAt)
+	assert.Nil(task.CompletedAt)
+}
+
+func TestMustReadTasksBeforeTheMigration(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 2)
+	mock.ExpectQuery(selectLegacyTasks).
+		WillReturnRows(sqlmock.NewRows(legacyTaskColumns).
+			AddRow(int64(1), "test", "test", legacyDate(created)).
+			AddRow(int64(2), "test2", "test2", legacyDate(updated)))
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+
+	// the filter is applied to the tasks read
+	tasks, findAllErr := repo.FindAll(context.Background(), domain.TaskFilter{CreatedBefore: updated})
+
+	assert.Nil(findAllErr)
+	assert.Equal(1, len(*tasks))
+	assert.Equal(created, (*tasks)[0].CreatedAt)
+	assert.Equal(created, (*tasks)[0].UpdatedAt)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailCompleteTaskBeforeTheMigration(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 2)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectSchema(mock, 2)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO TASKS (ID, TITLE, DETAILS, CREATED_DATE) VALUES(?,?,?,?)")).
+		WithArgs(nil, "test", "test", legacyDate(created)).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	completed := newTimedTask()
+	completed.Completed = true
+
+	_, completeErr := repo.Add(context.Background(), completed)
+	_, addErr := repo.Add(context.Background(), newTimedTask())
+
+	assert.True(errors.Is(completeErr, domain.ErrConflict))
+	assert.Equal(domain.CodeSchemaNotMigrated, completeErr.(*domain.Error).Code)
+	assert.Nil(addErr)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustKeyTasksWithTheNextNumberOfTheirProject(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE PROJECTS SET NEXT_NUMBER = NEXT_NUMBER + 1 WHERE PROJECT_KEY = ?")).
+		WithArgs("OPS", domain.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT ID, NEXT_NUMBER - 1, ARCHIVED_AT IS NOT NULL FROM PROJECTS WHERE PROJECT_KEY = ?")).
+		WithArgs("OPS", domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "NEXT_NUMBER", "ARCHIVED"}).AddRow(int64(3), int64(142), false))
+	expectQuota(mock)
+	mock.ExpectExec("INSERT INTO TASKS").
+		WithArgs(nil, "test", "test", legacyDate(created), false, millis(created), millis(created), nil, int64(3), "OPS-142", domain.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	task := newTimedTask()
+	task.Project = "OPS"
+
+	inserted, addErr := repo.Add(context.Background(), task)
+
+	assert.Nil(addErr)
+	assert.Equal("OPS-142", inserted.Key)
+	assert.Equal("OPS", inserted.Project)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailAddTaskToMissingProject(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	mock.ExpectExec("UPDATE PROJECTS SET NEXT_NUMBER").
+		WithArgs("OPS", domain.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	task := newTimedTask()
+	task.Project = "OPS"
+
+	_, addErr := repo.Add(context.Background(), task)
+
+	assert.True(errors.Is(addErr, domain.ErrValidation))
+	assert.Equal(domain.CodeInvalidTask, addErr.(*domain.Error).Code)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailAddTaskToArchivedProject(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	mock.ExpectExec("UPDATE PROJECTS SET NEXT_NUMBER").
+		WithArgs("OPS", domain.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery("SELECT ID, NEXT_NUMBER - 1, ARCHIVED_AT IS NOT NULL FROM PROJECTS").
+		WithArgs("OPS", domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"ID", "NEXT_NUMBER", "ARCHIVED"}).AddRow(int64(3), int64(142), true))
+	// the number taken is given back
+	mock.ExpectRollback()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	task := newTimedTask()
+	task.Project = "OPS"
+
+	_, addErr := repo.Add(context.Background(), task)
+
+	assert.True(errors.Is(addErr, domain.ErrConflict))
+	assert.Equal(domain.CodeProjectArchived, addErr.(*domain.Error).Code)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailChangeTaskOfArchivedProject(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	archived := func() {
+		mock.ExpectQuery(regexp.QuoteMeta("SELECT P.PROJECT_KEY FROM TASKS T JOIN PROJECTS P ON P.ID = T.PROJECT_ID WHERE T.ID = ? AND P.ARCHIVED_AT IS NOT NULL")).
+			WithArgs(int64(1), domain.DefaultTenant).
+			WillReturnRows(sqlmock.NewRows([]string{"PROJECT_KEY"}).AddRow("OPS"))
+		mock.ExpectRollback()
+	}
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	archived()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectTasks+" WHERE ID = ?").
+		WithArgs(int64(1), domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(taskColumns).
+			AddRow(1, "title", "details", legacyDate(created), false, millis(created), millis(created), nil, int64(3), "OPS-1"))
+	archived()
+	mock.ExpectBegin()
+	archived()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	task := newTimedTask()
+	task.Id = 1
+
+	_, updateErr := repo.Update(context.Background(), task)
+	_, patchErr := repo.Patch(context.Background(), 1, func(task *domain.Task) error { return nil })
+	deleteErr := repo.Delete(context.Background(), 1)
+
+	for _, err := range []error{updateErr, patchErr, deleteErr} {
+		assert.True(errors.Is(err, domain.ErrConflict))
+		assert.Equal(domain.CodeProjectArchived, err.(*domain.Error).Code)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailAddTaskToProjectBeforeTheMigration(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 3)
+	mock.ExpectRollback()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	task := newTimedTask()
+	task.Project = "OPS"
+
+	_, addErr := repo.Add(context.Background(), task)
+
+	assert.True(errors.Is(addErr, domain.ErrConflict))
+	assert.Equal(domain.CodeSchemaNotMigrated, addErr.(*domain.Error).Code)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSuccessfulFindByKey(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 7)
+	mock.ExpectQuery(selectTasks+" WHERE TASK_KEY = ?").
+		WithArgs("OPS-142", domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(taskColumns).
+			AddRow(int64(1), "test", "test", legacyDate(created), false, millis(created), millis(created), nil, int64(3), "OPS-142"))
+	mock.ExpectQuery(selectTasks+" WHERE TASK_KEY = ?").
+		WithArgs("OPS-143", domain.DefaultTenant).
+		WillReturnError(sql.ErrNoRows)
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+
+	task, findErr := repo.FindByKey(context.Background(), "OPS-142")
+	_, missingErr := repo.FindByKey(context.Background(), "OPS-143")
+
+	assert.Nil(findErr)
+	assert.Equal(int64(1), task.Id)
+	assert.Equal("OPS", task.Project)
+	assert.True(errors.Is(missingErr, domain.ErrNotFound))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustKeepTheTasksOfOtherTenantsOutOfReach(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	// task 1 belongs to another tenant, the scoped queries do not see it
+	expectSchema(mock, 7)
+	mock.ExpectQuery(regexp.QuoteMeta(selectTasks+" WHERE ID = ? AND TENANT_ID = ?")).
+		WithArgs(int64(1), "acme").
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectQuery(regexp.QuoteMeta(selectTasks + " WHERE TENANT_ID = ? ORDER BY ID")).
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows(taskColumns))
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(findArchivedTask+" AND T.TENANT_ID = ?")).
+		WithArgs(int64(1), "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"PROJECT_KEY"}))
+	mock.ExpectQuery(regexp.QuoteMeta(findTaskQuota)).WithArgs("acme").WillReturnRows(sqlmock.NewRows([]string{"MAX_TASKS", "MAX_DETAILS_SIZE"}))
+	mock.ExpectExec(regexp.QuoteMeta(update+" AND TENANT_ID = ?")).
+		WithArgs("test", "test", false, millis(created), false, millis(created), int64(1), "acme").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(findArchivedTask+" AND T.TENANT_ID = ?")).
+		WithArgs(int64(1), "acme").
+		WillReturnRows(sqlmock.NewRows([]string{"PROJECT_KEY"}))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM TASKS WHERE ID = ? AND TENANT_ID = ?")).
+		WithArgs(int64(1), "acme").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta("UPDATE PROJECTS SET NEXT_NUMBER = NEXT_NUMBER + 1 WHERE PROJECT_KEY = ? AND TENANT_ID = ?")).
+		WithArgs("OPS", "acme").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	ctx := domain.WithTenant(context.Background(), "acme")
+	task := newTimedTask()
+	task.Id = 1
+	inProject := newTimedTask()
+	inProject.Project = "OPS"
+
+	_, findErr := repo.FindById(ctx, 1)
+	tasks, findAllErr := repo.FindAll(ctx, domain.TaskFilter{})
+	_, updateErr := repo.Update(ctx, task)
+	deleteErr := repo.Delete(ctx, 1)
+	_, addErr := repo.Add(ctx, inProject)
+
+	for _, err := range []error{findErr, updateErr, deleteErr} {
+		assert.True(errors.Is(err, domain.ErrNotFound))
+	}
+	assert.Nil(findAllErr)
+	assert.Empty(*tasks)
+	assert.True(errors.Is(addErr, domain.ErrValidation))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustInsertTaskOfTheTenant(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	mock.ExpectQuery(regexp.QuoteMeta(findTaskQuota)).WithArgs("acme").WillReturnRows(sqlmock.NewRows([]string{"MAX_TASKS", "MAX_DETAILS_SIZE"}))
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO TASKS (ID, TITLE, DETAILS, CREATED_DATE, COMPLETED, CREATED_AT, UPDATED_AT, COMPLETED_AT, PROJECT_ID, TASK_KEY, TENANT_ID) VALUES(?,?,?,?,?,?,?,?,?,?,?)")).
+		WithArgs(nil, "test", "test", legacyDate(created), false, millis(created), millis(created), nil, nil, nil, "acme").
+		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+
+	task, addErr := repo.Add(domain.WithTenant(context.Background(), "acme"), newTimedTask())
+
+	assert.Nil(addErr)
+	assert.Equal(int64(1), task.Id)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailUseTenantBeforeTheMigration(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 5)
+	expectSchema(mock, 5)
+	mock.ExpectQuery(selectTasks).WillReturnRows(sqlmock.NewRows(taskColumns))
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+
+	_, tenantErr := repo.FindAll(domain.WithTenant(context.Background(), "acme"), domain.TaskFilter{})
+	tasks, defaultErr := repo.FindAll(context.Background(), domain.TaskFilter{})
+
+	assert.True(errors.Is(tenantErr, domain.ErrConflict))
+	assert.Equal(domain.CodeSchemaNotMigrated, tenantErr.(*domain.Error).Code)
+	assert.Nil(defaultErr)
+	assert.Empty(*tasks)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
 func TestSuccessfulDeleteTask(t *testing.T) {
 	id := int64(1)
 	assert := assert.New(t)
@@ -235,13 +736,17 @@ func TestSuccessfulDeleteTask(t *testing.T) {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	expectWritable(mock, id)
 	mock.ExpectExec("DELETE FROM TASKS WHERE ID = ?").
-		WithArgs(id).
+		WithArgs(id, domain.DefaultTenant).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectCommit()
 
 	repo, err := NewTaskRepository(applog, db)
 
-	deleteErr := repo.Delete(id)
+	deleteErr := repo.Delete(context.Background(), id)
 	// we make sure that all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -258,13 +763,17 @@ func TestFailedDelete(t *testing.T) {
 		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
 	}
 
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	expectWritable(mock, id)
 	mock.ExpectExec("DELETE FROM TASKS WHERE ID = ?").
-		WithArgs(id).
+		WithArgs(id, domain.DefaultTenant).
 		WillReturnError(fmt.Errorf("database error"))
+	mock.ExpectRollback()
 
 	repo, err := NewTaskRepository(applog, db)
 
-	deleteErr := repo.Delete(id)
+	deleteErr := repo.Delete(context.Background(), id)
 	// we make sure that all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
 		t.Errorf("there were unfulfilled expectations: %s", err)
@@ -282,17 +791,28 @@ func TestSuccessfulUpdate(t *testing.T) {
 	}
 	defer db.Close()
 	task := &domain.Task{
-		Id:          id,
-		Title:       "update title",
-		Details:     "new details",
-		CreatedDate: "20210926",
+		Id:        id,
+		Title:     "update title",
+		Details:   "new details",
+		Completed: true,
+		UpdatedAt: updated,
 	}
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	// a task becoming completed is completed at its update date
+	expectWritable(mock, id)
+	expectQuota(mock)
 	mock.ExpectExec("UPDATE TASKS ").
-		WithArgs("update title", "new details", id).
+		WithArgs("update title", "new details", true, millis(updated), true, millis(updated), id, domain.DefaultTenant).
 		WillReturnResult(sqlmock.NewResult(1, 1))
+	mock.ExpectQuery(selectTasks+" WHERE ID = ?").
+		WithArgs(id, domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(taskColumns).
+			AddRow(id, "update title", "new details", legacyDate(created), true, millis(created), millis(updated), millis(updated), nil, nil))
+	mock.ExpectCommit()
 
 	repo, err := NewTaskRepository(applog, db)
-	updatedTask, updateErr := repo.Update(task)
+	updatedTask, updateErr := repo.Update(context.Background(), task)
 
 	// we make sure that all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -302,6 +822,8 @@ func TestSuccessfulUpdate(t *testing.T) {
 	assert.Equal(updatedTask.Id, int64(1))
 	assert.Equal(updatedTask.Title, "update title")
 	assert.Equal(updatedTask.Details, "new details")
+	assert.Equal(created, updatedTask.CreatedAt)
+	assert.Equal(updated, *updatedTask.CompletedAt)
 	assert.Nil(updateErr)
 }
 
@@ -315,17 +837,22 @@ func TestFailedUpdate(t *testing.T) {
 	}
 	defer db.Close()
 	task := &domain.Task{
-		Id:          id,
-		Title:       "update title",
-		Details:     "new details",
-		CreatedDate: "20210926",
+		Id:        id,
+		Title:     "update title",
+		Details:   "new details",
+		UpdatedAt: updated,
 	}
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	expectWritable(mock, id)
+	expectQuota(mock)
 	mock.ExpectExec("UPDATE TASKS ").
-		WithArgs("update title", "new details", id).
+		WithArgs("update title", "new details", false, millis(updated), false, millis(updated), id, domain.DefaultTenant).
 		WillReturnError(fmt.Errorf("database error"))
+	mock.ExpectRollback()
 
 	repo, err := NewTaskRepository(applog, db)
-	_, updateErr := repo.Update(task)
+	_, updateErr := repo.Update(context.Background(), task)
 
 	// we make sure that all expectations were met
 	if err := mock.ExpectationsWereMet(); err != nil {
@@ -333,3 +860,328 @@ func TestFailedUpdate(t *testing.T) {
 	}
 	assert.NotNil(updateErr)
 }
+
+func TestFailDeleteMissingTask(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	expectWritable(mock, 7)
+	mock.ExpectExec("DELETE FROM TASKS WHERE ID = ?").
+		WithArgs(int64(7), domain.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+
+	deleteErr := repo.Delete(context.Background(), 7)
+
+	var domainErr *domain.Error
+	assert.True(errors.As(deleteErr, &domainErr))
+	assert.Equal(domain.CodeTaskNotFound, domainErr.Code)
+}
+
+func TestFailUpdateMissingTask(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 2)
+	mock.ExpectExec("UPDATE TASKS ").
+		WithArgs("title", "details", int64(7)).
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+
+	_, updateErr := repo.Update(context.Background(), &domain.Task{Id: 7, Title: "title", Details: "details"})
+
+	assert.True(errors.Is(updateErr, domain.ErrNotFound))
+}
+
+func TestMustClassifyDatabaseErrors(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	expectQuota(mock)
+	mock.ExpectExec("INSERT INTO TASKS").
+		WillReturnError(dqlite.Error{Code: 2067, Message: "UNIQUE constraint failed: TASKS.ID"})
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	expectQuota(mock)
+	mock.ExpectExec("INSERT INTO TASKS").
+		WillReturnError(dqlite.Error{Code: 5, Message: "database is locked"})
+	mock.ExpectRollback()
+	mock.ExpectQuery(selectTasks).
+		WillReturnError(dqlite.ErrNoAvailableLeader)
+	mock.ExpectQuery(selectTasks).
+		WillReturnError(fmt.Errorf("database error"))
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	task := newTimedTask()
+
+	_, conflictErr := repo.Add(context.Background(), task)
+	_, busyErr := repo.Add(context.Background(), task)
+	_, noLeaderErr := repo.FindAll(context.Background(), domain.TaskFilter{})
+	_, otherErr := repo.FindAll(context.Background(), domain.TaskFilter{})
+
+	assert.True(errors.Is(conflictErr, domain.ErrConflict))
+	assert.True(errors.Is(busyErr, domain.ErrUnavailable))
+	assert.True(errors.Is(noLeaderErr, domain.ErrNoLeader))
+	var domainErr *domain.Error
+	assert.False(errors.As(otherErr, &domainErr))
+}
+
+func TestFailQueryWhenTheContextIsDone(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 7)
+	mock.ExpectQuery(selectTasks).
+		WillDelayFor(time.Second).
+		WillReturnRows(sqlmock.NewRows(taskColumns))
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	_, findAllErr := repo.FindAll(ctx, domain.TaskFilter{})
+
+	assert.True(errors.Is(findAllErr, domain.ErrUnavailable))
+	assert.True(errors.Is(findAllErr, context.DeadlineExceeded))
+}
+
+func TestMustRecordTheTaskInsertedWithKey(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	mock.ExpectQuery("SELECT TASK_ID FROM IDEMPOTENCY_KEYS").
+		WithArgs("k1").
+		WillReturnRows(sqlmock.NewRows([]string{"TASK_ID"}).AddRow(nil))
+	expectQuota(mock)
+	mock.ExpectExec("INSERT INTO TASKS").
+		WithArgs(nil, "test", "test", legacyDate(created), false, millis(created), millis(created), nil, nil, nil, domain.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(12, 1))
+	mock.ExpectExec("UPDATE IDEMPOTENCY_KEYS SET TASK_ID = ?").WithArgs(int64(12), "k1").WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	ctx := domain.WithIdempotencyKey(context.Background(), "k1")
+
+	task, addErr := repo.Add(ctx, newTimedTask())
+
+	assert.Nil(addErr)
+	assert.Equal(int64(12), task.Id)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustNotInsertTwiceWithKey(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	mock.ExpectQuery("SELECT TASK_ID FROM IDEMPOTENCY_KEYS").
+		WithArgs("k1").
+		WillReturnRows(sqlmock.NewRows([]string{"TASK_ID"}).AddRow(12))
+	mock.ExpectQuery(selectTasks+" WHERE ID = ?").
+		WithArgs(int64(12), domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(taskColumns).
+			AddRow(12, "test", "test", legacyDate(created), false, millis(created), millis(created), nil, nil, nil))
+	mock.ExpectRollback()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	ctx := domain.WithIdempotencyKey(context.Background(), "k1")
+	retried := newTimedTask()
+	retried.CreatedAt = updated
+
+	task, addErr := repo.Add(ctx, retried)
+
+	assert.Nil(addErr)
+	assert.Equal(int64(12), task.Id)
+	assert.Equal(created, task.CreatedAt)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustNotDeleteTwiceWithKey(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	mock.ExpectQuery("SELECT TASK_ID FROM IDEMPOTENCY_KEYS").
+		WithArgs("k1").
+		WillReturnRows(sqlmock.NewRows([]string{"TASK_ID"}).AddRow(7))
+	mock.ExpectRollback()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	ctx := domain.WithIdempotencyKey(context.Background(), "k1")
+
+	deleteErr := repo.Delete(ctx, 7)
+
+	assert.Nil(deleteErr)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustPatchTaskInTransaction(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	mock.ExpectQuery(selectTasks+" WHERE ID = ?").
+		WithArgs(int64(1), domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(taskColumns).
+			AddRow(1, "title", "details", legacyDate(created), false, millis(created), millis(created), nil, nil, nil))
+	expectWritable(mock, 1)
+	expectQuota(mock)
+	mock.ExpectExec("UPDATE TASKS ").
+		WithArgs("title", "new details", false, millis(updated), false, millis(updated), int64(1), domain.DefaultTenant).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectQuery(selectTasks+" WHERE ID = ?").
+		WithArgs(int64(1), domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(taskColumns).
+			AddRow(1, "title", "new details", legacyDate(created), false, millis(created), millis(updated), nil, nil, nil))
+	mock.ExpectCommit()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+
+	task, patchErr := repo.Patch(context.Background(), 1, func(task *domain.Task) error {
+		task.Details = "new details"
+		task.UpdatedAt = updated
+		return nil
+	})
+
+	assert.Nil(patchErr)
+	assert.Equal("title", task.Title)
+	assert.Equal("new details", task.Details)
+	assert.Equal(updated, task.UpdatedAt)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailPatchRollsBack(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	mock.ExpectQuery(selectTasks+" WHERE ID = ?").
+		WithArgs(int64(1), domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows(taskColumns).
+			AddRow(1, "title", "details", legacyDate(created), false, millis(created), millis(created), nil, nil, nil))
+	expectWritable(mock, 1)
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(selectTasks+" WHERE ID = ?").
+		WithArgs(int64(2), domain.DefaultTenant).
+		WillReturnError(sql.ErrNoRows)
+	mock.ExpectRollback()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	invalid := domain.NewError(domain.ErrValidation, domain.CodeInvalidPatch, "invalid", nil)
+
+	_, patchErr := repo.Patch(context.Background(), 1, func(task *domain.Task) error { return invalid })
+	_, missingErr := repo.Patch(context.Background(), 2, func(task *domain.Task) error { return nil })
+
+	assert.Equal(invalid, patchErr)
+	assert.True(errors.Is(missingErr, domain.ErrNotFound))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailInsertTaskOverTheQuota(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	quotaRows := []string{"MAX_TASKS", "MAX_DETAILS_SIZE"}
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	mock.ExpectQuery(regexp.QuoteMeta(findTaskQuota)).
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows(quotaRows).AddRow(2, nil))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM TASKS WHERE TENANT_ID = ?")).
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(2))
+	mock.ExpectRollback()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta(findTaskQuota)).
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows(quotaRows).AddRow(2, 4))
+	mock.ExpectRollback()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	ctx := domain.WithTenant(context.Background(), "acme")
+	large := newTimedTask()
+	large.Details = "large"
+
+	_, quotaErr := repo.Add(ctx, newTimedTask())
+	_, detailsErr := repo.Add(ctx, large)
+
+	assert.True(errors.Is(quotaErr, domain.ErrConflict))
+	assert.Equal(domain.CodeTaskQuotaExceeded, quotaErr.(*domain.Error).Code)
+	assert.True(errors.Is(detailsErr, domain.ErrValidation))
+	assert.Equal(domain.CodeDetailsTooLarge, detailsErr.(*domain.Error).Code)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailUpdateDetailsOverTheQuota(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	expectWritable(mock, 1)
+	mock.ExpectQuery(regexp.QuoteMeta(findTaskQuota)).
+		WithArgs(domain.DefaultTenant).
+		WillReturnRows(sqlmock.NewRows([]string{"MAX_TASKS", "MAX_DETAILS_SIZE"}).AddRow(1, 3))
+	mock.ExpectRollback()
+	repo, _ := NewTaskRepository(applog.NewLogger(), db)
+	task := newTimedTask()
+	task.Id = 1
+
+	_, updateErr := repo.Update(context.Background(), task)
+
+	// the tasks are not counted, the tenant already has the task it updates
+	assert.Equal(domain.CodeDetailsTooLarge, updateErr.(*domain.Error).Code)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
@@ -0,0 +1,423 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/domain"
+)
+
+// Schema versions changing the TASKS table.
+const (
+	// timestampsSchemaVersion adds the typed timestamps
+	timestampsSchemaVersion = 3
+	// projectsSchemaVersion adds the project and the key of the tasks
+	projectsSchemaVersion = 4
+	// archivingSchemaVersion adds the archived projects, their tasks are read only
+	archivingSchemaVersion = 5
+	// tenantsSchemaVersion adds the tenant of the tasks and projects
+	tenantsSchemaVersion = 6
+	// quotasSchemaVersion adds the quotas of the tenants
+	quotasSchemaVersion     = 7
+	latestTaskSchemaVersion = quotasSchemaVersion
+)
+
+const (
+	legacyColumns    = "ID, TITLE, DETAILS, CREATED_DATE"
+	timestampColumns = ", COMPLETED, CREATED_AT, UPDATED_AT, COMPLETED_AT"
+	projectColumns   = ", PROJECT_ID, TASK_KEY"
+
+	// the completion date of a task which stays completed is kept
+	update       = "UPDATE TASKS SET TITLE=?, DETAILS=?, COMPLETED=?, UPDATED_AT=?, COMPLETED_AT=CASE WHEN ? THEN COALESCE(COMPLETED_AT, ?) ELSE NULL END WHERE ID=?"
+	legacyUpdate = "UPDATE TASKS SET TITLE=?, DETAILS=? WHERE ID=?"
+	// the snowflake ids sort the tasks by creation time, after the ones inserted before them
+	orderById = " ORDER BY ID"
+
+	// the number is taken before it is read, the transaction holds the write lock from then on
+	takeTaskNumber         = "UPDATE PROJECTS SET NEXT_NUMBER = NEXT_NUMBER + 1 WHERE PROJECT_KEY = ?"
+	findTaskNumber         = "SELECT ID, NEXT_NUMBER - 1 FROM PROJECTS WHERE PROJECT_KEY = ?"
+	findArchivedTaskNumber = "SELECT ID, NEXT_NUMBER - 1, ARCHIVED_AT IS NOT NULL FROM PROJECTS WHERE PROJECT_KEY = ?"
+	findArchivedTask       = "SELECT P.PROJECT_KEY FROM TASKS T JOIN PROJECTS P ON P.ID = T.PROJECT_ID WHERE T.ID = ? AND P.ARCHIVED_AT IS NOT NULL"
+
+	tenantColumn = "TENANT_ID"
+
+	findTaskQuota = "SELECT MAX_TASKS, MAX_DETAILS_SIZE FROM TENANT_QUOTAS WHERE TENANT_ID = ?"
+)
+
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+type querier interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+}
+
+type dbtx interface {
+	execer
+	querier
+}
+
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// taskSchema is the layout of the TASKS table in a schema version. Until the cluster is migrated to the
+// typed timestamps the tasks have only their RFC 1123 creation date, they cannot be completed and their
+// update date is their creation date. Until it is migrated to the projects the tasks have no project, and
+// until it is migrated to the archived projects no project is archived. Until it is migrated to the tenants
+// every row belongs to the default tenant, afterwards the queries read and write the rows of the tenant only.
+type taskSchema struct {
+	version int
+	tenant  string
+}
+
+// readSchema reads the schema version of the cluster with db, the tenant of the request other than the
+// default one cannot be used before the cluster is migrated to the tenants.
+func readSchema(ctx context.Context, cluster *clusterSchema, db querier) (taskSchema, error) {
+	version, err := cluster.read(ctx, db, latestTaskSchemaVersion)
+	if err != nil {
+		return taskSchema{}, err
+	}
+	schema := taskSchema{version: version, tenant: domain.TenantOf(ctx)}
+	if !schema.tenants() && schema.tenant != domain.DefaultTenant {
+		return schema, notMigrated("tenants can be used", tenantsSchemaVersion)
+	}
+	return schema, nil
+}
+
+func (s taskSchema) typed() bool {
+	return s.version >= timestampsSchemaVersion
+}
+
+func (s taskSchema) projects() bool {
+	return s.version >= projectsSchemaVersion
+}
+
+func (s taskSchema) archiving() bool {
+	return s.version >= archivingSchemaVersion
+}
+
+func (s taskSchema) tenants() bool {
+	return s.version >= tenantsSchemaVersion
+}
+
+func (s taskSchema) quotas() bool {
+	return s.version >= quotasSchemaVersion
+}
+
+// scope restricts the query, which ends with a condition, to the rows of the tenant.
+func (s taskSchema) scope(query string, args ...interface{}) (string, []interface{}) {
+	return s.scopeColumn(tenantColumn, query, args...)
+}
+
+// scopeColumn is scope for the queries joining tables, column names the tenant of the rows to restrict.
+func (s taskSchema) scopeColumn(column string, query string, args ...interface{}) (string, []interface{}) {
+	if !s.tenants() {
+		return query, args
+	}
+	return query + " AND " + column + " = ?", append(args, s.tenant)
+}
+
+func (s taskSchema) columns() string {
+	columns := legacyColumns
+	if s.typed() {
+		columns += timestampColumns
+	}
+	if s.projects() {
+		columns += projectColumns
+	}
+	return columns
+}
+
+func (s taskSchema) selectTasks() string {
+	return "SELECT " + s.columns() + " FROM TASKS"
+}
+
+// insert stores the task of the tenant and returns it as stored. A task of a project gets the next number
+// of the project of the tenant, the numbers of a project are allocated one at a time by the transaction db.
+func (s taskSchema) insert(ctx context.Context, db dbtx, task *domain.Task) (*domain.Task, error) {
+	if task.Completed && !s.typed() {
+		return nil, notMigrated("tasks can be completed", timestampsSchemaVersion)
+	}
+	if task.Project != "" && !s.projects() {
+		return nil, notMigrated("tasks can belong to a project", projectsSchemaVersion)
+	}
+	stored := *task
+	stored.Key = ""
+	stored.CreatedAt = fromMillis(millis(task.CreatedAt))
+	stored.UpdatedAt = fromMillis(millis(task.UpdatedAt))
+	if task.CompletedAt != nil {
+		completedAt := fromMillis(millis(*task.CompletedAt))
+		stored.CompletedAt = &completedAt
+	}
+	args := []interface{}{nullId(task.Id), task.Title, task.Details, task.CreatedAt.In(time.Local).Format(domain.LegacyDateLayout)}
+	if s.typed() {
+		args = append(args, task.Completed, millis(task.CreatedAt), millis(task.UpdatedAt), nullMillis(task.CompletedAt))
+	}
+	if s.projects() {
+		var projectId sql.NullInt64
+		if task.Project != "" {
+			var number int64
+			query, args := s.scope(takeTaskNumber, task.Project)
+			result, err := db.ExecContext(ctx, query, args...)
+			if err != nil {
+				return nil, err
+			}
+			if affected, err := result.RowsAffected(); err != nil {
+				return nil, err
+			} else if affected == 0 {
+				return nil, domain.NewError(domain.ErrValidation, domain.CodeInvalidTask, "project "+task.Project+" does not exist", nil)
+			}
+			if !s.archiving() {
+				err = db.QueryRowContext(ctx, findTaskNumber, task.Project).Scan(&projectId, &number)
+			} else {
+				var archived bool
+				query, args := s.scope(findArchivedTaskNumber, task.Project)
+				err = db.QueryRowContext(ctx, query, args...).Scan(&projectId, &number, &archived)
+				if err == nil && archived {
+					return nil, projectArchived(task.Project)
+				}
+			}
+			if err != nil {
+				return nil, err
+			}
+			stored.Key = task.Project + "-" + strconv.FormatInt(number, 10)
+		}
+		args = append(args, projectId, nullString(stored.Key))
+	}
+	if err := s.withinQuota(ctx, db, task, true); err != nil {
+		return nil, err
+	}
+	columns := s.columns()
+	if s.tenants() {
+		columns += ", " + tenantColumn
+		args = append(args, s.tenant)
+	}
+	query := "INSERT INTO TASKS (" + columns + ") VALUES(" + strings.TrimSuffix(strings.Repeat("?,", len(args)), ",") + ")"
+	result, err := db.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	if stored.Id, err = result.LastInsertId(); err != nil {
+		return nil, err
+	}
+	return &stored, nil
+}
+
+// update stores the task of the tenant, a task becoming completed is completed at its update date.
+// The project and the key of a task do not change.
+func (s taskSchema) update(ctx context.Context, db dbtx, task *domain.Task) (sql.Result, error) {
+	if err := s.withinQuota(ctx, db, task, false); err != nil {
+		return nil, err
+	}
+	if !s.typed() {
+		if task.Completed {
+			return nil, notMigrated("tasks can be completed", timestampsSchemaVersion)
+		}
+		return db.ExecContext(ctx, legacyUpdate, task.Title, task.Details, task.Id)
+	}
+	query, args := s.scope(update, task.Title, task.Details, task.Completed, millis(task.UpdatedAt),
+		task.Completed, millis(task.UpdatedAt), task.Id)
+	return db.ExecContext(ctx, query, args...)
+}
+
+// delete removes the task of the tenant.
+func (s taskSchema) delete(ctx context.Context, db execer, id int64) (sql.Result, error) {
+	query, args := s.scope(deleteTask, id)
+	return db.ExecContext(ctx, query, args...)
+}
+
+// withinQuota fails when the details of the task are larger than the quota of the tenant allows, or when
+// the tenant adding the task already has as many tasks as its quota allows. The tasks are counted in the
+// transaction db inserting the task.
+func (s taskSchema) withinQuota(ctx context.Context, db querier, task *domain.Task, adding bool) error {
+	if !s.quotas() {
+		return nil
+	}
+	var maxTasks, maxDetailsSize sql.NullInt64
+	err := db.QueryRowContext(ctx, findTaskQuota, s.tenant).Scan(&maxTasks, &maxDetailsSize)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	}
+	if maxDetailsSize.Int64 > 0 && int64(len(task.Details)) > maxDetailsSize.Int64 {
+		return domain.NewError(domain.ErrValidation, domain.CodeDetailsTooLarge,
+			"the details of the tasks of tenant "+s.tenant+" have at most "+strconv.FormatInt(maxDetailsSize.Int64, 10)+" bytes", nil)
+	}
+	if !adding || maxTasks.Int64 <= 0 {
+		return nil
+	}
+	var tasks int64
+	if err = db.QueryRowContext(ctx, countTenantTasks, s.tenant).Scan(&tasks); err != nil {
+		return err
+	}
+	if tasks >= maxTasks.Int64 {
+		return domain.NewError(domain.ErrConflict, domain.CodeTaskQuotaExceeded,
+			"tenant "+s.tenant+" has reached its quota of "+strconv.FormatInt(maxTasks.Int64, 10)+" tasks", nil)
+	}
+	return nil
+}
+
+// writable fails with a conflict when the task belongs to an archived project.
+func (s taskSchema) writable(ctx context.Context, db querier, id int64) error {
+	if !s.archiving() {
+		return nil
+	}
+	var project string
+	query, args := s.scopeColumn("T."+tenantColumn, findArchivedTask, id)
+	err := db.QueryRowContext(ctx, query, args...).Scan(&project)
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil
+	case err != nil:
+		return err
+	}
+	return projectArchived(project)
+}
+
+// find returns the error of the driver as is, sql.ErrNoRows when the task does not exist or belongs to another tenant.
+func (s taskSchema) find(ctx context.Context, db querier, id int64) (*domain.Task, error) {
+	query, args := s.scope(s.selectTasks()+" WHERE ID = ?", id)
+	return s.scan(db.QueryRowContext(ctx, query, args...))
+}
+
+// findByKey returns sql.ErrNoRows when the task does not exist, or the cluster has no projects yet.
+func (s taskSchema) findByKey(ctx context.Context, db querier, key string) (*domain.Task, error) {
+	if !s.projects() {
+		return nil, sql.ErrNoRows
+	}
+	query, args := s.scope(s.selectTasks()+" WHERE TASK_KEY = ?", key)
+	return s.scan(db.QueryRowContext(ctx, query, args...))
+}
+
+// findAll selects the tasks in the database once the cluster has the typed timestamps, and filters them here before.
+// There are no tasks in a project before the cluster has the projects.
+func (s taskSchema) findAll(ctx context.Context, db querier, filter domain.TaskFilter) ([]domain.Task, error) {
+	if filter.ProjectId != 0 && !s.projects() {
+		return []domain.Task{}, nil
+	}
+	query, args := s.selectTasks()+orderById, []interface{}{}
+	if s.typed() {
+		query, args = s.filterQuery(filter)
+	}
+	rows, err := db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	tasks := []domain.Task{}
+	for rows.Next() {
+		task, err := s.scan(rows)
+		if err != nil {
+			return nil, err
+		}
+		if filter.Matches(task) {
+			tasks = append(tasks, *task)
+		}
+	}
+	return tasks, rows.Err()
+}
+
+// scan reads a task, the rows inserted by the binaries of the previous schemas have no timestamps.
+func (s taskSchema) scan(row scanner) (*domain.Task, error) {
+	var task domain.Task
+	var createdDate sql.NullString
+	var createdAt, updatedAt, completedAt, projectId sql.NullInt64
+	var key sql.NullString
+	dest := []interface{}{&task.Id, &task.Title, &task.Details, &createdDate}
+	if s.typed() {
+		dest = append(dest, &task.Completed, &createdAt, &updatedAt, &completedAt)
+	}
+	if s.projects() {
+		dest = append(dest, &projectId, &key)
+	}
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	if createdAt.Valid {
+		task.CreatedAt = fromMillis(createdAt.Int64)
+	} else {
+		task.CreatedAt, _ = domain.ParseLegacyDate(createdDate.String, time.Local)
+	}
+	task.UpdatedAt = task.CreatedAt
+	if updatedAt.Valid {
+		task.UpdatedAt = fromMillis(updatedAt.Int64)
+	}
+	if completedAt.Valid {
+		completed := fromMillis(completedAt.Int64)
+		task.CompletedAt = &completed
+	}
+	task.Key = key.String
+	task.Project, _ = domain.ProjectOfTaskKey(key.String)
+	return &task, nil
+}
+
+// filterQuery selects the tasks of the tenant in the ranges of the filter. The rows a binary of a previous
+// schema inserted after the migration have no timestamps, they are outside of every range.
+func (s taskSchema) filterQuery(filter domain.TaskFilter) (string, []interface{}) {
+	var conditions []string
+	var args []interface{}
+	if s.tenants() {
+		conditions = append(conditions, tenantColumn+" = ?")
+		args = append(args, s.tenant)
+	}
+	if filter.ProjectId != 0 {
+		conditions = append(conditions, "PROJECT_ID = ?")
+		args = append(args, filter.ProjectId)
+	}
+	bound := func(condition string, t time.Time) {
+		if !t.IsZero() {
+			conditions = append(conditions, condition)
+			args = append(args, millis(t))
+		}
+	}
+	bound("CREATED_AT >= ?", filter.CreatedAfter)
+	bound("CREATED_AT < ?", filter.CreatedBefore)
+	bound("UPDATED_AT >= ?", filter.UpdatedAfter)
+	bound("UPDATED_AT < ?", filter.UpdatedBefore)
+	bound("COMPLETED_AT >= ?", filter.CompletedAfter)
+	bound("COMPLETED_AT < ?", filter.CompletedBefore)
+	if len(conditions) == 0 {
+		return s.selectTasks() + orderById, args
+	}
+	return s.selectTasks() + " WHERE " + strings.Join(conditions, " AND ") + orderById, args
+}
+
+func nullId(id int64) sql.NullInt64 {
+	return sql.NullInt64{Int64: id, Valid: id != 0}
+}
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func millis(t time.Time) int64 {
+	return t.UnixNano() / int64(time.Millisecond)
+}
+
+func nullMillis(t *time.Time) sql.NullInt64 {
+	if t == nil {
+		return sql.NullInt64{}
+	}
+	return sql.NullInt64{Int64: millis(*t), Valid: true}
+}
+
+func fromMillis(ms int64) time.Time {
+	return time.Unix(0, ms*int64(time.Millisecond)).UTC()
+}
+
+func projectArchived(key string) error {
+	return domain.NewError(domain.ErrConflict, domain.CodeProjectArchived, "project "+key+" is archived", nil)
+}
+
+func notMigrated(what string, version int) error {
+	return domain.NewError(domain.ErrConflict, domain.CodeSchemaNotMigrated,
+		what+" once the cluster schema is migrated to version "+strconv.Itoa(version), nil)
+}
@@ -0,0 +1,203 @@
+package repository
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"strconv"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+const (
+	tenantColumns        = "ID, NAME, CREATED_AT"
+	insertTenant         = "INSERT INTO TENANTS (ID, NAME, TOKEN_HASH, CREATED_AT) VALUES (?, ?, ?, ?)"
+	selectTenants        = "SELECT " + tenantColumns + " FROM TENANTS"
+	countTenantTasks     = "SELECT COUNT(*) FROM TASKS WHERE TENANT_ID = ?"
+	countTenantProjects  = "SELECT COUNT(*) FROM PROJECTS WHERE TENANT_ID = ?"
+	deleteTenantTasks    = "DELETE FROM TASKS WHERE TENANT_ID = ?"
+	deleteTenantProjects = "DELETE FROM PROJECTS WHERE TENANT_ID = ?"
+	deleteTenantKeys     = "DELETE FROM IDEMPOTENCY_KEYS WHERE IDEMPOTENCY_KEY LIKE ?"
+	deleteTenant         = "DELETE FROM TENANTS WHERE ID = ?"
+	deleteTenantQuota    = "DELETE FROM TENANT_QUOTAS WHERE TENANT_ID = ?"
+	deleteTenantRequests = "DELETE FROM RATE_LIMITS WHERE TENANT_ID = ?"
+	orderByTenantId      = " ORDER BY ID"
+)
+
+type TenantRepositoryImpl struct {
+	db      *sql.DB
+	log     *applog.Logger
+	cluster clusterSchema
+}
+
+func NewTenantRepository(applog *applog.Logger, db *sql.DB) *TenantRepositoryImpl {
+	return &TenantRepositoryImpl{
+		db:  db,
+		log: applog,
+	}
+}
+
+// Add stores the tenant with the SHA-256 of its token, an id or a token already taken is a conflict.
+func (t *TenantRepositoryImpl) Add(ctx context.Context, tenant *domain.Tenant, tokenHash string) (*domain.Tenant, error) {
+	schema, err := readSchema(ctx, &t.cluster, t.db)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if !schema.tenants() {
+		return nil, notMigrated("tenants can be created", tenantsSchemaVersion)
+	}
+	stored := *tenant
+	stored.Token = ""
+	stored.CreatedAt = fromMillis(millis(tenant.CreatedAt))
+	if _, err = t.db.ExecContext(ctx, insertTenant, stored.Id, stored.Name, tokenHash, millis(stored.CreatedAt)); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	t.log.Log.Info("tenant inserted", zap.String("id", stored.Id))
+	return &stored, nil
+}
+
+// FindById returns the tenant, there are none before the schema is migrated.
+func (t *TenantRepositoryImpl) FindById(ctx context.Context, id string) (*domain.Tenant, error) {
+	tenant, err := t.findOne(ctx, " WHERE ID = ?", id)
+	if err != nil {
+		return nil, err
+	}
+	if tenant == nil {
+		return nil, tenantNotFound(id)
+	}
+	return tenant, nil
+}
+
+// FindByTokenHash returns the tenant the token of which has the SHA-256 tokenHash.
+func (t *TenantRepositoryImpl) FindByTokenHash(ctx context.Context, tokenHash string) (*domain.Tenant, error) {
+	tenant, err := t.findOne(ctx, " WHERE TOKEN_HASH = ?", tokenHash)
+	if err != nil {
+		return nil, err
+	}
+	if tenant == nil {
+		return nil, domain.NewError(domain.ErrNotFound, domain.CodeTenantNotFound, "no tenant has the token", nil)
+	}
+	return tenant, nil
+}
+
+// FindAll returns the tenants ordered by id, there are none before the schema is migrated.
+func (t *TenantRepositoryImpl) FindAll(ctx context.Context) (*[]domain.Tenant, error) {
+	tenants := []domain.Tenant{}
+	schema, err := readSchema(ctx, &t.cluster, t.db)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if !schema.tenants() {
+		return &tenants, nil
+	}
+	rows, err := t.db.QueryContext(ctx, selectTenants+orderByTenantId)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	defer rows.Close()
+	for rows.Next() {
+		tenant, err := scanTenant(rows)
+		if err != nil {
+			return nil, queryError(ctx, err)
+		}
+		tenants = append(tenants, *tenant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, queryError(ctx, err)
+	}
+	return &tenants, nil
+}
+
+// Delete removes the tenant with its idempotency keys and its quota, and its tasks and projects in the same
+// transaction when cascade is set.
+func (t *TenantRepositoryImpl) Delete(ctx context.Context, id string, cascade bool) error {
+	tx, err := t.db.BeginTx(ctx, nil)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	defer tx.Rollback()
+	schema, err := readSchema(ctx, &t.cluster, tx)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if !schema.tenants() {
+		return tenantNotFound(id)
+	}
+	var tasks, projects int64
+	if err = tx.QueryRowContext(ctx, countTenantTasks, id).Scan(&tasks); err != nil {
+		return queryError(ctx, err)
+	}
+	if err = tx.QueryRowContext(ctx, countTenantProjects, id).Scan(&projects); err != nil {
+		return queryError(ctx, err)
+	}
+	if tasks+projects > 0 && !cascade {
+		return domain.NewError(domain.ErrConflict, domain.CodeTenantNotEmpty, "tenant "+id+" has "+
+			strconv.FormatInt(tasks, 10)+" tasks and "+strconv.FormatInt(projects, 10)+" projects", nil)
+	}
+	result, err := tx.ExecContext(ctx, deleteTenant, id)
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	rowsAffected, err := result.RowsAffected()
+	if err != nil {
+		return queryError(ctx, err)
+	}
+	if rowsAffected == 0 {
+		return tenantNotFound(id)
+	}
+	if tasks+projects > 0 {
+		// the tasks first, they reference the projects
+		for _, statement := range []string{deleteTenantTasks, deleteTenantProjects} {
+			if _, err = tx.ExecContext(ctx, statement, id); err != nil {
+				return queryError(ctx, err)
+			}
+		}
+	}
+	if schema.quotas() {
+		for _, statement := range []string{deleteTenantQuota, deleteTenantRequests} {
+			if _, err = tx.ExecContext(ctx, statement, id); err != nil {
+				return queryError(ctx, err)
+			}
+		}
+	}
+	// the keys of the tenant are scoped with its id, a tenant created again with it replays none of them
+	if _, err = tx.ExecContext(ctx, deleteTenantKeys, domain.TenantIdempotencyKey(domain.WithTenant(ctx, id), "%")); err != nil {
+		return queryError(ctx, err)
+	}
+	if err = tx.Commit(); err != nil {
+		return queryError(ctx, err)
+	}
+	t.log.Log.Info("tenant deleted", zap.String("id", id), zap.Int64("tasks", tasks), zap.Int64("projects", projects))
+	return nil
+}
+
+// findOne returns the tenant matching the condition, nil when there is none.
+func (t *TenantRepositoryImpl) findOne(ctx context.Context, condition string, arg interface{}) (*domain.Tenant, error) {
+	schema, err := readSchema(ctx, &t.cluster, t.db)
+	if err != nil {
+		return nil, queryError(ctx, err)
+	}
+	if !schema.tenants() {
+		return nil, nil
+	}
+	tenant, err := scanTenant(t.db.QueryRowContext(ctx, selectTenants+condition, arg))
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return nil, nil
+	case err != nil:
+		return nil, queryError(ctx, err)
+	}
+	return tenant, nil
+}
+
+func scanTenant(row scanner) (*domain.Tenant, error) {
+	var tenant domain.Tenant
+	var createdAt int64
+	if err := row.Scan(&tenant.Id, &tenant.Name, &createdAt); err != nil {
+		return nil, err
+	}
+	tenant.CreatedAt = fromMillis(createdAt)
+	return &tenant, nil
+}
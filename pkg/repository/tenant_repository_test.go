@@ -0,0 +1,185 @@
+package repository
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	dqlite "github.com/canonical/go-dqlite/driver"
+	"github.com/stretchr/testify/assert"
+)
+
+var tenantRows = []string{"ID", "NAME", "CREATED_AT"}
+
+func TestSuccessfulTenantInsert(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 7)
+	mock.ExpectExec(regexp.QuoteMeta("INSERT INTO TENANTS (ID, NAME, TOKEN_HASH, CREATED_AT) VALUES (?, ?, ?, ?)")).
+		WithArgs("acme", "Acme", "hash", millis(created)).
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO TENANTS").
+		WithArgs("acme", "Acme", "other", millis(created)).
+		WillReturnError(dqlite.Error{Code: 1555, Message: "UNIQUE constraint failed: TENANTS.ID"})
+	repo := NewTenantRepository(applog.NewLogger(), db)
+
+	tenant, addErr := repo.Add(context.Background(), &domain.Tenant{Id: "acme", Name: "Acme", Token: "secret", CreatedAt: created}, "hash")
+	_, conflictErr := repo.Add(context.Background(), &domain.Tenant{Id: "acme", Name: "Acme", CreatedAt: created}, "other")
+
+	assert.Nil(addErr)
+	assert.Empty(tenant.Token)
+	assert.Equal(created, tenant.CreatedAt)
+	assert.True(errors.Is(conflictErr, domain.ErrConflict))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestSuccessfulTenantFind(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 7)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT ID, NAME, CREATED_AT FROM TENANTS WHERE TOKEN_HASH = ?")).
+		WithArgs("hash").
+		WillReturnRows(sqlmock.NewRows(tenantRows).AddRow("acme", "Acme", millis(created)))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT ID, NAME, CREATED_AT FROM TENANTS WHERE ID = ?")).
+		WithArgs("globex").
+		WillReturnRows(sqlmock.NewRows(tenantRows))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT ID, NAME, CREATED_AT FROM TENANTS ORDER BY ID")).
+		WillReturnRows(sqlmock.NewRows(tenantRows).AddRow("acme", "Acme", millis(created)))
+	repo := NewTenantRepository(applog.NewLogger(), db)
+
+	tenant, findErr := repo.FindByTokenHash(context.Background(), "hash")
+	_, missingErr := repo.FindById(context.Background(), "globex")
+	tenants, findAllErr := repo.FindAll(context.Background())
+
+	assert.Nil(findErr)
+	assert.Equal("acme", tenant.Id)
+	assert.Equal(domain.CodeTenantNotFound, missingErr.(*domain.Error).Code)
+	assert.Nil(findAllErr)
+	assert.Equal([]domain.Tenant{{Id: "acme", Name: "Acme", CreatedAt: created}}, *tenants)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailTenantBeforeTheMigration(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	expectSchema(mock, 5)
+	expectSchema(mock, 5)
+	expectSchema(mock, 5)
+	repo := NewTenantRepository(applog.NewLogger(), db)
+
+	_, addErr := repo.Add(context.Background(), &domain.Tenant{Id: "acme", Name: "Acme", CreatedAt: created}, "hash")
+	_, findErr := repo.FindByTokenHash(context.Background(), "hash")
+	tenants, findAllErr := repo.FindAll(context.Background())
+
+	assert.Equal(domain.CodeSchemaNotMigrated, addErr.(*domain.Error).Code)
+	assert.True(errors.Is(findErr, domain.ErrNotFound))
+	assert.Nil(findAllErr)
+	assert.Empty(*tenants)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestFailDeleteTenantWithTasks(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM TASKS WHERE TENANT_ID = ?")).
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM PROJECTS WHERE TENANT_ID = ?")).
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(1))
+	mock.ExpectRollback()
+	repo := NewTenantRepository(applog.NewLogger(), db)
+
+	deleteErr := repo.Delete(context.Background(), "acme", false)
+
+	assert.True(errors.Is(deleteErr, domain.ErrConflict))
+	assert.Equal(domain.CodeTenantNotEmpty, deleteErr.(*domain.Error).Code)
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
+
+func TestMustDeleteTenantWithItsTasks(t *testing.T) {
+	assert := assert.New(t)
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatalf("an error '%s' was not expected when opening a stub database connection", err)
+	}
+	defer db.Close()
+	mock.ExpectBegin()
+	expectSchema(mock, 7)
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM TASKS WHERE TENANT_ID = ?")).
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(2))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM PROJECTS WHERE TENANT_ID = ?")).
+		WithArgs("acme").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM TENANTS WHERE ID = ?")).
+		WithArgs("acme").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM TASKS WHERE TENANT_ID = ?")).
+		WithArgs("acme").
+		WillReturnResult(sqlmock.NewResult(0, 2))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM PROJECTS WHERE TENANT_ID = ?")).
+		WithArgs("acme").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM TENANT_QUOTAS WHERE TENANT_ID = ?")).
+		WithArgs("acme").
+		WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM RATE_LIMITS WHERE TENANT_ID = ?")).
+		WithArgs("acme").
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM IDEMPOTENCY_KEYS WHERE IDEMPOTENCY_KEY LIKE ?")).
+		WithArgs("acme/%").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectCommit()
+	mock.ExpectBegin()
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM TASKS WHERE TENANT_ID = ?")).
+		WithArgs("globex").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(0))
+	mock.ExpectQuery(regexp.QuoteMeta("SELECT COUNT(*) FROM PROJECTS WHERE TENANT_ID = ?")).
+		WithArgs("globex").
+		WillReturnRows(sqlmock.NewRows([]string{"COUNT"}).AddRow(0))
+	mock.ExpectExec(regexp.QuoteMeta("DELETE FROM TENANTS WHERE ID = ?")).
+		WithArgs("globex").
+		WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectRollback()
+	repo := NewTenantRepository(applog.NewLogger(), db)
+
+	deleteErr := repo.Delete(context.Background(), "acme", true)
+	missingErr := repo.Delete(context.Background(), "globex", true)
+
+	assert.Nil(deleteErr)
+	assert.True(errors.Is(missingErr, domain.ErrNotFound))
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Errorf("there were unfulfilled expectations: %s", err)
+	}
+}
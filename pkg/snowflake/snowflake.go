@@ -0,0 +1,72 @@
+// Package snowflake generates 63 bits ids sorted by creation time. An id holds the milliseconds since Epoch,
+// then the node which generated it, then a sequence number distinguishing the ids of the same millisecond.
+package snowflake
+
+import (
+	"sync"
+	"time"
+)
+
+const (
+	NodeBits     = 10
+	SequenceBits = 12
+
+	maxNode     = 1<<NodeBits - 1
+	maxSequence = 1<<SequenceBits - 1
+	timeShift   = NodeBits + SequenceBits
+)
+
+// Epoch is the time of the ids whose time bits are zero.
+var Epoch = time.Date(2021, 1, 1, 0, 0, 0, 0, time.UTC)
+
+// Generator generates the ids of a node, it is safe for concurrent use.
+type Generator struct {
+	mu       sync.Mutex
+	node     int64
+	last     int64
+	sequence int64
+	now      func() time.Time
+}
+
+// NewGenerator generates the ids of the node, only the NodeBits low bits of the node are kept. Every
+// generator of a cluster needs its own node number, the dqlite id of a node does not fit in the node bits.
+func NewGenerator(node uint64) *Generator {
+	return &Generator{
+		node: int64(node & maxNode),
+		now:  time.Now,
+	}
+}
+
+// Node returns the node bits of the ids of the generator.
+func (g *Generator) Node() int64 {
+	return g.node
+}
+
+// Next returns an id greater than all the ids the generator returned before. When the clock goes back,
+// or the sequence of the millisecond is exhausted, the ids keep the time of the last id, then the next millisecond.
+func (g *Generator) Next() int64 {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	millis := g.now().Sub(Epoch).Milliseconds()
+	switch {
+	case millis > g.last:
+		g.last = millis
+		g.sequence = 0
+	case g.sequence < maxSequence:
+		g.sequence++
+	default:
+		g.last++
+		g.sequence = 0
+	}
+	return g.last<<timeShift | g.node<<SequenceBits | g.sequence
+}
+
+// Time returns when the id was generated, at the millisecond.
+func Time(id int64) time.Time {
+	return Epoch.Add(time.Duration(id>>timeShift) * time.Millisecond).UTC()
+}
+
+// Valid tells whether the id was generated after Epoch and up to skew after now.
+func Valid(id int64, now time.Time, skew time.Duration) bool {
+	return id>>timeShift > 0 && !Time(id).After(now.Add(skew))
+}
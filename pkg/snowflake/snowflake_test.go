@@ -0,0 +1,73 @@
+package snowflake
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func fixedGenerator(node uint64, now *time.Time) *Generator {
+	g := NewGenerator(node)
+	g.now = func() time.Time { return *now }
+	return g
+}
+
+func TestMustGenerateSortedIds(t *testing.T) {
+	now := Epoch.Add(time.Hour)
+	g := fixedGenerator(3, &now)
+
+	first := g.Next()
+	second := g.Next()
+	now = now.Add(time.Millisecond)
+	third := g.Next()
+
+	assert.Less(t, first, second)
+	assert.Less(t, second, third)
+	assert.Equal(t, Epoch.Add(time.Hour), Time(first))
+	assert.Equal(t, int64(3), first>>SequenceBits&maxNode)
+	assert.Equal(t, int64(1), second&maxSequence)
+}
+
+func TestMustKeepTheNodeLowBits(t *testing.T) {
+	assert.Equal(t, int64(0x1be), NewGenerator(0x2dc171858c3155be).Node())
+}
+
+func TestMustNotGoBackWithTheClock(t *testing.T) {
+	now := Epoch.Add(time.Hour)
+	g := fixedGenerator(1, &now)
+
+	first := g.Next()
+	now = now.Add(-time.Second)
+	second := g.Next()
+
+	assert.Less(t, first, second)
+	assert.Equal(t, Time(first), Time(second))
+}
+
+func TestMustBorrowTheNextMillisecondWhenTheSequenceIsExhausted(t *testing.T) {
+	now := Epoch.Add(time.Hour)
+	g := fixedGenerator(1, &now)
+
+	var last int64
+	for i := 0; i <= maxSequence; i++ {
+		last = g.Next()
+	}
+	next := g.Next()
+
+	assert.Less(t, last, next)
+	assert.Equal(t, now.Add(time.Millisecond), Time(next))
+	assert.Equal(t, int64(0), next&maxSequence)
+}
+
+func TestMustValidateIds(t *testing.T) {
+	now := Epoch.Add(time.Hour)
+	g := fixedGenerator(1, &now)
+	id := g.Next()
+
+	assert.True(t, Valid(id, now, 0))
+	assert.False(t, Valid(id, now.Add(-time.Minute), time.Second))
+	// the ids of the rows inserted before the snowflake ids
+	assert.False(t, Valid(42, now, 0))
+	assert.False(t, Valid(-id, now, 0))
+}
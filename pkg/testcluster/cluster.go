@@ -0,0 +1,402 @@
+// Package testcluster starts a cluster of bopbag nodes in the test process, on loopback addresses
+// and temporary data directories, so that replication and failover can be tested end to end.
+package testcluster
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"runtime"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/balchua/bopbag/pkg/infrastructure"
+	"github.com/balchua/bopbag/pkg/repository"
+	"github.com/balchua/bopbag/pkg/snowflake"
+	"github.com/balchua/bopbag/pkg/usecase"
+)
+
+// Option tweaks the cluster started by New.
+type Option func(*config)
+
+type config struct {
+	certsPath string
+	policies  usecase.RetryPolicies
+	dqlite    []infrastructure.Option
+}
+
+// WithTLS starts the nodes with the certificates found in certsPath, instead of a certificate of their own.
+// The nodes sharing a certificate cannot be partitioned.
+func WithTLS(certsPath string) Option {
+	return func(c *config) {
+		c.certsPath = certsPath
+	}
+}
+
+// WithRetryPolicies sets how the task service of every node retries the failed operations.
+func WithRetryPolicies(policies usecase.RetryPolicies) Option {
+	return func(c *config) {
+		c.policies = policies
+	}
+}
+
+// WithDqliteOptions passes options to every dqlite node.
+func WithDqliteOptions(opts ...infrastructure.Option) Option {
+	return func(c *config) {
+		c.dqlite = append(c.dqlite, opts...)
+	}
+}
+
+// Node is a member of the test cluster, its services are nil while it is not running.
+type Node struct {
+	Index   int
+	Address string
+	Dir     string
+	// Faults gates the connections of the node with its peers, it outlives restarts.
+	Faults *usecase.FaultService
+	certs  string
+
+	Dqlite         *infrastructure.Dqlite
+	ClusterRepo    *repository.ClusterRepository
+	TaskService    *usecase.TaskService
+	ClusterService *usecase.ClusterService
+}
+
+// Running tells whether the node is started.
+func (n *Node) Running() bool {
+	return n.Dqlite != nil
+}
+
+// Cluster is a set of nodes started in the test process.
+type Cluster struct {
+	t        testing.TB
+	log      *applog.Logger
+	cfg      config
+	mu       sync.Mutex
+	nodes    []*Node
+	isolated map[int]bool
+}
+
+// New starts a cluster of n nodes, the first one bootstraps it and the others join it.
+// The cluster is shut down and its data directories are removed when the test ends.
+func New(t testing.TB, n int, opts ...Option) *Cluster {
+	t.Helper()
+	cfg := config{
+		policies: usecase.DefaultRetryPolicies(),
+		dqlite:   []infrastructure.Option{infrastructure.WithRolesAdjustmentFrequency(time.Second)},
+	}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+
+	c := &Cluster{
+		t:   t,
+		log: applog.NewLogger(),
+		cfg: cfg,
+	}
+	t.Cleanup(c.Close)
+
+	var ca *authority
+	if cfg.certsPath == "" {
+		var err error
+		if ca, err = newAuthority(); err != nil {
+			t.Fatalf("unable to create the certificate authority: %v", err)
+		}
+	}
+	for i := 0; i < n; i++ {
+		address := freeAddress(t, i)
+		node := &Node{
+			Index:   i,
+			Address: address,
+			Faults:  usecase.NewFaultService(c.log),
+			certs:   cfg.certsPath,
+		}
+		dir, err := ioutil.TempDir("", "bopbag-node")
+		if err != nil {
+			t.Fatalf("unable to create the data directory of node %d: %v", i, err)
+		}
+		node.Dir = dir
+		c.nodes = append(c.nodes, node)
+		if ca != nil {
+			if node.certs, err = ioutil.TempDir("", "bopbag-certs"); err != nil {
+				t.Fatalf("unable to create the certificates directory of node %d: %v", i, err)
+			}
+			host, _, _ := net.SplitHostPort(address)
+			if err := ca.writeCerts(node.certs, int64(i+2), net.ParseIP(host)); err != nil {
+				t.Fatalf("unable to create the certificate of node %d: %v", i, err)
+			}
+		}
+
+		var join []string
+		opts := []infrastructure.Option{infrastructure.WithBootstrap()}
+		if i > 0 {
+			join, opts = []string{c.nodes[0].Address}, nil
+		}
+		if err := c.start(node, join, opts...); err != nil {
+			t.Fatalf("unable to start node %d: %v", i, err)
+		}
+	}
+	return c
+}
+
+// freeAddress gives every node its own loopback host, since the peer faults match the peers by host.
+// Only linux routes the whole 127.0.0.0/8 to the loopback interface, the test is skipped elsewhere.
+func freeAddress(t testing.TB, i int) string {
+	t.Helper()
+	if runtime.GOOS != "linux" {
+		t.Skipf("the nodes need loopback addresses other than 127.0.0.1, which %s does not have by default", runtime.GOOS)
+	}
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.%d:0", i+2))
+	if err != nil {
+		t.Skipf("unable to listen on a loopback address of node %d: %v", i, err)
+	}
+	defer listener.Close()
+	return listener.Addr().String()
+}
+
+func (c *Cluster) start(node *Node, join []string, extra ...infrastructure.Option) error {
+	opts := append([]infrastructure.Option{infrastructure.WithPeerGate(node.Faults)}, c.cfg.dqlite...)
+	opts = append(opts, extra...)
+	dqlite, err := infrastructure.NewDqlite(c.log, node.Dir, node.Address, join, true, node.certs, opts...)
+	if err != nil {
+		return err
+	}
+	taskRepo, err := repository.NewTaskRepository(c.log, dqlite.DB())
+	if err != nil {
+		dqlite.Kill()
+		return err
+	}
+	snowflakeNode, err := repository.NewSnowflakeNodeRepository(c.log, dqlite.DB()).Allocate(dqlite.NodeID())
+	if err != nil {
+		dqlite.Kill()
+		return err
+	}
+	clusterRepo := repository.NewClusterRepository(dqlite)
+
+	node.Dqlite = dqlite
+	node.ClusterRepo = clusterRepo
+	node.TaskService = usecase.NewTaskService(taskRepo, snowflake.NewGenerator(uint64(snowflakeNode)), c.cfg.policies, c.log)
+	node.ClusterService = usecase.NewClusterService(clusterRepo, repository.NewNodeRegistryRepository(c.log, dqlite.DB()), c.log)
+	return nil
+}
+
+func (c *Cluster) stopped(node *Node) {
+	node.Dqlite = nil
+	node.ClusterRepo = nil
+	node.TaskService = nil
+	node.ClusterService = nil
+}
+
+// Node returns the i-th node.
+func (c *Cluster) Node(i int) *Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.nodes[i]
+}
+
+// Nodes returns every node, running or not.
+func (c *Cluster) Nodes() []*Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return append([]*Node(nil), c.nodes...)
+}
+
+// Running returns the nodes which are started.
+func (c *Cluster) Running() []*Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	running := make([]*Node, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		if node.Running() {
+			running = append(running, node)
+		}
+	}
+	return running
+}
+
+// Kill stops the i-th node abruptly, without handing over its leadership.
+func (c *Cluster) Kill(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node := c.nodes[i]
+	if !node.Running() {
+		return
+	}
+	node.Dqlite.Kill()
+	c.stopped(node)
+}
+
+// Stop shuts the i-th node down gracefully, handing over its leadership first.
+func (c *Cluster) Stop(i int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node := c.nodes[i]
+	if !node.Running() {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+
+	defer cancel()
+	node.Dqlite.Shutdown(ctx)
+	c.stopped(node)
+}
+
+// Restart starts the i-th node again with its data directory, it fails the test if the node does not start.
+func (c *Cluster) Restart(i int) {
+	c.t.Helper()
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	node := c.nodes[i]
+	if node.Running() {
+		return
+	}
+	if err := c.start(node, nil); err != nil {
+		c.t.Fatalf("unable to restart node %d: %v", i, err)
+	}
+}
+
+// Partition cuts the given nodes off the rest of the cluster while every node keeps running.
+//
+// Each side drops the connections the other one opens with it, the nodes tell their peers apart by the
+// IP of their certificates. Every raft connection is accepted by one side, so the cut goes both ways.
+// Heal lets the nodes reconnect.
+func (c *Cluster) Partition(indexes ...int) {
+	c.t.Helper()
+	if c.cfg.certsPath != "" {
+		c.t.Fatalf("the nodes sharing the certificates of %s cannot be partitioned", c.cfg.certsPath)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isolated = make(map[int]bool)
+	for _, i := range indexes {
+		c.isolated[i] = true
+	}
+	for _, node := range c.nodes {
+		for _, peer := range c.nodes {
+			if c.isolated[node.Index] == c.isolated[peer.Index] {
+				continue
+			}
+			fault := &domain.Fault{Kind: domain.FaultPeer, Peer: peer.Address, Action: domain.PeerDrop, Duration: "1h"}
+			if _, err := node.Faults.Inject(fault); err != nil {
+				c.t.Fatalf("unable to cut node %d off node %d: %v", node.Index, peer.Index, err)
+			}
+		}
+	}
+}
+
+// Heal lets the nodes cut apart by Partition reconnect.
+func (c *Cluster) Heal() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.isolated = nil
+	for _, node := range c.nodes {
+		node.Faults.ClearAll()
+	}
+}
+
+// Leader returns the running node the cluster currently considers the leader.
+// While the cluster is partitioned, only the nodes which are not isolated are asked.
+func (c *Cluster) Leader() (*Node, error) {
+	running := c.reachable()
+	if len(running) == 0 {
+		return nil, fmt.Errorf("no node is running")
+	}
+	var lastErr error
+	for _, node := range running {
+		address, err := node.ClusterRepo.FindLeader()
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		for _, candidate := range running {
+			if candidate.Address == address {
+				return candidate, nil
+			}
+		}
+		lastErr = fmt.Errorf("leader %s is not running or isolated", address)
+	}
+	return nil, lastErr
+}
+
+// reachable returns the running nodes which are not isolated by a partition.
+func (c *Cluster) reachable() []*Node {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	reachable := make([]*Node, 0, len(c.nodes))
+	for _, node := range c.nodes {
+		if node.Running() && !c.isolated[node.Index] {
+			reachable = append(reachable, node)
+		}
+	}
+	return reachable
+}
+
+// WaitForLeader waits until a running node is elected leader, it fails the test after the timeout.
+func (c *Cluster) WaitForLeader(timeout time.Duration) *Node {
+	c.t.Helper()
+	deadline := time.Now().Add(timeout)
+	for {
+		leader, err := c.Leader()
+		if err == nil {
+			return leader
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("no leader elected within %s: %v", timeout, err)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// WaitForVoters waits until the leader reports n voters, dqlite promotes the joining nodes in the background.
+func (c *Cluster) WaitForVoters(n int, timeout time.Duration) {
+	c.t.Helper()
+	deadline := time.Now().Add(timeout)
+	voters := 0
+	for {
+		if leader, err := c.Leader(); err == nil {
+			voters = countVoters(leader)
+		}
+		if voters >= n {
+			return
+		}
+		if time.Now().After(deadline) {
+			c.t.Fatalf("only %d voters out of %d within %s", voters, n, timeout)
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+func countVoters(node *Node) int {
+	clusterInfoInBytes, err := node.ClusterRepo.ClusterInfo()
+	if err != nil {
+		return 0
+	}
+	members := make([]domain.ClusterInfo, 0)
+	if err := json.Unmarshal(clusterInfoInBytes, &members); err != nil {
+		return 0
+	}
+	voters := 0
+	for _, member := range members {
+		if member.Role == domain.RoleVoter {
+			voters++
+		}
+	}
+	return voters
+}
+
+// Close kills every node and removes the data directories.
+func (c *Cluster) Close() {
+	for _, node := range c.Nodes() {
+		c.Kill(node.Index)
+		os.RemoveAll(node.Dir)
+		if node.certs != c.cfg.certsPath {
+			os.RemoveAll(node.certs)
+		}
+	}
+}
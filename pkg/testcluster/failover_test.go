@@ -0,0 +1,112 @@
+package testcluster
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLeaderFailoverDuringWrites(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a dqlite cluster")
+	}
+	cluster := New(t, 3)
+	cluster.WaitForVoters(3, time.Minute)
+	leader := cluster.WaitForLeader(time.Minute)
+
+	var writer *Node
+	for _, node := range cluster.Running() {
+		if node != leader {
+			writer = node
+			break
+		}
+	}
+	taskService := writer.TaskService
+
+	var mu sync.Mutex
+	acknowledged := make([]int64, 0)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			task := &domain.Task{Title: fmt.Sprintf("task %d", i), Details: "written during a leader failover"}
+			created, err := taskService.CreateTask(context.Background(), task)
+			if err != nil {
+				time.Sleep(10 * time.Millisecond)
+				continue
+			}
+			mu.Lock()
+			acknowledged = append(acknowledged, created.Id)
+			mu.Unlock()
+		}
+	}()
+
+	time.Sleep(time.Second)
+	mu.Lock()
+	beforeFailover := len(acknowledged)
+	mu.Unlock()
+	cluster.Kill(leader.Index)
+
+	newLeader := cluster.WaitForLeader(time.Minute)
+	assert.NotEqual(t, leader.Address, newLeader.Address)
+	time.Sleep(2 * time.Second)
+	close(stop)
+	<-done
+
+	assert.Greater(t, beforeFailover, 0, "no write before the failover")
+	assert.Greater(t, len(acknowledged), beforeFailover, "no write after the failover")
+
+	cluster.Restart(leader.Index)
+	cluster.WaitForLeader(time.Minute)
+	for _, node := range cluster.Running() {
+		tasks, err := node.TaskService.GetAllTasks(context.Background(), domain.TaskFilter{})
+		if !assert.Nil(t, err) {
+			continue
+		}
+		stored := make(map[int64]bool)
+		for _, task := range *tasks {
+			stored[task.Id] = true
+		}
+		for _, id := range acknowledged {
+			assert.Truef(t, stored[id], "task %d acknowledged but not found on node %d", id, node.Index)
+		}
+	}
+}
+
+func TestPartitionedMinorityKeepsMajorityAvailable(t *testing.T) {
+	if testing.Short() {
+		t.Skip("starts a dqlite cluster")
+	}
+	cluster := New(t, 3)
+	cluster.WaitForVoters(3, time.Minute)
+	leader := cluster.WaitForLeader(time.Minute)
+
+	cluster.Partition(leader.Index)
+	newLeader := cluster.WaitForLeader(time.Minute)
+	// the cut goes both ways, the isolated node reaches no majority to write
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, isolatedErr := cluster.Node(leader.Index).TaskService.CreateTask(ctx, &domain.Task{Title: "isolated", Details: "written by the minority"})
+	assert.NotNil(t, isolatedErr)
+	created, err := newLeader.TaskService.CreateTask(context.Background(), &domain.Task{Title: "partition", Details: "written by the majority"})
+	if !assert.Nil(t, err) {
+		return
+	}
+
+	cluster.Heal()
+	cluster.WaitForLeader(time.Minute)
+	task, err := cluster.Node(leader.Index).TaskService.GetTaskById(context.Background(), created.Id)
+	assert.Nil(t, err)
+	assert.Equal(t, "partition", task.Title)
+}
@@ -2,6 +2,9 @@ package usecase
 
 import (
 	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
 
 	"github.com/balchua/bopbag/pkg/applog"
 	"github.com/balchua/bopbag/pkg/domain"
@@ -9,12 +12,14 @@ import (
 
 type ClusterService struct {
 	clusterRepo domain.ClusterRepository
+	nodeRepo    domain.NodeRegistryRepository
 	logger      *applog.Logger
 }
 
-func NewClusterService(clusterRepo domain.ClusterRepository, logger *applog.Logger) *ClusterService {
+func NewClusterService(clusterRepo domain.ClusterRepository, nodeRepo domain.NodeRegistryRepository, logger *applog.Logger) *ClusterService {
 	return &ClusterService{
 		clusterRepo: clusterRepo,
+		nodeRepo:    nodeRepo,
 		logger:      logger,
 	}
 }
@@ -32,20 +37,173 @@ func (c *ClusterService) GetClusterInfo() ([]domain.ClusterInfo, error) {
 	if err := json.Unmarshal(clusterInfoInBytes, &clusterInfo); err != nil {
 		return nil, err
 	}
+	rolesInBytes, err := c.clusterRepo.RolesConfig()
+	if err != nil {
+		return nil, err
+	}
+	var roles domain.RolesConfig
+	if err := json.Unmarshal(rolesInBytes, &roles); err != nil {
+		return nil, err
+	}
+	registered := c.registeredNodes()
+	self := c.clusterRepo.LocalAddress()
 	for i, info := range clusterInfo {
 		c.logger.Log.Sugar().Infof("Nodes %s, leader %s", info.Address, leader)
 		if info.Address == leader {
 			clusterInfo[i].Leader = true
 		}
+		if info.Address == self {
+			clusterInfo[i].Roles = &roles
+		}
+		if node, ok := registered[info.Address]; ok {
+			clusterInfo[i].FailureDomainName = node.FailureDomain
+			clusterInfo[i].ApiUrl = node.ApiUrl
+			clusterInfo[i].Version = node.Version
+			clusterInfo[i].Hostname = node.Hostname
+			clusterInfo[i].StartTime = node.StartTime
+			clusterInfo[i].Heartbeat = node.Heartbeat
+			clusterInfo[i].SchemaVersion = node.SchemaVersion
+		}
 	}
 	return clusterInfo, nil
 }
 
-func (c *ClusterService) RemoveNode(address string) (string, error) {
-	removedNode, err := c.clusterRepo.RemoveNode(address)
+// registeredNodes returns the NODES table by dqlite address, the cluster info is still reported without it
+// when the table cannot be read.
+func (c *ClusterService) registeredNodes() map[string]domain.NodeMetadata {
+	registered := make(map[string]domain.NodeMetadata)
+	nodes, err := c.nodeRepo.FindAll()
+	if err != nil {
+		c.logger.Log.Sugar().Warnf("unable to read the node registry %v", err)
+		return registered
+	}
+	for _, node := range *nodes {
+		registered[node.Address] = node
+	}
+	return registered
+}
+
+// RemoveNode removes the node matching the ID or address of the request from the cluster.
+// The removal is refused when it would remove the leader or leave the voters without a quorum, unless it is forced.
+// A dry run only reports the impact of the removal.
+func (c *ClusterService) RemoveNode(request domain.RemovalRequest) (*domain.RemovalReport, error) {
+	clusterInfoInBytes, err := c.clusterRepo.ClusterInfo()
 	if err != nil {
-		return "", err
+		return nil, err
+	}
+	members := make([]domain.ClusterInfo, 0)
+	if err := json.Unmarshal(clusterInfoInBytes, &members); err != nil {
+		return nil, err
+	}
+	leader, err := c.clusterRepo.FindLeader()
+	if err != nil {
+		return nil, err
 	}
 
-	return removedNode, nil
+	target := findMember(members, request.Node)
+	if target == nil {
+		return nil, fmt.Errorf("%w: %s", domain.ErrNodeNotFound, request.Node)
+	}
+	report := c.analyseRemoval(*target, members, leader)
+	report.DryRun = request.DryRun
+	if request.DryRun {
+		return report, nil
+	}
+	if !report.Safe && !request.Force {
+		return report, fmt.Errorf("%w of %s: %s", domain.ErrUnsafeRemoval, target.Address, strings.Join(report.Reasons, ", "))
+	}
+
+	if err := c.clusterRepo.RemoveNodeById(target.ID); err != nil {
+		c.logger.Log.Sugar().Errorf("unable to remove node %s %v", target.Address, err)
+		return report, err
+	}
+	c.logger.Log.Sugar().Infof("node %s removed, forced %t", target.Address, request.Force)
+	report.Removed = true
+	return report, nil
+}
+
+func findMember(members []domain.ClusterInfo, node string) *domain.ClusterInfo {
+	id, err := strconv.ParseUint(node, 10, 64)
+	for i, member := range members {
+		if member.Address == node || (err == nil && member.ID == id) {
+			return &members[i]
+		}
+	}
+	return nil
+}
+
+// analyseRemoval computes the voters and fault tolerance of the cluster before and after the removal of target.
+// The fault tolerance is the number of online voters the cluster can still lose while keeping a quorum.
+func (c *ClusterService) analyseRemoval(target domain.ClusterInfo, members []domain.ClusterInfo, leader string) *domain.RemovalReport {
+	self := c.clusterRepo.LocalAddress()
+	voters, online := 0, 0
+	targetOnline := false
+	for _, member := range members {
+		if member.Role != domain.RoleVoter {
+			continue
+		}
+		voters++
+		up := member.Address == self || c.clusterRepo.ProbeNode(member.Address) == nil
+		if up {
+			online++
+		}
+		if member.ID == target.ID {
+			targetOnline = up
+		}
+	}
+
+	report := &domain.RemovalReport{
+		ID:                   target.ID,
+		Address:              target.Address,
+		Role:                 target.Role,
+		Leader:               target.Address == leader,
+		VotersBefore:         voters,
+		VotersAfter:          voters,
+		OnlineVotersAfter:    online,
+		FaultToleranceBefore: faultTolerance(voters, online),
+	}
+	if target.Role == domain.RoleVoter {
+		report.VotersAfter--
+		if targetOnline {
+			report.OnlineVotersAfter--
+		}
+	}
+	report.FaultToleranceAfter = faultTolerance(report.VotersAfter, report.OnlineVotersAfter)
+
+	if report.Leader {
+		report.Reasons = append(report.Reasons, "node is the leader, hand over the leadership first")
+	}
+	if report.VotersAfter == 0 {
+		report.Reasons = append(report.Reasons, "no voter would be left")
+	} else if report.FaultToleranceAfter < 0 {
+		report.Reasons = append(report.Reasons, fmt.Sprintf("%d online voters out of %d would not form a quorum",
+			report.OnlineVotersAfter, report.VotersAfter))
+	}
+	report.Safe = len(report.Reasons) == 0
+	return report
+}
+
+func faultTolerance(voters int, online int) int {
+	if voters == 0 {
+		return -1
+	}
+	return online - (voters/2 + 1)
+}
+
+// HandoverLeadership transfers the leadership and voting rights of this node to other nodes.
+func (c *ClusterService) HandoverLeadership() error {
+	if err := c.clusterRepo.Handover(); err != nil {
+		c.logger.Log.Sugar().Errorf("unable to hand over the leadership %v", err)
+		return err
+	}
+	return nil
+}
+
+// LeaveCluster removes this node from the cluster, typically right before it is shut down for good.
+func (c *ClusterService) LeaveCluster() error {
+	if err := c.clusterRepo.Leave(); err != nil {
+		c.logger.Log.Sugar().Errorf("unable to leave the cluster %v", err)
+		return err
+	}
+	return nil
 }
@@ -1,10 +1,12 @@
 package usecase
 
 import (
+	"errors"
 	"fmt"
 	"testing"
 
 	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -18,9 +20,9 @@ func (m *MockClusterRepository) ClusterInfo() ([]byte, error) {
 	return args.Get(0).([]byte), args.Error(1)
 }
 
-func (m *MockClusterRepository) RemoveNode(address string) (string, error) {
-	args := m.Called(address)
-	return args.String(0), args.Error(1)
+func (m *MockClusterRepository) RolesConfig() ([]byte, error) {
+	args := m.Called()
+	return args.Get(0).([]byte), args.Error(1)
 }
 
 func (m *MockClusterRepository) FindLeader() (string, error) {
@@ -28,6 +30,51 @@ func (m *MockClusterRepository) FindLeader() (string, error) {
 	return args.String(0), args.Error(1)
 }
 
+func (m *MockClusterRepository) Leave() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockClusterRepository) LocalAddress() string {
+	args := m.Called()
+	return args.String(0)
+}
+
+func (m *MockClusterRepository) ProbeNode(address string) error {
+	args := m.Called(address)
+	return args.Error(0)
+}
+
+func (m *MockClusterRepository) AssignRole(id uint64, role uint8) error {
+	args := m.Called(id, role)
+	return args.Error(0)
+}
+
+func (m *MockClusterRepository) RemoveNodeById(id uint64) error {
+	args := m.Called(id)
+	return args.Error(0)
+}
+
+func (m *MockClusterRepository) Handover() error {
+	args := m.Called()
+	return args.Error(0)
+}
+
+func (m *MockClusterRepository) SchemaVersion() (int, error) {
+	args := m.Called()
+	return args.Int(0), args.Error(1)
+}
+
+func (m *MockClusterRepository) SupportedSchemaVersion() int {
+	args := m.Called()
+	return args.Int(0)
+}
+
+func (m *MockClusterRepository) MigrateSchema(from int, to int) error {
+	args := m.Called(from, to)
+	return args.Error(0)
+}
+
 func TestMustSuccessfullyReturnClusterInfo(t *testing.T) {
 	logger := applog.NewLogger()
 	mockClusterRepo := new(MockClusterRepository)
@@ -50,12 +97,58 @@ func TestMustSuccessfullyReturnClusterInfo(t *testing.T) {
 	mockClusterRepo.On("ClusterInfo").Return(data, nil)
 	leaderAddress := "norse:9000"
 	mockClusterRepo.On("FindLeader").Return(leaderAddress, nil)
+	mockClusterRepo.On("RolesConfig").Return([]byte(`{"Voters": 3, "StandBys": 1, "FailureDomain": "zone-a"}`), nil)
+	mockClusterRepo.On("LocalAddress").Return("norse:9001")
+	mockNodeRepo := new(MockNodeRegistryRepository)
+	nodes := []domain.NodeMetadata{
+		{Address: "norse:9000", ApiUrl: "http://norse:8000", Version: "v0.2.0", FailureDomain: "zone-b"},
+		{Address: "norse:9001", ApiUrl: "http://norse:8001", Version: "v0.3.0", FailureDomain: "zone-a"},
+	}
+	mockNodeRepo.On("FindAll").Return(&nodes, nil)
 
-	service := NewClusterService(mockClusterRepo, logger)
+	service := NewClusterService(mockClusterRepo, mockNodeRepo, logger)
 
 	response, _ := service.GetClusterInfo()
 	assert.NotNil(t, response)
 	assert.Equal(t, 2, len(response))
+	assert.Nil(t, response[0].Roles)
+	assert.Equal(t, 3, response[1].Roles.Voters)
+	assert.Equal(t, "zone-a", response[1].Roles.FailureDomain)
+	assert.Equal(t, "http://norse:8000", response[0].ApiUrl)
+	assert.Equal(t, "v0.3.0", response[1].Version)
+	assert.Equal(t, "zone-b", response[0].FailureDomainName)
+}
+
+func TestMustReturnClusterInfoWithoutRegistry(t *testing.T) {
+	logger := applog.NewLogger()
+	mockClusterRepo := new(MockClusterRepository)
+	mockClusterRepo.On("ClusterInfo").Return([]byte(`[{"ID": 1, "Address": "norse:9000", "Role": 0}]`), nil)
+	mockClusterRepo.On("FindLeader").Return("norse:9000", nil)
+	mockClusterRepo.On("RolesConfig").Return([]byte(`{"Voters": 3}`), nil)
+	mockClusterRepo.On("LocalAddress").Return("norse:9000")
+	mockNodeRepo := new(MockNodeRegistryRepository)
+	var nodes *[]domain.NodeMetadata
+	mockNodeRepo.On("FindAll").Return(nodes, fmt.Errorf("no such table: NODES"))
+
+	service := NewClusterService(mockClusterRepo, mockNodeRepo, logger)
+
+	response, err := service.GetClusterInfo()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(response))
+	assert.Empty(t, response[0].ApiUrl)
+}
+
+func TestMustFailIfRolesConfigUnavailable(t *testing.T) {
+	logger := applog.NewLogger()
+	mockClusterRepo := new(MockClusterRepository)
+	mockClusterRepo.On("ClusterInfo").Return([]byte(`[{"ID": 1, "Address": "norse:9000", "Role": 0}]`), nil)
+	mockClusterRepo.On("FindLeader").Return("norse:9000", nil)
+	mockClusterRepo.On("RolesConfig").Return([]byte{}, fmt.Errorf("no config"))
+
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), logger)
+
+	_, err := service.GetClusterInfo()
+	assert.NotNil(t, err)
 }
 
 func TestMustFailIfUnSuccessfulCall(t *testing.T) {
@@ -77,7 +170,7 @@ func TestMustFailIfUnSuccessfulCall(t *testing.T) {
 	leaderAddress := "norse:9000"
 	mockClusterRepo.On("FindLeader").Return(leaderAddress, fmt.Errorf("no leader found"))
 
-	service := NewClusterService(mockClusterRepo, logger)
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), logger)
 
 	response, err := service.GetClusterInfo()
 	assert.Nil(t, response)
@@ -97,34 +190,164 @@ func TestMustFailIfInvalidJSON(t *testing.T) {
 	mockClusterRepo.On("ClusterInfo").Return(data, nil)
 	leaderAddress := "norse:9000"
 	mockClusterRepo.On("FindLeader").Return(leaderAddress, fmt.Errorf("no leader found"))
-	service := NewClusterService(mockClusterRepo, logger)
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), logger)
 
 	_, err := service.GetClusterInfo()
 	assert.NotNil(t, err)
 }
 
-func TestReturnRemovedNode(t *testing.T) {
-	logger := applog.NewLogger()
+var threeVoters = []byte(`[
+	{"ID": 1, "Address": "norse:9000", "Role": 0},
+	{"ID": 2, "Address": "odin:9000", "Role": 0},
+	{"ID": 3, "Address": "thor:9000", "Role": 0},
+	{"ID": 4, "Address": "loki:9000", "Role": 1}
+]`)
+
+func setupRemoval(unreachable ...string) *MockClusterRepository {
 	mockClusterRepo := new(MockClusterRepository)
-	data := "localhost:50000"
-	mockClusterRepo.On("RemoveNode", data).Return(data, nil)
+	mockClusterRepo.On("ClusterInfo").Return(threeVoters, nil)
+	mockClusterRepo.On("FindLeader").Return("norse:9000", nil)
+	mockClusterRepo.On("LocalAddress").Return("norse:9000")
+	for _, down := range unreachable {
+		mockClusterRepo.On("ProbeNode", down).Return(fmt.Errorf("connection refused"))
+	}
+	mockClusterRepo.On("ProbeNode", mock.Anything).Return(nil)
+	return mockClusterRepo
+}
+
+func TestMustRemoveNodeByAddress(t *testing.T) {
+	mockClusterRepo := setupRemoval()
+	mockClusterRepo.On("RemoveNodeById", uint64(3)).Return(nil)
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), applog.NewLogger())
 
-	service := NewClusterService(mockClusterRepo, logger)
+	report, err := service.RemoveNode(domain.RemovalRequest{Node: "thor:9000"})
 
-	node, err := service.RemoveNode("localhost:50000")
-	assert.Equal(t, "localhost:50000", node)
+	assert.Nil(t, err)
+	assert.True(t, report.Removed)
+	assert.Equal(t, 3, report.VotersBefore)
+	assert.Equal(t, 2, report.VotersAfter)
+	assert.Equal(t, 1, report.FaultToleranceBefore)
+	assert.Equal(t, 0, report.FaultToleranceAfter)
+}
+
+func TestMustRemoveNodeById(t *testing.T) {
+	mockClusterRepo := setupRemoval()
+	mockClusterRepo.On("RemoveNodeById", uint64(4)).Return(nil)
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), applog.NewLogger())
+
+	report, err := service.RemoveNode(domain.RemovalRequest{Node: "4"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "loki:9000", report.Address)
+	assert.Equal(t, 3, report.VotersAfter)
+}
+
+func TestMustNotRemoveOnDryRun(t *testing.T) {
+	mockClusterRepo := setupRemoval()
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), applog.NewLogger())
+
+	report, err := service.RemoveNode(domain.RemovalRequest{Node: "norse:9000", DryRun: true})
+
+	assert.Nil(t, err)
+	assert.True(t, report.DryRun)
+	assert.False(t, report.Removed)
+	assert.False(t, report.Safe)
+	mockClusterRepo.AssertNotCalled(t, "RemoveNodeById", mock.Anything)
+}
+
+func TestMustRefuseToRemoveLeader(t *testing.T) {
+	mockClusterRepo := setupRemoval()
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), applog.NewLogger())
+
+	report, err := service.RemoveNode(domain.RemovalRequest{Node: "norse:9000"})
+
+	assert.True(t, errors.Is(err, domain.ErrUnsafeRemoval))
+	assert.True(t, report.Leader)
+	mockClusterRepo.AssertNotCalled(t, "RemoveNodeById", mock.Anything)
+}
+
+func TestMustRefuseToLoseQuorum(t *testing.T) {
+	mockClusterRepo := setupRemoval("odin:9000")
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), applog.NewLogger())
+
+	report, err := service.RemoveNode(domain.RemovalRequest{Node: "thor:9000"})
+
+	assert.True(t, errors.Is(err, domain.ErrUnsafeRemoval))
+	assert.Equal(t, 1, report.OnlineVotersAfter)
+	assert.Equal(t, -1, report.FaultToleranceAfter)
+}
+
+func TestMustRemoveUnreachableVoter(t *testing.T) {
+	mockClusterRepo := setupRemoval("odin:9000")
+	mockClusterRepo.On("RemoveNodeById", uint64(2)).Return(nil)
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), applog.NewLogger())
+
+	report, err := service.RemoveNode(domain.RemovalRequest{Node: "odin:9000"})
+
+	assert.Nil(t, err)
+	assert.True(t, report.Safe)
+	assert.Equal(t, 0, report.FaultToleranceAfter)
+}
+
+func TestMustForceUnsafeRemoval(t *testing.T) {
+	mockClusterRepo := setupRemoval("odin:9000")
+	mockClusterRepo.On("RemoveNodeById", uint64(3)).Return(nil)
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), applog.NewLogger())
+
+	report, err := service.RemoveNode(domain.RemovalRequest{Node: "thor:9000", Force: true})
 
 	assert.Nil(t, err)
+	assert.True(t, report.Removed)
+	assert.False(t, report.Safe)
+}
+
+func TestFailRemoveUnknownNode(t *testing.T) {
+	mockClusterRepo := setupRemoval()
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), applog.NewLogger())
+
+	_, err := service.RemoveNode(domain.RemovalRequest{Node: "freya:9000"})
+
+	assert.True(t, errors.Is(err, domain.ErrNodeNotFound))
 }
 
 func TestFailedRemovedNode(t *testing.T) {
+	mockClusterRepo := setupRemoval()
+	mockClusterRepo.On("RemoveNodeById", uint64(3)).Return(fmt.Errorf("lost leader"))
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), applog.NewLogger())
+
+	report, err := service.RemoveNode(domain.RemovalRequest{Node: "thor:9000"})
+
+	assert.NotNil(t, err)
+	assert.False(t, report.Removed)
+}
+
+func TestMustHandoverLeadership(t *testing.T) {
+	mockClusterRepo := new(MockClusterRepository)
+	mockClusterRepo.On("Handover").Return(nil)
+
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), applog.NewLogger())
+
+	assert.Nil(t, service.HandoverLeadership())
+	mockClusterRepo.AssertExpectations(t)
+}
+
+func TestMustLeaveCluster(t *testing.T) {
 	logger := applog.NewLogger()
 	mockClusterRepo := new(MockClusterRepository)
-	data := "localhost:50000"
-	mockClusterRepo.On("RemoveNode", data).Return(data, fmt.Errorf("lost leader"))
+	mockClusterRepo.On("Leave").Return(nil)
 
-	service := NewClusterService(mockClusterRepo, logger)
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), logger)
 
-	_, err := service.RemoveNode("localhost:50000")
-	assert.NotNil(t, err)
+	assert.Nil(t, service.LeaveCluster())
+	mockClusterRepo.AssertExpectations(t)
+}
+
+func TestFailLeaveCluster(t *testing.T) {
+	logger := applog.NewLogger()
+	mockClusterRepo := new(MockClusterRepository)
+	mockClusterRepo.On("Leave").Return(fmt.Errorf("no leader"))
+
+	service := NewClusterService(mockClusterRepo, new(MockNodeRegistryRepository), logger)
+
+	assert.NotNil(t, service.LeaveCluster())
 }
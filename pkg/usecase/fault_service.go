@@ -0,0 +1,359 @@
+package usecase
+
+import (
+	"fmt"
+	"math/rand"
+	"net"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+const (
+	maxFaultDuration = time.Hour
+	freezePoll       = 100 * time.Millisecond
+	// peer connections remembered per host, the oldest are forgotten first
+	maxTrackedConns = 256
+)
+
+var repositoryOperations = map[string]bool{
+	domain.OperationTaskAdd:      true,
+	domain.OperationTaskFindById: true,
+	domain.OperationTaskFindAll:  true,
+	domain.OperationTaskDelete:   true,
+	domain.OperationTaskUpdate:   true,
+	domain.OperationTaskPatch:    true,
+	domain.AnyTarget:             true,
+}
+
+type activeFault struct {
+	fault    domain.Fault
+	duration time.Duration
+	latency  time.Duration
+	until    time.Time
+}
+
+// FaultService keeps the faults injected in this node and applies them to the repository
+// operations, to the connections with the dqlite peers and to the API.
+type FaultService struct {
+	mu     sync.Mutex
+	nextId int64
+	faults map[int64]*activeFault
+	conns  map[string][]*trackedConn
+	random *rand.Rand
+	logger *applog.Logger
+	now    func() time.Time
+	sleep  func(time.Duration)
+}
+
+func NewFaultService(logger *applog.Logger) *FaultService {
+	return &FaultService{
+		faults: make(map[int64]*activeFault),
+		conns:  make(map[string][]*trackedConn),
+		random: rand.New(rand.NewSource(time.Now().UnixNano())),
+		logger: logger,
+		now:    time.Now,
+		sleep:  time.Sleep,
+	}
+}
+
+// Inject validates the fault and applies it until its duration elapses.
+func (f *FaultService) Inject(fault *domain.Fault) (*domain.Fault, error) {
+	active, err := validateFault(*fault)
+	if err != nil {
+		return nil, err
+	}
+
+	f.mu.Lock()
+	f.nextId++
+	active.fault.Id = f.nextId
+	active.until = f.now().Add(active.duration)
+	active.fault.Expires = active.until.Format(time.RFC1123)
+	f.faults[active.fault.Id] = active
+	switch {
+	case active.fault.Kind == domain.FaultFreeze:
+		f.dropConns(domain.AnyTarget)
+	case active.fault.Kind == domain.FaultPeer && active.fault.Action == domain.PeerDrop:
+		f.dropConns(peerHost(active.fault.Peer))
+	}
+	f.mu.Unlock()
+
+	f.logger.Log.Warn("fault injected", zap.Int64("id", active.fault.Id), zap.String("kind", active.fault.Kind),
+		zap.String("expires", active.fault.Expires))
+	injected := active.fault
+	return &injected, nil
+}
+
+// Faults lists the faults which have not expired yet.
+func (f *FaultService) Faults() []domain.Fault {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	faults := []domain.Fault{}
+	for _, active := range f.active() {
+		faults = append(faults, active.fault)
+	}
+	return faults
+}
+
+// Clear removes a fault before it expires.
+func (f *FaultService) Clear(id int64) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.active()
+	if _, ok := f.faults[id]; !ok {
+		return domain.ErrFaultNotFound
+	}
+	delete(f.faults, id)
+	f.logger.Log.Info("fault cleared", zap.Int64("id", id))
+	return nil
+}
+
+// ClearAll removes every fault.
+func (f *FaultService) ClearAll() {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	f.faults = make(map[int64]*activeFault)
+	f.logger.Log.Info("all faults cleared")
+}
+
+// Before holds the operation while the node is frozen, then applies the latency and
+// the errors of the repository faults targeting it.
+func (f *FaultService) Before(operation string) error {
+	f.WaitWhileFrozen()
+
+	var latency time.Duration
+	fail := false
+	f.mu.Lock()
+	for _, active := range f.active() {
+		if active.fault.Kind != domain.FaultRepository || !matches(active.fault.Operation, operation) {
+			continue
+		}
+		if active.latency > latency {
+			latency = active.latency
+		}
+		if active.fault.ErrorRate > 0 && f.random.Float64() < active.fault.ErrorRate {
+			fail = true
+		}
+	}
+	f.mu.Unlock()
+
+	if latency > 0 {
+		f.sleep(latency)
+	}
+	if fail {
+		return fmt.Errorf("%w: %s", domain.ErrInjectedFault, operation)
+	}
+	return nil
+}
+
+// Admit tells whether a connection with the peer host may be established,
+// it waits first when the connections with the peer are delayed.
+func (f *FaultService) Admit(host string) error {
+	var delay time.Duration
+	f.mu.Lock()
+	for _, active := range f.active() {
+		switch {
+		case active.fault.Kind == domain.FaultFreeze:
+			f.mu.Unlock()
+			return fmt.Errorf("%w: node frozen", domain.ErrInjectedFault)
+		case active.fault.Kind != domain.FaultPeer || !matches(peerHost(active.fault.Peer), host):
+			continue
+		case active.fault.Action == domain.PeerDrop:
+			f.mu.Unlock()
+			return fmt.Errorf("%w: connections with %s dropped", domain.ErrInjectedFault, host)
+		case active.latency > delay:
+			delay = active.latency
+		}
+	}
+	f.mu.Unlock()
+
+	if delay > 0 {
+		f.sleep(delay)
+	}
+	return nil
+}
+
+// Track remembers an established connection with the peer host, so that a drop fault can close it.
+// Closing the returned connection forgets it, the connections closed without it are forgotten on
+// the next Track.
+func (f *FaultService) Track(host string, conn net.Conn) net.Conn {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tracked := &trackedConn{Conn: conn, host: host, faults: f}
+	conns := append(openConns(f.conns[host]), tracked)
+	if len(conns) > maxTrackedConns {
+		conns = conns[len(conns)-maxTrackedConns:]
+	}
+	f.conns[host] = conns
+	return tracked
+}
+
+// untrack forgets a connection closed through Track.
+func (f *FaultService) untrack(conn *trackedConn) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	conns := f.conns[conn.host]
+	for i, tracked := range conns {
+		if tracked == conn {
+			f.conns[conn.host] = append(conns[:i:i], conns[i+1:]...)
+			break
+		}
+	}
+	if len(f.conns[conn.host]) == 0 {
+		delete(f.conns, conn.host)
+	}
+}
+
+// WaitWhileFrozen returns once no freeze fault is active.
+func (f *FaultService) WaitWhileFrozen() {
+	for {
+		f.mu.Lock()
+		var until time.Time
+		for _, active := range f.active() {
+			if active.fault.Kind == domain.FaultFreeze && active.until.After(until) {
+				until = active.until
+			}
+		}
+		now := f.now()
+		f.mu.Unlock()
+
+		if until.IsZero() {
+			return
+		}
+		wait := until.Sub(now)
+		if wait > freezePoll {
+			// polls so that clearing the fault thaws the node
+			wait = freezePoll
+		}
+		f.sleep(wait)
+	}
+}
+
+// active forgets the expired faults and returns the others ordered by id, the lock must be held.
+func (f *FaultService) active() []*activeFault {
+	now := f.now()
+	actives := []*activeFault{}
+	for id, active := range f.faults {
+		if !now.Before(active.until) {
+			delete(f.faults, id)
+			continue
+		}
+		actives = append(actives, active)
+	}
+	sort.Slice(actives, func(i, j int) bool {
+		return actives[i].fault.Id < actives[j].fault.Id
+	})
+	return actives
+}
+
+// dropConns closes the tracked connections with the host, the lock must be held.
+func (f *FaultService) dropConns(host string) {
+	for tracked, conns := range f.conns {
+		if !matches(host, tracked) {
+			continue
+		}
+		for _, conn := range conns {
+			conn.Conn.Close()
+		}
+		delete(f.conns, tracked)
+	}
+}
+
+func validateFault(fault domain.Fault) (*activeFault, error) {
+	duration, err := time.ParseDuration(fault.Duration)
+	if err != nil || duration <= 0 || duration > maxFaultDuration {
+		return nil, fmt.Errorf("%w: the duration must be positive and at most %s", domain.ErrInvalidFault, maxFaultDuration)
+	}
+	var latency time.Duration
+	if fault.Latency != "" {
+		if latency, err = time.ParseDuration(fault.Latency); err != nil || latency < 0 {
+			return nil, fmt.Errorf("%w: bad latency %q", domain.ErrInvalidFault, fault.Latency)
+		}
+	}
+	if fault.ErrorRate < 0 || fault.ErrorRate > 1 {
+		return nil, fmt.Errorf("%w: the error rate must be between 0 and 1", domain.ErrInvalidFault)
+	}
+
+	switch fault.Kind {
+	case domain.FaultRepository:
+		if !repositoryOperations[fault.Operation] {
+			return nil, fmt.Errorf("%w: unknown operation %q", domain.ErrInvalidFault, fault.Operation)
+		}
+		if latency == 0 && fault.ErrorRate == 0 {
+			return nil, fmt.Errorf("%w: a latency or an error rate is required", domain.ErrInvalidFault)
+		}
+	case domain.FaultPeer:
+		if fault.Peer == "" {
+			return nil, fmt.Errorf("%w: the peer is required", domain.ErrInvalidFault)
+		}
+		if fault.Action != domain.PeerDrop && fault.Action != domain.PeerDelay {
+			return nil, fmt.Errorf("%w: the action must be %s or %s", domain.ErrInvalidFault, domain.PeerDrop, domain.PeerDelay)
+		}
+		if fault.Action == domain.PeerDelay && latency == 0 {
+			return nil, fmt.Errorf("%w: a delay needs a latency", domain.ErrInvalidFault)
+		}
+	case domain.FaultFreeze:
+	default:
+		return nil, fmt.Errorf("%w: unknown kind %q", domain.ErrInvalidFault, fault.Kind)
+	}
+	return &activeFault{fault: fault, duration: duration, latency: latency}, nil
+}
+
+// peerHost accepts a dqlite address or a bare host.
+func peerHost(peer string) string {
+	if host, _, err := net.SplitHostPort(peer); err == nil {
+		return host
+	}
+	return peer
+}
+
+func matches(target string, value string) bool {
+	return target == domain.AnyTarget || target == value
+}
+
+// trackedConn is a connection tracked by the fault service until it is closed.
+type trackedConn struct {
+	net.Conn
+	host   string
+	faults *FaultService
+	once   sync.Once
+}
+
+func (c *trackedConn) Close() error {
+	c.once.Do(func() { c.faults.untrack(c) })
+	return c.Conn.Close()
+}
+
+// openConns keeps the connections which were not closed, the ones closed through Track are already gone.
+func openConns(conns []*trackedConn) []*trackedConn {
+	open := conns[:0]
+	for _, conn := range conns {
+		if !closedConn(conn.Conn) {
+			open = append(open, conn)
+		}
+	}
+	return open
+}
+
+// closedConn tells whether the connection was closed, a connection without file descriptor is never.
+func closedConn(conn net.Conn) bool {
+	sc, ok := conn.(syscall.Conn)
+	if !ok {
+		return false
+	}
+	raw, err := sc.SyscallConn()
+	if err != nil {
+		return true
+	}
+	return raw.Control(func(uintptr) {}) != nil
+}
@@ -0,0 +1,150 @@
+package usecase
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+// setupFaults returns a fault service whose clock only moves when it sleeps.
+func setupFaults() (*FaultService, *[]time.Duration) {
+	service := NewFaultService(applog.NewLogger())
+	now := time.Date(2021, 10, 1, 8, 0, 0, 0, time.UTC)
+	slept := []time.Duration{}
+	service.now = func() time.Time { return now }
+	service.sleep = func(d time.Duration) {
+		slept = append(slept, d)
+		now = now.Add(d)
+	}
+	return service, &slept
+}
+
+func TestMustDelayRepositoryOperation(t *testing.T) {
+	service, slept := setupFaults()
+	_, err := service.Inject(&domain.Fault{Kind: domain.FaultRepository, Operation: domain.OperationTaskAdd,
+		Latency: "200ms", Duration: "1m"})
+	assert.Nil(t, err)
+
+	assert.Nil(t, service.Before(domain.OperationTaskAdd))
+	assert.Nil(t, service.Before(domain.OperationTaskFindAll))
+	assert.Equal(t, []time.Duration{200 * time.Millisecond}, *slept)
+}
+
+func TestMustFailRepositoryOperation(t *testing.T) {
+	service, _ := setupFaults()
+	_, err := service.Inject(&domain.Fault{Kind: domain.FaultRepository, Operation: domain.AnyTarget,
+		ErrorRate: 1, Duration: "1m"})
+	assert.Nil(t, err)
+
+	err = service.Before(domain.OperationTaskUpdate)
+	assert.True(t, errors.Is(err, domain.ErrInjectedFault))
+}
+
+func TestMustExpireFault(t *testing.T) {
+	service, _ := setupFaults()
+	_, err := service.Inject(&domain.Fault{Kind: domain.FaultRepository, Operation: domain.AnyTarget,
+		ErrorRate: 1, Duration: "1s"})
+	assert.Nil(t, err)
+
+	service.sleep(time.Second)
+
+	assert.Nil(t, service.Before(domain.OperationTaskUpdate))
+	assert.Empty(t, service.Faults())
+}
+
+func TestMustClearFault(t *testing.T) {
+	service, _ := setupFaults()
+	fault, _ := service.Inject(&domain.Fault{Kind: domain.FaultFreeze, Duration: "1m"})
+
+	assert.Nil(t, service.Clear(fault.Id))
+	assert.Empty(t, service.Faults())
+	assert.Equal(t, domain.ErrFaultNotFound, service.Clear(fault.Id))
+}
+
+func TestFailInvalidFaults(t *testing.T) {
+	service, _ := setupFaults()
+	faults := []domain.Fault{
+		{Kind: domain.FaultFreeze},
+		{Kind: domain.FaultFreeze, Duration: "2h"},
+		{Kind: "FLOOD", Duration: "1m"},
+		{Kind: domain.FaultRepository, Operation: "task.explode", ErrorRate: 1, Duration: "1m"},
+		{Kind: domain.FaultRepository, Operation: domain.OperationTaskAdd, Duration: "1m"},
+		{Kind: domain.FaultRepository, Operation: domain.OperationTaskAdd, ErrorRate: 2, Duration: "1m"},
+		{Kind: domain.FaultPeer, Action: domain.PeerDrop, Duration: "1m"},
+		{Kind: domain.FaultPeer, Peer: "10.0.0.2", Action: "BLACKHOLE", Duration: "1m"},
+		{Kind: domain.FaultPeer, Peer: "10.0.0.2", Action: domain.PeerDelay, Duration: "1m"},
+	}
+	for _, fault := range faults {
+		_, err := service.Inject(&fault)
+		assert.Truef(t, errors.Is(err, domain.ErrInvalidFault), "%+v must be rejected", fault)
+	}
+	assert.Empty(t, service.Faults())
+}
+
+func TestMustDropPeerConnections(t *testing.T) {
+	service, _ := setupFaults()
+	dropped, other := net.Pipe()
+	defer other.Close()
+	kept, peer := net.Pipe()
+	defer kept.Close()
+	defer peer.Close()
+	service.Track("10.0.0.2", dropped)
+	service.Track("10.0.0.3", kept)
+
+	_, err := service.Inject(&domain.Fault{Kind: domain.FaultPeer, Peer: "10.0.0.2:9000", Action: domain.PeerDrop, Duration: "1m"})
+	assert.Nil(t, err)
+
+	_, err = dropped.Write([]byte("x"))
+	assert.NotNil(t, err, "the tracked connection is closed")
+	assert.True(t, errors.Is(service.Admit("10.0.0.2"), domain.ErrInjectedFault))
+	assert.Nil(t, service.Admit("10.0.0.3"))
+}
+
+func TestMustForgetClosedPeerConnections(t *testing.T) {
+	service, _ := setupFaults()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("unable to listen: %v", err)
+	}
+	defer listener.Close()
+	wrapped, other := net.Pipe()
+	defer other.Close()
+	accepted, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatalf("unable to dial: %v", err)
+	}
+
+	service.Track("10.0.0.2", wrapped).Close()
+	service.Track("10.0.0.3", accepted)
+	accepted.Close()
+	service.Track("10.0.0.3", other)
+
+	assert.Empty(t, service.conns["10.0.0.2"], "closing the tracked connection forgets it")
+	assert.Len(t, service.conns["10.0.0.3"], 1, "the connection closed without Track is forgotten on the next one")
+}
+
+func TestMustDelayPeerConnections(t *testing.T) {
+	service, slept := setupFaults()
+	_, err := service.Inject(&domain.Fault{Kind: domain.FaultPeer, Peer: "10.0.0.2", Action: domain.PeerDelay,
+		Latency: "3s", Duration: "1m"})
+	assert.Nil(t, err)
+
+	assert.Nil(t, service.Admit("10.0.0.2"))
+	assert.Equal(t, []time.Duration{3 * time.Second}, *slept)
+}
+
+func TestMustFreezeUntilExpiry(t *testing.T) {
+	service, slept := setupFaults()
+	_, err := service.Inject(&domain.Fault{Kind: domain.FaultFreeze, Duration: "250ms"})
+	assert.Nil(t, err)
+
+	assert.True(t, errors.Is(service.Admit("10.0.0.2"), domain.ErrInjectedFault))
+	assert.Nil(t, service.Before(domain.OperationTaskFindById))
+	assert.Equal(t, []time.Duration{100 * time.Millisecond, 100 * time.Millisecond, 50 * time.Millisecond}, *slept)
+	assert.Nil(t, service.Admit("10.0.0.2"))
+}
@@ -0,0 +1,241 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+// HealingConfig controls the auto-healing controller.
+type HealingConfig struct {
+	// Interval between two reconciliations.
+	Interval time.Duration
+	// GracePeriod is how long a node must stay unreachable before it is considered dead.
+	GracePeriod time.Duration
+	// RemoveDead removes dead nodes from the cluster.
+	RemoveDead bool
+}
+
+// HealingService runs on every node but only acts on the leader. It probes every member of the cluster,
+// replaces the voters which stay unreachable beyond the grace period with a healthy stand-by or spare,
+// demotes or removes them, and records every decision in the audit table.
+type HealingService struct {
+	clusterRepo domain.ClusterRepository
+	auditRepo   domain.HealingAuditRepository
+	cfg         HealingConfig
+	logger      *applog.Logger
+
+	unreachableSince map[uint64]time.Time
+	dead             map[uint64]bool
+	replaced         map[uint64]bool
+	// failed is the last action which failed on a member, its failure is only recorded once
+	failed map[uint64]string
+	now    func() time.Time
+}
+
+func NewHealingService(clusterRepo domain.ClusterRepository, auditRepo domain.HealingAuditRepository, cfg HealingConfig, logger *applog.Logger) *HealingService {
+	return &HealingService{
+		clusterRepo:      clusterRepo,
+		auditRepo:        auditRepo,
+		cfg:              cfg,
+		logger:           logger,
+		unreachableSince: make(map[uint64]time.Time),
+		dead:             make(map[uint64]bool),
+		replaced:         make(map[uint64]bool),
+		failed:           make(map[uint64]string),
+		now:              time.Now,
+	}
+}
+
+// Run reconciles the cluster every interval until the context is cancelled.
+func (h *HealingService) Run(ctx context.Context) {
+	ticker := time.NewTicker(h.cfg.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := h.Reconcile(); err != nil {
+				h.logger.Log.Warn("auto-healing reconciliation failed", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Reconcile runs a single pass of the auto-healing controller.
+func (h *HealingService) Reconcile() error {
+	leader, err := h.clusterRepo.FindLeader()
+	if err != nil {
+		return err
+	}
+	self := h.clusterRepo.LocalAddress()
+	if leader != self {
+		// a new leader starts over with its own observations
+		h.unreachableSince = make(map[uint64]time.Time)
+		h.dead = make(map[uint64]bool)
+		h.replaced = make(map[uint64]bool)
+		h.failed = make(map[uint64]string)
+		return nil
+	}
+
+	clusterInfoInBytes, err := h.clusterRepo.ClusterInfo()
+	if err != nil {
+		return err
+	}
+	members := make([]domain.ClusterInfo, 0)
+	if err := json.Unmarshal(clusterInfoInBytes, &members); err != nil {
+		return err
+	}
+
+	healthy := make(map[uint64]bool)
+	for _, member := range members {
+		if member.Address == self {
+			healthy[member.ID] = true
+			continue
+		}
+		healthy[member.ID] = h.probe(member)
+	}
+	h.forgetRemoved(members)
+
+	for _, member := range members {
+		if !h.dead[member.ID] {
+			continue
+		}
+		if member.Role == domain.RoleVoter && !h.replaced[member.ID] {
+			h.replaced[member.ID] = h.promoteReplacement(member, members, healthy)
+		}
+		switch {
+		case h.cfg.RemoveDead:
+			h.remove(member)
+		case member.Role == domain.RoleVoter && h.replaced[member.ID]:
+			// the dead voter would otherwise keep counting in the quorum
+			h.demote(member)
+		}
+	}
+	return nil
+}
+
+func (h *HealingService) probe(member domain.ClusterInfo) bool {
+	err := h.clusterRepo.ProbeNode(member.Address)
+	since, suspected := h.unreachableSince[member.ID]
+	if err == nil {
+		if suspected {
+			h.forget(member.ID)
+			h.audit(member, domain.HealingRecovered, "node answers probes again")
+		}
+		return true
+	}
+
+	if !suspected {
+		h.unreachableSince[member.ID] = h.now()
+		h.audit(member, domain.HealingUnreachable, err.Error())
+		return false
+	}
+	if !h.dead[member.ID] && h.now().Sub(since) >= h.cfg.GracePeriod {
+		h.dead[member.ID] = true
+		h.audit(member, domain.HealingDead, fmt.Sprintf("unreachable since %s", since.Format(time.RFC1123)))
+	}
+	return false
+}
+
+// promoteReplacement promotes a healthy stand-by, or else a healthy spare, to take over the vote of a dead voter.
+func (h *HealingService) promoteReplacement(deadVoter domain.ClusterInfo, members []domain.ClusterInfo, healthy map[uint64]bool) bool {
+	for _, role := range []uint8{domain.RoleStandBy, domain.RoleSpare} {
+		for i, candidate := range members {
+			if candidate.Role != role || !healthy[candidate.ID] {
+				continue
+			}
+			if err := h.clusterRepo.AssignRole(candidate.ID, domain.RoleVoter); err != nil {
+				h.fail(candidate, "promote to voter", err)
+				continue
+			}
+			delete(h.failed, candidate.ID)
+			members[i].Role = domain.RoleVoter
+			h.audit(candidate, domain.HealingPromote, fmt.Sprintf("replaces dead voter %s", deadVoter.Address))
+			return true
+		}
+	}
+	h.logger.Log.Warn("no healthy stand-by or spare to replace the dead voter", zap.String("address", deadVoter.Address))
+	return false
+}
+
+// demote turns the dead voter, once replaced, into a spare.
+func (h *HealingService) demote(member domain.ClusterInfo) {
+	if err := h.clusterRepo.AssignRole(member.ID, domain.RoleSpare); err != nil {
+		h.fail(member, "demote to spare", err)
+		return
+	}
+	delete(h.failed, member.ID)
+	h.audit(member, domain.HealingDemote, "dead voter demoted to spare")
+}
+
+func (h *HealingService) remove(member domain.ClusterInfo) {
+	if err := h.clusterRepo.RemoveNodeById(member.ID); err != nil {
+		h.fail(member, "remove", err)
+		return
+	}
+	h.forget(member.ID)
+	h.audit(member, domain.HealingRemove, "dead node removed from the cluster")
+}
+
+// fail records that the action failed on the member, a failure repeated on every reconciliation is only
+// recorded the first time.
+func (h *HealingService) fail(member domain.ClusterInfo, action string, err error) {
+	if h.failed[member.ID] == action {
+		h.logger.Log.Debug("auto-healing action still failing", zap.String("action", action),
+			zap.String("address", member.Address), zap.Error(err))
+		return
+	}
+	h.failed[member.ID] = action
+	h.audit(member, domain.HealingFailed, fmt.Sprintf("unable to %s: %v", action, err))
+}
+
+func (h *HealingService) forget(id uint64) {
+	delete(h.unreachableSince, id)
+	delete(h.dead, id)
+	delete(h.replaced, id)
+	delete(h.failed, id)
+}
+
+func (h *HealingService) forgetRemoved(members []domain.ClusterInfo) {
+	current := make(map[uint64]bool)
+	for _, member := range members {
+		current[member.ID] = true
+	}
+	for id := range h.unreachableSince {
+		if !current[id] {
+			h.forget(id)
+		}
+	}
+	for id := range h.failed {
+		if !current[id] {
+			delete(h.failed, id)
+		}
+	}
+}
+
+func (h *HealingService) audit(member domain.ClusterInfo, action string, reason string) {
+	h.logger.Log.Info("auto-healing decision", zap.String("action", action), zap.String("address", member.Address),
+		zap.String("reason", reason))
+	audit := &domain.HealingAudit{
+		NodeId:      member.ID,
+		Address:     member.Address,
+		Action:      action,
+		Reason:      reason,
+		CreatedDate: h.now().Format(time.RFC1123),
+	}
+	if _, err := h.auditRepo.Add(audit); err != nil {
+		h.logger.Log.Warn("unable to record the healing decision", zap.Error(err))
+	}
+}
+
+// GetAuditTrail returns every decision taken by the auto-healing controller.
+func (h *HealingService) GetAuditTrail() (*[]domain.HealingAudit, error) {
+	return h.auditRepo.FindAll()
+}
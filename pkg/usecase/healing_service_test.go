@@ -0,0 +1,150 @@
+package usecase
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockHealingAuditRepository struct {
+	mock.Mock
+}
+
+func (m *MockHealingAuditRepository) Add(audit *domain.HealingAudit) (*domain.HealingAudit, error) {
+	args := m.Called(audit.Action, audit.Address)
+	return audit, args.Error(0)
+}
+
+func (m *MockHealingAuditRepository) FindAll() (*[]domain.HealingAudit, error) {
+	args := m.Called()
+	return args.Get(0).(*[]domain.HealingAudit), args.Error(1)
+}
+
+var threeNodes = []byte(`[
+	{"ID": 1, "Address": "norse:9000", "Role": 0},
+	{"ID": 2, "Address": "norse:9001", "Role": 0},
+	{"ID": 3, "Address": "norse:9002", "Role": 1}
+]`)
+
+func newHealingFixture(removeDead bool) (*HealingService, *MockClusterRepository, *MockHealingAuditRepository, *time.Time) {
+	mockClusterRepo := new(MockClusterRepository)
+	mockClusterRepo.On("FindLeader").Return("norse:9000", nil)
+	mockClusterRepo.On("LocalAddress").Return("norse:9000")
+	mockClusterRepo.On("ClusterInfo").Return(threeNodes, nil)
+	mockClusterRepo.On("ProbeNode", "norse:9002").Return(nil)
+	mockAuditRepo := new(MockHealingAuditRepository)
+	mockAuditRepo.On("Add", mock.Anything, mock.Anything).Return(nil)
+
+	cfg := HealingConfig{Interval: time.Second, GracePeriod: time.Minute, RemoveDead: removeDead}
+	service := NewHealingService(mockClusterRepo, mockAuditRepo, cfg, applog.NewLogger())
+	now := time.Now()
+	service.now = func() time.Time { return now }
+	return service, mockClusterRepo, mockAuditRepo, &now
+}
+
+func TestMustDoNothingWhenNotLeader(t *testing.T) {
+	mockClusterRepo := new(MockClusterRepository)
+	mockClusterRepo.On("FindLeader").Return("norse:9001", nil)
+	mockClusterRepo.On("LocalAddress").Return("norse:9000")
+	mockAuditRepo := new(MockHealingAuditRepository)
+
+	service := NewHealingService(mockClusterRepo, mockAuditRepo, HealingConfig{}, applog.NewLogger())
+
+	assert.Nil(t, service.Reconcile())
+	mockClusterRepo.AssertNotCalled(t, "ClusterInfo")
+}
+
+func TestMustOnlyMarkUnreachableWithinGracePeriod(t *testing.T) {
+	service, mockClusterRepo, mockAuditRepo, now := newHealingFixture(true)
+	mockClusterRepo.On("ProbeNode", "norse:9001").Return(fmt.Errorf("connection refused"))
+
+	assert.Nil(t, service.Reconcile())
+	*now = now.Add(30 * time.Second)
+	assert.Nil(t, service.Reconcile())
+
+	mockAuditRepo.AssertCalled(t, "Add", domain.HealingUnreachable, "norse:9001")
+	mockClusterRepo.AssertNotCalled(t, "AssignRole", mock.Anything, mock.Anything)
+	mockClusterRepo.AssertNotCalled(t, "RemoveNodeById", mock.Anything)
+}
+
+func TestMustPromoteStandByAndRemoveDeadVoter(t *testing.T) {
+	service, mockClusterRepo, mockAuditRepo, now := newHealingFixture(true)
+	mockClusterRepo.On("ProbeNode", "norse:9001").Return(fmt.Errorf("connection refused"))
+	mockClusterRepo.On("AssignRole", uint64(3), domain.RoleVoter).Return(nil)
+	mockClusterRepo.On("RemoveNodeById", uint64(2)).Return(nil)
+
+	assert.Nil(t, service.Reconcile())
+	*now = now.Add(2 * time.Minute)
+	assert.Nil(t, service.Reconcile())
+
+	mockAuditRepo.AssertCalled(t, "Add", domain.HealingDead, "norse:9001")
+	mockAuditRepo.AssertCalled(t, "Add", domain.HealingPromote, "norse:9002")
+	mockAuditRepo.AssertCalled(t, "Add", domain.HealingRemove, "norse:9001")
+	mockClusterRepo.AssertExpectations(t)
+}
+
+func TestMustDemoteDeadVoterWhenRemovalIsDisabled(t *testing.T) {
+	service, mockClusterRepo, mockAuditRepo, now := newHealingFixture(false)
+	mockClusterRepo.On("ProbeNode", "norse:9001").Return(fmt.Errorf("connection refused"))
+	mockClusterRepo.On("AssignRole", uint64(3), domain.RoleVoter).Return(nil)
+	mockClusterRepo.On("AssignRole", uint64(2), domain.RoleSpare).Return(nil)
+
+	assert.Nil(t, service.Reconcile())
+	*now = now.Add(2 * time.Minute)
+	assert.Nil(t, service.Reconcile())
+
+	// the replacement is promoted and the dead voter demoted in the same pass
+	mockClusterRepo.AssertExpectations(t)
+	mockAuditRepo.AssertCalled(t, "Add", domain.HealingDemote, "norse:9001")
+	mockClusterRepo.AssertNotCalled(t, "RemoveNodeById", mock.Anything)
+}
+
+func TestMustRecordRepeatedFailureOnce(t *testing.T) {
+	service, mockClusterRepo, mockAuditRepo, now := newHealingFixture(true)
+	mockClusterRepo.On("ProbeNode", "norse:9001").Return(fmt.Errorf("connection refused"))
+	mockClusterRepo.On("AssignRole", uint64(3), domain.RoleVoter).Return(nil)
+	mockClusterRepo.On("RemoveNodeById", uint64(2)).Return(fmt.Errorf("no leader"))
+
+	assert.Nil(t, service.Reconcile())
+	for i := 0; i < 3; i++ {
+		*now = now.Add(2 * time.Minute)
+		assert.Nil(t, service.Reconcile())
+	}
+
+	failures := 0
+	for _, call := range mockAuditRepo.Calls {
+		if call.Arguments.Get(0) == domain.HealingFailed {
+			failures++
+		}
+	}
+	mockClusterRepo.AssertNumberOfCalls(t, "RemoveNodeById", 3)
+	assert.Equal(t, 1, failures)
+}
+
+func TestMustForgetNodeThatRecovers(t *testing.T) {
+	service, mockClusterRepo, mockAuditRepo, now := newHealingFixture(true)
+	mockClusterRepo.On("ProbeNode", "norse:9001").Return(fmt.Errorf("connection refused")).Once()
+	mockClusterRepo.On("ProbeNode", "norse:9001").Return(nil)
+
+	assert.Nil(t, service.Reconcile())
+	*now = now.Add(2 * time.Minute)
+	assert.Nil(t, service.Reconcile())
+
+	mockAuditRepo.AssertCalled(t, "Add", domain.HealingRecovered, "norse:9001")
+	mockClusterRepo.AssertNotCalled(t, "RemoveNodeById", mock.Anything)
+}
+
+func TestMustReturnAuditTrail(t *testing.T) {
+	service, _, mockAuditRepo, _ := newHealingFixture(false)
+	audits := []domain.HealingAudit{{Id: 1, Action: domain.HealingDead}}
+	mockAuditRepo.On("FindAll").Return(&audits, nil)
+
+	trail, err := service.GetAuditTrail()
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*trail))
+}
@@ -0,0 +1,128 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+const maxIdempotencyKeyLength = 255
+
+// IdempotencyConfig controls how long the idempotency keys are kept.
+type IdempotencyConfig struct {
+	// TTL is how long the response of a request is replayed to its retries.
+	TTL time.Duration
+	// Lease is how long a request in progress holds its key, a retry of the same request takes it over afterwards.
+	Lease time.Duration
+	// PurgeInterval between two deletions of the expired keys.
+	PurgeInterval time.Duration
+}
+
+// IdempotencyService replays the response of the first request carrying an idempotency key to its retries,
+// whichever node they are sent to.
+type IdempotencyService struct {
+	repo   domain.IdempotencyRepository
+	cfg    IdempotencyConfig
+	logger *applog.Logger
+	now    func() time.Time
+}
+
+func NewIdempotencyService(repo domain.IdempotencyRepository, cfg IdempotencyConfig, logger *applog.Logger) *IdempotencyService {
+	return &IdempotencyService{
+		repo:   repo,
+		cfg:    cfg,
+		logger: logger,
+		now:    time.Now,
+	}
+}
+
+// Begin reserves the key of the tenant for the request, it returns the recorded response when the request
+// was already answered.
+func (i *IdempotencyService) Begin(ctx context.Context, key string, fingerprint string) (*domain.IdempotencyRecord, error) {
+	if key == "" || len(key) > maxIdempotencyKeyLength {
+		return nil, domain.NewError(domain.ErrMalformed, domain.CodeInvalidKey, "the idempotency key must have 1 to 255 characters", nil)
+	}
+	now := i.now()
+	record := &domain.IdempotencyRecord{
+		Key:         domain.TenantIdempotencyKey(ctx, key),
+		Fingerprint: fingerprint,
+		Expires:     now.Add(i.cfg.Lease),
+	}
+	existing, err := i.repo.Reserve(ctx, record, now)
+	switch {
+	case errors.Is(err, domain.ErrConflict):
+		// reserved by a concurrent request
+		return nil, keyInUse(key)
+	case err != nil:
+		return nil, err
+	case existing == nil:
+		return nil, nil
+	case existing.Fingerprint != fingerprint:
+		return nil, domain.NewError(domain.ErrValidation, domain.CodeKeyReused,
+			"idempotency key "+key+" was used by another request", nil)
+	case existing.InProgress():
+		return nil, keyInUse(key)
+	}
+	i.logger.Log.Info("replaying response", zap.String("key", key), zap.Int("status", existing.Status))
+	return existing, nil
+}
+
+// Complete records the response of the request, it is replayed until the key of the tenant expires.
+// A request which failed with a transient error releases its key instead, so that it can be retried.
+func (i *IdempotencyService) Complete(ctx context.Context, key string, status int, contentType string, body []byte) error {
+	var err error
+	now := i.now()
+	key = domain.TenantIdempotencyKey(ctx, key)
+	if status >= 500 {
+		err = i.repo.Release(ctx, key, now)
+	} else {
+		err = i.repo.Complete(ctx, &domain.IdempotencyRecord{
+			Key:         key,
+			Status:      status,
+			ContentType: contentType,
+			Body:        body,
+			Expires:     now.Add(i.cfg.TTL),
+		})
+	}
+	if err != nil {
+		i.logger.Log.Warn("unable to record the response", zap.String("key", key), zap.Error(err))
+	}
+	return err
+}
+
+// Run deletes the expired keys every purge interval until the context is cancelled.
+func (i *IdempotencyService) Run(ctx context.Context) {
+	ticker := time.NewTicker(i.cfg.PurgeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := i.Purge(ctx); err != nil {
+				i.logger.Log.Warn("unable to delete the expired idempotency keys", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Purge deletes the expired keys, the abandoned reservations are kept for a TTL as they may have written.
+func (i *IdempotencyService) Purge(ctx context.Context) error {
+	now := i.now()
+	deleted, err := i.repo.DeleteExpired(ctx, now, now.Add(-i.cfg.TTL))
+	if err != nil {
+		return err
+	}
+	if deleted > 0 {
+		i.logger.Log.Info("expired idempotency keys deleted", zap.Int64("keys", deleted))
+	}
+	return nil
+}
+
+func keyInUse(key string) error {
+	return domain.NewError(domain.ErrConflict, domain.CodeKeyInUse, "idempotency key "+key+" is used by a request in progress", nil)
+}
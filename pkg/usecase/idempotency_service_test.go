@@ -0,0 +1,144 @@
+package usecase
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockIdempotencyRepository struct {
+	mock.Mock
+}
+
+func (m *MockIdempotencyRepository) Reserve(ctx context.Context, record *domain.IdempotencyRecord, now time.Time) (*domain.IdempotencyRecord, error) {
+	args := m.Called(record.Key, record.Expires)
+	return args.Get(0).(*domain.IdempotencyRecord), args.Error(1)
+}
+
+func (m *MockIdempotencyRepository) Complete(ctx context.Context, record *domain.IdempotencyRecord) error {
+	args := m.Called(record.Key, record.Status, record.Expires)
+	return args.Error(0)
+}
+
+func (m *MockIdempotencyRepository) Release(ctx context.Context, key string, now time.Time) error {
+	args := m.Called(key, now)
+	return args.Error(0)
+}
+
+func (m *MockIdempotencyRepository) DeleteExpired(ctx context.Context, now time.Time, abandoned time.Time) (int64, error) {
+	args := m.Called(now, abandoned)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+var idempotencyNow = time.Unix(1000, 0)
+
+func newIdempotencyFixture() (*IdempotencyService, *MockIdempotencyRepository) {
+	repo := new(MockIdempotencyRepository)
+	service := NewIdempotencyService(repo, IdempotencyConfig{TTL: time.Hour, Lease: time.Minute}, applog.NewLogger())
+	service.now = func() time.Time { return idempotencyNow }
+	return service, repo
+}
+
+func TestMustReserveNewKey(t *testing.T) {
+	service, repo := newIdempotencyFixture()
+	repo.On("Reserve", "default/k1", idempotencyNow.Add(time.Minute)).Return((*domain.IdempotencyRecord)(nil), nil)
+
+	record, err := service.Begin(context.Background(), "k1", "POST /api/v1/task 1234")
+
+	assert.Nil(t, err)
+	assert.Nil(t, record)
+}
+
+func TestMustScopeTheKeyToTheTenant(t *testing.T) {
+	service, repo := newIdempotencyFixture()
+	repo.On("Reserve", "acme/k1", idempotencyNow.Add(time.Minute)).Return((*domain.IdempotencyRecord)(nil), nil)
+	repo.On("Complete", "acme/k1", 200, idempotencyNow.Add(time.Hour)).Return(nil)
+	ctx := domain.WithTenant(context.Background(), "acme")
+
+	record, beginErr := service.Begin(ctx, "k1", "POST /api/v1/task 1234")
+	completeErr := service.Complete(ctx, "k1", 200, "application/json", []byte(`{"id":12}`))
+
+	assert.Nil(t, beginErr)
+	assert.Nil(t, record)
+	assert.Nil(t, completeErr)
+	repo.AssertExpectations(t)
+}
+
+func TestMustReplayCompletedRequest(t *testing.T) {
+	service, repo := newIdempotencyFixture()
+	completed := &domain.IdempotencyRecord{Key: "default/k1", Fingerprint: "POST /api/v1/task 1234", Status: 200, Body: []byte(`{"id":12}`)}
+	repo.On("Reserve", "default/k1", mock.Anything).Return(completed, nil)
+
+	record, err := service.Begin(context.Background(), "k1", "POST /api/v1/task 1234")
+
+	assert.Nil(t, err)
+	assert.Equal(t, completed, record)
+}
+
+func TestFailBeginWithKeyInUse(t *testing.T) {
+	service, repo := newIdempotencyFixture()
+	inProgress := &domain.IdempotencyRecord{Key: "default/k1", Fingerprint: "POST /api/v1/task 1234"}
+	repo.On("Reserve", "default/k1", mock.Anything).Return(inProgress, nil).Once()
+	repo.On("Reserve", "default/k1", mock.Anything).Return((*domain.IdempotencyRecord)(nil),
+		domain.NewError(domain.ErrConflict, domain.CodeConstraintViolation, "constraint violation", nil)).Once()
+
+	_, inProgressErr := service.Begin(context.Background(), "k1", "POST /api/v1/task 1234")
+	_, concurrentErr := service.Begin(context.Background(), "k1", "POST /api/v1/task 1234")
+
+	assert.Equal(t, domain.CodeKeyInUse, inProgressErr.(*domain.Error).Code)
+	assert.Equal(t, domain.CodeKeyInUse, concurrentErr.(*domain.Error).Code)
+}
+
+func TestFailBeginWithKeyOfAnotherRequest(t *testing.T) {
+	service, repo := newIdempotencyFixture()
+	completed := &domain.IdempotencyRecord{Key: "default/k1", Fingerprint: "DELETE /api/v1/task/3 1234", Status: 200}
+	repo.On("Reserve", "default/k1", mock.Anything).Return(completed, nil)
+
+	_, err := service.Begin(context.Background(), "k1", "POST /api/v1/task 1234")
+
+	assert.ErrorIs(t, err, domain.ErrValidation)
+	assert.Equal(t, domain.CodeKeyReused, err.(*domain.Error).Code)
+}
+
+func TestFailBeginWithInvalidKey(t *testing.T) {
+	service, _ := newIdempotencyFixture()
+
+	_, err := service.Begin(context.Background(), string(make([]byte, 256)), "POST /api/v1/task 1234")
+
+	assert.ErrorIs(t, err, domain.ErrMalformed)
+}
+
+func TestMustRecordTheResponseUntilItExpires(t *testing.T) {
+	service, repo := newIdempotencyFixture()
+	repo.On("Complete", "default/k1", 200, idempotencyNow.Add(time.Hour)).Return(nil)
+
+	err := service.Complete(context.Background(), "k1", 200, "application/json", []byte(`{"id":12}`))
+
+	assert.Nil(t, err)
+	repo.AssertExpectations(t)
+}
+
+func TestMustReleaseTheKeyOfFailedRequest(t *testing.T) {
+	service, repo := newIdempotencyFixture()
+	repo.On("Release", "default/k1", idempotencyNow).Return(nil)
+
+	err := service.Complete(context.Background(), "k1", 503, "application/problem+json", []byte(`{}`))
+
+	assert.Nil(t, err)
+	repo.AssertExpectations(t)
+}
+
+func TestMustPurgeExpiredKeys(t *testing.T) {
+	service, repo := newIdempotencyFixture()
+	repo.On("DeleteExpired", idempotencyNow, idempotencyNow.Add(-time.Hour)).Return(int64(2), nil)
+
+	err := service.Purge(context.Background())
+
+	assert.Nil(t, err)
+	repo.AssertExpectations(t)
+}
@@ -0,0 +1,62 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+// NodeRegistryService registers this node in the NODES table on boot and keeps its heartbeat fresh.
+type NodeRegistryService struct {
+	nodeRepo domain.NodeRegistryRepository
+	self     domain.NodeMetadata
+	interval time.Duration
+	logger   *applog.Logger
+	now      func() time.Time
+}
+
+func NewNodeRegistryService(nodeRepo domain.NodeRegistryRepository, self domain.NodeMetadata, interval time.Duration, logger *applog.Logger) *NodeRegistryService {
+	return &NodeRegistryService{
+		nodeRepo: nodeRepo,
+		self:     self,
+		interval: interval,
+		logger:   logger,
+		now:      time.Now,
+	}
+}
+
+// Register records the metadata of this node, replacing what a previous run registered.
+func (n *NodeRegistryService) Register() error {
+	node := n.self
+	node.Heartbeat = n.now().Format(time.RFC1123)
+	return n.nodeRepo.Register(&node)
+}
+
+// Run refreshes the heartbeat every interval until the context is cancelled.
+func (n *NodeRegistryService) Run(ctx context.Context) {
+	ticker := time.NewTicker(n.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := n.Heartbeat(); err != nil {
+				n.logger.Log.Warn("unable to refresh the node heartbeat", zap.Error(err))
+			}
+		}
+	}
+}
+
+// Heartbeat refreshes the heartbeat of this node, registering it again if its entry is gone.
+func (n *NodeRegistryService) Heartbeat() error {
+	err := n.nodeRepo.Heartbeat(n.self.Address, n.now().Format(time.RFC1123))
+	if errors.Is(err, domain.ErrNotFound) {
+		return n.Register()
+	}
+	return err
+}
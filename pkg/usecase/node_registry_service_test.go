@@ -0,0 +1,74 @@
+package usecase
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockNodeRegistryRepository struct {
+	mock.Mock
+}
+
+func (m *MockNodeRegistryRepository) Register(node *domain.NodeMetadata) error {
+	args := m.Called(node)
+	return args.Error(0)
+}
+
+func (m *MockNodeRegistryRepository) Heartbeat(address string, heartbeat string) error {
+	args := m.Called(address, heartbeat)
+	return args.Error(0)
+}
+
+func (m *MockNodeRegistryRepository) FindAll() (*[]domain.NodeMetadata, error) {
+	args := m.Called()
+	return args.Get(0).(*[]domain.NodeMetadata), args.Error(1)
+}
+
+var registryClock = time.Date(2021, 10, 26, 10, 0, 0, 0, time.UTC)
+
+func newTestRegistry(nodeRepo *MockNodeRegistryRepository) *NodeRegistryService {
+	self := domain.NodeMetadata{Address: "norse:9000", ApiUrl: "http://norse:8000", Version: "v0.2.0"}
+	service := NewNodeRegistryService(nodeRepo, self, time.Second, applog.NewLogger())
+	service.now = func() time.Time { return registryClock }
+	return service
+}
+
+func TestMustRegisterWithHeartbeat(t *testing.T) {
+	mockNodeRepo := new(MockNodeRegistryRepository)
+	mockNodeRepo.On("Register", &domain.NodeMetadata{Address: "norse:9000", ApiUrl: "http://norse:8000", Version: "v0.2.0",
+		Heartbeat: registryClock.Format(time.RFC1123)}).Return(nil)
+
+	assert.Nil(t, newTestRegistry(mockNodeRepo).Register())
+	mockNodeRepo.AssertExpectations(t)
+}
+
+func TestMustRefreshHeartbeat(t *testing.T) {
+	mockNodeRepo := new(MockNodeRegistryRepository)
+	mockNodeRepo.On("Heartbeat", "norse:9000", registryClock.Format(time.RFC1123)).Return(nil)
+
+	assert.Nil(t, newTestRegistry(mockNodeRepo).Heartbeat())
+	mockNodeRepo.AssertNotCalled(t, "Register", mock.Anything)
+}
+
+func TestMustRegisterAgainWhenEntryIsGone(t *testing.T) {
+	mockNodeRepo := new(MockNodeRegistryRepository)
+	mockNodeRepo.On("Heartbeat", "norse:9000", mock.Anything).Return(domain.NewError(domain.ErrNotFound, domain.CodeNodeNotFound, "node norse:9000 is not registered", nil))
+	mockNodeRepo.On("Register", mock.Anything).Return(nil)
+
+	assert.Nil(t, newTestRegistry(mockNodeRepo).Heartbeat())
+	mockNodeRepo.AssertCalled(t, "Register", mock.Anything)
+}
+
+func TestFailHeartbeat(t *testing.T) {
+	mockNodeRepo := new(MockNodeRegistryRepository)
+	mockNodeRepo.On("Heartbeat", "norse:9000", mock.Anything).Return(fmt.Errorf("no leader"))
+
+	assert.NotNil(t, newTestRegistry(mockNodeRepo).Heartbeat())
+	mockNodeRepo.AssertNotCalled(t, "Register", mock.Anything)
+}
@@ -0,0 +1,104 @@
+package usecase
+
+import (
+	"context"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+type ProjectService struct {
+	projectRepo domain.ProjectRepository
+	taskRepo    domain.TaskRepository
+	lg          *applog.Logger
+	now         func() time.Time
+}
+
+func NewProjectService(repo domain.ProjectRepository, taskRepo domain.TaskRepository, lg *applog.Logger) *ProjectService {
+	return &ProjectService{
+		projectRepo: repo,
+		taskRepo:    taskRepo,
+		lg:          lg,
+		now:         time.Now,
+	}
+}
+
+// CreateProject stores the project, created now. Its key prefixes the keys of its tasks.
+func (p *ProjectService) CreateProject(ctx context.Context, project *domain.Project) (*domain.Project, error) {
+	if !domain.ValidProjectKey(project.Key) {
+		return nil, domain.NewError(domain.ErrValidation, domain.CodeInvalidProject,
+			"the key of the project must be an upper case letter followed by 1 to 9 upper case letters or digits", nil)
+	}
+	if err := p.validateProject(project); err != nil {
+		return nil, err
+	}
+	project.Id = 0
+	project.CreatedAt = p.timestamp()
+	newProject, err := p.projectRepo.Add(ctx, project)
+	if err != nil {
+		p.lg.Log.Info("Unable to create the project", zap.String("key", project.Key), zap.Error(err))
+		return nil, err
+	}
+	return newProject, nil
+}
+
+// GetProjectById returns the project.
+func (p *ProjectService) GetProjectById(ctx context.Context, id int64) (*domain.Project, error) {
+	return p.projectRepo.FindById(ctx, id)
+}
+
+// GetAllProjects returns the projects ordered by key.
+func (p *ProjectService) GetAllProjects(ctx context.Context) (*[]domain.Project, error) {
+	return p.projectRepo.FindAll(ctx)
+}
+
+// GetProjectTasks returns the tasks of the project in the date ranges of the filter.
+func (p *ProjectService) GetProjectTasks(ctx context.Context, id int64, filter domain.TaskFilter) (*[]domain.Task, error) {
+	if _, err := p.projectRepo.FindById(ctx, id); err != nil {
+		return nil, err
+	}
+	filter.ProjectId = id
+	return p.taskRepo.FindAll(ctx, filter)
+}
+
+// UpdateProject renames the project and archives or restores it, a project becoming archived is archived now.
+// The key of a project cannot change.
+func (p *ProjectService) UpdateProject(ctx context.Context, project *domain.Project) (*domain.Project, error) {
+	if err := p.validateProject(project); err != nil {
+		return nil, err
+	}
+	project.ArchivedAt = nil
+	if project.Archived {
+		archivedAt := p.timestamp()
+		project.ArchivedAt = &archivedAt
+	}
+	updatedProject, err := p.projectRepo.Update(ctx, project)
+	if err != nil {
+		p.lg.Log.Info("Unable to update the project", zap.Int64("id", project.Id), zap.Error(err))
+		return nil, err
+	}
+	return updatedProject, nil
+}
+
+// DeleteProject removes the project, a project which still has tasks is removed with them when cascade is set only.
+func (p *ProjectService) DeleteProject(ctx context.Context, id int64, cascade bool) error {
+	if err := p.projectRepo.Delete(ctx, id, cascade); err != nil {
+		p.lg.Log.Info("Unable to delete the project", zap.Int64("id", id), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+func (p *ProjectService) validateProject(project *domain.Project) error {
+	if project.Name == "" {
+		return domain.NewError(domain.ErrValidation, domain.CodeInvalidProject, "the name of the project is required", nil)
+	}
+	return nil
+}
+
+// timestamp returns the current time at the precision of the stored timestamps.
+func (p *ProjectService) timestamp() time.Time {
+	return p.now().UTC().Truncate(time.Millisecond)
+}
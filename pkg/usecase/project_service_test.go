@@ -0,0 +1,131 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockedProjectRepository struct {
+	mock.Mock
+}
+
+func (m *MockedProjectRepository) Add(ctx context.Context, project *domain.Project) (*domain.Project, error) {
+	args := m.Called(project)
+	return args.Get(0).(*domain.Project), args.Error(1)
+}
+
+func (m *MockedProjectRepository) FindById(ctx context.Context, id int64) (*domain.Project, error) {
+	args := m.Called(id)
+	return args.Get(0).(*domain.Project), args.Error(1)
+}
+
+func (m *MockedProjectRepository) FindAll(ctx context.Context) (*[]domain.Project, error) {
+	args := m.Called()
+	return args.Get(0).(*[]domain.Project), args.Error(1)
+}
+
+func (m *MockedProjectRepository) Update(ctx context.Context, project *domain.Project) (*domain.Project, error) {
+	args := m.Called(project)
+	return args.Get(0).(*domain.Project), args.Error(1)
+}
+
+func (m *MockedProjectRepository) Delete(ctx context.Context, id int64, cascade bool) error {
+	args := m.Called(id, cascade)
+	return args.Error(0)
+}
+
+func newTimedProjectService(repo domain.ProjectRepository, taskRepo domain.TaskRepository) *ProjectService {
+	service := NewProjectService(repo, taskRepo, applog.NewLogger())
+	service.now = func() time.Time { return taskNow.In(time.FixedZone("CEST", 2*60*60)) }
+	return service
+}
+
+func TestSuccessfulProjectCreation(t *testing.T) {
+	mockProjectRepo := new(MockedProjectRepository)
+	mockProjectRepo.On("Add", mock.Anything).Return(&domain.Project{Id: 1, Key: "OPS"}, nil)
+	service := newTimedProjectService(mockProjectRepo, new(MockedTaskRepository))
+
+	project, err := service.CreateProject(context.Background(), &domain.Project{Id: 7, Key: "OPS", Name: "Operations"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(1), project.Id)
+	added := mockProjectRepo.Calls[0].Arguments.Get(0).(*domain.Project)
+	assert.Equal(t, int64(0), added.Id)
+	assert.Equal(t, taskNow, added.CreatedAt)
+	assert.Equal(t, time.UTC, added.CreatedAt.Location())
+}
+
+func TestInvalidProjectCreation(t *testing.T) {
+	for _, project := range []domain.Project{
+		{Key: "ops", Name: "Operations"},
+		{Key: "O", Name: "Operations"},
+		{Key: "OPERATIONS1", Name: "Operations"},
+		{Key: "OPS-1", Name: "Operations"},
+		{Key: "OPS"},
+	} {
+		mockProjectRepo := new(MockedProjectRepository)
+		service := NewProjectService(mockProjectRepo, new(MockedTaskRepository), applog.NewLogger())
+
+		_, err := service.CreateProject(context.Background(), &project)
+
+		var domainErr *domain.Error
+		assert.Truef(t, errors.As(err, &domainErr), "%v must be invalid", project)
+		assert.ErrorIs(t, err, domain.ErrValidation)
+		assert.Equal(t, domain.CodeInvalidProject, domainErr.Code)
+		mockProjectRepo.AssertNotCalled(t, "Add", mock.Anything)
+	}
+}
+
+func TestMustArchiveProjectNow(t *testing.T) {
+	mockProjectRepo := new(MockedProjectRepository)
+	mockProjectRepo.On("Update", mock.Anything).Return(&domain.Project{Id: 1}, nil)
+	service := newTimedProjectService(mockProjectRepo, new(MockedTaskRepository))
+	sent := taskCreated
+
+	_, archiveErr := service.UpdateProject(context.Background(), &domain.Project{Id: 1, Name: "Operations", Archived: true, ArchivedAt: &sent})
+	_, restoreErr := service.UpdateProject(context.Background(), &domain.Project{Id: 1, Name: "Operations", ArchivedAt: &sent})
+	_, invalidErr := service.UpdateProject(context.Background(), &domain.Project{Id: 1})
+
+	assert.Nil(t, archiveErr)
+	assert.Nil(t, restoreErr)
+	// the repository keeps the archiving date of a project which was already archived
+	assert.Equal(t, taskNow, *mockProjectRepo.Calls[0].Arguments.Get(0).(*domain.Project).ArchivedAt)
+	assert.Nil(t, mockProjectRepo.Calls[1].Arguments.Get(0).(*domain.Project).ArchivedAt)
+	assert.ErrorIs(t, invalidErr, domain.ErrValidation)
+	mockProjectRepo.AssertNumberOfCalls(t, "Update", 2)
+}
+
+func TestMustReturnTheTasksOfTheProject(t *testing.T) {
+	mockProjectRepo := new(MockedProjectRepository)
+	mockProjectRepo.On("FindById", int64(3)).Return(&domain.Project{Id: 3, Key: "OPS"}, nil)
+	mockTaskRepo := new(MockedTaskRepository)
+	filter := domain.TaskFilter{CreatedAfter: taskCreated}
+	mockTaskRepo.On("FindAll", domain.TaskFilter{ProjectId: 3, CreatedAfter: taskCreated}).
+		Return(&[]domain.Task{{Id: 1, Key: "OPS-1", Project: "OPS"}}, nil)
+	service := NewProjectService(mockProjectRepo, mockTaskRepo, applog.NewLogger())
+
+	tasks, err := service.GetProjectTasks(context.Background(), 3, filter)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, len(*tasks))
+}
+
+func TestFailGetTasksOfMissingProject(t *testing.T) {
+	mockProjectRepo := new(MockedProjectRepository)
+	notFound := domain.NewError(domain.ErrNotFound, domain.CodeProjectNotFound, "project 3 not found", nil)
+	mockProjectRepo.On("FindById", int64(3)).Return((*domain.Project)(nil), notFound)
+	mockTaskRepo := new(MockedTaskRepository)
+	service := NewProjectService(mockProjectRepo, mockTaskRepo, applog.NewLogger())
+
+	_, err := service.GetProjectTasks(context.Background(), 3, domain.TaskFilter{})
+
+	assert.ErrorIs(t, err, domain.ErrNotFound)
+	mockTaskRepo.AssertNotCalled(t, "FindAll", mock.Anything)
+}
@@ -0,0 +1,217 @@
+package usecase
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+// quotaCacheTtl is how long a node applies the quota of a tenant before reading it again,
+// a quota changed through another node applies after it.
+const quotaCacheTtl = 5 * time.Second
+
+// quotaFlushInterval is how often a node adds the requests it admitted to the counters shared by the nodes,
+// a node admits the requests sent to the other nodes since their last flush on top of the rate.
+const quotaFlushInterval = 100 * time.Millisecond
+
+// requestCount is what a node knows of the requests of a tenant in a second.
+type requestCount struct {
+	second int64
+	// counted is the requests of all the nodes read at the last flush
+	counted int64
+	// flushing is the requests this node is adding to the counter
+	flushing int64
+	// pending is the requests this node admitted since the last flush
+	pending int64
+}
+
+type cachedQuota struct {
+	quota   domain.Quota
+	expires time.Time
+}
+
+// QuotaService limits the requests of every tenant, the requests of all the nodes are counted together.
+type QuotaService struct {
+	repo       domain.QuotaRepository
+	tenantRepo domain.TenantRepository
+	lg         *applog.Logger
+	now        func() time.Time
+
+	mu     sync.Mutex
+	quotas map[string]cachedQuota
+	// limited holds the second in which each tenant went over its rate, the rest of it is refused without counting
+	limited map[string]int64
+	counts  map[string]*requestCount
+}
+
+func NewQuotaService(repo domain.QuotaRepository, tenantRepo domain.TenantRepository, lg *applog.Logger) *QuotaService {
+	return &QuotaService{
+		repo:       repo,
+		tenantRepo: tenantRepo,
+		lg:         lg,
+		now:        time.Now,
+		quotas:     map[string]cachedQuota{},
+		limited:    map[string]int64{},
+		counts:     map[string]*requestCount{},
+	}
+}
+
+// GetQuota returns the quota of the tenant, a tenant without quota has no limit.
+func (q *QuotaService) GetQuota(ctx context.Context, tenant string) (*domain.Quota, error) {
+	if err := q.tenantExists(ctx, tenant); err != nil {
+		return nil, err
+	}
+	return q.repo.FindByTenant(ctx, tenant)
+}
+
+// SetQuota replaces the quota of the tenant, a zero limit removes the limit. The other nodes apply it
+// within 5 seconds.
+func (q *QuotaService) SetQuota(ctx context.Context, quota *domain.Quota) (*domain.Quota, error) {
+	if quota.MaxTasks < 0 || quota.MaxDetailsSize < 0 || quota.RequestsPerSecond < 0 {
+		return nil, domain.NewError(domain.ErrValidation, domain.CodeInvalidQuota, "the limits of a quota cannot be negative", nil)
+	}
+	if err := q.tenantExists(ctx, quota.Tenant); err != nil {
+		return nil, err
+	}
+	saved, err := q.repo.Save(ctx, quota)
+	if err != nil {
+		q.lg.Log.Info("Unable to set the quota", zap.String("tenant", quota.Tenant), zap.Error(err))
+		return nil, err
+	}
+	q.mu.Lock()
+	q.quotas[saved.Tenant] = cachedQuota{quota: *saved, expires: q.now().Add(quotaCacheTtl)}
+	q.mu.Unlock()
+	return saved, nil
+}
+
+// Admit counts the request of the tenant of ctx, it returns how long to wait before the next request
+// when the tenant is over its requests per second, zero otherwise. The request is counted by this node,
+// Flush shares the counts with the other nodes. Once the tenant is over its rate, this node refuses its
+// requests until the end of the second without counting them.
+func (q *QuotaService) Admit(ctx context.Context) (time.Duration, error) {
+	tenant := domain.TenantOf(ctx)
+	quota, err := q.cachedQuota(ctx, tenant)
+	if err != nil {
+		return 0, err
+	}
+	if quota.RequestsPerSecond <= 0 {
+		return 0, nil
+	}
+	now := q.now()
+	second := now.Unix()
+	q.mu.Lock()
+	if q.limited[tenant] == second {
+		q.mu.Unlock()
+		return rateLimited(tenant, quota, now)
+	}
+	count, ok := q.counts[tenant]
+	if !ok || count.second != second {
+		count = &requestCount{second: second}
+		q.counts[tenant] = count
+	}
+	count.pending++
+	requests := count.counted + count.flushing + count.pending
+	if requests > quota.RequestsPerSecond {
+		q.limited[tenant] = second
+	}
+	q.mu.Unlock()
+	if requests <= quota.RequestsPerSecond {
+		return 0, nil
+	}
+	q.lg.Log.Debug("request rate limited", zap.String("tenant", tenant), zap.Int64("requests", requests))
+	return rateLimited(tenant, quota, now)
+}
+
+// Run flushes the request counts every quotaFlushInterval until the context is cancelled.
+func (q *QuotaService) Run(ctx context.Context) {
+	ticker := time.NewTicker(quotaFlushInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			q.Flush(ctx)
+		}
+	}
+}
+
+// Flush adds the requests this node admitted in the current second to the counters shared by the nodes,
+// and reads back the requests all the nodes admitted. The counts of the past seconds are dropped.
+// A counter which cannot be written is logged and flushed again with the next requests, the requests
+// are admitted on the counts of this node meanwhile.
+func (q *QuotaService) Flush(ctx context.Context) {
+	second := q.now().Unix()
+	pending := map[string]int64{}
+	q.mu.Lock()
+	for tenant, count := range q.counts {
+		if count.second != second {
+			delete(q.counts, tenant)
+			continue
+		}
+		if count.pending > 0 && count.flushing == 0 {
+			pending[tenant] = count.pending
+			count.flushing, count.pending = count.pending, 0
+		}
+	}
+	q.mu.Unlock()
+
+	for tenant, requests := range pending {
+		counted, err := q.repo.CountRequests(ctx, tenant, second, requests)
+		q.mu.Lock()
+		count, ok := q.counts[tenant]
+		if ok && count.second == second {
+			if err != nil {
+				count.pending += requests
+			} else {
+				count.counted = counted
+			}
+			count.flushing = 0
+		}
+		q.mu.Unlock()
+		if err != nil {
+			q.lg.Log.Warn("Unable to count the requests, they are limited by this node alone",
+				zap.String("tenant", tenant), zap.Error(err))
+		}
+	}
+}
+
+// rateLimited refuses the request of the tenant over its rate until the end of the second.
+func rateLimited(tenant string, quota domain.Quota, now time.Time) (time.Duration, error) {
+	// the counter of the next second starts from zero
+	return time.Unix(now.Unix()+1, 0).Sub(now), domain.NewError(domain.ErrTooManyRequests, domain.CodeRateLimited,
+		"tenant "+tenant+" is limited to "+strconv.FormatInt(quota.RequestsPerSecond, 10)+" requests per second", nil)
+}
+
+// cachedQuota returns the quota of the tenant read at most quotaCacheTtl ago.
+func (q *QuotaService) cachedQuota(ctx context.Context, tenant string) (domain.Quota, error) {
+	now := q.now()
+	q.mu.Lock()
+	cached, ok := q.quotas[tenant]
+	q.mu.Unlock()
+	if ok && now.Before(cached.expires) {
+		return cached.quota, nil
+	}
+	quota, err := q.repo.FindByTenant(ctx, tenant)
+	if err != nil {
+		return domain.Quota{}, err
+	}
+	q.mu.Lock()
+	q.quotas[tenant] = cachedQuota{quota: *quota, expires: now.Add(quotaCacheTtl)}
+	q.mu.Unlock()
+	return *quota, nil
+}
+
+// tenantExists fails with a not found error when the tenant other than the default one does not exist.
+func (q *QuotaService) tenantExists(ctx context.Context, tenant string) error {
+	if tenant == domain.DefaultTenant {
+		return nil
+	}
+	_, err := q.tenantRepo.FindById(ctx, tenant)
+	return err
+}
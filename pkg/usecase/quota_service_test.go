@@ -0,0 +1,170 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockedQuotaRepository struct {
+	mock.Mock
+}
+
+func (m *MockedQuotaRepository) FindByTenant(ctx context.Context, tenant string) (*domain.Quota, error) {
+	args := m.Called(tenant)
+	return args.Get(0).(*domain.Quota), args.Error(1)
+}
+
+func (m *MockedQuotaRepository) Save(ctx context.Context, quota *domain.Quota) (*domain.Quota, error) {
+	args := m.Called(quota)
+	return args.Get(0).(*domain.Quota), args.Error(1)
+}
+
+func (m *MockedQuotaRepository) CountRequests(ctx context.Context, tenant string, second int64, requests int64) (int64, error) {
+	args := m.Called(tenant, second, requests)
+	return args.Get(0).(int64), args.Error(1)
+}
+
+func newTimedQuotaService(repo domain.QuotaRepository, tenantRepo domain.TenantRepository, now *time.Time) *QuotaService {
+	service := NewQuotaService(repo, tenantRepo, applog.NewLogger())
+	service.now = func() time.Time { return *now }
+	return service
+}
+
+func TestSuccessfulQuotaSet(t *testing.T) {
+	mockQuotaRepo := new(MockedQuotaRepository)
+	mockTenantRepo := new(MockedTenantRepository)
+	quota := &domain.Quota{Tenant: "acme", MaxTasks: 100, RequestsPerSecond: 5}
+	mockTenantRepo.On("FindById", "acme").Return(&domain.Tenant{Id: "acme", Name: "Acme"}, nil)
+	mockQuotaRepo.On("Save", quota).Return(quota, nil)
+	now := taskNow
+	service := newTimedQuotaService(mockQuotaRepo, mockTenantRepo, &now)
+
+	saved, err := service.SetQuota(context.Background(), quota)
+
+	assert.Nil(t, err)
+	assert.Equal(t, quota, saved)
+	mockQuotaRepo.AssertExpectations(t)
+}
+
+func TestInvalidQuotaSet(t *testing.T) {
+	mockQuotaRepo := new(MockedQuotaRepository)
+	mockTenantRepo := new(MockedTenantRepository)
+	mockTenantRepo.On("FindById", "globex").Return((*domain.Tenant)(nil), domain.NewError(domain.ErrNotFound, domain.CodeTenantNotFound, "tenant globex not found", nil))
+	now := taskNow
+	service := newTimedQuotaService(mockQuotaRepo, mockTenantRepo, &now)
+
+	_, invalidErr := service.SetQuota(context.Background(), &domain.Quota{Tenant: "acme", MaxTasks: -1})
+	_, missingErr := service.SetQuota(context.Background(), &domain.Quota{Tenant: "globex", MaxTasks: 1})
+
+	assert.Equal(t, domain.CodeInvalidQuota, invalidErr.(*domain.Error).Code)
+	assert.True(t, errors.Is(missingErr, domain.ErrNotFound))
+	mockQuotaRepo.AssertNotCalled(t, "Save", mock.Anything)
+}
+
+func TestMustAdmitTheRequestsWithinTheRate(t *testing.T) {
+	mockQuotaRepo := new(MockedQuotaRepository)
+	mockQuotaRepo.On("FindByTenant", "acme").Return(&domain.Quota{Tenant: "acme", RequestsPerSecond: 2}, nil).Once()
+	now := taskNow.Add(250 * time.Millisecond)
+	service := newTimedQuotaService(mockQuotaRepo, new(MockedTenantRepository), &now)
+	ctx := domain.WithTenant(context.Background(), "acme")
+
+	_, firstErr := service.Admit(ctx)
+	admitted, admitErr := service.Admit(ctx)
+	retryAfter, limitErr := service.Admit(ctx)
+
+	assert.Nil(t, firstErr)
+	assert.Nil(t, admitErr)
+	assert.Zero(t, admitted)
+	assert.True(t, errors.Is(limitErr, domain.ErrTooManyRequests))
+	assert.Equal(t, domain.CodeRateLimited, limitErr.(*domain.Error).Code)
+	assert.Equal(t, 750*time.Millisecond, retryAfter)
+	// the quota is read once while it is cached, the requests are counted by the node until the flush
+	mockQuotaRepo.AssertExpectations(t)
+	mockQuotaRepo.AssertNotCalled(t, "CountRequests", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestMustRefuseTheRestOfTheSecondWithoutCounting(t *testing.T) {
+	mockQuotaRepo := new(MockedQuotaRepository)
+	second := taskNow.Unix()
+	mockQuotaRepo.On("FindByTenant", "acme").Return(&domain.Quota{Tenant: "acme", RequestsPerSecond: 1}, nil).Once()
+	mockQuotaRepo.On("CountRequests", "acme", second+1, int64(1)).Return(int64(1), nil).Once()
+	now := taskNow
+	service := newTimedQuotaService(mockQuotaRepo, new(MockedTenantRepository), &now)
+	ctx := domain.WithTenant(context.Background(), "acme")
+
+	service.Admit(ctx)
+	_, limitErr := service.Admit(ctx)
+	now = now.Add(400 * time.Millisecond)
+	retryAfter, refusedErr := service.Admit(ctx)
+	now = now.Add(600 * time.Millisecond)
+	_, nextErr := service.Admit(ctx)
+	service.Flush(context.Background())
+
+	assert.True(t, errors.Is(limitErr, domain.ErrTooManyRequests))
+	assert.True(t, errors.Is(refusedErr, domain.ErrTooManyRequests))
+	assert.Equal(t, 600*time.Millisecond, retryAfter)
+	assert.Nil(t, nextErr)
+	// the refused request of the same second is not counted and the past second is not flushed
+	mockQuotaRepo.AssertExpectations(t)
+}
+
+func TestMustLimitWithTheRequestsOfTheOtherNodes(t *testing.T) {
+	mockQuotaRepo := new(MockedQuotaRepository)
+	second := taskNow.Unix()
+	mockQuotaRepo.On("FindByTenant", "acme").Return(&domain.Quota{Tenant: "acme", RequestsPerSecond: 3}, nil).Once()
+	mockQuotaRepo.On("CountRequests", "acme", second, int64(1)).Return(int64(3), nil).Once()
+	now := taskNow
+	service := newTimedQuotaService(mockQuotaRepo, new(MockedTenantRepository), &now)
+	ctx := domain.WithTenant(context.Background(), "acme")
+
+	_, admitErr := service.Admit(ctx)
+	service.Flush(context.Background())
+	_, limitErr := service.Admit(ctx)
+
+	assert.Nil(t, admitErr)
+	assert.True(t, errors.Is(limitErr, domain.ErrTooManyRequests))
+	mockQuotaRepo.AssertExpectations(t)
+}
+
+func TestMustAdmitTheRequestsWhenTheCounterFails(t *testing.T) {
+	mockQuotaRepo := new(MockedQuotaRepository)
+	second := taskNow.Unix()
+	mockQuotaRepo.On("FindByTenant", "acme").Return(&domain.Quota{Tenant: "acme", RequestsPerSecond: 3}, nil).Once()
+	mockQuotaRepo.On("CountRequests", "acme", second, int64(1)).Return(int64(0), errors.New("no leader")).Once()
+	mockQuotaRepo.On("CountRequests", "acme", second, int64(2)).Return(int64(2), nil).Once()
+	now := taskNow
+	service := newTimedQuotaService(mockQuotaRepo, new(MockedTenantRepository), &now)
+	ctx := domain.WithTenant(context.Background(), "acme")
+
+	service.Admit(ctx)
+	service.Flush(context.Background())
+	_, admitErr := service.Admit(ctx)
+	service.Flush(context.Background())
+
+	assert.Nil(t, admitErr)
+	// the requests which could not be counted are flushed with the next ones
+	mockQuotaRepo.AssertExpectations(t)
+}
+
+func TestMustNotCountTheRequestsWithoutRate(t *testing.T) {
+	mockQuotaRepo := new(MockedQuotaRepository)
+	mockQuotaRepo.On("FindByTenant", domain.DefaultTenant).Return(&domain.Quota{Tenant: domain.DefaultTenant}, nil).Twice()
+	now := taskNow
+	service := newTimedQuotaService(mockQuotaRepo, new(MockedTenantRepository), &now)
+
+	_, firstErr := service.Admit(context.Background())
+	now = now.Add(quotaCacheTtl)
+	_, expiredErr := service.Admit(context.Background())
+
+	assert.Nil(t, firstErr)
+	assert.Nil(t, expiredErr)
+	mockQuotaRepo.AssertExpectations(t)
+	mockQuotaRepo.AssertNotCalled(t, "CountRequests", mock.Anything, mock.Anything, mock.Anything)
+}
@@ -0,0 +1,137 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"math/rand"
+	"time"
+
+	"github.com/Rican7/retry/backoff"
+	"github.com/Rican7/retry/jitter"
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+// Classes of errors, only the transient ones are retried.
+const (
+	ClassPermanent  = "permanent"
+	ClassLeaderLost = "leader-lost"
+	ClassBusy       = "busy"
+	ClassNetwork    = "network"
+)
+
+// RetryPolicy tells how an operation failing with a transient error is retried.
+type RetryPolicy struct {
+	// Attempts caps the number of tries, the first one included.
+	Attempts uint
+	// Backoff is the delay before the first retry, it doubles at every retry up to MaxBackoff.
+	Backoff    time.Duration
+	MaxBackoff time.Duration
+	// Timeout caps the time spent on the operation, the deadline of the request may end it earlier.
+	Timeout time.Duration
+	// QueryTimeout caps every attempt, so that a hung leader is given up and the attempt retried.
+	QueryTimeout time.Duration
+}
+
+// RetryPolicies holds the policy of every repository operation, ex. domain.OperationTaskAdd.
+type RetryPolicies map[string]RetryPolicy
+
+// DefaultRetryPolicies retries the writes for up to 10 seconds and the reads for up to 5 seconds.
+func DefaultRetryPolicies() RetryPolicies {
+	write := RetryPolicy{Attempts: 10, Backoff: 10 * time.Millisecond, MaxBackoff: time.Second, Timeout: 10 * time.Second,
+		QueryTimeout: 2 * time.Second}
+	read := RetryPolicy{Attempts: 3, Backoff: 10 * time.Millisecond, MaxBackoff: 200 * time.Millisecond, Timeout: 5 * time.Second,
+		QueryTimeout: time.Second}
+	return RetryPolicies{
+		domain.OperationTaskAdd:      write,
+		domain.OperationTaskUpdate:   write,
+		domain.OperationTaskDelete:   write,
+		domain.OperationTaskPatch:    write,
+		domain.OperationTaskFindById: read,
+		domain.OperationTaskFindAll:  read,
+	}
+}
+
+// Of returns the policy of the operation, an operation without policy is tried once.
+func (r RetryPolicies) Of(operation string) RetryPolicy {
+	if policy, ok := r[operation]; ok {
+		return policy
+	}
+	return RetryPolicy{Attempts: 1}
+}
+
+// Classify tells whether the error is transient, and why.
+func Classify(err error) string {
+	var domainErr *domain.Error
+	switch {
+	case domain.IsPermanent(err):
+		return ClassPermanent
+	case errors.Is(err, domain.ErrNoLeader):
+		return ClassLeaderLost
+	case errors.As(err, &domainErr) && domainErr.Code == domain.CodeDatabaseBusy:
+		return ClassBusy
+	case errors.Is(err, domain.ErrUnavailable):
+		// dqlite reports a lost leadership and a broken connection alike
+		return ClassNetwork
+	}
+	// unknown errors, ex. a closed database, would fail again
+	return ClassPermanent
+}
+
+// Do runs the action until it succeeds, fails with a permanent error, runs out of attempts or time.
+// Every attempt is given a context bounded by QueryTimeout, it returns the error of the last attempt.
+func (p RetryPolicy) Do(ctx context.Context, logger *applog.Logger, operation string, action func(ctx context.Context, attempt uint) error) error {
+	if p.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, p.Timeout)
+		defer cancel()
+	}
+	random := rand.New(rand.NewSource(time.Now().UnixNano()))
+	backoffFor := backoff.BinaryExponential(p.Backoff)
+	jitterFor := jitter.Deviation(random, 0.5)
+
+	var err error
+	for attempt := uint(0); ; attempt++ {
+		if ctx.Err() != nil {
+			if err == nil {
+				err = domain.NewError(domain.ErrUnavailable, domain.CodeUnavailable, operation+" abandoned", ctx.Err())
+			}
+			return err
+		}
+		if err = p.try(ctx, attempt, action); err == nil {
+			return nil
+		}
+		class := Classify(err)
+		if class == ClassPermanent || attempt+1 >= p.Attempts {
+			return err
+		}
+
+		delay := backoffFor(attempt)
+		if p.MaxBackoff > 0 && (delay > p.MaxBackoff || delay <= 0) {
+			// delay <= 0 when the exponential overflows
+			delay = p.MaxBackoff
+		}
+		delay = jitterFor(delay)
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < delay {
+			// the next attempt would start too late
+			return err
+		}
+		logger.Log.Info("retrying", zap.String("operation", operation), zap.String("class", class),
+			zap.Uint("attempt", attempt), zap.Duration("delay", delay), zap.Error(err))
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+	}
+}
+
+func (p RetryPolicy) try(ctx context.Context, attempt uint, action func(ctx context.Context, attempt uint) error) error {
+	if p.QueryTimeout <= 0 {
+		return action(ctx, attempt)
+	}
+	attemptCtx, cancel := context.WithTimeout(ctx, p.QueryTimeout)
+	defer cancel()
+	return action(attemptCtx, attempt)
+}
@@ -0,0 +1,129 @@
+package usecase
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+var (
+	errBusy     = domain.NewError(domain.ErrUnavailable, domain.CodeDatabaseBusy, "database busy", nil)
+	errNoLeader = domain.NewError(domain.ErrNoLeader, domain.CodeNoLeader, "no dqlite leader is available", nil)
+	errBadConn  = domain.NewError(domain.ErrUnavailable, domain.CodeDatabaseUnavailable, "database unavailable", nil)
+	errConflict = domain.NewError(domain.ErrConflict, domain.CodeConstraintViolation, "constraint violation", nil)
+)
+
+func TestMustClassifyErrors(t *testing.T) {
+	assert.Equal(t, ClassBusy, Classify(errBusy))
+	assert.Equal(t, ClassLeaderLost, Classify(fmt.Errorf("insert: %w", errNoLeader)))
+	assert.Equal(t, ClassNetwork, Classify(errBadConn))
+	assert.Equal(t, ClassNetwork, Classify(domain.ErrInjectedFault))
+	assert.Equal(t, ClassPermanent, Classify(errConflict))
+	assert.Equal(t, ClassPermanent, Classify(errors.New("sql: database is closed")))
+}
+
+func TestMustRetryTransientErrors(t *testing.T) {
+	policy := RetryPolicy{Attempts: 5, Backoff: time.Millisecond}
+	calls := 0
+
+	err := policy.Do(context.Background(), applog.NewLogger(), domain.OperationTaskAdd, func(ctx context.Context, attempt uint) error {
+		calls++
+		if attempt < 2 {
+			return errNoLeader
+		}
+		return nil
+	})
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestFailAfterLastAttempt(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond}
+	calls := 0
+
+	err := policy.Do(context.Background(), applog.NewLogger(), domain.OperationTaskAdd, func(ctx context.Context, attempt uint) error {
+		calls++
+		return errBusy
+	})
+
+	assert.Equal(t, errBusy, err)
+	assert.Equal(t, 3, calls)
+}
+
+func TestMustNotRetryPermanentErrors(t *testing.T) {
+	policy := RetryPolicy{Attempts: 5000, Backoff: time.Millisecond}
+	calls := 0
+
+	err := policy.Do(context.Background(), applog.NewLogger(), domain.OperationTaskAdd, func(ctx context.Context, attempt uint) error {
+		calls++
+		return errConflict
+	})
+
+	assert.Equal(t, errConflict, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestMustStopRetryingWhenCancelled(t *testing.T) {
+	policy := RetryPolicy{Attempts: 5000, Backoff: 10 * time.Millisecond}
+	ctx, cancel := context.WithCancel(context.Background())
+	calls := 0
+
+	err := policy.Do(ctx, applog.NewLogger(), domain.OperationTaskAdd, func(ctx context.Context, attempt uint) error {
+		calls++
+		cancel()
+		return errBadConn
+	})
+
+	assert.Equal(t, errBadConn, err)
+	assert.Equal(t, 1, calls)
+}
+
+func TestMustCapTheTimeSpentRetrying(t *testing.T) {
+	policy := RetryPolicy{Attempts: 5000, Backoff: 20 * time.Millisecond, MaxBackoff: 20 * time.Millisecond, Timeout: 100 * time.Millisecond}
+	start := time.Now()
+
+	err := policy.Do(context.Background(), applog.NewLogger(), domain.OperationTaskAdd, func(ctx context.Context, attempt uint) error {
+		return errBusy
+	})
+
+	assert.Equal(t, errBusy, err)
+	assert.Less(t, int64(time.Since(start)), int64(time.Second))
+}
+
+func TestFailWhenTheRequestIsAlreadyCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	err := RetryPolicy{Attempts: 3}.Do(ctx, applog.NewLogger(), domain.OperationTaskFindAll, func(ctx context.Context, attempt uint) error {
+		return nil
+	})
+
+	assert.True(t, errors.Is(err, domain.ErrUnavailable))
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestMustTryOperationsWithoutPolicyOnce(t *testing.T) {
+	assert.Equal(t, uint(1), RetryPolicies{}.Of(domain.OperationTaskAdd).Attempts)
+}
+
+func TestMustRetryAttemptsRunningOutOfTime(t *testing.T) {
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond, QueryTimeout: 10 * time.Millisecond}
+
+	err := policy.Do(context.Background(), applog.NewLogger(), domain.OperationTaskFindAll, func(ctx context.Context, attempt uint) error {
+		if attempt == 0 {
+			// a hung leader
+			<-ctx.Done()
+			return domain.NewError(domain.ErrUnavailable, domain.CodeDatabaseUnavailable, "database unavailable", ctx.Err())
+		}
+		return ctx.Err()
+	})
+
+	assert.Nil(t, err)
+}
@@ -1,76 +1,102 @@
 package usecase
 
 import (
+	"bytes"
 	"context"
-	"fmt"
-	"math/rand"
+	"encoding/json"
+	"errors"
 	"time"
 
-	"github.com/Rican7/retry"
-	"github.com/Rican7/retry/backoff"
-	"github.com/Rican7/retry/jitter"
-	"github.com/Rican7/retry/strategy"
 	"github.com/balchua/bopbag/pkg/applog"
 	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/balchua/bopbag/pkg/jsonpatch"
+	"github.com/balchua/bopbag/pkg/snowflake"
 	"go.uber.org/zap"
 )
 
+// maxIdSkew is how far in the future of this node the ids assigned by the clients may be.
+const maxIdSkew = time.Minute
+
 type TaskService struct {
 	taskRepo domain.TaskRepository
+	ids      *snowflake.Generator
 	lg       *applog.Logger
-	retries  uint
+	policies RetryPolicies
+	now      func() time.Time
 }
 
-func NewTaskService(repo domain.TaskRepository, retries uint, lg *applog.Logger) *TaskService {
+func NewTaskService(repo domain.TaskRepository, ids *snowflake.Generator, policies RetryPolicies, lg *applog.Logger) *TaskService {
 	return &TaskService{
 		taskRepo: repo,
+		ids:      ids,
 		lg:       lg,
-		retries:  retries,
+		policies: policies,
+		now:      time.Now,
 	}
 
 }
 
+// CreateTask stores the task, created and updated now, and completed now when it is created completed.
+// The task gets a snowflake id unless the client assigned one.
 func (t *TaskService) CreateTask(ctx context.Context, task *domain.Task) (*domain.Task, error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(20)*time.Millisecond)
-	defer cancel()
-	currentTime := time.Now()
-	task.CreatedDate = currentTime.Format(time.RFC1123)
+	now := t.timestamp()
+	if task.Id == 0 {
+		task.Id = t.ids.Next()
+	} else if !snowflake.Valid(task.Id, now, maxIdSkew) {
+		return nil, domain.NewError(domain.ErrValidation, domain.CodeInvalidTask, "the id of the task must be a snowflake id generated in the past", nil)
+	}
+	task.CreatedAt = now
+	task.UpdatedAt = now
+	task.CompletedAt = nil
+	if task.Completed {
+		task.CompletedAt = &now
+	}
 
 	var newTask *domain.Task
 	//validate the fields as part of the business requirement
-	if t.isValidTask(task) {
-		seed := time.Now().UnixNano()
-		random := rand.New(rand.NewSource(seed))
-		action := func(attempt uint) error {
-			var addErr error
-			newTask, addErr = t.taskRepo.Add(task)
-			t.lg.Log.Info("Create task Attempt", zap.Uint("attempt", attempt))
-			if addErr != nil {
-				t.lg.Log.Info("Unable to add the task", zap.Error(addErr))
+	if err := t.validateTask(task); err != nil {
+		return nil, err
+	}
+	action := func(ctx context.Context, attempt uint) error {
+		var addErr error
+		newTask, addErr = t.taskRepo.Add(ctx, task)
+		t.lg.Log.Info("Create task Attempt", zap.Uint("attempt", attempt))
+		if attempt > 0 && errors.Is(addErr, domain.ErrConflict) {
+			// the id is kept across the attempts, the task may be the one an earlier attempt inserted
+			var stored *domain.Task
+			if stored, addErr = t.insertedTask(ctx, task, addErr); addErr == nil {
+				newTask = stored
 			}
-			return addErr
 		}
-
-		err := retry.Retry(
-			action,
-			strategy.Limit(t.retries),
-			strategy.BackoffWithJitter(
-				backoff.BinaryExponential(10*time.Millisecond),
-				jitter.Deviation(random, 0.5),
-			),
-		)
-		if err != nil {
-			return nil, err
+		if addErr != nil {
+			t.lg.Log.Info("Unable to add the task", zap.Error(addErr))
 		}
-		return newTask, nil
+		return addErr
+	}
+
+	if err := t.retry(ctx, domain.OperationTaskAdd, action); err != nil {
+		return nil, err
+	}
+	return newTask, nil
+}
+
+// insertedTask returns the stored task when it is the task, conflictErr otherwise.
+func (t *TaskService) insertedTask(ctx context.Context, task *domain.Task, conflictErr error) (*domain.Task, error) {
+	stored, err := t.taskRepo.FindById(ctx, task.Id)
+	if err != nil || stored.Title != task.Title || stored.Details != task.Details || !stored.CreatedAt.Equal(task.CreatedAt) {
+		return nil, conflictErr
 	}
-	return nil, fmt.Errorf("invalid task passed")
+	t.lg.Log.Info("task inserted by an earlier attempt", zap.Int64("id", task.Id))
+	return stored, nil
 }
 
 func (t *TaskService) GetTaskById(ctx context.Context, id int64) (*domain.Task, error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(20)*time.Millisecond)
-	defer cancel()
-	task, err := t.taskRepo.FindById(id)
+	var task *domain.Task
+	err := t.retry(ctx, domain.OperationTaskFindById, func(ctx context.Context, attempt uint) error {
+		var findErr error
+		task, findErr = t.taskRepo.FindById(ctx, id)
+		return findErr
+	})
 	if err != nil {
 		return nil, err
 	}
@@ -78,65 +104,89 @@ func (t *TaskService) GetTaskById(ctx context.Context, id int64) (*domain.Task,
 	return task, nil
 }
 
-func (t *TaskService) GetAllTasks(ctx context.Context) (*[]domain.Task, error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(20)*time.Millisecond)
-	defer cancel()
-	tasks, err := t.taskRepo.FindAll()
+// GetTaskByKey returns the task with the project key, ex. OPS-142.
+func (t *TaskService) GetTaskByKey(ctx context.Context, key string) (*domain.Task, error) {
+	var task *domain.Task
+	err := t.retry(ctx, domain.OperationTaskFindById, func(ctx context.Context, attempt uint) error {
+		var findErr error
+		task, findErr = t.taskRepo.FindByKey(ctx, key)
+		return findErr
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return task, nil
+}
+
+// GetAllTasks returns the tasks in the date ranges of the filter.
+func (t *TaskService) GetAllTasks(ctx context.Context, filter domain.TaskFilter) (*[]domain.Task, error) {
+	var tasks *[]domain.Task
+	err := t.retry(ctx, domain.OperationTaskFindAll, func(ctx context.Context, attempt uint) error {
+		var findErr error
+		tasks, findErr = t.taskRepo.FindAll(ctx, filter)
+		return findErr
+	})
 	if err != nil {
 		return nil, err
 	}
 	return tasks, nil
 }
 
-func (t *TaskService) isValidTask(task *domain.Task) bool {
+func (t *TaskService) validateTask(task *domain.Task) error {
 	if task.Title == "" || task.Details == "" {
-		return false
+		return domain.NewError(domain.ErrValidation, domain.CodeInvalidTask, "the title and the details of the task are required", nil)
 	}
-	return true
+	return nil
 }
 
-func (t *TaskService) DeleteTask(ctx context.Context, id int64) error {
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(20)*time.Millisecond)
-	defer cancel()
+// timestamp returns the current time at the precision of the stored timestamps.
+func (t *TaskService) timestamp() time.Time {
+	return t.now().UTC().Truncate(time.Millisecond)
+}
+
+// retry runs the action with the retry policy of the operation.
+func (t *TaskService) retry(ctx context.Context, operation string, action func(ctx context.Context, attempt uint) error) error {
+	return t.policies.Of(operation).Do(ctx, t.lg, operation, action)
+}
 
-	seed := time.Now().UnixNano()
-	random := rand.New(rand.NewSource(seed))
-	delete := func(attempt uint) error {
+func (t *TaskService) DeleteTask(ctx context.Context, id int64) error {
+	delete := func(ctx context.Context, attempt uint) error {
 		var deleteErr error
-		deleteErr = t.taskRepo.Delete(id)
+		deleteErr = t.taskRepo.Delete(ctx, id)
 		t.lg.Log.Info("Deleting task Attempt", zap.Uint("attempt", attempt))
+		if attempt > 0 && errors.Is(deleteErr, domain.ErrNotFound) {
+			// an earlier attempt may have deleted the task before its answer was lost
+			t.lg.Log.Info("task deleted by an earlier attempt", zap.Int64("id", id))
+			deleteErr = nil
+		}
 		if deleteErr != nil {
 			t.lg.Log.Info("Unable to delete the task", zap.Error(deleteErr))
 		}
 		return deleteErr
 	}
 
-	err := retry.Retry(
-		delete,
-		strategy.Limit(t.retries),
-		strategy.BackoffWithJitter(
-			backoff.BinaryExponential(10*time.Millisecond),
-			jitter.Deviation(random, 0.5),
-		),
-	)
-	if err != nil {
+	if err := t.retry(ctx, domain.OperationTaskDelete, delete); err != nil {
 		return err
 	}
 
 	return nil
 }
 
+// UpdateTask replaces the title, the details and the completion of the task, it is updated now.
+// A task becoming completed is completed now, a task keeps its project and key.
 func (t *TaskService) UpdateTask(ctx context.Context, task *domain.Task) (*domain.Task, error) {
-	ctx, cancel := context.WithTimeout(ctx, time.Duration(20)*time.Millisecond)
-	defer cancel()
-
 	var updatedTask *domain.Task
+	if err := t.validateTask(task); err != nil {
+		return nil, err
+	}
+	task.CreatedAt = time.Time{}
+	task.UpdatedAt = t.timestamp()
+	task.CompletedAt = nil
 
-	seed := time.Now().UnixNano()
-	random := rand.New(rand.NewSource(seed))
-	action := func(attempt uint) error {
+	action := func(ctx context.Context, attempt uint) error {
 		var updateErr error
-		updatedTask, updateErr = t.taskRepo.Update(task)
+		updatedTask, updateErr = t.taskRepo.Update(ctx, task)
 		t.lg.Log.Info("Update task Attempt", zap.Uint("attempt", attempt))
 		if updateErr != nil {
 			t.lg.Log.Info("Unable to update the task", zap.Error(updateErr))
@@ -144,18 +194,98 @@ func (t *TaskService) UpdateTask(ctx context.Context, task *domain.Task) (*domai
 		return updateErr
 	}
 
-	err := retry.Retry(
-		action,
-		strategy.Limit(t.retries),
-		strategy.BackoffWithJitter(
-			backoff.BinaryExponential(10*time.Millisecond),
-			jitter.Deviation(random, 0.5),
-		),
-	)
-	if err != nil {
+	if err := t.retry(ctx, domain.OperationTaskUpdate, action); err != nil {
 		return nil, err
 	}
 
 	return updatedTask, nil
 
 }
+
+// PatchTask applies a merge patch or a JSON patch to the task, its id, key, project and timestamps cannot be changed.
+func (t *TaskService) PatchTask(ctx context.Context, id int64, patchType string, patch []byte) (*domain.Task, error) {
+	var apply func(doc []byte, patch []byte) ([]byte, error)
+	switch patchType {
+	case domain.MergePatchType:
+		apply = jsonpatch.Merge
+	case domain.JSONPatchType:
+		apply = jsonpatch.Apply
+	default:
+		return nil, domain.NewError(domain.ErrValidation, domain.CodeInvalidPatch, "unsupported patch type "+patchType, nil)
+	}
+	if !json.Valid(patch) {
+		return nil, domain.NewError(domain.ErrMalformed, domain.CodeMalformedRequest, "malformed patch", nil)
+	}
+
+	var patchedTask *domain.Task
+	action := func(ctx context.Context, attempt uint) error {
+		var patchErr error
+		patchedTask, patchErr = t.taskRepo.Patch(ctx, id, func(task *domain.Task) error {
+			if err := t.applyPatch(task, apply, patch); err != nil {
+				return err
+			}
+			task.UpdatedAt = t.timestamp()
+			return nil
+		})
+		t.lg.Log.Info("Patch task Attempt", zap.Uint("attempt", attempt))
+		if patchErr != nil {
+			t.lg.Log.Info("Unable to patch the task", zap.Error(patchErr))
+		}
+		return patchErr
+	}
+
+	if err := t.retry(ctx, domain.OperationTaskPatch, action); err != nil {
+		return nil, err
+	}
+	return patchedTask, nil
+}
+
+func (t *TaskService) applyPatch(task *domain.Task, apply func(doc []byte, patch []byte) ([]byte, error), patch []byte) error {
+	// the patches apply to the task without the deprecated createdDate
+	doc, err := json.Marshal((*strictTask)(task))
+	if err != nil {
+		return err
+	}
+	if doc, err = apply(doc, patch); err != nil {
+		return patchError(err)
+	}
+	var patched domain.Task
+	decoder := json.NewDecoder(bytes.NewReader(doc))
+	decoder.DisallowUnknownFields()
+	// decoded without Task.UnmarshalJSON, which would not refuse the unknown fields
+	if err := decoder.Decode((*strictTask)(&patched)); err != nil {
+		return domain.NewError(domain.ErrValidation, domain.CodeInvalidPatch, "the patched task is invalid", err)
+	}
+	if patched.Id != task.Id || patched.Key != task.Key || patched.Project != task.Project {
+		return domain.NewError(domain.ErrValidation, domain.CodeInvalidPatch, "the id, the key and the project of a task cannot be changed", nil)
+	}
+	if !patched.CreatedAt.Equal(task.CreatedAt) || !patched.UpdatedAt.Equal(task.UpdatedAt) ||
+		!sameTime(patched.CompletedAt, task.CompletedAt) {
+		return domain.NewError(domain.ErrValidation, domain.CodeInvalidPatch, "the timestamps of a task cannot be changed", nil)
+	}
+	if err := t.validateTask(&patched); err != nil {
+		return err
+	}
+	*task = patched
+	return nil
+}
+
+// strictTask is a task without the JSON methods of domain.Task, it renders and reads the id as a string only.
+type strictTask domain.Task
+
+func sameTime(a *time.Time, b *time.Time) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return a.Equal(*b)
+}
+
+func patchError(err error) error {
+	switch {
+	case errors.Is(err, jsonpatch.ErrTestFailed):
+		return domain.NewError(domain.ErrConflict, domain.CodePatchTestFailed, "the task does not match the patch", err)
+	case errors.Is(err, jsonpatch.ErrMalformed):
+		return domain.NewError(domain.ErrMalformed, domain.CodeMalformedRequest, "malformed patch", err)
+	}
+	return domain.NewError(domain.ErrValidation, domain.CodeInvalidPatch, "the patch cannot be applied to the task", err)
+}
@@ -2,11 +2,14 @@ package usecase
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/balchua/bopbag/pkg/applog"
 	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/balchua/bopbag/pkg/snowflake"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 )
@@ -19,31 +22,60 @@ type MockedTaskRepository struct {
 	mock.Mock
 }
 
-func (m *MockedTaskRepository) Add(task *domain.Task) (*domain.Task, error) {
+func (m *MockedTaskRepository) Add(ctx context.Context, task *domain.Task) (*domain.Task, error) {
 	args := m.Called(task)
 	return args.Get(0).(*domain.Task), args.Error(1)
 }
 
-func (m *MockedTaskRepository) FindById(id int64) (*domain.Task, error) {
+func (m *MockedTaskRepository) FindById(ctx context.Context, id int64) (*domain.Task, error) {
 	args := m.Called(id)
 	return args.Get(0).(*domain.Task), args.Error(1)
 }
 
-func (m *MockedTaskRepository) FindAll() (*[]domain.Task, error) {
-	args := m.Called()
+func (m *MockedTaskRepository) FindByKey(ctx context.Context, key string) (*domain.Task, error) {
+	args := m.Called(key)
+	return args.Get(0).(*domain.Task), args.Error(1)
+}
+
+func (m *MockedTaskRepository) FindAll(ctx context.Context, filter domain.TaskFilter) (*[]domain.Task, error) {
+	args := m.Called(filter)
 	return args.Get(0).(*[]domain.Task), args.Error(1)
 }
 
-func (m *MockedTaskRepository) Update(task *domain.Task) (*domain.Task, error) {
+func (m *MockedTaskRepository) Update(ctx context.Context, task *domain.Task) (*domain.Task, error) {
 	args := m.Called(task)
 	return args.Get(0).(*domain.Task), args.Error(1)
 }
 
-func (m *MockedTaskRepository) Delete(id int64) error {
+// Patch applies the patch to the task the expectation returns, as the repository does to the stored one.
+func (m *MockedTaskRepository) Patch(ctx context.Context, id int64, patch func(task *domain.Task) error) (*domain.Task, error) {
+	args := m.Called(id)
+	if err := args.Error(1); err != nil {
+		return nil, err
+	}
+	task := *args.Get(0).(*domain.Task)
+	if err := patch(&task); err != nil {
+		return nil, err
+	}
+	return &task, nil
+}
+
+func (m *MockedTaskRepository) Delete(ctx context.Context, id int64) error {
 	args := m.Called(id)
 	return args.Error(0)
 }
 
+// attempts tries every operation up to n times, retrying right away.
+func attempts(n uint) RetryPolicies {
+	policies := DefaultRetryPolicies()
+	for operation, policy := range policies {
+		policy.Attempts = n
+		policy.Backoff = time.Millisecond
+		policies[operation] = policy
+	}
+	return policies
+}
+
 func TestSuccessfulTaskCreation(t *testing.T) {
 	logger := applog.NewLogger()
 	assert := assert.New(t)
@@ -51,17 +83,16 @@ func TestSuccessfulTaskCreation(t *testing.T) {
 	// create an instance of our test object
 	mockTaskRepo := new(MockedTaskRepository)
 	task := &domain.Task{
-		Title:       "test",
-		Details:     "test",
-		CreatedDate: "20210926",
+		Title:   "test",
+		Details: "test",
 	}
-	newTask := task
+	newTask := *task
 	newTask.Id = 1
 
 	// setup expectations
-	mockTaskRepo.On("Add", task).Return(newTask, nil)
+	mockTaskRepo.On("Add", task).Return(&newTask, nil)
 
-	service := NewTaskService(mockTaskRepo, 1, logger)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), logger)
 
 	response, err := service.CreateTask(context.Background(), task)
 	assert.NotNil(response)
@@ -75,17 +106,16 @@ func TestFailTaskCreation(t *testing.T) {
 	// create an instance of our test object
 	mockTaskRepo := new(MockedTaskRepository)
 	task := &domain.Task{
-		Title:       "test",
-		Details:     "test",
-		CreatedDate: "20210926",
+		Title:   "test",
+		Details: "test",
 	}
-	newTask := task
+	newTask := *task
 	newTask.Id = 1
 
 	// setup expectations
-	mockTaskRepo.On("Add", task).Return(newTask, fmt.Errorf("database error"))
+	mockTaskRepo.On("Add", task).Return(&newTask, fmt.Errorf("database error"))
 
-	service := NewTaskService(mockTaskRepo, 1, logger)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), logger)
 
 	_, err := service.CreateTask(context.Background(), task)
 	assert.NotNil(err)
@@ -98,9 +128,8 @@ func TestInvalidTaskCreation(t *testing.T) {
 	// create an instance of our test object
 	mockTaskRepo := new(MockedTaskRepository)
 	task := &domain.Task{
-		Title:       "",
-		Details:     "test",
-		CreatedDate: "20210926",
+		Title:   "",
+		Details: "test",
 	}
 	newTask := task
 	newTask.Id = 1
@@ -108,10 +137,11 @@ func TestInvalidTaskCreation(t *testing.T) {
 	// setup expectations
 	mockTaskRepo.On("Add", task).Return(newTask, nil)
 
-	service := NewTaskService(mockTaskRepo, 1, logger)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), logger)
 
 	_, err := service.CreateTask(context.Background(), task)
-	assert.NotNil(err)
+	assert.True(errors.Is(err, domain.ErrValidation))
+	mockTaskRepo.AssertNotCalled(t, "Add", task)
 }
 
 func TestShoudReturnTaskWhenIdIsPassed(t *testing.T) {
@@ -121,16 +151,15 @@ func TestShoudReturnTaskWhenIdIsPassed(t *testing.T) {
 	// create an instance of our test object
 	mockTaskRepo := new(MockedTaskRepository)
 	newTask := &domain.Task{
-		Id:          999,
-		Title:       "test",
-		Details:     "test",
-		CreatedDate: "20210926",
+		Id:      999,
+		Title:   "test",
+		Details: "test",
 	}
 
 	// setup expectations
 	mockTaskRepo.On("FindById", int64(999)).Return(newTask, nil)
 
-	service := NewTaskService(mockTaskRepo, 1, logger)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), logger)
 
 	response, err := service.GetTaskById(context.Background(), 999)
 	assert.NotNil(response)
@@ -144,21 +173,31 @@ func TestFailFindById(t *testing.T) {
 	// create an instance of our test object
 	mockTaskRepo := new(MockedTaskRepository)
 	newTask := &domain.Task{
-		Id:          999,
-		Title:       "test",
-		Details:     "test",
-		CreatedDate: "20210926",
+		Id:      999,
+		Title:   "test",
+		Details: "test",
 	}
 
 	// setup expectations
 	mockTaskRepo.On("FindById", int64(999)).Return(newTask, fmt.Errorf("database error"))
 
-	service := NewTaskService(mockTaskRepo, 1, logger)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), logger)
 
 	_, err := service.GetTaskById(context.Background(), 999)
 	assert.NotNil(err)
 }
 
+func TestShoudReturnTaskWhenKeyIsPassed(t *testing.T) {
+	mockTaskRepo := new(MockedTaskRepository)
+	mockTaskRepo.On("FindByKey", "OPS-142").Return(&domain.Task{Id: 999, Key: "OPS-142", Project: "OPS"}, nil)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), applog.NewLogger())
+
+	response, err := service.GetTaskByKey(context.Background(), "OPS-142")
+
+	assert.Nil(t, err)
+	assert.Equal(t, int64(999), response.Id)
+}
+
 func TestShoudReturnAllTasks(t *testing.T) {
 	logger := applog.NewLogger()
 	assert := assert.New(t)
@@ -170,20 +209,19 @@ func TestShoudReturnAllTasks(t *testing.T) {
 
 	for i := 0; i < 2; i++ {
 		newTask := domain.Task{
-			Id:          int64(i),
-			Title:       "test",
-			Details:     "test",
-			CreatedDate: "20210926",
+			Id:      int64(i),
+			Title:   "test",
+			Details: "test",
 		}
 		_tasks[i] = newTask
 	}
 	tasks = &_tasks
 	// setup expectations
-	mockTaskRepo.On("FindAll").Return(tasks, nil)
+	mockTaskRepo.On("FindAll", mock.Anything).Return(tasks, nil)
 
-	service := NewTaskService(mockTaskRepo, 1, logger)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), logger)
 
-	response, err := service.GetAllTasks(context.Background())
+	response, err := service.GetAllTasks(context.Background(), domain.TaskFilter{})
 	assert.Equal(len(*response), 2)
 	assert.Nil(err)
 }
@@ -199,11 +237,11 @@ func TestMustFailGetAllTasks(t *testing.T) {
 
 	tasks = &_tasks
 	// setup expectations
-	mockTaskRepo.On("FindAll").Return(tasks, fmt.Errorf("database error"))
+	mockTaskRepo.On("FindAll", mock.Anything).Return(tasks, fmt.Errorf("database error"))
 
-	service := NewTaskService(mockTaskRepo, 1, logger)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), logger)
 
-	_, err := service.GetAllTasks(context.Background())
+	_, err := service.GetAllTasks(context.Background(), domain.TaskFilter{})
 	assert.NotNil(err)
 }
 
@@ -218,7 +256,7 @@ func TestSuccessfulTaskDelete(t *testing.T) {
 	// setup expectations
 	mockTaskRepo.On("Delete", int64(1)).Return(nil)
 
-	service := NewTaskService(mockTaskRepo, 1, logger)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), logger)
 
 	err := service.DeleteTask(context.Background(), id)
 	assert.Nil(err)
@@ -235,7 +273,7 @@ func TestFailedTaskDelete(t *testing.T) {
 	// setup expectations
 	mockTaskRepo.On("Delete", int64(1)).Return(fmt.Errorf("unable to delete"))
 
-	service := NewTaskService(mockTaskRepo, 1, logger)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), logger)
 
 	err := service.DeleteTask(context.Background(), id)
 	assert.NotNil(err)
@@ -248,10 +286,9 @@ func TestSuccessfulTaskUpdate(t *testing.T) {
 	// create an instance of our test object
 	mockTaskRepo := new(MockedTaskRepository)
 	task := &domain.Task{
-		Id:          int64(1),
-		Title:       "abc",
-		Details:     "new details",
-		CreatedDate: "20210926",
+		Id:      int64(1),
+		Title:   "abc",
+		Details: "new details",
 	}
 	newTask := task
 	newTask.Id = 1
@@ -259,7 +296,7 @@ func TestSuccessfulTaskUpdate(t *testing.T) {
 	// setup expectations
 	mockTaskRepo.On("Update", task).Return(newTask, nil)
 
-	service := NewTaskService(mockTaskRepo, 1, logger)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), logger)
 
 	response, err := service.UpdateTask(context.Background(), task)
 	assert.NotNil(response)
@@ -273,9 +310,8 @@ func TestFailTaskUpdate(t *testing.T) {
 	// create an instance of our test object
 	mockTaskRepo := new(MockedTaskRepository)
 	task := &domain.Task{
-		Title:       "test",
-		Details:     "test",
-		CreatedDate: "20210926",
+		Title:   "test",
+		Details: "test",
 	}
 	newTask := task
 	newTask.Id = 1
@@ -283,8 +319,239 @@ func TestFailTaskUpdate(t *testing.T) {
 	// setup expectations
 	mockTaskRepo.On("Update", task).Return(newTask, fmt.Errorf("database error"))
 
-	service := NewTaskService(mockTaskRepo, 1, logger)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), logger)
 
 	_, err := service.UpdateTask(context.Background(), task)
 	assert.NotNil(err)
 }
+
+func TestMustNotRetryMissingTask(t *testing.T) {
+	logger := applog.NewLogger()
+	assert := assert.New(t)
+
+	mockTaskRepo := new(MockedTaskRepository)
+	notFound := domain.NewError(domain.ErrNotFound, domain.CodeTaskNotFound, "task 1 not found", nil)
+	mockTaskRepo.On("Delete", int64(1)).Return(notFound)
+
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(5000), logger)
+
+	err := service.DeleteTask(context.Background(), 1)
+	assert.True(errors.Is(err, domain.ErrNotFound))
+	mockTaskRepo.AssertNumberOfCalls(t, "Delete", 1)
+}
+
+func TestMustRetryUnavailableDatabase(t *testing.T) {
+	logger := applog.NewLogger()
+	assert := assert.New(t)
+
+	mockTaskRepo := new(MockedTaskRepository)
+	busy := domain.NewError(domain.ErrUnavailable, domain.CodeDatabaseBusy, "database busy", nil)
+	mockTaskRepo.On("Delete", int64(1)).Return(busy).Once()
+	mockTaskRepo.On("Delete", int64(1)).Return(nil).Once()
+
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(3), logger)
+
+	err := service.DeleteTask(context.Background(), 1)
+	assert.Nil(err)
+	mockTaskRepo.AssertNumberOfCalls(t, "Delete", 2)
+}
+
+func TestMustAcceptTheTaskDeletedByAnEarlierAttempt(t *testing.T) {
+	logger := applog.NewLogger()
+	assert := assert.New(t)
+
+	mockTaskRepo := new(MockedTaskRepository)
+	// the first attempt deleted the task but its answer was lost
+	lost := domain.NewError(domain.ErrUnavailable, domain.CodeDatabaseUnavailable, "database unavailable", nil)
+	notFound := domain.NewError(domain.ErrNotFound, domain.CodeTaskNotFound, "task 1 not found", nil)
+	mockTaskRepo.On("Delete", int64(1)).Return(lost).Once()
+	mockTaskRepo.On("Delete", int64(1)).Return(notFound).Once()
+
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(3), logger)
+
+	err := service.DeleteTask(context.Background(), 1)
+	assert.Nil(err)
+	mockTaskRepo.AssertNumberOfCalls(t, "Delete", 2)
+}
+
+func TestFailUpdateInvalidTask(t *testing.T) {
+	logger := applog.NewLogger()
+	assert := assert.New(t)
+
+	mockTaskRepo := new(MockedTaskRepository)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), logger)
+
+	_, err := service.UpdateTask(context.Background(), &domain.Task{Id: 1, Details: "no title"})
+	assert.True(errors.Is(err, domain.ErrValidation))
+}
+
+var (
+	taskCreated = time.Date(2021, 9, 26, 10, 0, 0, 0, time.UTC)
+	taskNow     = time.Date(2021, 9, 27, 10, 0, 0, 0, time.UTC)
+)
+
+func storedTask() *domain.Task {
+	return &domain.Task{Id: 1, Title: "title", Details: "details", CreatedAt: taskCreated, UpdatedAt: taskCreated}
+}
+
+func newTimedService(repo domain.TaskRepository) *TaskService {
+	service := NewTaskService(repo, snowflake.NewGenerator(1), attempts(1), applog.NewLogger())
+	service.now = func() time.Time { return taskNow.In(time.FixedZone("CEST", 2*60*60)) }
+	return service
+}
+
+func TestMustTimestampNewTask(t *testing.T) {
+	mockTaskRepo := new(MockedTaskRepository)
+	mockTaskRepo.On("Add", mock.Anything).Return(&domain.Task{Id: 1}, nil)
+	service := newTimedService(mockTaskRepo)
+	sent := taskCreated.Add(-time.Hour)
+
+	_, err := service.CreateTask(context.Background(), &domain.Task{Title: "title", Details: "details",
+		Completed: true, CreatedAt: sent, UpdatedAt: sent})
+
+	assert.Nil(t, err)
+	added := mockTaskRepo.Calls[0].Arguments.Get(0).(*domain.Task)
+	assert.Equal(t, taskNow, added.CreatedAt)
+	assert.Equal(t, time.UTC, added.CreatedAt.Location())
+	assert.Equal(t, taskNow, added.UpdatedAt)
+	assert.Equal(t, taskNow, *added.CompletedAt)
+}
+
+func TestMustAssignSnowflakeId(t *testing.T) {
+	mockTaskRepo := new(MockedTaskRepository)
+	mockTaskRepo.On("Add", mock.Anything).Return(&domain.Task{Id: 1}, nil)
+	service := newTimedService(mockTaskRepo)
+
+	_, err := service.CreateTask(context.Background(), &domain.Task{Title: "title", Details: "details"})
+
+	assert.Nil(t, err)
+	added := mockTaskRepo.Calls[0].Arguments.Get(0).(*domain.Task)
+	assert.True(t, snowflake.Valid(added.Id, time.Now(), 0))
+}
+
+func TestMustKeepIdAssignedByClient(t *testing.T) {
+	mockTaskRepo := new(MockedTaskRepository)
+	mockTaskRepo.On("Add", mock.Anything).Return(&domain.Task{Id: 1}, nil)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), applog.NewLogger())
+	id := snowflake.NewGenerator(7).Next()
+
+	_, err := service.CreateTask(context.Background(), &domain.Task{Id: id, Title: "title", Details: "details"})
+	_, invalidErr := service.CreateTask(context.Background(), &domain.Task{Id: 42, Title: "title", Details: "details"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, id, mockTaskRepo.Calls[0].Arguments.Get(0).(*domain.Task).Id)
+	assert.ErrorIs(t, invalidErr, domain.ErrValidation)
+	mockTaskRepo.AssertNumberOfCalls(t, "Add", 1)
+}
+
+func TestMustReturnTheTaskInsertedByAnEarlierAttempt(t *testing.T) {
+	mockTaskRepo := new(MockedTaskRepository)
+	// the first attempt inserted the task but its answer was lost
+	lost := domain.NewError(domain.ErrUnavailable, domain.CodeDatabaseUnavailable, "database unavailable", nil)
+	conflict := domain.NewError(domain.ErrConflict, domain.CodeConstraintViolation, "constraint violation", nil)
+	mockTaskRepo.On("Add", mock.Anything).Return((*domain.Task)(nil), lost).Once()
+	mockTaskRepo.On("Add", mock.Anything).Return((*domain.Task)(nil), conflict).Once()
+	service := newTimedService(mockTaskRepo)
+	service.policies = attempts(3)
+	stored := &domain.Task{Title: "title", Details: "details", CreatedAt: taskNow, UpdatedAt: taskNow}
+	mockTaskRepo.On("FindById", mock.Anything).Return(stored, nil)
+
+	task, err := service.CreateTask(context.Background(), &domain.Task{Title: "title", Details: "details"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, stored, task)
+}
+
+func TestMustTimestampUpdatedTask(t *testing.T) {
+	mockTaskRepo := new(MockedTaskRepository)
+	mockTaskRepo.On("Update", mock.Anything).Return(&domain.Task{Id: 1}, nil)
+	service := newTimedService(mockTaskRepo)
+	sent := taskCreated.Add(-time.Hour)
+
+	_, err := service.UpdateTask(context.Background(), &domain.Task{Id: 1, Title: "title", Details: "details",
+		Completed: true, CreatedAt: sent, CompletedAt: &sent})
+
+	assert.Nil(t, err)
+	updated := mockTaskRepo.Calls[0].Arguments.Get(0).(*domain.Task)
+	assert.True(t, updated.CreatedAt.IsZero())
+	assert.Equal(t, taskNow, updated.UpdatedAt)
+	// the repository completes the task at its update date unless it was already completed
+	assert.Nil(t, updated.CompletedAt)
+}
+
+func TestMustMergePatchTask(t *testing.T) {
+	mockTaskRepo := new(MockedTaskRepository)
+	mockTaskRepo.On("Patch", int64(1)).Return(storedTask(), nil)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), applog.NewLogger())
+
+	task, err := service.PatchTask(context.Background(), 1, domain.MergePatchType, []byte(`{"details":"new details"}`))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "title", task.Title)
+	assert.Equal(t, "new details", task.Details)
+	assert.Equal(t, taskCreated, task.CreatedAt)
+}
+
+func TestMustCompleteTaskWithPatch(t *testing.T) {
+	mockTaskRepo := new(MockedTaskRepository)
+	mockTaskRepo.On("Patch", int64(1)).Return(storedTask(), nil)
+	service := newTimedService(mockTaskRepo)
+
+	task, err := service.PatchTask(context.Background(), 1, domain.MergePatchType, []byte(`{"completed":true}`))
+
+	assert.Nil(t, err)
+	assert.True(t, task.Completed)
+	assert.Equal(t, taskNow, task.UpdatedAt)
+}
+
+func TestMustJSONPatchTask(t *testing.T) {
+	mockTaskRepo := new(MockedTaskRepository)
+	mockTaskRepo.On("Patch", int64(1)).Return(storedTask(), nil)
+	service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(1), applog.NewLogger())
+
+	task, err := service.PatchTask(context.Background(), 1, domain.JSONPatchType,
+		[]byte(`[{"op":"test","path":"/title","value":"title"},{"op":"replace","path":"/title","value":"new title"}]`))
+
+	assert.Nil(t, err)
+	assert.Equal(t, "new title", task.Title)
+	assert.Equal(t, "details", task.Details)
+}
+
+func TestFailPatchTask(t *testing.T) {
+	cases := []struct {
+		patchType string
+		patch     string
+		kind      error
+		code      string
+	}{
+		{domain.JSONPatchType, `[{"op":"test","path":"/title","value":"other"}]`, domain.ErrConflict, domain.CodePatchTestFailed},
+		{domain.JSONPatchType, `[{"op":"remove","path":"/owner"}]`, domain.ErrValidation, domain.CodeInvalidPatch},
+		{domain.JSONPatchType, `[{"op":"explode","path":"/title"}]`, domain.ErrMalformed, domain.CodeMalformedRequest},
+		{domain.MergePatchType, `{"title":`, domain.ErrMalformed, domain.CodeMalformedRequest},
+		{domain.MergePatchType, `{"title":null}`, domain.ErrValidation, domain.CodeInvalidTask},
+		{domain.MergePatchType, `{"id":2}`, domain.ErrValidation, domain.CodeInvalidPatch},
+		{domain.MergePatchType, `{"key":"OPS-1"}`, domain.ErrValidation, domain.CodeInvalidPatch},
+		{domain.MergePatchType, `{"project":"OPS"}`, domain.ErrValidation, domain.CodeInvalidPatch},
+		{domain.MergePatchType, `{"createdAt":"2021-10-01T00:00:00Z"}`, domain.ErrValidation, domain.CodeInvalidPatch},
+		{domain.MergePatchType, `{"completedAt":"2021-10-01T00:00:00Z"}`, domain.ErrValidation, domain.CodeInvalidPatch},
+		{domain.JSONPatchType, `[{"op":"replace","path":"/updatedAt","value":"2021-10-01T00:00:00Z"}]`, domain.ErrValidation, domain.CodeInvalidPatch},
+		{domain.MergePatchType, `{"createdDate":"today"}`, domain.ErrValidation, domain.CodeInvalidPatch},
+		{domain.MergePatchType, `{"owner":"me"}`, domain.ErrValidation, domain.CodeInvalidPatch},
+		{domain.MergePatchType, `{"title":5}`, domain.ErrValidation, domain.CodeInvalidPatch},
+		{"application/xml", `{}`, domain.ErrValidation, domain.CodeInvalidPatch},
+	}
+	for _, c := range cases {
+		mockTaskRepo := new(MockedTaskRepository)
+		mockTaskRepo.On("Patch", int64(1)).Return(storedTask(), nil)
+		service := NewTaskService(mockTaskRepo, snowflake.NewGenerator(1), attempts(3), applog.NewLogger())
+
+		_, err := service.PatchTask(context.Background(), 1, c.patchType, []byte(c.patch))
+
+		var domainErr *domain.Error
+		assert.Truef(t, errors.As(err, &domainErr), "%s must fail", c.patch)
+		assert.ErrorIsf(t, err, c.kind, "%s", c.patch)
+		assert.Equalf(t, c.code, domainErr.Code, "%s", c.patch)
+		// the errors of the patch are not retried
+		assert.LessOrEqual(t, len(mockTaskRepo.Calls), 1)
+	}
+}
@@ -0,0 +1,99 @@
+package usecase
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+// tenantTokenBytes is the entropy of the tokens of the tenants.
+const tenantTokenBytes = 32
+
+type TenantService struct {
+	repo  domain.TenantRepository
+	lg    *applog.Logger
+	now   func() time.Time
+	token func() (string, error)
+}
+
+func NewTenantService(repo domain.TenantRepository, lg *applog.Logger) *TenantService {
+	return &TenantService{
+		repo:  repo,
+		lg:    lg,
+		now:   time.Now,
+		token: randomToken,
+	}
+}
+
+// CreateTenant stores the tenant, created now, with a new token. The token is returned this once,
+// the cluster keeps its SHA-256 only.
+func (t *TenantService) CreateTenant(ctx context.Context, tenant *domain.Tenant) (*domain.Tenant, error) {
+	if !domain.ValidTenantId(tenant.Id) || tenant.Id == domain.DefaultTenant {
+		return nil, domain.NewError(domain.ErrValidation, domain.CodeInvalidTenant,
+			"the id of the tenant must be a lower case letter followed by 1 to 31 lower case letters, digits or dashes, other than "+
+				domain.DefaultTenant, nil)
+	}
+	if tenant.Name == "" {
+		return nil, domain.NewError(domain.ErrValidation, domain.CodeInvalidTenant, "the name of the tenant is required", nil)
+	}
+	token, err := t.token()
+	if err != nil {
+		return nil, err
+	}
+	tenant.CreatedAt = t.now().UTC().Truncate(time.Millisecond)
+	newTenant, err := t.repo.Add(ctx, tenant, hashToken(token))
+	if err != nil {
+		t.lg.Log.Info("Unable to create the tenant", zap.String("id", tenant.Id), zap.Error(err))
+		return nil, err
+	}
+	newTenant.Token = token
+	return newTenant, nil
+}
+
+// GetTenantById returns the tenant, without its token.
+func (t *TenantService) GetTenantById(ctx context.Context, id string) (*domain.Tenant, error) {
+	return t.repo.FindById(ctx, id)
+}
+
+// GetAllTenants returns the tenants ordered by id, the default tenant is not one of them.
+func (t *TenantService) GetAllTenants(ctx context.Context) (*[]domain.Tenant, error) {
+	return t.repo.FindAll(ctx)
+}
+
+// DeleteTenant removes the tenant, a tenant which still has tasks or projects is removed with them when cascade is set only.
+func (t *TenantService) DeleteTenant(ctx context.Context, id string, cascade bool) error {
+	if err := t.repo.Delete(ctx, id, cascade); err != nil {
+		t.lg.Log.Info("Unable to delete the tenant", zap.String("id", id), zap.Error(err))
+		return err
+	}
+	return nil
+}
+
+// Authenticate returns the tenant the token belongs to, an unknown token is not found.
+func (t *TenantService) Authenticate(ctx context.Context, token string) (*domain.Tenant, error) {
+	tenant, err := t.repo.FindByTokenHash(ctx, hashToken(token))
+	if err != nil && !errors.Is(err, domain.ErrNotFound) {
+		t.lg.Log.Warn("Unable to authenticate the tenant", zap.Error(err))
+	}
+	return tenant, err
+}
+
+func hashToken(token string) string {
+	digest := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(digest[:])
+}
+
+func randomToken() (string, error) {
+	token := make([]byte, tenantTokenBytes)
+	if _, err := rand.Read(token); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(token), nil
+}
@@ -0,0 +1,115 @@
+package usecase
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+type MockedTenantRepository struct {
+	mock.Mock
+}
+
+func (m *MockedTenantRepository) Add(ctx context.Context, tenant *domain.Tenant, tokenHash string) (*domain.Tenant, error) {
+	args := m.Called(tenant, tokenHash)
+	return args.Get(0).(*domain.Tenant), args.Error(1)
+}
+
+func (m *MockedTenantRepository) FindById(ctx context.Context, id string) (*domain.Tenant, error) {
+	args := m.Called(id)
+	return args.Get(0).(*domain.Tenant), args.Error(1)
+}
+
+func (m *MockedTenantRepository) FindByTokenHash(ctx context.Context, tokenHash string) (*domain.Tenant, error) {
+	args := m.Called(tokenHash)
+	return args.Get(0).(*domain.Tenant), args.Error(1)
+}
+
+func (m *MockedTenantRepository) FindAll(ctx context.Context) (*[]domain.Tenant, error) {
+	args := m.Called()
+	return args.Get(0).(*[]domain.Tenant), args.Error(1)
+}
+
+func (m *MockedTenantRepository) Delete(ctx context.Context, id string, cascade bool) error {
+	args := m.Called(id, cascade)
+	return args.Error(0)
+}
+
+func sha256Hex(s string) string {
+	digest := sha256.Sum256([]byte(s))
+	return hex.EncodeToString(digest[:])
+}
+
+func newTimedTenantService(repo domain.TenantRepository) *TenantService {
+	service := NewTenantService(repo, applog.NewLogger())
+	service.now = func() time.Time { return taskNow.In(time.FixedZone("CEST", 2*60*60)) }
+	service.token = func() (string, error) { return "secret", nil }
+	return service
+}
+
+func TestSuccessfulTenantCreation(t *testing.T) {
+	mockTenantRepo := new(MockedTenantRepository)
+	mockTenantRepo.On("Add", mock.Anything, sha256Hex("secret")).Return(&domain.Tenant{Id: "acme", Name: "Acme", CreatedAt: taskNow}, nil)
+	service := newTimedTenantService(mockTenantRepo)
+
+	tenant, err := service.CreateTenant(context.Background(), &domain.Tenant{Id: "acme", Name: "Acme"})
+
+	assert.Nil(t, err)
+	assert.Equal(t, "secret", tenant.Token)
+	added := mockTenantRepo.Calls[0].Arguments.Get(0).(*domain.Tenant)
+	assert.Equal(t, taskNow, added.CreatedAt)
+	assert.Equal(t, time.UTC, added.CreatedAt.Location())
+}
+
+func TestInvalidTenantCreation(t *testing.T) {
+	for _, tenant := range []domain.Tenant{
+		{Id: "Acme", Name: "Acme"},
+		{Id: "a", Name: "Acme"},
+		{Id: "1acme", Name: "Acme"},
+		{Id: "acme/ops", Name: "Acme"},
+		{Id: domain.DefaultTenant, Name: "Default"},
+		{Id: "acme"},
+	} {
+		mockTenantRepo := new(MockedTenantRepository)
+		service := newTimedTenantService(mockTenantRepo)
+
+		_, err := service.CreateTenant(context.Background(), &tenant)
+
+		assert.Truef(t, errors.Is(err, domain.ErrValidation), "%v must be invalid", tenant)
+		assert.Equal(t, domain.CodeInvalidTenant, err.(*domain.Error).Code)
+		mockTenantRepo.AssertNotCalled(t, "Add", mock.Anything, mock.Anything)
+	}
+}
+
+func TestMustAuthenticateTheTokenByItsHash(t *testing.T) {
+	mockTenantRepo := new(MockedTenantRepository)
+	mockTenantRepo.On("FindByTokenHash", sha256Hex("secret")).Return(&domain.Tenant{Id: "acme"}, nil)
+	mockTenantRepo.On("FindByTokenHash", sha256Hex("guess")).
+		Return((*domain.Tenant)(nil), domain.NewError(domain.ErrNotFound, domain.CodeTenantNotFound, "no tenant has the token", nil))
+	service := newTimedTenantService(mockTenantRepo)
+
+	tenant, err := service.Authenticate(context.Background(), "secret")
+	_, unknownErr := service.Authenticate(context.Background(), "guess")
+
+	assert.Nil(t, err)
+	assert.Equal(t, "acme", tenant.Id)
+	assert.True(t, errors.Is(unknownErr, domain.ErrNotFound))
+}
+
+func TestMustGenerateDistinctTokens(t *testing.T) {
+	first, firstErr := randomToken()
+	second, secondErr := randomToken()
+
+	assert.Nil(t, firstErr)
+	assert.Nil(t, secondErr)
+	assert.Len(t, first, 2*tenantTokenBytes)
+	assert.NotEqual(t, first, second)
+}
@@ -0,0 +1,194 @@
+package usecase
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"go.uber.org/zap"
+)
+
+// UpgradeService keeps a cluster running mixed versions consistent during a rolling upgrade.
+// The leader only migrates the schema once every voter runs a binary supporting the new schema,
+// so that a node which is not upgraded yet never reads a schema it does not know.
+type UpgradeService struct {
+	clusterRepo domain.ClusterRepository
+	nodeRepo    domain.NodeRegistryRepository
+	logger      *applog.Logger
+}
+
+func NewUpgradeService(clusterRepo domain.ClusterRepository, nodeRepo domain.NodeRegistryRepository, logger *applog.Logger) *UpgradeService {
+	return &UpgradeService{
+		clusterRepo: clusterRepo,
+		nodeRepo:    nodeRepo,
+		logger:      logger,
+	}
+}
+
+// Run tries to migrate the schema every interval until the context is cancelled.
+func (u *UpgradeService) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		if _, err := u.MigrateSchema(); err != nil {
+			u.logger.Log.Warn("schema migration failed", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// MigrateSchema migrates the schema as far as every voter supports, it does nothing on the followers.
+// It returns the schema version of the cluster.
+func (u *UpgradeService) MigrateSchema() (int, error) {
+	leader, err := u.clusterRepo.FindLeader()
+	if err != nil {
+		return 0, err
+	}
+	current, err := u.clusterRepo.SchemaVersion()
+	if err != nil {
+		return 0, err
+	}
+	target := u.clusterRepo.SupportedSchemaVersion()
+	if leader != u.clusterRepo.LocalAddress() || current >= target {
+		return current, nil
+	}
+
+	members, registered, err := u.members()
+	if err != nil {
+		return current, err
+	}
+	allowed := target
+	var waiting []string
+	for _, member := range members {
+		if member.Role != domain.RoleVoter {
+			continue
+		}
+		supported := registered[member.Address].SchemaVersion
+		if supported < target {
+			waiting = append(waiting, member.Address)
+		}
+		if supported < allowed {
+			allowed = supported
+		}
+	}
+	if allowed <= current {
+		u.logger.Log.Info("schema migration waits for voters to be upgraded", zap.Int("from", current),
+			zap.Int("to", target), zap.Strings("voters", waiting))
+		return current, nil
+	}
+
+	if err := u.clusterRepo.MigrateSchema(current, allowed); err != nil {
+		return current, err
+	}
+	return allowed, nil
+}
+
+// Plan lists the nodes which must be upgraded to the target version, in the order they must be upgraded.
+func (u *UpgradeService) Plan(targetVersion string, targetSchemaVersion int) (*domain.UpgradePlan, error) {
+	leader, err := u.clusterRepo.FindLeader()
+	if err != nil {
+		return nil, err
+	}
+	current, err := u.clusterRepo.SchemaVersion()
+	if err != nil {
+		return nil, err
+	}
+	members, registered, err := u.members()
+	if err != nil {
+		return nil, err
+	}
+
+	plan := &domain.UpgradePlan{
+		TargetVersion:        targetVersion,
+		TargetSchemaVersion:  targetSchemaVersion,
+		ClusterSchemaVersion: current,
+		Steps:                make([]domain.UpgradeStep, 0),
+		UpToDate:             make([]string, 0),
+	}
+	if targetSchemaVersion > 0 && targetSchemaVersion < current {
+		plan.Notes = append(plan.Notes, fmt.Sprintf("%s supports schema %d but the cluster is at schema %d, it cannot be rolled out",
+			targetVersion, targetSchemaVersion, current))
+	}
+
+	sort.SliceStable(members, func(i, j int) bool {
+		return upgradeRank(members[i], leader) < upgradeRank(members[j], leader)
+	})
+	for _, member := range members {
+		node := registered[member.Address]
+		if node.Version == targetVersion {
+			plan.UpToDate = append(plan.UpToDate, member.Address)
+			continue
+		}
+		if node.Version == "" {
+			plan.Notes = append(plan.Notes, fmt.Sprintf("%s is not registered, its version is unknown", member.Address))
+		}
+		plan.Steps = append(plan.Steps, upgradeStep(len(plan.Steps)+1, member, node, member.Address == leader, targetVersion))
+	}
+
+	if targetSchemaVersion > current {
+		plan.Notes = append(plan.Notes, fmt.Sprintf("once every voter runs %s the leader migrates the schema from %d to %d",
+			targetVersion, current, targetSchemaVersion))
+	}
+	return plan, nil
+}
+
+func (u *UpgradeService) members() ([]domain.ClusterInfo, map[string]domain.NodeMetadata, error) {
+	clusterInfoInBytes, err := u.clusterRepo.ClusterInfo()
+	if err != nil {
+		return nil, nil, err
+	}
+	members := make([]domain.ClusterInfo, 0)
+	if err := json.Unmarshal(clusterInfoInBytes, &members); err != nil {
+		return nil, nil, err
+	}
+	nodes, err := u.nodeRepo.FindAll()
+	if err != nil {
+		return nil, nil, err
+	}
+	registered := make(map[string]domain.NodeMetadata)
+	for _, node := range *nodes {
+		registered[node.Address] = node
+	}
+	return members, registered, nil
+}
+
+// upgradeRank orders the spares first, then the stand-bys, then the voters and the leader last.
+func upgradeRank(member domain.ClusterInfo, leader string) int {
+	switch {
+	case member.Address == leader:
+		return 3
+	case member.Role == domain.RoleSpare:
+		return 0
+	case member.Role == domain.RoleStandBy:
+		return 1
+	}
+	return 2
+}
+
+func upgradeStep(order int, member domain.ClusterInfo, node domain.NodeMetadata, leader bool, targetVersion string) domain.UpgradeStep {
+	step := domain.UpgradeStep{
+		Order:         order,
+		Address:       member.Address,
+		ApiUrl:        node.ApiUrl,
+		Role:          member.Role,
+		Leader:        leader,
+		Version:       node.Version,
+		SchemaVersion: node.SchemaVersion,
+	}
+	if member.Role == domain.RoleVoter {
+		step.Actions = append(step.Actions, fmt.Sprintf("transfer the leadership and voting rights away: POST %s/api/v1/node/self/handover", node.ApiUrl))
+	}
+	step.Actions = append(step.Actions,
+		fmt.Sprintf("stop the node, replace its binary with %s and start it again", targetVersion),
+		fmt.Sprintf("wait until the cluster info reports version %s for %s", targetVersion, member.Address),
+	)
+	return step
+}
@@ -0,0 +1,137 @@
+package usecase
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/balchua/bopbag/pkg/applog"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
+)
+
+var mixedCluster = []byte(`[
+	{"ID": 1, "Address": "norse:9000", "Role": 0},
+	{"ID": 2, "Address": "odin:9000", "Role": 0},
+	{"ID": 3, "Address": "thor:9000", "Role": 0},
+	{"ID": 4, "Address": "loki:9000", "Role": 1},
+	{"ID": 5, "Address": "freya:9000", "Role": 2}
+]`)
+
+func setupUpgrade(schemaVersions map[string]int, versions map[string]string) (*MockClusterRepository, *MockNodeRegistryRepository) {
+	mockClusterRepo := new(MockClusterRepository)
+	mockClusterRepo.On("ClusterInfo").Return(mixedCluster, nil)
+	mockClusterRepo.On("FindLeader").Return("norse:9000", nil)
+	mockClusterRepo.On("LocalAddress").Return("norse:9000")
+	mockClusterRepo.On("SchemaVersion").Return(1, nil)
+	mockClusterRepo.On("SupportedSchemaVersion").Return(3)
+
+	nodes := make([]domain.NodeMetadata, 0)
+	for _, address := range []string{"norse:9000", "odin:9000", "thor:9000", "loki:9000", "freya:9000"} {
+		nodes = append(nodes, domain.NodeMetadata{
+			Address:       address,
+			ApiUrl:        "http://" + address,
+			Version:       versions[address],
+			SchemaVersion: schemaVersions[address],
+		})
+	}
+	mockNodeRepo := new(MockNodeRegistryRepository)
+	mockNodeRepo.On("FindAll").Return(&nodes, nil)
+	return mockClusterRepo, mockNodeRepo
+}
+
+func TestMustMigrateWhenAllVotersSupportIt(t *testing.T) {
+	mockClusterRepo, mockNodeRepo := setupUpgrade(map[string]int{"norse:9000": 3, "odin:9000": 3, "thor:9000": 3}, nil)
+	mockClusterRepo.On("MigrateSchema", 1, 3).Return(nil)
+	service := NewUpgradeService(mockClusterRepo, mockNodeRepo, applog.NewLogger())
+
+	version, err := service.MigrateSchema()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 3, version)
+	mockClusterRepo.AssertExpectations(t)
+}
+
+func TestMustMigrateOnlyAsFarAsVotersSupport(t *testing.T) {
+	mockClusterRepo, mockNodeRepo := setupUpgrade(map[string]int{"norse:9000": 3, "odin:9000": 2, "thor:9000": 3}, nil)
+	mockClusterRepo.On("MigrateSchema", 1, 2).Return(nil)
+	service := NewUpgradeService(mockClusterRepo, mockNodeRepo, applog.NewLogger())
+
+	version, err := service.MigrateSchema()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 2, version)
+}
+
+func TestMustRefuseMigrationUntilVotersAreUpgraded(t *testing.T) {
+	mockClusterRepo, mockNodeRepo := setupUpgrade(map[string]int{"norse:9000": 3, "odin:9000": 1, "thor:9000": 3}, nil)
+	service := NewUpgradeService(mockClusterRepo, mockNodeRepo, applog.NewLogger())
+
+	version, err := service.MigrateSchema()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, version)
+	mockClusterRepo.AssertNotCalled(t, "MigrateSchema", mock.Anything, mock.Anything)
+}
+
+func TestMustNotMigrateOnFollower(t *testing.T) {
+	mockClusterRepo := new(MockClusterRepository)
+	mockClusterRepo.On("FindLeader").Return("odin:9000", nil)
+	mockClusterRepo.On("LocalAddress").Return("norse:9000")
+	mockClusterRepo.On("SchemaVersion").Return(1, nil)
+	mockClusterRepo.On("SupportedSchemaVersion").Return(3)
+	service := NewUpgradeService(mockClusterRepo, new(MockNodeRegistryRepository), applog.NewLogger())
+
+	version, err := service.MigrateSchema()
+
+	assert.Nil(t, err)
+	assert.Equal(t, 1, version)
+	mockClusterRepo.AssertNotCalled(t, "MigrateSchema", mock.Anything, mock.Anything)
+}
+
+func TestFailMigrationWithoutLeader(t *testing.T) {
+	mockClusterRepo := new(MockClusterRepository)
+	mockClusterRepo.On("FindLeader").Return("", fmt.Errorf("no leader"))
+	service := NewUpgradeService(mockClusterRepo, new(MockNodeRegistryRepository), applog.NewLogger())
+
+	_, err := service.MigrateSchema()
+
+	assert.NotNil(t, err)
+}
+
+func TestMustPlanUpgradeWithLeaderLast(t *testing.T) {
+	versions := map[string]string{"norse:9000": "v0.2.0", "odin:9000": "v0.3.0", "thor:9000": "v0.2.0", "loki:9000": "v0.2.0", "freya:9000": "v0.2.0"}
+	mockClusterRepo, mockNodeRepo := setupUpgrade(nil, versions)
+	service := NewUpgradeService(mockClusterRepo, mockNodeRepo, applog.NewLogger())
+
+	plan, err := service.Plan("v0.3.0", 3)
+
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"odin:9000"}, plan.UpToDate)
+	assert.Equal(t, 4, len(plan.Steps))
+	assert.Equal(t, "freya:9000", plan.Steps[0].Address)
+	assert.Equal(t, "loki:9000", plan.Steps[1].Address)
+	assert.Equal(t, "thor:9000", plan.Steps[2].Address)
+	assert.Equal(t, "norse:9000", plan.Steps[3].Address)
+	assert.True(t, plan.Steps[3].Leader)
+	assert.Contains(t, plan.Steps[3].Actions[0], "/api/v1/node/self/handover")
+	assert.Equal(t, 2, len(plan.Steps[0].Actions))
+	assert.Contains(t, plan.Notes[0], "from 1 to 3")
+}
+
+func TestMustWarnAboutSchemaDowngrade(t *testing.T) {
+	mockClusterRepo := new(MockClusterRepository)
+	mockClusterRepo.On("ClusterInfo").Return(mixedCluster, nil)
+	mockClusterRepo.On("FindLeader").Return("norse:9000", nil)
+	mockClusterRepo.On("SchemaVersion").Return(2, nil)
+	nodes := make([]domain.NodeMetadata, 0)
+	mockNodeRepo := new(MockNodeRegistryRepository)
+	mockNodeRepo.On("FindAll").Return(&nodes, nil)
+	service := NewUpgradeService(mockClusterRepo, mockNodeRepo, applog.NewLogger())
+
+	plan, err := service.Plan("v0.1.0", 1)
+
+	assert.Nil(t, err)
+	assert.Equal(t, 5, len(plan.Steps))
+	assert.Contains(t, plan.Notes[0], "cannot be rolled out")
+}
@@ -0,0 +1,83 @@
+package verify
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+
+	"github.com/anishathalye/porcupine"
+)
+
+// Report summarizes the check of a history.
+type Report struct {
+	Result     porcupine.CheckResult     `json:"result"`
+	Operations int                       `json:"operations"`
+	Checked    int                       `json:"checked"`
+	Counts     map[string]map[string]int `json:"counts"`
+	// Violations are the tasks whose history is not linearizable.
+	Violations []int64 `json:"violations,omitempty"`
+	// Visualization is the HTML file showing the history and the longest linearizations found.
+	Visualization string `json:"visualization,omitempty"`
+}
+
+// Check verifies that the history is linearizable, giving up after the timeout with an Unknown result.
+// The history, the report and a visualization are written to dir when it is not empty.
+func Check(history *History, timeout time.Duration, dir string) (*Report, error) {
+	entries := history.Entries()
+	ops := operations(entries)
+	result, info := porcupine.CheckOperationsVerbose(Model, ops, timeout)
+
+	report := &Report{
+		Result:     result,
+		Operations: len(entries),
+		Checked:    len(ops),
+		Counts:     make(map[string]map[string]int),
+	}
+	for _, entry := range entries {
+		if report.Counts[entry.Input.Op] == nil {
+			report.Counts[entry.Input.Op] = make(map[string]int)
+		}
+		report.Counts[entry.Input.Op][entry.Output.Result]++
+	}
+	if result == porcupine.Illegal {
+		report.Violations = violations(ops, timeout)
+	}
+
+	if dir == "" {
+		return report, nil
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return report, err
+	}
+	report.Visualization = filepath.Join(dir, "visualization.html")
+	if err := porcupine.VisualizePath(Model, info, report.Visualization); err != nil {
+		return report, err
+	}
+	if err := writeJSON(filepath.Join(dir, "history.json"), entries); err != nil {
+		return report, err
+	}
+	return report, writeJSON(filepath.Join(dir, "report.json"), report)
+}
+
+// violations checks every task on its own to tell which ones are not linearizable.
+func violations(ops []porcupine.Operation, timeout time.Duration) []int64 {
+	var illegal []int64
+	for _, ops := range partition(ops) {
+		if porcupine.CheckOperationsTimeout(Model, ops, timeout) == porcupine.Illegal {
+			illegal = append(illegal, key(ops[0]))
+		}
+	}
+	sort.Slice(illegal, func(i, j int) bool { return illegal[i] < illegal[j] })
+	return illegal
+}
+
+func writeJSON(path string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,94 @@
+package verify
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/anishathalye/porcupine"
+	"github.com/stretchr/testify/assert"
+)
+
+func historyOf(entries ...Entry) *History {
+	history := NewHistory()
+	for _, entry := range entries {
+		history.Add(entry)
+	}
+	return history
+}
+
+func TestMustAcceptLinearizableHistory(t *testing.T) {
+	history := historyOf(
+		Entry{Client: 0, Input: Input{Op: OpCreate, Details: "a"}, Output: Output{Result: ResultOk, Id: 1}, Call: 0, Return: 10},
+		Entry{Client: 0, Input: Input{Op: OpUpdate, Id: 1, Details: "b"}, Output: Output{Result: ResultOk}, Call: 20, Return: 40},
+		// concurrent with the update, it may see either value
+		Entry{Client: 1, Input: Input{Op: OpRead, Id: 1}, Output: Output{Result: ResultOk, Details: "a"}, Call: 25, Return: 30},
+		Entry{Client: 1, Input: Input{Op: OpRead, Id: 1}, Output: Output{Result: ResultOk, Details: "b"}, Call: 50, Return: 60},
+		Entry{Client: 0, Input: Input{Op: OpDelete, Id: 1}, Output: Output{Result: ResultOk}, Call: 70, Return: 80},
+		Entry{Client: 1, Input: Input{Op: OpRead, Id: 1}, Output: Output{Result: ResultNotFound}, Call: 90, Return: 95},
+	)
+
+	report, err := Check(history, time.Minute, "")
+
+	assert.Nil(t, err)
+	assert.Equal(t, porcupine.Ok, report.Result)
+	assert.Equal(t, 6, report.Checked)
+	assert.Equal(t, 2, report.Counts[OpRead][ResultOk])
+}
+
+func TestMustDetectStaleRead(t *testing.T) {
+	dir, err := ioutil.TempDir("", "verify")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	history := historyOf(
+		Entry{Client: 0, Input: Input{Op: OpCreate, Details: "a"}, Output: Output{Result: ResultOk, Id: 1}, Call: 0, Return: 10},
+		Entry{Client: 0, Input: Input{Op: OpCreate, Details: "x"}, Output: Output{Result: ResultOk, Id: 2}, Call: 12, Return: 15},
+		Entry{Client: 0, Input: Input{Op: OpUpdate, Id: 1, Details: "b"}, Output: Output{Result: ResultOk}, Call: 20, Return: 30},
+		// the update had returned, reading the old value is a violation
+		Entry{Client: 1, Input: Input{Op: OpRead, Id: 1}, Output: Output{Result: ResultOk, Details: "a"}, Call: 40, Return: 50},
+		Entry{Client: 1, Input: Input{Op: OpRead, Id: 2}, Output: Output{Result: ResultOk, Details: "x"}, Call: 40, Return: 50},
+	)
+
+	report, err := Check(history, time.Minute, dir)
+
+	assert.Nil(t, err)
+	assert.Equal(t, porcupine.Illegal, report.Result)
+	assert.Equal(t, []int64{1}, report.Violations)
+	assert.FileExists(t, filepath.Join(dir, "visualization.html"))
+	assert.FileExists(t, filepath.Join(dir, "history.json"))
+	assert.FileExists(t, filepath.Join(dir, "report.json"))
+}
+
+func TestMustAllowUnknownOutcomeEitherWay(t *testing.T) {
+	history := historyOf(
+		Entry{Client: 0, Input: Input{Op: OpCreate, Details: "a"}, Output: Output{Result: ResultOk, Id: 1}, Call: 0, Return: 10},
+		Entry{Client: 0, Input: Input{Op: OpDelete, Id: 1}, Output: Output{Result: ResultUnknown}, Call: 20, Return: 30},
+		Entry{Client: 1, Input: Input{Op: OpRead, Id: 1}, Output: Output{Result: ResultOk, Details: "a"}, Call: 40, Return: 50},
+		Entry{Client: 1, Input: Input{Op: OpRead, Id: 1}, Output: Output{Result: ResultNotFound}, Call: 60, Return: 70},
+		// a read which failed says nothing
+		Entry{Client: 1, Input: Input{Op: OpRead, Id: 1}, Output: Output{Result: ResultUnknown}, Call: 80, Return: 90},
+	)
+
+	report, err := Check(history, time.Minute, "")
+
+	assert.Nil(t, err)
+	assert.Equal(t, porcupine.Ok, report.Result)
+	assert.Equal(t, 4, report.Checked)
+}
+
+func TestMustDetectResurrectedTask(t *testing.T) {
+	history := historyOf(
+		Entry{Client: 0, Input: Input{Op: OpCreate, Details: "a"}, Output: Output{Result: ResultOk, Id: 1}, Call: 0, Return: 10},
+		Entry{Client: 0, Input: Input{Op: OpDelete, Id: 1}, Output: Output{Result: ResultOk}, Call: 20, Return: 30},
+		Entry{Client: 1, Input: Input{Op: OpRead, Id: 1}, Output: Output{Result: ResultOk, Details: "a"}, Call: 40, Return: 50},
+	)
+
+	report, err := Check(history, time.Minute, "")
+
+	assert.Nil(t, err)
+	assert.Equal(t, porcupine.Illegal, report.Result)
+}
@@ -0,0 +1,80 @@
+package verify
+
+import (
+	"sync"
+	"time"
+
+	"github.com/anishathalye/porcupine"
+)
+
+// Entry is an operation of the history, timestamps are nanoseconds since the start of the run.
+type Entry struct {
+	Client int    `json:"client"`
+	Node   string `json:"node"`
+	Input  Input  `json:"input"`
+	Output Output `json:"output"`
+	Call   int64  `json:"call"`
+	Return int64  `json:"return"`
+}
+
+// History records the operations of concurrent clients.
+type History struct {
+	mu      sync.Mutex
+	start   time.Time
+	entries []Entry
+}
+
+func NewHistory() *History {
+	return &History{start: time.Now()}
+}
+
+// Now returns the timestamp of the history.
+func (h *History) Now() int64 {
+	return time.Since(h.start).Nanoseconds()
+}
+
+func (h *History) Add(entry Entry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, entry)
+}
+
+// Entries returns a copy of the recorded operations.
+func (h *History) Entries() []Entry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return append([]Entry(nil), h.entries...)
+}
+
+// operations turns the history into what the checker expects.
+//
+// Reads and creates with an unknown outcome cannot change the state of a known task, they are left out.
+// Updates and deletes with an unknown outcome may take effect at any time after their call, so they are
+// considered to return after every other operation.
+func operations(entries []Entry) []porcupine.Operation {
+	var end int64
+	for _, entry := range entries {
+		if entry.Return > end {
+			end = entry.Return
+		}
+	}
+
+	history := make([]porcupine.Operation, 0, len(entries))
+	for _, entry := range entries {
+		ret := entry.Return
+		if entry.Output.Result == ResultUnknown {
+			if entry.Input.Op == OpRead || entry.Input.Op == OpCreate {
+				continue
+			}
+			ret = end + 1
+		}
+		history = append(history, porcupine.Operation{
+			ClientId: entry.Client,
+			Input:    entry.Input,
+			Call:     entry.Call,
+			Output:   entry.Output,
+			Return:   ret,
+		})
+	}
+	return history
+}
@@ -0,0 +1,150 @@
+// Package verify drives concurrent task operations against the REST API of a cluster, records their history
+// and checks that the history is linearizable.
+package verify
+
+import (
+	"fmt"
+
+	"github.com/anishathalye/porcupine"
+)
+
+const (
+	OpCreate = "create"
+	OpRead   = "read"
+	OpUpdate = "update"
+	OpDelete = "delete"
+
+	ResultOk       = "ok"
+	ResultNotFound = "not-found"
+	// ResultUnknown is an operation which failed or timed out, it may or may not have been applied.
+	ResultUnknown = "unknown"
+)
+
+// Input is an operation on a task as requested by a client.
+type Input struct {
+	Op      string `json:"op"`
+	Id      int64  `json:"id,omitempty"`
+	Details string `json:"details,omitempty"`
+}
+
+// Output is what the node answered.
+type Output struct {
+	Result  string `json:"result"`
+	Id      int64  `json:"id,omitempty"`
+	Details string `json:"details,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// taskState is the state of a single task, the history is checked task by task.
+type taskState struct {
+	Exists  bool
+	Details string
+}
+
+// key is the task an operation applies to, creates only learn it from the answer.
+func key(op porcupine.Operation) int64 {
+	in := op.Input.(Input)
+	if in.Op == OpCreate {
+		return op.Output.(Output).Id
+	}
+	return in.Id
+}
+
+func partition(history []porcupine.Operation) [][]porcupine.Operation {
+	byTask := make(map[int64][]porcupine.Operation)
+	var order []int64
+	for _, op := range history {
+		k := key(op)
+		if _, ok := byTask[k]; !ok {
+			order = append(order, k)
+		}
+		byTask[k] = append(byTask[k], op)
+	}
+	partitions := make([][]porcupine.Operation, 0, len(order))
+	for _, k := range order {
+		partitions = append(partitions, byTask[k])
+	}
+	return partitions
+}
+
+// step returns every state the task can be in after the operation, none if the answer is impossible.
+// An update of a missing task succeeds without creating it.
+func step(state interface{}, input interface{}, output interface{}) []interface{} {
+	current := state.(taskState)
+	in := input.(Input)
+	out := output.(Output)
+	deleted := taskState{}
+
+	switch in.Op {
+	case OpCreate:
+		if out.Result == ResultOk && !current.Exists {
+			return []interface{}{taskState{Exists: true, Details: in.Details}}
+		}
+	case OpRead:
+		if out.Result == ResultOk && current.Exists && current.Details == out.Details {
+			return []interface{}{current}
+		}
+		if out.Result == ResultNotFound && !current.Exists {
+			return []interface{}{current}
+		}
+	case OpUpdate:
+		updated := current
+		if current.Exists {
+			updated.Details = in.Details
+		}
+		switch out.Result {
+		case ResultOk:
+			return []interface{}{updated}
+		case ResultNotFound:
+			if !current.Exists {
+				return []interface{}{current}
+			}
+		case ResultUnknown:
+			return []interface{}{current, updated}
+		}
+	case OpDelete:
+		switch out.Result {
+		case ResultOk:
+			return []interface{}{deleted}
+		case ResultNotFound:
+			if !current.Exists {
+				return []interface{}{current}
+			}
+		case ResultUnknown:
+			return []interface{}{current, deleted}
+		}
+	}
+	return nil
+}
+
+// Model is the sequential specification of the tasks API.
+var Model = (&porcupine.NondeterministicModel{
+	Partition: partition,
+	Init: func() []interface{} {
+		return []interface{}{taskState{}}
+	},
+	Step: step,
+	DescribeOperation: func(input interface{}, output interface{}) string {
+		in := input.(Input)
+		out := output.(Output)
+		switch in.Op {
+		case OpCreate:
+			return fmt.Sprintf("create(%q) -> %d", in.Details, out.Id)
+		case OpRead:
+			if out.Result == ResultOk {
+				return fmt.Sprintf("read(%d) -> %q", in.Id, out.Details)
+			}
+			return fmt.Sprintf("read(%d) -> %s", in.Id, out.Result)
+		case OpUpdate:
+			return fmt.Sprintf("update(%d, %q) -> %s", in.Id, in.Details, out.Result)
+		}
+		return fmt.Sprintf("%s(%d) -> %s", in.Op, in.Id, out.Result)
+	},
+	DescribeState: func(state interface{}) string {
+		s := state.(taskState)
+		if !s.Exists {
+			return "absent"
+		}
+		return fmt.Sprintf("%q", s.Details)
+	},
+}).ToModel()
@@ -0,0 +1,147 @@
+package verify
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/balchua/bopbag/pkg/apiclient"
+	"github.com/balchua/bopbag/pkg/domain"
+)
+
+// Config controls the operations sent to the cluster.
+type Config struct {
+	// Urls of the nodes, the clients are spread across them.
+	Urls []string
+	// Clients is the number of concurrent clients.
+	Clients int
+	// Duration of the run.
+	Duration time.Duration
+	// Tasks is the number of tasks the clients operate on at the same time.
+	Tasks int
+	// RequestTimeout is how long a client waits for an answer, the outcome is unknown after that.
+	RequestTimeout time.Duration
+}
+
+// tasks is the pool of tasks the clients operate on.
+type tasks struct {
+	mu     sync.Mutex
+	ids    []int64
+	max    int
+	random *rand.Rand
+}
+
+func (t *tasks) add(id int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.ids) < t.max {
+		t.ids = append(t.ids, id)
+		return
+	}
+	t.ids[t.random.Intn(len(t.ids))] = id
+}
+
+func (t *tasks) pick() (int64, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if len(t.ids) == 0 {
+		return 0, false
+	}
+	return t.ids[t.random.Intn(len(t.ids))], true
+}
+
+// Run sends create, read, update and delete operations from concurrent clients until the duration
+// elapses or the context is cancelled, and returns their history.
+func Run(ctx context.Context, cfg Config) (*History, error) {
+	if len(cfg.Urls) == 0 {
+		return nil, fmt.Errorf("at least one node url is required")
+	}
+	if cfg.Clients < 1 || cfg.Tasks < 1 {
+		return nil, fmt.Errorf("clients and tasks must be positive")
+	}
+	ctx, cancel := context.WithTimeout(ctx, cfg.Duration)
+	defer cancel()
+
+	history := NewHistory()
+	pool := &tasks{max: cfg.Tasks, random: rand.New(rand.NewSource(time.Now().UnixNano()))}
+	var wg sync.WaitGroup
+	for i := 0; i < cfg.Clients; i++ {
+		wg.Add(1)
+		go func(client int) {
+			defer wg.Done()
+			api := apiclient.New(cfg.Urls[client%len(cfg.Urls)], cfg.RequestTimeout)
+			random := rand.New(rand.NewSource(time.Now().UnixNano() + int64(client)))
+			for seq := 0; ctx.Err() == nil; seq++ {
+				in := nextInput(random, pool, fmt.Sprintf("c%d-%d", client, seq))
+				call := history.Now()
+				out := apply(api, in, cfg.RequestTimeout)
+				entry := Entry{
+					Client: client,
+					Node:   api.BaseUrl(),
+					Input:  in,
+					Output: out,
+					Call:   call,
+					Return: history.Now(),
+				}
+				history.Add(entry)
+				if in.Op == OpCreate && out.Result == ResultOk {
+					pool.add(out.Id)
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	return history, nil
+}
+
+// nextInput picks 10% creates, 50% reads, 30% updates and 10% deletes.
+func nextInput(random *rand.Rand, pool *tasks, details string) Input {
+	id, ok := pool.pick()
+	n := random.Intn(10)
+	switch {
+	case !ok || n == 0:
+		return Input{Op: OpCreate, Details: details}
+	case n <= 5:
+		return Input{Op: OpRead, Id: id}
+	case n <= 8:
+		return Input{Op: OpUpdate, Id: id, Details: details}
+	}
+	return Input{Op: OpDelete, Id: id}
+}
+
+func apply(api *apiclient.Client, in Input, timeout time.Duration) Output {
+	// every operation gets its own deadline, the run deadline must not turn an answer into an unknown outcome
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var err error
+	out := Output{Result: ResultOk}
+	switch in.Op {
+	case OpCreate:
+		var task *domain.Task
+		if task, err = api.CreateTask(ctx, &domain.Task{Title: "verify", Details: in.Details}); err == nil {
+			out.Id = task.Id
+		}
+	case OpRead:
+		var task *domain.Task
+		if task, err = api.GetTask(ctx, in.Id); err == nil {
+			out.Details = task.Details
+		}
+	case OpUpdate:
+		_, err = api.UpdateTask(ctx, &domain.Task{Id: in.Id, Title: "verify", Details: in.Details})
+	case OpDelete:
+		err = api.DeleteTask(ctx, in.Id)
+	}
+
+	switch {
+	case errors.Is(err, apiclient.ErrNotFound):
+		out.Result = ResultNotFound
+	case err != nil:
+		out.Result = ResultUnknown
+		out.Error = err.Error()
+	}
+	return out
+}
@@ -0,0 +1,99 @@
+package verify
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/anishathalye/porcupine"
+	"github.com/balchua/bopbag/pkg/domain"
+	"github.com/stretchr/testify/assert"
+)
+
+// fakeApi serves the task API from memory, stale makes the reads return the first value of a task.
+type fakeApi struct {
+	mu     sync.Mutex
+	nextId int64
+	tasks  map[int64]string
+	first  map[int64]string
+	stale  bool
+}
+
+func newFakeApi(stale bool) *fakeApi {
+	return &fakeApi{tasks: make(map[int64]string), first: make(map[int64]string), stale: stale}
+}
+
+func (f *fakeApi) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	var task domain.Task
+	if r.Body != nil {
+		json.NewDecoder(r.Body).Decode(&task)
+	}
+	id, _ := strconv.ParseInt(strings.TrimPrefix(r.URL.Path, "/api/v1/task/"), 10, 64)
+	switch r.Method {
+	case http.MethodPost:
+		f.nextId++
+		task.Id = f.nextId
+		f.tasks[task.Id] = task.Details
+		f.first[task.Id] = task.Details
+	case http.MethodGet:
+		details, ok := f.tasks[id]
+		if !ok {
+			http.Error(w, "sql: no rows in result set", http.StatusServiceUnavailable)
+			return
+		}
+		if f.stale {
+			details = f.first[id]
+		}
+		task = domain.Task{Id: id, Details: details}
+	case http.MethodPut:
+		if _, ok := f.tasks[id]; ok {
+			f.tasks[id] = task.Details
+		}
+	case http.MethodDelete:
+		delete(f.tasks, id)
+		w.Write([]byte(`"deleted"`))
+		return
+	}
+	json.NewEncoder(w).Encode(task)
+}
+
+func TestMustVerifyConsistentApi(t *testing.T) {
+	server := httptest.NewServer(newFakeApi(false))
+	defer server.Close()
+	cfg := Config{Urls: []string{server.URL}, Clients: 4, Duration: 300 * time.Millisecond, Tasks: 3, RequestTimeout: time.Second}
+
+	history, err := Run(context.Background(), cfg)
+	assert.Nil(t, err)
+	report, err := Check(history, time.Minute, "")
+
+	assert.Nil(t, err)
+	assert.Greater(t, report.Operations, 0)
+	assert.Equal(t, porcupine.Ok, report.Result)
+}
+
+func TestMustCatchStaleReads(t *testing.T) {
+	server := httptest.NewServer(newFakeApi(true))
+	defer server.Close()
+	cfg := Config{Urls: []string{server.URL}, Clients: 2, Duration: 300 * time.Millisecond, Tasks: 1, RequestTimeout: time.Second}
+
+	history, err := Run(context.Background(), cfg)
+	assert.Nil(t, err)
+	report, err := Check(history, time.Minute, "")
+
+	assert.Nil(t, err)
+	assert.Equal(t, porcupine.Illegal, report.Result)
+	assert.NotEmpty(t, report.Violations)
+}
+
+func TestFailRunWithoutNodes(t *testing.T) {
+	_, err := Run(context.Background(), Config{Clients: 1, Tasks: 1, Duration: time.Second})
+	assert.NotNil(t, err)
+}